@@ -2,16 +2,21 @@ package agent
 
 import (
     "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/barrier"
+    "etcdagent/agent/config"
     "etcdagent/agent/event"
+    "etcdagent/agent/lock"
     "etcdagent/agent/log"
     "etcdagent/agent/ms"
+    "etcdagent/agent/namespace"
     "etcdagent/agent/node"
+    "fmt"
     "os"
     "strconv"
-    "strings"
+    "sync"
     "time"
 
-    mvccpb "github.com/coreos/etcd/mvcc/mvccpb"
     "github.com/etcd-io/etcd/clientv3"
 )
 
@@ -24,43 +29,172 @@ type Agent struct {
     node.Node
     ms.MS
     event.Event
+    lock.Lock
+    config.Config
+    barrier.Barrier
     client *clientv3.Client
+    subs   event.Dispatcher
+    retry  backend.Retry
 }
 
 func NewAgent(addrs []string, timeout time.Duration) (*Agent, error) {
-    conf := clientv3.Config{
-        Endpoints:   addrs,
-        DialTimeout: timeout,
+    conf := DefaultConfig()
+    conf.Endpoints = addrs
+    conf.DialTimeout = Duration{timeout}
+    return NewAgentFromConfig(conf)
+}
+
+func NewAgentFromConfig(conf *AgentConfig) (*Agent, error) {
+    if err := conf.Validate(); err != nil {
+        log.Warn("Validate agent config error, reason: %v\n", err.Error())
+        return nil, err
+    }
+
+    cconf := clientv3.Config{
+        Endpoints:   conf.Endpoints,
+        DialTimeout: conf.DialTimeout.Duration,
     }
 
     var client *clientv3.Client
     var err error
 
-    if client, err = clientv3.New(conf); err != nil {
+    if client, err = clientv3.New(cconf); err != nil {
         log.Warn("New v3 client error, reason: %v\n", err.Error())
         return nil, err
     }
 
-    return &Agent{
-        Node:   node.NewNode(client),
-        MS:     ms.NewMS(client),
-        Event:  event.NewEvent(client),
-        client: client,
-    }, nil
+    //所有key统一加上namespace前缀
+    if conf.Namespace != "" {
+        client.KV = namespace.NewKV(client.KV, conf.Namespace)
+        client.Watcher = namespace.NewWatcher(client.Watcher, conf.Namespace)
+        client.Lease = namespace.NewLease(client.Lease, conf.Namespace)
+    }
+
+    //node、ms和event共用一个重试统计
+    retry := backend.NewRetry(backend.NewEtcd(client), conf.Retry.Policy())
+    a := &Agent{
+        Node:    node.NewNodeFromBackend(retry),
+        MS:      ms.NewMSFromBackend(retry),
+        Event:   event.NewEventFromBackend(retry),
+        Lock:    lock.NewLock(client),
+        Config:  config.NewConfig(client),
+        Barrier: barrier.NewBarrier(client),
+        client:  client,
+        subs:    event.NewDispatcher(),
+        retry:   retry,
+    }
+
+    a.NodeSetTTL(conf.Node.TTL)
+    a.NodeSetIdGrace(conf.Node.IdGrace)
+    a.NodeSetTimeout(conf.Node.Timeout.Duration)
+    a.MSSetTTL(conf.MS.TTL)
+    a.MSSetTimeout(conf.MS.Timeout.Duration)
+    a.MSSetPreempt(conf.MS.Preempt, conf.MS.PreemptDelay.Duration)
+    a.BarrierSetTTL(conf.Barrier.TTL)
+    a.EventSetMq(conf.Mq.Name, conf.Mq.MaxMsg, conf.Mq.MsgSize, uint32(conf.Mq.Mode))
+    if err = a.LockSetTTL(conf.Lock.TTL); err != nil {
+        client.Close()
+        return nil, err
+    }
+    if err = a.EventSetOverflow(conf.Mq.Overflow, conf.Mq.SendTimeout.Duration); err != nil {
+        client.Close()
+        return nil, err
+    }
+    if err = a.EventSetJournal(conf.Journal.Path, conf.Journal.MaxSize); err != nil {
+        client.Close()
+        return nil, err
+    }
+    return a, nil
 }
 
+//优先读取ETCD_AGENT_CONFIG指定的配置文件，否则使用默认配置和环境变量
 func NewDefaultAgent() (*Agent, error) {
-    addrs := strings.Split(os.Getenv("ETCD_NODES"), ",")
-    if len(addrs) == 0 {
-        addrs = []string{ETCD_DEFAULT_ADDR}
+    if path := os.Getenv(ETCD_CONFIG_ENV); path != "" {
+        conf, err := LoadConfig(path)
+        if err != nil {
+            log.Warn("Load agent config error, reason: %v\n", err.Error())
+            return nil, err
+        }
+        return NewAgentFromConfig(conf)
     }
 
-    timeout, err := strconv.Atoi(os.Getenv("ETCD_TIMEOUT"))
-    if timeout == 0 || err != nil {
-        timeout = ETCD_DEFAULT_TIMEOUT
+    conf := DefaultConfig()
+    if err := conf.ApplyEnv(); err != nil {
+        log.Warn("Load agent config error, reason: %v\n", err.Error())
+        return nil, err
     }
+    return NewAgentFromConfig(conf)
+}
+
+//供管理工具直接访问etcd，已应用namespace
+func (a *Agent) Client() *clientv3.Client {
+    return a.client
+}
 
-    return NewAgent(addrs, time.Duration(timeout)*time.Second)
+func (a *Agent) RetryStats() backend.RetryStats {
+    return a.retry.RetryStats()
+}
+
+func (a *Agent) Close() error {
+    return a.client.Close()
+}
+
+//注册冲突时通知应用当前的owner
+func (a *Agent) NodeOnline(ctx context.Context, nodeId uint32, serviceAddr string) error {
+    err := a.Node.NodeOnline(ctx, nodeId, serviceAddr)
+    if conflict, ok := err.(*node.ConflictError); ok {
+        key := fmt.Sprintf("%s%v", node.NODE_PREFIX, nodeId)
+        if nerr := a.notify(ctx, event.MakeMessage(key, conflict.ServiceAddr, event.EVENT_TYPE_CONFLICT)); nerr != nil {
+            log.Warn("Notify node conflict error, nodeId: %v, reason: %v", nodeId, nerr.Error())
+        }
+    }
+    return err
+}
+
+//保活时发现锁已丢失，通知应用
+func (a *Agent) LockKeepalive(name string, nodeId uint32) error {
+    err := a.Lock.LockKeepalive(name, nodeId)
+    if err == lock.ErrLockLost {
+        a.notifyLockLost(context.Background(), name, nodeId, 0)
+    }
+    return err
+}
+
+func (a *Agent) notifyLockLost(ctx context.Context, name string, nodeId uint32, fence int64) {
+    key := fmt.Sprintf("%s%s/%v", lock.LOCK_PREFIX, name, nodeId)
+    if err := a.notify(ctx, event.MakeMessage(key, strconv.FormatInt(fence, 10), event.EVENT_TYPE_LOCK_LOST)); err != nil {
+        log.Warn("Notify lock lost error, lock: %v, nodeId: %v, reason: %v", name, nodeId, err.Error())
+    }
+}
+
+//订阅满足条件的事件，包括Watch到的变化和冲突、锁丢失等本地通知
+//订阅者处理不及时时事件被丢弃，不阻塞Run
+func (a *Agent) Subscribe(filter event.Filter, size int) (<-chan event.Message, func()) {
+    target, ch := event.NewChanTarget(size)
+    id := a.subs.Subscribe(filter, target)
+
+    var once sync.Once
+    cancel := func() {
+        once.Do(func() {
+            a.subs.Unsubscribe(id)
+        })
+    }
+    return ch, cancel
+}
+
+//订阅到指定的目标，如MQ、C回调，返回订阅id
+func (a *Agent) SubscribeTarget(filter event.Filter, target event.Target) uint32 {
+    return a.subs.Subscribe(filter, target)
+}
+
+func (a *Agent) Unsubscribe(id uint32) error {
+    return a.subs.Unsubscribe(id)
+}
+
+//同时发送到MQ和订阅者
+func (a *Agent) notify(ctx context.Context, m event.Message) error {
+    a.subs.Dispatch(m)
+    return a.NotifyMessage(ctx, m)
 }
 
 func (a *Agent) Run() {
@@ -68,23 +202,41 @@ func (a *Agent) Run() {
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
-    evtChan := make(chan *clientv3.Event, 1024)
+    evtChan := make(chan event.Message, 1024)
     go a.Event.Watch(ctx, evtChan)
 
-    //如果Watch到的事件与node或者ms相关，则修改本地状态
-    for e := range evtChan {
-        log.Info("Event = %v", e)
-        if e.Type == mvccpb.DELETE {
-            key := string(e.Kv.Key)
-            tmp := strings.Split(key, "/")
-            inodeId, _ := strconv.Atoi(tmp[len(tmp)-1])
-            nodeId := uint32(inodeId)
-            if strings.HasPrefix(key, node.NODE_PREFIX) {
-                a.NodeOffline(nodeId)
-            }
+    ticker := time.NewTicker(AGENT_RECONCILE_INTERVAL)
+    defer ticker.Stop()
+
+    //只处理本agent注册的node、候选者和锁，其他agent的key由其owner处理
+    for {
+        select {
+        case <-ticker.C:
+            a.reconcileAll(ctx)
+        case e := <-evtChan:
+            log.Info("Event = %+v", e)
+            a.subs.Dispatch(e)
+            a.handle(ctx, e)
+        }
+    }
+}
+
+func (a *Agent) handle(ctx context.Context, e event.Message) {
+    switch e.Type {
+    case event.EVENT_TYPE_PUT:
+        a.reconcile(ctx, e)
+
+    case event.EVENT_TYPE_DELETE:
+        a.reconcile(ctx, e)
+
+        //master失效，本地候选者立即参与选主
+        if group, ok := ms.ParseMasterKey(e.FullKey); ok {
+            a.MSElect(ctx, group)
+        }
 
-            if strings.HasPrefix(key, ms.MS_PREFIX) {
-                a.MSGiveUp(nodeId)
+        if name, lockNodeId, ok := lock.ParseKey(e.FullKey); ok {
+            if fence, held := a.LockLost(name, lockNodeId); held {
+                a.notifyLockLost(ctx, name, lockNodeId, fence)
             }
         }
     }
@@ -0,0 +1,122 @@
+package agent
+
+import (
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+    f, err := ioutil.TempFile("", "etcdagent-*.yaml")
+    if err != nil {
+        t.Fatalf("Create config file failed: %v", err)
+    }
+    defer f.Close()
+
+    if _, err = f.WriteString(content); err != nil {
+        t.Fatalf("Write config file failed: %v", err)
+    }
+    return f.Name()
+}
+
+func TestLoadConfig(t *testing.T) {
+    os.Unsetenv("ETCD_NODES")
+    os.Unsetenv("ETCD_TIMEOUT")
+
+    path := writeConfig(t, `
+endpoints: ["10.0.0.1:2379", "10.0.0.2:2379"]
+dialTimeout: 3s
+namespace: /prod
+node:
+  ttl: 2
+  timeout: 500ms
+ms:
+  preempt: true
+  preemptDelay: 500ms
+mqueue:
+  name: /testmq
+  mode: "0640"
+`)
+    defer os.Remove(path)
+
+    conf, err := LoadConfig(path)
+    if err != nil {
+        t.Fatalf("Load config failed: %v", err)
+    }
+
+    if len(conf.Endpoints) != 2 || conf.DialTimeout.Duration != 3*time.Second || conf.Namespace != "/prod" {
+        t.Errorf("Expected endpoints/dialTimeout/namespace from file, acctually = %v/%v/%v",
+            conf.Endpoints, conf.DialTimeout, conf.Namespace)
+    }
+
+    if conf.Node.TTL != 2 || !conf.MS.Preempt || conf.MS.PreemptDelay.Duration != 500*time.Millisecond {
+        t.Errorf("Expected node/ms settings from file, acctually = %+v/%+v", conf.Node, conf.MS)
+    }
+
+    if conf.Node.Timeout.Duration != 500*time.Millisecond || conf.MS.Timeout.Duration != 2*time.Second {
+        t.Errorf("Expected node timeout from file and default ms timeout, acctually = %v/%v", conf.Node.Timeout, conf.MS.Timeout)
+    }
+
+    //未配置的字段使用默认值
+    defaults := DefaultConfig()
+    if conf.Lock != defaults.Lock || conf.Retry != defaults.Retry || conf.Mq.MaxMsg != defaults.Mq.MaxMsg || conf.Mq.Name != "/testmq" || conf.Mq.Mode != 0640 {
+        t.Errorf("Expected defaults for missing fields, acctually = %+v/%+v", conf.Lock, conf.Mq)
+    }
+
+    //环境变量优先
+    os.Setenv("ETCD_NODES", "10.0.0.3:2379")
+    os.Setenv("ETCD_TIMEOUT", "7")
+    defer os.Unsetenv("ETCD_NODES")
+    defer os.Unsetenv("ETCD_TIMEOUT")
+
+    if conf, err = LoadConfig(path); err != nil {
+        t.Fatalf("Load config failed: %v", err)
+    }
+
+    if len(conf.Endpoints) != 1 || conf.Endpoints[0] != "10.0.0.3:2379" || conf.DialTimeout.Duration != 7*time.Second {
+        t.Errorf("Expected env override, acctually = %v/%v", conf.Endpoints, conf.DialTimeout)
+    }
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+    data := []struct {
+        content string
+        reason  string
+    }{
+        {"endpoints: [\"10.0.0.1\"]", "endpoints[0]"},
+        {"node:\n  ttl: 0", "node.ttl"},
+        {"ms:\n  timeout: -1s", "ms.timeout"},
+        {"rpc:\n  address: 0.0.0.0:2380", "rpc.address"},
+        {"retry:\n  maxAttempts: 0", "retry.maxAttempts"},
+        {"retry:\n  initialBackoff: 2s", "retry.maxBackoff"},
+        {"retry:\n  jitter: 1.5", "retry.jitter"},
+        {"mqueue:\n  name: etcdmq", "mqueue.name"},
+        {"mqueue:\n  msgSize: 1", "mqueue.msgSize"},
+        {"mqueue:\n  mode: \"0999\"", "invalid mode"},
+        {"mqueue:\n  mode: 0", "mqueue.mode"},
+        {"mqueue:\n  overflow: block", "mqueue.overflow"},
+        {"journal:\n  path: journal", "journal.path"},
+        {"journal:\n  maxSize: 100", "journal.maxSize"},
+        {"dialTimeout: soon", "invalid duration"},
+        {"ms:\n  tll: 1", "unknown field"},
+    }
+
+    for _, d := range data {
+        path := writeConfig(t, d.content)
+        _, err := LoadConfig(path)
+        os.Remove(path)
+
+        if err == nil || !strings.Contains(err.Error(), d.reason) {
+            t.Errorf("Expected error containing %q for %q, acctually = %v", d.reason, d.content, err)
+        }
+    }
+
+    //所有错误一次返回
+    path := writeConfig(t, "node:\n  ttl: 0\nlock:\n  ttl: 0")
+    defer os.Remove(path)
+    if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "node.ttl") || !strings.Contains(err.Error(), "lock.ttl") {
+        t.Errorf("Expected both node.ttl and lock.ttl errors, acctually = %v", err)
+    }
+}
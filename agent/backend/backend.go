@@ -0,0 +1,139 @@
+package backend
+
+import (
+    "context"
+    "time"
+
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+//node、ms和event使用的存储接口，方法与clientv3一致，etcd之外的实现见NewMemory
+//比较条件直接使用clientv3.Compare，请求使用本包的Op，响应使用clientv3的类型
+type KV interface {
+    Get(ctx context.Context, key string, opts ...OpOption) (*clientv3.GetResponse, error)
+    Put(ctx context.Context, key, val string, opts ...OpOption) (*clientv3.PutResponse, error)
+    Delete(ctx context.Context, key string, opts ...OpOption) (*clientv3.DeleteResponse, error)
+    Txn(ctx context.Context) Txn
+}
+
+//*clientv3.Client同样实现了该接口
+type Lease interface {
+    Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error)
+    Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error)
+    TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error)
+    KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error)
+}
+
+type Watcher interface {
+    Watch(ctx context.Context, key string, opts ...OpOption) clientv3.WatchChan
+}
+
+//lease使用的时钟，本地估算lease到期时间时使用
+type Clock interface {
+    Now() time.Time
+}
+
+type Backend interface {
+    KV
+    Lease
+    Watcher
+    Clock
+    Close() error
+}
+
+//If中的条件全部满足时执行Then，否则执行Else
+type Txn interface {
+    If(cs ...clientv3.Cmp) Txn
+    Then(ops ...Op) Txn
+    Else(ops ...Op) Txn
+    Commit() (*clientv3.TxnResponse, error)
+}
+
+type opType int
+
+const (
+    tGet opType = iota + 1
+    tPut
+    tDelete
+)
+
+//clientv3.Op不能读取lease等参数，其他实现无法执行，因此单独定义
+type Op struct {
+    t             opType
+    key           string
+    val           string
+    prefix        bool
+    rev           int64
+    lease         clientv3.LeaseID
+    ignoreLease   bool
+    prevKV        bool
+    keysOnly      bool
+    countOnly     bool
+    createdNotify bool
+}
+
+type OpOption func(op *Op)
+
+func OpGet(key string, opts ...OpOption) Op {
+    return newOp(tGet, key, "", opts)
+}
+
+func OpPut(key, val string, opts ...OpOption) Op {
+    return newOp(tPut, key, val, opts)
+}
+
+func OpDelete(key string, opts ...OpOption) Op {
+    return newOp(tDelete, key, "", opts)
+}
+
+func newOp(t opType, key, val string, opts []OpOption) Op {
+    op := Op{t: t, key: key, val: val}
+    for _, opt := range opts {
+        opt(&op)
+    }
+    return op
+}
+
+func WithPrefix() OpOption {
+    return func(op *Op) { op.prefix = true }
+}
+
+//Get读取指定revision的数据，Watch从指定revision开始
+func WithRev(rev int64) OpOption {
+    return func(op *Op) { op.rev = rev }
+}
+
+func WithLease(lease clientv3.LeaseID) OpOption {
+    return func(op *Op) { op.lease = lease }
+}
+
+//Put时沿用key当前的lease
+func WithIgnoreLease() OpOption {
+    return func(op *Op) { op.ignoreLease = true }
+}
+
+//Delete返回删除前的数据，Watch的删除事件包含删除前的数据
+func WithPrevKV() OpOption {
+    return func(op *Op) { op.prevKV = true }
+}
+
+func WithKeysOnly() OpOption {
+    return func(op *Op) { op.keysOnly = true }
+}
+
+func WithCountOnly() OpOption {
+    return func(op *Op) { op.countOnly = true }
+}
+
+//Watch建立后先返回一个Created为true的响应
+func WithCreatedNotify() OpOption {
+    return func(op *Op) { op.createdNotify = true }
+}
+
+//ctx没有deadline时使用timeout作为默认超时，timeout为0时不限制
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+    if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+        return context.WithCancel(ctx)
+    }
+    return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,445 @@
+package backend
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    "github.com/coreos/etcd/mvcc/mvccpb"
+    "github.com/etcd-io/etcd/clientv3"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+const ETCDADDR = "172.100.1.239:2379"
+
+//同一组用例分别在etcd和Memory上执行，保证两者行为一致
+func backends(t *testing.T) map[string]Backend {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+    return map[string]Backend{"etcd": NewEtcd(client), "memory": NewMemory()}
+}
+
+func TestBackendTxn(t *testing.T) {
+    for name, b := range backends(t) {
+        prefix := "/CoreNetTest/Backend/Txn/"
+        key := prefix + "1"
+        b.Delete(context.TODO(), prefix, WithPrefix())
+
+        grantResp, err := b.Grant(context.TODO(), 10)
+        if err != nil {
+            t.Fatalf("%v: grant lease error, reason: %v", name, err.Error())
+        }
+
+        //key不存在时写入，已存在时返回当前值
+        for i, expected := range []bool{true, false} {
+            resp, err := b.Txn(context.TODO()).
+                If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+                Then(OpPut(key, fmt.Sprint(i), WithLease(grantResp.ID))).
+                Else(OpGet(key)).
+                Commit()
+            if err != nil || resp.Succeeded != expected {
+                t.Errorf("%v: txn %v expected succeeded = %v, acctually = %+v, err = %v", name, i, expected, resp, err)
+            }
+        }
+
+        resp, err := b.Get(context.TODO(), key)
+        if err != nil || len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "0" || clientv3.LeaseID(resp.Kvs[0].Lease) != grantResp.ID {
+            t.Errorf("%v: get %v expected value 0 with lease %v, acctually = %+v, err = %v", name, key, grantResp.ID, resp, err)
+        }
+
+        //沿用原lease修改value
+        if _, err = b.Put(context.TODO(), key, "2", WithIgnoreLease()); err != nil {
+            t.Errorf("%v: put with ignore lease error, reason: %v", name, err.Error())
+        }
+        b.Put(context.TODO(), prefix+"10", "10")
+        b.Put(context.TODO(), prefix+"2", "2")
+
+        resp, err = b.Get(context.TODO(), prefix, WithPrefix())
+        if err != nil || fmt.Sprint(keys(resp.Kvs)) != fmt.Sprintf("[%v1 %v10 %v2]", prefix, prefix, prefix) ||
+            resp.Kvs[0].Version != 2 || clientv3.LeaseID(resp.Kvs[0].Lease) != grantResp.ID {
+            t.Errorf("%v: get prefix expected sorted keys, acctually = %+v, err = %v", name, resp, err)
+        }
+
+        if resp, err = b.Get(context.TODO(), prefix, WithPrefix(), WithCountOnly()); err != nil || resp.Count != 3 || len(resp.Kvs) != 0 {
+            t.Errorf("%v: get count expected = 3, acctually = %+v, err = %v", name, resp, err)
+        }
+
+        //lease被回收后关联的key随之删除，使用不存在的lease写入失败
+        if _, err = b.Revoke(context.TODO(), grantResp.ID); err != nil {
+            t.Errorf("%v: revoke lease error, reason: %v", name, err.Error())
+        }
+        if _, err = b.Put(context.TODO(), key, "3", WithLease(grantResp.ID)); err != rpctypes.ErrLeaseNotFound {
+            t.Errorf("%v: put with revoked lease expected = %v, acctually = %v", name, rpctypes.ErrLeaseNotFound, err)
+        }
+        if ttlResp, err := b.TimeToLive(context.TODO(), grantResp.ID); err != nil || ttlResp.TTL != -1 {
+            t.Errorf("%v: ttl of revoked lease expected = -1, acctually = %+v, err = %v", name, ttlResp, err)
+        }
+
+        delResp, err := b.Delete(context.TODO(), prefix, WithPrefix(), WithPrevKV())
+        if err != nil || delResp.Deleted != 2 || len(delResp.PrevKvs) != 2 {
+            t.Errorf("%v: delete prefix expected 2 keys, acctually = %+v, err = %v", name, delResp, err)
+        }
+        b.Close()
+    }
+}
+
+func TestBackendWatch(t *testing.T) {
+    for name, b := range backends(t) {
+        prefix := "/CoreNetTest/Backend/Watch/"
+        putResp, err := b.Put(context.TODO(), prefix+"1", "1")
+        if err != nil {
+            t.Fatalf("%v: put error, reason: %v", name, err.Error())
+        }
+        b.Delete(context.TODO(), prefix+"1")
+
+        //从指定revision开始时先返回历史修改
+        ctx, cancel := context.WithCancel(context.Background())
+        wChan := b.Watch(ctx, prefix, WithPrefix(), WithPrevKV(), WithRev(putResp.Header.Revision))
+        expected := []string{"PUT 1", "DELETE 1"}
+        acctually := make([]string, 0)
+        for len(acctually) < len(expected) {
+            select {
+            case wResp := <-wChan:
+                for _, ev := range wResp.Events {
+                    value := ev.Kv.Value
+                    if ev.Type == mvccpb.DELETE && ev.PrevKv != nil {
+                        value = ev.PrevKv.Value
+                    }
+                    acctually = append(acctually, fmt.Sprintf("%v %s", ev.Type, value))
+                }
+            case <-time.After(2 * time.Second):
+                t.Fatalf("%v: watch expected = %v, acctually = %v", name, expected, acctually)
+            }
+        }
+        if fmt.Sprint(acctually) != fmt.Sprint(expected) {
+            t.Errorf("%v: watch expected = %v, acctually = %v", name, expected, acctually)
+        }
+
+        //ctx结束后关闭channel
+        cancel()
+        select {
+        case _, ok := <-wChan:
+            if ok {
+                t.Errorf("%v: watch channel should be closed after cancel", name)
+            }
+        case <-time.After(2 * time.Second):
+            t.Errorf("%v: watch channel is not closed after cancel", name)
+        }
+        b.Close()
+    }
+}
+
+func TestMemoryLease(t *testing.T) {
+    m := NewMemory()
+    defer m.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    wChan := m.Watch(ctx, "/CoreNet/Node/", WithPrefix(), WithPrevKV())
+
+    grantResp, _ := m.Grant(context.TODO(), 2)
+    m.Put(context.TODO(), "/CoreNet/Node/1", "192.168.0.1:50051", WithLease(grantResp.ID))
+    <-wChan
+
+    //续约后从续约时刻重新计算
+    data := []struct {
+        advance   time.Duration
+        keepalive bool
+        ttl       int64
+    }{
+        {time.Second, true, 2},
+        {1500 * time.Millisecond, false, 1},
+        {time.Second, false, -1},
+    }
+    for _, d := range data {
+        m.Advance(d.advance)
+        if d.keepalive {
+            if _, err := m.KeepAliveOnce(context.TODO(), grantResp.ID); err != nil {
+                t.Errorf("Keepalive error, reason: %v", err.Error())
+            }
+        }
+
+        if resp, err := m.TimeToLive(context.TODO(), grantResp.ID); err != nil || resp.TTL != d.ttl {
+            t.Errorf("Test memory lease failed, expected ttl = %v, acctually = %+v, err = %v", d.ttl, resp, err)
+        }
+    }
+
+    select {
+    case wResp := <-wChan:
+        if len(wResp.Events) != 1 || wResp.Events[0].Type != mvccpb.DELETE || wResp.Events[0].PrevKv.Lease != int64(grantResp.ID) {
+            t.Errorf("Test memory lease failed, expected delete event with lease, acctually = %+v", wResp)
+        }
+    case <-time.After(time.Second):
+        t.Errorf("Test memory lease failed, expected delete event after lease expired")
+    }
+
+    if _, err := m.KeepAliveOnce(context.TODO(), grantResp.ID); err != rpctypes.ErrLeaseNotFound {
+        t.Errorf("Keepalive expired lease expected = %v, acctually = %v", rpctypes.ErrLeaseNotFound, err)
+    }
+}
+
+func TestMemoryCompact(t *testing.T) {
+    m := NewMemory()
+    defer m.Close()
+
+    resp, _ := m.Put(context.TODO(), "/CoreNet/Node/1", "1")
+    compactResp, _ := m.Put(context.TODO(), "/CoreNet/Node/1", "2")
+    m.Compact(compactResp.Header.Revision)
+
+    if _, err := m.Get(context.TODO(), "/CoreNet/Node/1", WithRev(resp.Header.Revision)); err != rpctypes.ErrCompacted {
+        t.Errorf("Get compacted revision expected = %v, acctually = %v", rpctypes.ErrCompacted, err)
+    }
+
+    wResp := <-m.Watch(context.TODO(), "/CoreNet/Node/", WithPrefix(), WithRev(resp.Header.Revision))
+    if wResp.Err() != rpctypes.ErrCompacted || wResp.CompactRevision != compactResp.Header.Revision {
+        t.Errorf("Watch compacted revision expected compact revision = %v, acctually = %+v", compactResp.Header.Revision, wResp)
+    }
+
+    if resp, err := m.Get(context.TODO(), "/CoreNet/Node/1", WithRev(compactResp.Header.Revision)); err != nil || string(resp.Kvs[0].Value) != "2" {
+        t.Errorf("Get compact revision expected value 2, acctually = %+v, err = %v", resp, err)
+    }
+}
+
+//按顺序返回errs中的错误后恢复正常，closes次Watch在收到一个响应后关闭
+type flaky struct {
+    Memory
+    errs   []error
+    closes int //Watch返回第一个响应后关闭
+    fails  int //closes用完后Watch没有任何响应直接关闭
+}
+
+func (f *flaky) fail() error {
+    if len(f.errs) == 0 {
+        return nil
+    }
+    err := f.errs[0]
+    f.errs = f.errs[1:]
+    return err
+}
+
+func (f *flaky) Get(ctx context.Context, key string, opts ...OpOption) (*clientv3.GetResponse, error) {
+    if err := f.fail(); err != nil {
+        return nil, err
+    }
+    return f.Memory.Get(ctx, key, opts...)
+}
+
+func (f *flaky) Put(ctx context.Context, key, val string, opts ...OpOption) (*clientv3.PutResponse, error) {
+    if err := f.fail(); err != nil {
+        return nil, err
+    }
+    return f.Memory.Put(ctx, key, val, opts...)
+}
+
+func (f *flaky) Watch(ctx context.Context, key string, opts ...OpOption) clientv3.WatchChan {
+    if f.closes == 0 && f.fails > 0 {
+        f.fails--
+        wChan := make(chan clientv3.WatchResponse)
+        close(wChan)
+        return wChan
+    }
+    if f.closes == 0 {
+        return f.Memory.Watch(ctx, key, opts...)
+    }
+    f.closes--
+
+    wChan := make(chan clientv3.WatchResponse)
+    go func() {
+        defer close(wChan)
+        wctx, cancel := context.WithCancel(ctx)
+        defer cancel()
+        if wResp, ok := <-f.Memory.Watch(wctx, key, opts...); ok {
+            wChan <- wResp
+        }
+    }()
+    return wChan
+}
+
+func TestRetry(t *testing.T) {
+    unavailable := status.Error(codes.Unavailable, "there is no connection available")
+    data := []struct {
+        name     string
+        errs     []error
+        write    bool
+        expected error
+        stats    RetryStats
+    }{
+        {"leader changed", []error{rpctypes.ErrLeaderChanged, rpctypes.ErrTimeout}, false, nil, RetryStats{2, 1, 0}},
+        {"too many requests", []error{rpctypes.ErrTooManyRequests}, true, nil, RetryStats{1, 1, 0}},
+        {"no connection", []error{unavailable}, true, nil, RetryStats{1, 1, 0}},
+        {"ambiguous write", []error{rpctypes.ErrTimeout}, true, rpctypes.ErrTimeout, RetryStats{0, 0, 0}},
+        {"permission denied", []error{rpctypes.ErrPermissionDenied}, false, rpctypes.ErrPermissionDenied, RetryStats{0, 0, 0}},
+        {"exhausted", []error{rpctypes.ErrNoLeader, rpctypes.ErrNoLeader, rpctypes.ErrNoLeader}, false, rpctypes.ErrNoLeader, RetryStats{2, 0, 1}},
+    }
+
+    policy := DefaultRetryPolicy()
+    policy.MaxAttempts = 3
+    policy.InitialBackoff = time.Millisecond
+    for _, d := range data {
+        f := &flaky{Memory: NewMemory(), errs: d.errs}
+        r := NewRetry(f, policy)
+
+        var err error
+        if d.write {
+            _, err = r.Put(context.TODO(), "/CoreNet/Node/1", "1")
+        } else {
+            _, err = r.Get(context.TODO(), "/CoreNet/Node/1")
+        }
+        if err != d.expected || r.RetryStats() != d.stats {
+            t.Errorf("%v: expected err = %v, stats = %+v, acctually err = %v, stats = %+v", d.name, d.expected, d.stats, err, r.RetryStats())
+        }
+        r.Close()
+    }
+}
+
+func TestRetryDeadline(t *testing.T) {
+    f := &flaky{Memory: NewMemory(), errs: []error{rpctypes.ErrNoLeader, rpctypes.ErrNoLeader}}
+    defer f.Close()
+
+    //剩余时间不足以等待下一次重试时立即返回
+    policy := DefaultRetryPolicy()
+    policy.InitialBackoff = time.Second
+    r := NewRetry(f, policy)
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+
+    start := time.Now()
+    if _, err := r.Get(ctx, "/CoreNet/Node/1"); err != rpctypes.ErrNoLeader || time.Since(start) > 50*time.Millisecond {
+        t.Errorf("Retry with short deadline expected = %v immediately, acctually = %v after %v", rpctypes.ErrNoLeader, err, time.Since(start))
+    }
+    if stats := r.RetryStats(); stats.Exhausted != 1 || stats.Retries != 0 {
+        t.Errorf("Retry with short deadline expected 1 exhausted, acctually = %+v", stats)
+    }
+}
+
+func TestRetryWatch(t *testing.T) {
+    f := &flaky{Memory: NewMemory(), closes: 2}
+    defer f.Close()
+
+    policy := DefaultRetryPolicy()
+    policy.InitialBackoff = time.Millisecond
+    r := NewRetry(f, policy)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    //每次中断后从下一个revision继续，不重复也不遗漏
+    prefix := "/CoreNet/Node/"
+    wChan := r.Watch(ctx, prefix, WithPrefix(), WithCreatedNotify())
+    if wResp := <-wChan; !wResp.Created {
+        t.Fatalf("Watch expected created response, acctually = %+v", wResp)
+    }
+
+    expected := []string{"1", "2", "3"}
+    for _, value := range expected {
+        f.Put(context.TODO(), prefix+value, value)
+    }
+
+    acctually := make([]string, 0)
+    for len(acctually) < len(expected) {
+        select {
+        case wResp := <-wChan:
+            for _, ev := range wResp.Events {
+                acctually = append(acctually, string(ev.Kv.Value))
+            }
+        case <-time.After(2 * time.Second):
+            t.Fatalf("Retry watch expected = %v, acctually = %v", expected, acctually)
+        }
+    }
+    if fmt.Sprint(acctually) != fmt.Sprint(expected) {
+        t.Errorf("Retry watch expected = %v, acctually = %v", expected, acctually)
+    }
+    if stats := r.RetryStats(); stats.Retries != 2 || stats.Recovered != 1 {
+        t.Errorf("Retry watch expected 2 retries and 1 recovered, acctually = %+v", stats)
+    }
+
+    //ctx结束后关闭channel
+    cancel()
+    select {
+    case _, ok := <-wChan:
+        if ok {
+            t.Errorf("Retry watch channel should be closed after ctx done")
+        }
+    case <-time.After(2 * time.Second):
+        t.Errorf("Retry watch channel is not closed after ctx done")
+    }
+}
+
+func keys(kvs []*mvccpb.KeyValue) []string {
+    result := make([]string, 0, len(kvs))
+    for _, kv := range kvs {
+        result = append(result, string(kv.Key))
+    }
+    return result
+}
+
+//第一个事件之前中断时从建立时的revision之后继续，调用者没有要求时不转发Created
+func TestRetryWatchCreated(t *testing.T) {
+    f := &flaky{Memory: NewMemory(), closes: 1}
+    defer f.Close()
+
+    policy := DefaultRetryPolicy()
+    policy.InitialBackoff = 200 * time.Millisecond
+    r := NewRetry(f, policy)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    prefix := "/CoreNet/Node/"
+    wChan := r.Watch(ctx, prefix, WithPrefix())
+    time.Sleep(50 * time.Millisecond)
+    f.Put(context.TODO(), prefix+"1", "1")
+
+    select {
+    case wResp := <-wChan:
+        if wResp.Created || len(wResp.Events) != 1 || string(wResp.Events[0].Kv.Value) != "1" {
+            t.Errorf("Retry watch created expected event 1, acctually = %+v", wResp)
+        }
+    case <-time.After(2 * time.Second):
+        t.Errorf("Retry watch created expected event 1 put before reconnect")
+    }
+}
+
+func TestRetryWatchAttempts(t *testing.T) {
+    policy := DefaultRetryPolicy()
+    policy.InitialBackoff = time.Millisecond
+    policy.MaxBackoff = 10 * time.Millisecond
+    f := &flaky{Memory: NewMemory(), closes: 1, fails: policy.MaxAttempts * 2}
+    defer f.Close()
+
+    r := NewRetry(f, policy)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    //Watch建立后连续中断超过MaxAttempts次，仍然重试到恢复
+    prefix := "/CoreNet/Node/"
+    wChan := r.Watch(ctx, prefix, WithPrefix(), WithCreatedNotify())
+    if wResp := <-wChan; !wResp.Created {
+        t.Fatalf("Watch expected created response, acctually = %+v", wResp)
+    }
+
+    f.Put(context.TODO(), prefix+"1", "1")
+    select {
+    case wResp, ok := <-wChan:
+        if !ok || len(wResp.Events) != 1 || string(wResp.Events[0].Kv.Value) != "1" {
+            t.Errorf("Retry watch expected = 1, acctually = %+v, ok = %v", wResp, ok)
+        }
+    case <-time.After(5 * time.Second):
+        t.Errorf("Retry watch stopped after %v failures", policy.MaxAttempts*2)
+    }
+
+    if stats := r.RetryStats(); stats.Retries != uint64(policy.MaxAttempts*2+1) || stats.Exhausted != 0 {
+        t.Errorf("Retry watch expected %v retries and no exhausted, acctually = %+v", policy.MaxAttempts*2+1, stats)
+    }
+}
@@ -0,0 +1,112 @@
+package backend
+
+import (
+    "context"
+    "time"
+
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+type etcd struct {
+    *clientv3.Client
+}
+
+//使用client访问etcd，client已设置namespace时同样生效
+func NewEtcd(client *clientv3.Client) Backend {
+    return &etcd{Client: client}
+}
+
+func (e *etcd) Now() time.Time {
+    return time.Now()
+}
+
+func (e *etcd) Get(ctx context.Context, key string, opts ...OpOption) (*clientv3.GetResponse, error) {
+    op := OpGet(key, opts...)
+    return e.Client.Get(ctx, key, op.options()...)
+}
+
+func (e *etcd) Put(ctx context.Context, key, val string, opts ...OpOption) (*clientv3.PutResponse, error) {
+    op := OpPut(key, val, opts...)
+    return e.Client.Put(ctx, key, val, op.options()...)
+}
+
+func (e *etcd) Delete(ctx context.Context, key string, opts ...OpOption) (*clientv3.DeleteResponse, error) {
+    op := OpDelete(key, opts...)
+    return e.Client.Delete(ctx, key, op.options()...)
+}
+
+func (e *etcd) Watch(ctx context.Context, key string, opts ...OpOption) clientv3.WatchChan {
+    op := OpGet(key, opts...)
+    return e.Client.Watch(ctx, key, op.options()...)
+}
+
+func (e *etcd) Txn(ctx context.Context) Txn {
+    return &etcdTxn{txn: e.Client.Txn(ctx)}
+}
+
+type etcdTxn struct {
+    txn clientv3.Txn
+}
+
+func (t *etcdTxn) If(cs ...clientv3.Cmp) Txn {
+    t.txn = t.txn.If(cs...)
+    return t
+}
+
+func (t *etcdTxn) Then(ops ...Op) Txn {
+    t.txn = t.txn.Then(toEtcdOps(ops)...)
+    return t
+}
+
+func (t *etcdTxn) Else(ops ...Op) Txn {
+    t.txn = t.txn.Else(toEtcdOps(ops)...)
+    return t
+}
+
+func (t *etcdTxn) Commit() (*clientv3.TxnResponse, error) {
+    return t.txn.Commit()
+}
+
+func toEtcdOps(ops []Op) []clientv3.Op {
+    result := make([]clientv3.Op, 0, len(ops))
+    for _, op := range ops {
+        switch op.t {
+        case tGet:
+            result = append(result, clientv3.OpGet(op.key, op.options()...))
+        case tPut:
+            result = append(result, clientv3.OpPut(op.key, op.val, op.options()...))
+        case tDelete:
+            result = append(result, clientv3.OpDelete(op.key, op.options()...))
+        }
+    }
+    return result
+}
+
+func (op Op) options() []clientv3.OpOption {
+    opts := make([]clientv3.OpOption, 0)
+    if op.prefix {
+        opts = append(opts, clientv3.WithPrefix())
+    }
+    if op.rev != 0 {
+        opts = append(opts, clientv3.WithRev(op.rev))
+    }
+    if op.lease != clientv3.NoLease {
+        opts = append(opts, clientv3.WithLease(op.lease))
+    }
+    if op.ignoreLease {
+        opts = append(opts, clientv3.WithIgnoreLease())
+    }
+    if op.prevKV {
+        opts = append(opts, clientv3.WithPrevKV())
+    }
+    if op.keysOnly {
+        opts = append(opts, clientv3.WithKeysOnly())
+    }
+    if op.countOnly {
+        opts = append(opts, clientv3.WithCountOnly())
+    }
+    if op.createdNotify {
+        opts = append(opts, clientv3.WithCreatedNotify())
+    }
+    return opts
+}
@@ -0,0 +1,655 @@
+package backend
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+    "github.com/coreos/etcd/mvcc/mvccpb"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+const MEMORY_FIRST_LEASE = 0x1000
+
+//进程内的Backend，用于不依赖etcd的单元测试
+//时间只在调用Advance时前进，到期的lease在Advance中回收，删除事件与etcd一致
+type Memory interface {
+    Backend
+    Advance(d time.Duration)
+    Compact(rev int64)
+}
+
+type memLease struct {
+    ttl    int64
+    expire time.Time
+    keys   map[string]bool
+}
+
+type memory struct {
+    sync.Mutex
+    now       time.Time
+    rev       int64
+    compacted int64
+    kvs       map[string]*mvccpb.KeyValue
+    leases    map[clientv3.LeaseID]*memLease
+    nextLease clientv3.LeaseID
+    history   []*clientv3.Event //所有修改，用于从指定revision开始的Watch和读取
+    watchers  map[*memWatcher]bool
+    closed    bool
+}
+
+func NewMemory() Memory {
+    return &memory{
+        now:       time.Now(),
+        rev:       1,
+        kvs:       make(map[string]*mvccpb.KeyValue),
+        leases:    make(map[clientv3.LeaseID]*memLease),
+        nextLease: MEMORY_FIRST_LEASE,
+        watchers:  make(map[*memWatcher]bool),
+    }
+}
+
+func (m *memory) Now() time.Time {
+    m.Lock()
+    defer m.Unlock()
+    return m.now
+}
+
+//时间前进d，回收到期的lease及其关联的key
+func (m *memory) Advance(d time.Duration) {
+    m.Lock()
+    defer m.Unlock()
+
+    m.now = m.now.Add(d)
+    expired := make([]clientv3.LeaseID, 0)
+    for id, l := range m.leases {
+        if !l.expire.After(m.now) {
+            expired = append(expired, id)
+        }
+    }
+    sort.Slice(expired, func(i, j int) bool {
+        return expired[i] < expired[j]
+    })
+
+    //与etcd相同，每个lease的key在一个revision中删除
+    for _, id := range expired {
+        m.revoke(id)
+    }
+}
+
+//与etcd相同，rev之前的历史不能再读取，从rev之前开始的Watch返回CompactRevision
+func (m *memory) Compact(rev int64) {
+    m.Lock()
+    defer m.Unlock()
+    if rev > m.compacted {
+        m.compacted = rev
+    }
+}
+
+func (m *memory) Close() error {
+    m.Lock()
+    defer m.Unlock()
+
+    m.closed = true
+    for w := range m.watchers {
+        w.stop()
+    }
+    return nil
+}
+
+func (m *memory) header() *pb.ResponseHeader {
+    return &pb.ResponseHeader{Revision: m.rev}
+}
+
+func (m *memory) check(ctx context.Context) error {
+    if m.closed {
+        return fmt.Errorf("Memory backend is closed")
+    }
+    return ctx.Err()
+}
+
+func (m *memory) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+    m.Lock()
+    defer m.Unlock()
+
+    if err := m.check(ctx); err != nil {
+        return nil, err
+    }
+
+    id := m.nextLease
+    m.nextLease++
+    m.leases[id] = &memLease{ttl: ttl, expire: m.now.Add(time.Duration(ttl) * time.Second), keys: make(map[string]bool)}
+    return &clientv3.LeaseGrantResponse{ResponseHeader: m.header(), ID: id, TTL: ttl}, nil
+}
+
+func (m *memory) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+    m.Lock()
+    defer m.Unlock()
+
+    if err := m.check(ctx); err != nil {
+        return nil, err
+    }
+    if _, ok := m.leases[id]; !ok {
+        return nil, rpctypes.ErrLeaseNotFound
+    }
+
+    m.revoke(id)
+    return &clientv3.LeaseRevokeResponse{Header: m.header()}, nil
+}
+
+func (m *memory) revoke(id clientv3.LeaseID) {
+    l := m.leases[id]
+    delete(m.leases, id)
+
+    keys := make([]string, 0, len(l.keys))
+    for key := range l.keys {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    w := m.begin()
+    for _, key := range keys {
+        w.delete(key)
+    }
+    w.commit()
+}
+
+func (m *memory) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+    m.Lock()
+    defer m.Unlock()
+
+    if err := m.check(ctx); err != nil {
+        return nil, err
+    }
+    l, ok := m.leases[id]
+    if !ok {
+        return nil, rpctypes.ErrLeaseNotFound
+    }
+
+    l.expire = m.now.Add(time.Duration(l.ttl) * time.Second)
+    return &clientv3.LeaseKeepAliveResponse{ResponseHeader: m.header(), ID: id, TTL: l.ttl}, nil
+}
+
+//lease不存在时TTL为-1，与etcd一致
+func (m *memory) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+    m.Lock()
+    defer m.Unlock()
+
+    if err := m.check(ctx); err != nil {
+        return nil, err
+    }
+
+    resp := &clientv3.LeaseTimeToLiveResponse{ResponseHeader: m.header(), ID: id, TTL: -1}
+    if l, ok := m.leases[id]; ok {
+        resp.TTL = int64((l.expire.Sub(m.now) + time.Second - 1) / time.Second)
+        resp.GrantedTTL = l.ttl
+        for key := range l.keys {
+            resp.Keys = append(resp.Keys, []byte(key))
+        }
+    }
+    return resp, nil
+}
+
+func (m *memory) Get(ctx context.Context, key string, opts ...OpOption) (*clientv3.GetResponse, error) {
+    m.Lock()
+    defer m.Unlock()
+
+    if err := m.check(ctx); err != nil {
+        return nil, err
+    }
+    resp, err := m.get(OpGet(key, opts...))
+    return (*clientv3.GetResponse)(resp), err
+}
+
+func (m *memory) Put(ctx context.Context, key, val string, opts ...OpOption) (*clientv3.PutResponse, error) {
+    resp, err := m.Txn(ctx).Then(OpPut(key, val, opts...)).Commit()
+    if err != nil {
+        return nil, err
+    }
+    return (*clientv3.PutResponse)(resp.Responses[0].GetResponsePut()), nil
+}
+
+func (m *memory) Delete(ctx context.Context, key string, opts ...OpOption) (*clientv3.DeleteResponse, error) {
+    resp, err := m.Txn(ctx).Then(OpDelete(key, opts...)).Commit()
+    if err != nil {
+        return nil, err
+    }
+    return (*clientv3.DeleteResponse)(resp.Responses[0].GetResponseDeleteRange()), nil
+}
+
+func (op Op) match(key string) bool {
+    if op.prefix {
+        return strings.HasPrefix(key, op.key)
+    }
+    return key == op.key
+}
+
+func (m *memory) get(op Op) (*pb.RangeResponse, error) {
+    kvs := m.kvs
+    if op.rev != 0 && op.rev < m.rev {
+        if op.rev < m.compacted {
+            return nil, rpctypes.ErrCompacted
+        }
+        kvs = m.snapshot(op.rev)
+    }
+
+    resp := &pb.RangeResponse{Header: m.header()}
+    for key, kv := range kvs {
+        if !op.match(key) {
+            continue
+        }
+
+        resp.Count++
+        if op.countOnly {
+            continue
+        }
+
+        kv := *kv
+        if op.keysOnly {
+            kv.Value = nil
+        }
+        resp.Kvs = append(resp.Kvs, &kv)
+    }
+
+    sort.Slice(resp.Kvs, func(i, j int) bool {
+        return bytes.Compare(resp.Kvs[i].Key, resp.Kvs[j].Key) < 0
+    })
+    return resp, nil
+}
+
+//按历史修改重建rev时的数据
+func (m *memory) snapshot(rev int64) map[string]*mvccpb.KeyValue {
+    kvs := make(map[string]*mvccpb.KeyValue)
+    for _, ev := range m.history {
+        if ev.Kv.ModRevision > rev {
+            break
+        }
+        if ev.Type == mvccpb.PUT {
+            kvs[string(ev.Kv.Key)] = ev.Kv
+        } else {
+            delete(kvs, string(ev.Kv.Key))
+        }
+    }
+    return kvs
+}
+
+func (m *memory) Txn(ctx context.Context) Txn {
+    return &memTxn{m: m, ctx: ctx}
+}
+
+type memTxn struct {
+    m     *memory
+    ctx   context.Context
+    cmps  []clientv3.Cmp
+    then  []Op
+    other []Op
+}
+
+func (t *memTxn) If(cs ...clientv3.Cmp) Txn {
+    t.cmps = append(t.cmps, cs...)
+    return t
+}
+
+func (t *memTxn) Then(ops ...Op) Txn {
+    t.then = append(t.then, ops...)
+    return t
+}
+
+func (t *memTxn) Else(ops ...Op) Txn {
+    t.other = append(t.other, ops...)
+    return t
+}
+
+func (t *memTxn) Commit() (*clientv3.TxnResponse, error) {
+    m := t.m
+    m.Lock()
+    defer m.Unlock()
+
+    if err := m.check(t.ctx); err != nil {
+        return nil, err
+    }
+
+    succeeded := true
+    for _, cmp := range t.cmps {
+        if !m.compare(pb.Compare(cmp)) {
+            succeeded = false
+            break
+        }
+    }
+
+    ops := t.then
+    if !succeeded {
+        ops = t.other
+    }
+
+    //先检查所有请求，失败时不做任何修改
+    for _, op := range ops {
+        if op.t != tPut {
+            continue
+        }
+        if op.ignoreLease {
+            if _, ok := m.kvs[op.key]; !ok {
+                return nil, rpctypes.ErrKeyNotFound
+            }
+        } else if _, ok := m.leases[op.lease]; op.lease != clientv3.NoLease && !ok {
+            return nil, rpctypes.ErrLeaseNotFound
+        }
+    }
+
+    w := m.begin()
+    responses := make([]*pb.ResponseOp, 0, len(ops))
+    for _, op := range ops {
+        switch op.t {
+        case tGet:
+            resp, err := m.get(op)
+            if err != nil {
+                return nil, err
+            }
+            responses = append(responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: resp}})
+        case tPut:
+            resp := &pb.PutResponse{PrevKv: w.put(op)}
+            if !op.prevKV {
+                resp.PrevKv = nil
+            }
+            responses = append(responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: resp}})
+        case tDelete:
+            resp := &pb.DeleteRangeResponse{}
+            for _, key := range m.keys(op) {
+                prev := w.delete(key)
+                resp.Deleted++
+                if op.prevKV {
+                    resp.PrevKvs = append(resp.PrevKvs, prev)
+                }
+            }
+            responses = append(responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: resp}})
+        }
+    }
+    w.commit()
+
+    //所有响应使用事务完成后的revision
+    header := m.header()
+    for _, r := range responses {
+        switch v := r.Response.(type) {
+        case *pb.ResponseOp_ResponseRange:
+            v.ResponseRange.Header = header
+        case *pb.ResponseOp_ResponsePut:
+            v.ResponsePut.Header = header
+        case *pb.ResponseOp_ResponseDeleteRange:
+            v.ResponseDeleteRange.Header = header
+        }
+    }
+    return &clientv3.TxnResponse{Header: header, Succeeded: succeeded, Responses: responses}, nil
+}
+
+func (m *memory) keys(op Op) []string {
+    keys := make([]string, 0)
+    for key := range m.kvs {
+        if op.match(key) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+//与etcd相同，范围比较要求范围内的每个key都满足条件，key不存在时与空的KeyValue比较
+func (m *memory) compare(c pb.Compare) bool {
+    kvs := make([]*mvccpb.KeyValue, 0)
+    for key, kv := range m.kvs {
+        if inRange(key, c.Key, c.RangeEnd) {
+            kvs = append(kvs, kv)
+        }
+    }
+
+    if len(kvs) == 0 {
+        if c.Target == pb.Compare_VALUE {
+            return false
+        }
+        kvs = append(kvs, &mvccpb.KeyValue{})
+    }
+
+    for _, kv := range kvs {
+        var result int
+        switch c.Target {
+        case pb.Compare_VALUE:
+            result = bytes.Compare(kv.Value, c.GetValue())
+        case pb.Compare_CREATE:
+            result = compareInt64(kv.CreateRevision, c.GetCreateRevision())
+        case pb.Compare_MOD:
+            result = compareInt64(kv.ModRevision, c.GetModRevision())
+        case pb.Compare_VERSION:
+            result = compareInt64(kv.Version, c.GetVersion())
+        case pb.Compare_LEASE:
+            result = compareInt64(kv.Lease, c.GetLease())
+        }
+
+        var ok bool
+        switch c.Result {
+        case pb.Compare_EQUAL:
+            ok = result == 0
+        case pb.Compare_NOT_EQUAL:
+            ok = result != 0
+        case pb.Compare_GREATER:
+            ok = result > 0
+        case pb.Compare_LESS:
+            ok = result < 0
+        }
+        if !ok {
+            return false
+        }
+    }
+    return true
+}
+
+func inRange(key string, begin, end []byte) bool {
+    if len(end) == 0 {
+        return key == string(begin)
+    }
+    if bytes.Compare([]byte(key), begin) < 0 {
+        return false
+    }
+    return (len(end) == 1 && end[0] == 0) || bytes.Compare([]byte(key), end) < 0
+}
+
+func compareInt64(a, b int64) int {
+    switch {
+    case a < b:
+        return -1
+    case a > b:
+        return 1
+    default:
+        return 0
+    }
+}
+
+//一次写操作中的所有修改使用同一个revision
+type memWrite struct {
+    m      *memory
+    rev    int64
+    events []*clientv3.Event
+}
+
+func (m *memory) begin() *memWrite {
+    return &memWrite{m: m, rev: m.rev + 1}
+}
+
+func (w *memWrite) put(op Op) *mvccpb.KeyValue {
+    m := w.m
+    kv := &mvccpb.KeyValue{Key: []byte(op.key), Value: []byte(op.val), CreateRevision: w.rev, ModRevision: w.rev, Version: 1, Lease: int64(op.lease)}
+    prev, ok := m.kvs[op.key]
+    if ok {
+        kv.CreateRevision = prev.CreateRevision
+        kv.Version = prev.Version + 1
+        if op.ignoreLease {
+            kv.Lease = prev.Lease
+        }
+        if l, ok := m.leases[clientv3.LeaseID(prev.Lease)]; ok {
+            delete(l.keys, op.key)
+        }
+    }
+    if l, ok := m.leases[clientv3.LeaseID(kv.Lease)]; ok {
+        l.keys[op.key] = true
+    }
+
+    m.kvs[op.key] = kv
+    w.events = append(w.events, &clientv3.Event{Type: mvccpb.PUT, Kv: kv, PrevKv: prev})
+    return prev
+}
+
+func (w *memWrite) delete(key string) *mvccpb.KeyValue {
+    m := w.m
+    prev := m.kvs[key]
+    delete(m.kvs, key)
+    if l, ok := m.leases[clientv3.LeaseID(prev.Lease)]; ok {
+        delete(l.keys, key)
+    }
+
+    kv := &mvccpb.KeyValue{Key: []byte(key), ModRevision: w.rev}
+    w.events = append(w.events, &clientv3.Event{Type: mvccpb.DELETE, Kv: kv, PrevKv: prev})
+    return prev
+}
+
+func (w *memWrite) commit() {
+    if len(w.events) == 0 {
+        return
+    }
+
+    m := w.m
+    m.rev = w.rev
+    m.history = append(m.history, w.events...)
+    for watcher := range m.watchers {
+        watcher.send(w.rev, w.events)
+    }
+}
+
+type memWatcher struct {
+    m      *memory
+    op     Op
+    ch     chan clientv3.WatchResponse
+    queue  []clientv3.WatchResponse
+    notify chan struct{}
+    done   chan struct{}
+}
+
+//与etcd相同，ctx结束后关闭返回的channel，起始revision已被压缩时返回CompactRevision后关闭
+func (m *memory) Watch(ctx context.Context, key string, opts ...OpOption) clientv3.WatchChan {
+    m.Lock()
+    defer m.Unlock()
+
+    w := &memWatcher{
+        m:      m,
+        op:     OpGet(key, opts...),
+        ch:     make(chan clientv3.WatchResponse),
+        notify: make(chan struct{}, 1),
+        done:   make(chan struct{}),
+    }
+
+    if m.closed {
+        close(w.ch)
+        return w.ch
+    }
+
+    if w.op.rev != 0 && w.op.rev < m.compacted {
+        w.queue = append(w.queue, clientv3.WatchResponse{Header: *m.header(), CompactRevision: m.compacted})
+        w.stop()
+    } else {
+        m.watchers[w] = true
+        if w.op.createdNotify {
+            w.queue = append(w.queue, clientv3.WatchResponse{Header: *m.header(), Created: true})
+        }
+
+        //按revision补发历史修改
+        if w.op.rev != 0 {
+            for i := 0; i < len(m.history); {
+                rev := m.history[i].Kv.ModRevision
+                j := i
+                for j < len(m.history) && m.history[j].Kv.ModRevision == rev {
+                    j++
+                }
+                if rev >= w.op.rev {
+                    w.send(rev, m.history[i:j])
+                }
+                i = j
+            }
+        }
+    }
+
+    go w.run(ctx)
+    return w.ch
+}
+
+//在memory加锁时调用
+func (w *memWatcher) send(rev int64, events []*clientv3.Event) {
+    resp := clientv3.WatchResponse{Header: pb.ResponseHeader{Revision: rev}}
+    for _, ev := range events {
+        if !w.op.match(string(ev.Kv.Key)) {
+            continue
+        }
+
+        ev := *ev
+        if !w.op.prevKV {
+            ev.PrevKv = nil
+        }
+        resp.Events = append(resp.Events, &ev)
+    }
+
+    if len(resp.Events) == 0 {
+        return
+    }
+    w.queue = append(w.queue, resp)
+    select {
+    case w.notify <- struct{}{}:
+    default:
+    }
+}
+
+//在memory加锁时调用，发送完已排队的响应后关闭channel
+func (w *memWatcher) stop() {
+    delete(w.m.watchers, w)
+    select {
+    case <-w.done:
+    default:
+        close(w.done)
+    }
+}
+
+func (w *memWatcher) run(ctx context.Context) {
+    defer close(w.ch)
+    for {
+        w.m.Lock()
+        var resp clientv3.WatchResponse
+        pending := len(w.queue) != 0
+        if pending {
+            resp = w.queue[0]
+            w.queue = w.queue[1:]
+        }
+        w.m.Unlock()
+
+        if pending {
+            select {
+            case w.ch <- resp:
+                continue
+            case <-ctx.Done():
+            }
+        } else {
+            select {
+            case <-w.notify:
+                continue
+            case <-w.done:
+                return
+            case <-ctx.Done():
+            }
+        }
+
+        w.m.Lock()
+        w.stop()
+        w.m.Unlock()
+        return
+    }
+}
@@ -0,0 +1,355 @@
+package backend
+
+import (
+    "context"
+    "etcdagent/agent/log"
+    "math"
+    "math/rand"
+    "sync"
+    "time"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    "github.com/etcd-io/etcd/clientv3"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+const (
+    RETRY_DEFAULT_ATTEMPTS        = 5
+    RETRY_DEFAULT_INITIAL_BACKOFF = 50 * time.Millisecond
+    RETRY_DEFAULT_MAX_BACKOFF     = time.Second
+    RETRY_DEFAULT_MULTIPLIER      = 2
+    RETRY_DEFAULT_JITTER          = 0.2
+)
+
+//MaxAttempts包括第一次请求，为1时不重试
+//第n次重试前等待InitialBackoff*Multiplier^(n-1)，不超过MaxBackoff，再随机增减Jitter比例
+type RetryPolicy struct {
+    MaxAttempts    int
+    InitialBackoff time.Duration
+    MaxBackoff     time.Duration
+    Multiplier     float64
+    Jitter         float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+    return RetryPolicy{
+        MaxAttempts:    RETRY_DEFAULT_ATTEMPTS,
+        InitialBackoff: RETRY_DEFAULT_INITIAL_BACKOFF,
+        MaxBackoff:     RETRY_DEFAULT_MAX_BACKOFF,
+        Multiplier:     RETRY_DEFAULT_MULTIPLIER,
+        Jitter:         RETRY_DEFAULT_JITTER,
+    }
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+    d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+    if d > float64(p.MaxBackoff) {
+        d = float64(p.MaxBackoff)
+    }
+    return time.Duration(d * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
+//单位为请求个数，Watch每次重新建立计一次重试，不计入Exhausted
+type RetryStats struct {
+    Retries   uint64 //重试次数
+    Recovered uint64 //重试后成功的请求
+    Exhausted uint64 //重试次数用尽或者剩余时间不足以等待下一次重试，最终失败的请求
+}
+
+type Retry interface {
+    Backend
+    RetryStats() RetryStats
+}
+
+type retry struct {
+    Backend
+    policy RetryPolicy
+    mu     sync.Mutex
+    stats  RetryStats
+}
+
+//etcd暂时不可用（如重新选主、网络闪断）时按policy重试，总时间不超过ctx的deadline
+//读和lease请求重复执行没有副作用，写请求只在确定未被etcd执行时重试，见retryable
+func NewRetry(b Backend, policy RetryPolicy) Retry {
+    return &retry{Backend: b, policy: policy}
+}
+
+func (r *retry) RetryStats() RetryStats {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.stats
+}
+
+func errorCode(err error) codes.Code {
+    if e, ok := err.(rpctypes.EtcdError); ok {
+        return e.Code()
+    }
+    return status.Code(err)
+}
+
+//Unavailable和请求过多可以重试，权限、参数、lease不存在、ctx结束等错误直接返回
+//写请求超时或者leader切换时可能已经执行，重试会使事务的比较条件失败，因此不重试
+func retryable(err error, write bool) bool {
+    switch errorCode(err) {
+    case codes.Unavailable:
+        if !write {
+            return true
+        }
+
+        switch err {
+        case rpctypes.ErrNoLeader, rpctypes.ErrNotCapable, rpctypes.ErrUnhealthy:
+            return true
+        }
+        desc := rpctypes.ErrorDesc(err)
+        return desc == "there is no address available" || desc == "there is no connection available"
+    case codes.ResourceExhausted:
+        return err == rpctypes.ErrTooManyRequests
+    }
+    return false
+}
+
+func (r *retry) count(f func(s *RetryStats)) {
+    r.mu.Lock()
+    f(&r.stats)
+    r.mu.Unlock()
+}
+
+func (r *retry) do(ctx context.Context, name string, write bool, f func() error) error {
+    for attempt := 1; ; attempt++ {
+        err := f()
+        if err == nil {
+            if attempt > 1 {
+                r.count(func(s *RetryStats) { s.Recovered++ })
+                log.Info("%v succeeded after %v attempts", name, attempt)
+            }
+            return nil
+        }
+
+        if !retryable(err, write) {
+            return err
+        }
+
+        delay := r.policy.backoff(attempt)
+        deadline, ok := ctx.Deadline()
+        if attempt >= r.policy.MaxAttempts || (ok && time.Until(deadline) < delay) {
+            r.count(func(s *RetryStats) { s.Exhausted++ })
+            log.Warn("%v failed after %v attempts, reason: %v", name, attempt, err.Error())
+            return err
+        }
+
+        r.count(func(s *RetryStats) { s.Retries++ })
+        log.Warn("%v error, retry after %v, attempt: %v, reason: %v", name, delay, attempt, err.Error())
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(delay):
+        }
+    }
+}
+
+func (r *retry) Get(ctx context.Context, key string, opts ...OpOption) (*clientv3.GetResponse, error) {
+    var resp *clientv3.GetResponse
+    err := r.do(ctx, "Get "+key, false, func() (err error) {
+        resp, err = r.Backend.Get(ctx, key, opts...)
+        return err
+    })
+    return resp, err
+}
+
+func (r *retry) Put(ctx context.Context, key, val string, opts ...OpOption) (*clientv3.PutResponse, error) {
+    var resp *clientv3.PutResponse
+    err := r.do(ctx, "Put "+key, true, func() (err error) {
+        resp, err = r.Backend.Put(ctx, key, val, opts...)
+        return err
+    })
+    return resp, err
+}
+
+func (r *retry) Delete(ctx context.Context, key string, opts ...OpOption) (*clientv3.DeleteResponse, error) {
+    var resp *clientv3.DeleteResponse
+    err := r.do(ctx, "Delete "+key, true, func() (err error) {
+        resp, err = r.Backend.Delete(ctx, key, opts...)
+        return err
+    })
+    return resp, err
+}
+
+func (r *retry) Txn(ctx context.Context) Txn {
+    return &retryTxn{r: r, ctx: ctx}
+}
+
+//重试时使用相同的条件和操作重新提交
+type retryTxn struct {
+    r     *retry
+    ctx   context.Context
+    cmps  []clientv3.Cmp
+    thens []Op
+    elses []Op
+}
+
+func (t *retryTxn) If(cs ...clientv3.Cmp) Txn {
+    t.cmps = append(t.cmps, cs...)
+    return t
+}
+
+func (t *retryTxn) Then(ops ...Op) Txn {
+    t.thens = append(t.thens, ops...)
+    return t
+}
+
+func (t *retryTxn) Else(ops ...Op) Txn {
+    t.elses = append(t.elses, ops...)
+    return t
+}
+
+func (t *retryTxn) Commit() (*clientv3.TxnResponse, error) {
+    write := false
+    for _, op := range append(t.thens[:len(t.thens):len(t.thens)], t.elses...) {
+        write = write || op.t != tGet
+    }
+
+    var resp *clientv3.TxnResponse
+    err := t.r.do(t.ctx, "Txn", write, func() (err error) {
+        resp, err = t.r.Backend.Txn(t.ctx).If(t.cmps...).Then(t.thens...).Else(t.elses...).Commit()
+        return err
+    })
+    return resp, err
+}
+
+//重复申请时多出的lease到期后自动回收
+func (r *retry) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+    var resp *clientv3.LeaseGrantResponse
+    err := r.do(ctx, "Lease grant", false, func() (err error) {
+        resp, err = r.Backend.Grant(ctx, ttl)
+        return err
+    })
+    return resp, err
+}
+
+func (r *retry) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+    var resp *clientv3.LeaseRevokeResponse
+    err := r.do(ctx, "Lease revoke", false, func() (err error) {
+        resp, err = r.Backend.Revoke(ctx, id)
+        return err
+    })
+    return resp, err
+}
+
+func (r *retry) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+    var resp *clientv3.LeaseTimeToLiveResponse
+    err := r.do(ctx, "Lease ttl", false, func() (err error) {
+        resp, err = r.Backend.TimeToLive(ctx, id, opts...)
+        return err
+    })
+    return resp, err
+}
+
+func (r *retry) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+    var resp *clientv3.LeaseKeepAliveResponse
+    err := r.do(ctx, "Lease keepalive", false, func() (err error) {
+        resp, err = r.Backend.KeepAliveOnce(ctx, id)
+        return err
+    })
+    return resp, err
+}
+
+//Watch中断时从最后收到的revision之后重新建立，收到响应后重新计算等待时间
+//Watch长期存在，不受MaxAttempts限制，一直重试到ctx结束
+//压缩和不可重试的错误转发后关闭channel，与etcd一致
+func (r *retry) Watch(ctx context.Context, key string, opts ...OpOption) clientv3.WatchChan {
+    wChan := make(chan clientv3.WatchResponse)
+    go r.watch(ctx, key, opts, wChan)
+    return wChan
+}
+
+func (r *retry) watch(ctx context.Context, key string, opts []OpOption, wChan chan<- clientv3.WatchResponse) {
+    defer close(wChan)
+
+    //内部总是要求Created通知以记录建立时的revision，调用者没有要求时不转发
+    notify := OpGet(key, opts...).createdNotify
+    opts = append(opts[:len(opts):len(opts)], WithCreatedNotify())
+
+    var last int64
+    var err error
+    for attempt := 1; ; attempt++ {
+        wopts := opts
+        if last != 0 {
+            wopts = append(opts[:len(opts):len(opts)], WithRev(last+1))
+        }
+
+        var done bool
+        start := last
+        if done, err = r.forward(ctx, key, wopts, notify, attempt > 1, &last, wChan); done {
+            return
+        }
+
+        if last != start {
+            attempt = 1
+        }
+
+        reason := "watch closed"
+        if err != nil {
+            reason = err.Error()
+        }
+
+        delay := r.policy.backoff(attempt)
+        r.count(func(s *RetryStats) { s.Retries++ })
+        log.Warn("Watch %v error, retry from revision %v after %v, attempt: %v, reason: %v", key, last+1, delay, attempt, reason)
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(delay):
+        }
+    }
+}
+
+//转发一次Watch的响应，done为true时不再重试；last更新为最后转发的revision
+func (r *retry) forward(ctx context.Context, key string, opts []OpOption, notify bool, rewatch bool, last *int64,
+    wChan chan<- clientv3.WatchResponse) (bool, error) {
+    wctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    start := *last
+    for wResp := range r.Backend.Watch(wctx, key, opts...) {
+        err := wResp.Err()
+        if err != nil && wResp.CompactRevision == 0 && retryable(err, false) {
+            return false, err
+        }
+
+        //没有指定revision时从建立时的revision之后继续，收到第一个事件前中断也不会遗漏
+        //重新建立时不再通知Created
+        if wResp.Created && err == nil {
+            if *last == 0 && OpGet(key, opts...).rev == 0 {
+                *last = wResp.Header.Revision
+                start = *last
+            }
+            if rewatch || !notify {
+                continue
+            }
+        }
+
+        select {
+        case wChan <- wResp:
+        case <-ctx.Done():
+            return true, nil
+        }
+
+        if err != nil {
+            return true, nil
+        }
+
+        //历史事件可能分多次返回，header中的revision不一定已经全部发送
+        for _, ev := range wResp.Events {
+            if ev.Kv.ModRevision > *last {
+                *last = ev.Kv.ModRevision
+            }
+        }
+        if rewatch && *last != start {
+            rewatch = false
+            r.count(func(s *RetryStats) { s.Recovered++ })
+            log.Info("Watch %v recovered from revision %v", key, start+1)
+        }
+    }
+    return ctx.Err() != nil, nil
+}
@@ -0,0 +1,290 @@
+package barrier
+
+import (
+    "context"
+    "etcdagent/agent/log"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/coreos/etcd/mvcc/mvccpb"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+const (
+    BARRIER_PREFIX          = "/CoreNet/Barrier/"
+    BARRIER_READY           = "ready"
+    BARRIER_DEFAULT_TTL     = 5
+    BARRIER_DEFAULT_TIMEOUT = 2 * time.Second
+)
+
+//双重屏障：所有参与者Enter后才能一起进入下一阶段，所有参与者Leave后才能一起离开
+type Barrier interface {
+    BarrierEnter(name string, nodeId uint32, count int, timeout time.Duration) error
+    BarrierLeave(name string, nodeId uint32, timeout time.Duration) error
+    BarrierSetTTL(ttl int64)
+}
+
+type member struct {
+    lease  clientv3.LeaseID
+    cancel context.CancelFunc
+}
+
+type barrier struct {
+    sync.Mutex
+    client  *clientv3.Client
+    members map[string]*member //key: /CoreNet/Barrier/<name>/<nodeId>
+    ttl     int64
+}
+
+func NewBarrier(client *clientv3.Client) Barrier {
+    return &barrier{
+        client:  client,
+        members: make(map[string]*member),
+        ttl:     BARRIER_DEFAULT_TTL,
+    }
+}
+
+func checkName(name string) error {
+    if name == "" || strings.Contains(name, "/") {
+        return fmt.Errorf("Invalid barrier name: %q", name)
+    }
+    return nil
+}
+
+func barrierPrefix(name string) string {
+    return fmt.Sprintf("%s%s/", BARRIER_PREFIX, name)
+}
+
+func barrierKey(name string, nodeId uint32) string {
+    return fmt.Sprintf("%s%v", barrierPrefix(name), nodeId)
+}
+
+//timeout为0时使用BARRIER_DEFAULT_TIMEOUT
+func withTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+    if timeout == 0 {
+        timeout = BARRIER_DEFAULT_TIMEOUT
+    }
+    return context.WithTimeout(context.Background(), timeout)
+}
+
+//参与者数量达到count后写入，所有参与者离开后删除
+func readyKey(name string) string {
+    return barrierPrefix(name) + BARRIER_READY
+}
+
+//写入自己的key，等待ready key，超时返回context.DeadlineExceeded
+//写入后参与者数量达到count的一方写ready key，其他参与者离开后仍然保留，不会错过
+func (b *barrier) BarrierEnter(name string, nodeId uint32, count int, timeout time.Duration) error {
+    if err := checkName(name); err != nil {
+        return err
+    }
+    if count <= 0 {
+        return fmt.Errorf("Invalid barrier count: %v", count)
+    }
+
+    ctx, cancel := withTimeout(timeout)
+    defer cancel()
+
+    key := barrierKey(name, nodeId)
+    b.Lock()
+    if _, ok := b.members[key]; ok {
+        b.Unlock()
+        return fmt.Errorf("Barrier enter error, node: %v already entered barrier: %v", nodeId, name)
+    }
+    ttl := b.ttl
+    b.Unlock()
+
+    var err error
+    var grantResp *clientv3.LeaseGrantResponse
+    if grantResp, err = b.client.Grant(ctx, ttl); err != nil {
+        log.Warn("Lease grant error, barrier: %v, nodeId: %v, reason: %v\n", name, nodeId, err.Error())
+        return err
+    }
+
+    //屏障期间由agent自动保活，进程退出后key随lease删除
+    kctx, kcancel := context.WithCancel(context.Background())
+    var kaChan <-chan *clientv3.LeaseKeepAliveResponse
+    if kaChan, err = b.client.KeepAlive(kctx, grantResp.ID); err != nil {
+        kcancel()
+        b.revoke(grantResp.ID)
+        return err
+    }
+
+    go func() {
+        for range kaChan {
+        }
+    }()
+
+    //写入自己的key并在同一个事务中读取参与者，数量达到count的一方唯一确定
+    prefix := barrierPrefix(name)
+    var txnResp *clientv3.TxnResponse
+    txnResp, err = b.client.Txn(ctx).Then(
+        clientv3.OpPut(key, "", clientv3.WithLease(grantResp.ID)),
+        clientv3.OpGet(prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())).Commit()
+    if err != nil {
+        kcancel()
+        b.revoke(grantResp.ID)
+        log.Warn("Put %v error, reason: %v", key, err.Error())
+        return err
+    }
+
+    b.Lock()
+    b.members[key] = &member{lease: grantResp.ID, cancel: kcancel}
+    b.Unlock()
+
+    rev := txnResp.Header.Revision
+    if countMembers(prefix, txnResp.Responses[1].GetResponseRange().Kvs) >= count {
+        if _, err = b.client.Put(ctx, readyKey(name), ""); err != nil {
+            b.remove(key)
+            log.Warn("Put %v error, reason: %v", readyKey(name), err.Error())
+            return err
+        }
+    }
+
+    //上一轮残留的ready key早于自己的key写入，不能作为本轮的条件
+    if _, err = b.wait(ctx, name, func(n int, ready int64) bool { return ready > rev }); err != nil {
+        b.remove(key)
+        return err
+    }
+
+    log.Info("Barrier enter, barrier: %v, nodeId: %v, count: %v", name, nodeId, count)
+    return nil
+}
+
+//删除自己的key，等待所有参与者离开
+func (b *barrier) BarrierLeave(name string, nodeId uint32, timeout time.Duration) error {
+    if err := checkName(name); err != nil {
+        return err
+    }
+
+    ctx, cancel := withTimeout(timeout)
+    defer cancel()
+
+    key := barrierKey(name, nodeId)
+    b.Lock()
+    _, ok := b.members[key]
+    b.Unlock()
+    if !ok {
+        return fmt.Errorf("Barrier leave error, node: %v not in barrier: %v", nodeId, name)
+    }
+
+    b.remove(key)
+    rev, err := b.wait(ctx, name, func(n int, ready int64) bool { return n == 0 })
+    if err != nil {
+        return err
+    }
+
+    //新一轮的参与者可能已经写入ready key，只删除本轮的
+    ready := readyKey(name)
+    if _, err = b.client.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(ready), "<", rev+1)).
+        Then(clientv3.OpDelete(ready)).Commit(); err != nil {
+        log.Warn("Delete %v error, reason: %v", ready, err.Error())
+        return err
+    }
+
+    log.Info("Barrier leave, barrier: %v, nodeId: %v", name, nodeId)
+    return nil
+}
+
+func (b *barrier) BarrierSetTTL(ttl int64) {
+    b.Lock()
+    defer b.Unlock()
+
+    b.ttl = ttl
+    log.Info("Set barrier ttl = %v", ttl)
+}
+
+func (b *barrier) remove(key string) {
+    b.Lock()
+    m, ok := b.members[key]
+    delete(b.members, key)
+    b.Unlock()
+
+    if ok {
+        m.cancel()
+        b.revoke(m.lease)
+    }
+}
+
+func (b *barrier) revoke(lease clientv3.LeaseID) {
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    if _, err := b.client.Revoke(ctx, lease); err != nil {
+        log.Warn("Revoke lease: %v error, reason: %v\n", lease, err.Error())
+    }
+}
+
+func countMembers(prefix string, kvs []*mvccpb.KeyValue) int {
+    n := 0
+    for _, kv := range kvs {
+        if _, err := strconv.ParseUint(strings.TrimPrefix(string(kv.Key), prefix), 10, 32); err == nil {
+            n++
+        }
+    }
+    return n
+}
+
+//先Get当前参与者和ready key，再从下一个revision开始Watch，每个事件后重新判断条件
+//done的参数为参与者数量和ready key的ModRevision（不存在时为0），返回条件满足时的revision
+func (b *barrier) wait(ctx context.Context, name string, done func(int, int64) bool) (int64, error) {
+    prefix := barrierPrefix(name)
+    resp, err := b.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+    if err != nil {
+        return 0, err
+    }
+
+    var ready int64
+    members := make(map[string]bool)
+    for _, kv := range resp.Kvs {
+        if string(kv.Key) == readyKey(name) {
+            ready = kv.ModRevision
+        } else if countMembers(prefix, []*mvccpb.KeyValue{kv}) == 1 {
+            members[string(kv.Key)] = true
+        }
+    }
+
+    if done(len(members), ready) {
+        return resp.Header.Revision, nil
+    }
+
+    wChan := b.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+    for {
+        select {
+        case <-ctx.Done():
+            log.Warn("Wait for barrier: %v timeout, members: %v", name, len(members))
+            return 0, ctx.Err()
+        case wResp, ok := <-wChan:
+            if !ok {
+                return 0, ctx.Err()
+            }
+
+            if err := wResp.Err(); err != nil {
+                return 0, err
+            }
+
+            //同一个响应中可能先满足后又不满足，逐个事件判断
+            for _, ev := range wResp.Events {
+                key := string(ev.Kv.Key)
+                switch {
+                case key == readyKey(name) && ev.Type == mvccpb.DELETE:
+                    ready = 0
+                case key == readyKey(name):
+                    ready = ev.Kv.ModRevision
+                case countMembers(prefix, []*mvccpb.KeyValue{ev.Kv}) == 0:
+                    continue
+                case ev.Type == mvccpb.DELETE:
+                    delete(members, key)
+                default:
+                    members[key] = true
+                }
+
+                if done(len(members), ready) {
+                    return ev.Kv.ModRevision, nil
+                }
+            }
+        }
+    }
+}
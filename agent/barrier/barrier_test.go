@@ -0,0 +1,89 @@
+package barrier
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+const ETCDADDR = "172.100.1.239:2379"
+
+func TestBarrier(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    barrier := NewBarrier(client)
+    nodes := []uint32{1, 2, 3}
+    var wg sync.WaitGroup
+    for _, nodeId := range nodes {
+        wg.Add(1)
+        go func(nodeId uint32) {
+            defer wg.Done()
+            <-time.After(time.Duration(nodeId) * 200 * time.Millisecond)
+            if err := barrier.BarrierEnter("phase", nodeId, len(nodes), 3*time.Second); err != nil {
+                t.Errorf("Barrier enter error, node: %v, reason: %v", nodeId, err.Error())
+            }
+
+            <-time.After(time.Duration(nodeId) * 200 * time.Millisecond)
+            if err := barrier.BarrierLeave("phase", nodeId, 3*time.Second); err != nil {
+                t.Errorf("Barrier leave error, node: %v, reason: %v", nodeId, err.Error())
+            }
+        }(nodeId)
+    }
+    wg.Wait()
+
+    //最后进入的参与者立即离开，等待中的参与者仍然可以进入
+    errs := make(chan error, 1)
+    go func() {
+        errs <- barrier.BarrierEnter("fast", 1, 2, 3*time.Second)
+    }()
+    <-time.After(200 * time.Millisecond)
+    if err := barrier.BarrierEnter("fast", 2, 2, 3*time.Second); err != nil {
+        t.Errorf("Barrier enter error, node: 2, reason: %v", err.Error())
+    }
+    leave := make(chan error, 1)
+    go func() {
+        leave <- barrier.BarrierLeave("fast", 2, 3*time.Second)
+    }()
+    if err := <-errs; err != nil {
+        t.Errorf("Test barrier fast leave failed, expected = nil, acctually = %v", err)
+    }
+    if err := barrier.BarrierLeave("fast", 1, 3*time.Second); err != nil {
+        t.Errorf("Barrier leave error, node: 1, reason: %v", err.Error())
+    }
+    if err := <-leave; err != nil {
+        t.Errorf("Barrier leave error, node: 2, reason: %v", err.Error())
+    }
+
+    //timeout为0时使用默认超时
+    if err := barrier.BarrierEnter("default", 1, 1, 0); err != nil {
+        t.Errorf("Barrier enter with default timeout error, reason: %v", err.Error())
+    }
+    if err := barrier.BarrierLeave("default", 1, 0); err != nil {
+        t.Errorf("Barrier leave with default timeout error, reason: %v", err.Error())
+    }
+
+    if err := barrier.BarrierEnter("invalid", 1, 0, time.Second); err == nil {
+        t.Errorf("Test barrier count failed, expected error, acctually = nil")
+    }
+
+    //参与者不足时超时
+    if err := barrier.BarrierEnter("timeout", 1, 2, 500*time.Millisecond); err != context.DeadlineExceeded {
+        t.Errorf("Test barrier failed, expected = %v, acctually = %v", context.DeadlineExceeded, err)
+    }
+    client.Close()
+}
@@ -0,0 +1,314 @@
+package agent
+
+import (
+    "encoding/json"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/barrier"
+    "etcdagent/agent/event"
+    "etcdagent/agent/lock"
+    "etcdagent/agent/ms"
+    "etcdagent/agent/node"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "sigs.k8s.io/yaml"
+)
+
+const (
+    ETCD_CONFIG_ENV     = "ETCD_AGENT_CONFIG"
+    ETCD_DEFAULT_SOCKET = "/var/run/etcdagent.sock"
+    MAX_SOCKET_LENGTH   = 107 //sockaddr_un.sun_path长度减去结尾的'\0'
+)
+
+//时间配置支持 "5s"、"500ms" 等格式，也支持整数秒
+type Duration struct {
+    time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+    var v interface{}
+    if err := json.Unmarshal(b, &v); err != nil {
+        return err
+    }
+
+    switch value := v.(type) {
+    case float64:
+        d.Duration = time.Duration(value * float64(time.Second))
+    case string:
+        duration, err := time.ParseDuration(value)
+        if err != nil {
+            return fmt.Errorf("invalid duration %q, expected a value like \"5s\" or \"500ms\"", value)
+        }
+        d.Duration = duration
+    default:
+        return fmt.Errorf("invalid duration %v, expected a string like \"5s\" or a number of seconds", v)
+    }
+    return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(d.String())
+}
+
+//权限配置支持 "0660" 格式的八进制字符串，也支持整数
+type FileMode uint32
+
+func (m *FileMode) UnmarshalJSON(b []byte) error {
+    var v interface{}
+    if err := json.Unmarshal(b, &v); err != nil {
+        return err
+    }
+
+    switch value := v.(type) {
+    case float64:
+        *m = FileMode(value)
+    case string:
+        mode, err := strconv.ParseUint(value, 8, 32)
+        if err != nil {
+            return fmt.Errorf("invalid mode %q, expected an octal value like \"0660\"", value)
+        }
+        *m = FileMode(mode)
+    default:
+        return fmt.Errorf("invalid mode %v, expected an octal string like \"0660\"", v)
+    }
+    return nil
+}
+
+func (m FileMode) MarshalJSON() ([]byte, error) {
+    return json.Marshal(fmt.Sprintf("%04o", uint32(m)))
+}
+
+//TTL的单位均为秒，Timeout为调用者没有指定deadline时每次请求的超时，为0时不限制
+type NodeConfig struct {
+    TTL     int64    `json:"ttl"`
+    IdGrace int64    `json:"idGrace"`
+    Timeout Duration `json:"timeout"`
+}
+
+type MSConfig struct {
+    TTL          int64    `json:"ttl"`
+    Preempt      bool     `json:"preempt"`
+    PreemptDelay Duration `json:"preemptDelay"`
+    Timeout      Duration `json:"timeout"`
+}
+
+type LockConfig struct {
+    TTL int64 `json:"ttl"`
+}
+
+type BarrierConfig struct {
+    TTL int64 `json:"ttl"`
+}
+
+//etcd暂时不可用时node、ms和event请求的重试策略，MaxAttempts包括第一次请求，为1时不重试
+//每次等待时间为上一次的Multiplier倍，不超过MaxBackoff，并随机增减Jitter比例
+type RetryConfig struct {
+    MaxAttempts    int      `json:"maxAttempts"`
+    InitialBackoff Duration `json:"initialBackoff"`
+    MaxBackoff     Duration `json:"maxBackoff"`
+    Multiplier     float64  `json:"multiplier"`
+    Jitter         float64  `json:"jitter"`
+}
+
+func (c RetryConfig) Policy() backend.RetryPolicy {
+    return backend.RetryPolicy{
+        MaxAttempts:    c.MaxAttempts,
+        InitialBackoff: c.InitialBackoff.Duration,
+        MaxBackoff:     c.MaxBackoff.Duration,
+        Multiplier:     c.Multiplier,
+        Jitter:         c.Jitter,
+    }
+}
+
+//MQ已存在时沿用原有属性，超过系统限制时按系统限制创建
+//队列满时最多等待SendTimeout，之后按Overflow策略丢弃事件
+type MqConfig struct {
+    Name        string   `json:"name"`
+    MaxMsg      int64    `json:"maxMsg"`
+    MsgSize     int64    `json:"msgSize"`
+    Mode        FileMode `json:"mode"`
+    Overflow    string   `json:"overflow"`
+    SendTimeout Duration `json:"sendTimeout"`
+}
+
+//记录已发送的事件，消费者重启后通过EtcdEventsSince补齐，Path为空时不记录
+type JournalConfig struct {
+    Path    string `json:"path"`
+    MaxSize int64  `json:"maxSize"`
+}
+
+//本机进程通过Unix socket访问agent守护进程
+type IpcConfig struct {
+    Socket string `json:"socket"`
+}
+
+//gRPC服务地址，unix:<路径> 或者 <host>:<port>，为空时不启动
+type RpcConfig struct {
+    Address string `json:"address"`
+}
+
+//agent配置文件，支持YAML和JSON格式
+type AgentConfig struct {
+    Endpoints   []string      `json:"endpoints"`
+    DialTimeout Duration      `json:"dialTimeout"`
+    Namespace   string        `json:"namespace"` //所有key的前缀，用于多套系统共用一个etcd集群
+    Node        NodeConfig    `json:"node"`
+    MS          MSConfig      `json:"ms"`
+    Lock        LockConfig    `json:"lock"`
+    Barrier     BarrierConfig `json:"barrier"`
+    Retry       RetryConfig   `json:"retry"`
+    Mq          MqConfig      `json:"mqueue"`
+    Journal     JournalConfig `json:"journal"`
+    Ipc         IpcConfig     `json:"ipc"`
+    Rpc         RpcConfig     `json:"rpc"`
+}
+
+func DefaultConfig() *AgentConfig {
+    return &AgentConfig{
+        Endpoints:   []string{ETCD_DEFAULT_ADDR},
+        DialTimeout: Duration{ETCD_DEFAULT_TIMEOUT * time.Second},
+        Node: NodeConfig{
+            TTL:     node.NODE_DEFAULT_TTL,
+            IdGrace: node.NODE_ID_DEFAULT_GRACE,
+            Timeout: Duration{node.NODE_DEFAULT_TIMEOUT},
+        },
+        MS: MSConfig{
+            TTL:     ms.MS_DEFAULT_TTL,
+            Timeout: Duration{ms.MS_DEFAULT_TIMEOUT},
+        },
+        Lock: LockConfig{
+            TTL: lock.LOCK_DEFAULT_TTL,
+        },
+        Barrier: BarrierConfig{
+            TTL: barrier.BARRIER_DEFAULT_TTL,
+        },
+        Retry: RetryConfig{
+            MaxAttempts:    backend.RETRY_DEFAULT_ATTEMPTS,
+            InitialBackoff: Duration{backend.RETRY_DEFAULT_INITIAL_BACKOFF},
+            MaxBackoff:     Duration{backend.RETRY_DEFAULT_MAX_BACKOFF},
+            Multiplier:     backend.RETRY_DEFAULT_MULTIPLIER,
+            Jitter:         backend.RETRY_DEFAULT_JITTER,
+        },
+        Mq: MqConfig{
+            Name:     event.MQ_DEFAULT_NAME,
+            MaxMsg:   event.MQ_DEFAULT_MAXMSG,
+            MsgSize:  event.MQ_DEFAULT_MSGSIZE,
+            Mode:     event.MQ_DEFAULT_MODE,
+            Overflow: event.MQ_OVERFLOW_RESYNC,
+        },
+        Journal: JournalConfig{
+            MaxSize: event.JOURNAL_DEFAULT_MAXSIZE,
+        },
+        Ipc: IpcConfig{
+            Socket: ETCD_DEFAULT_SOCKET,
+        },
+    }
+}
+
+//读取配置文件，未配置的字段使用默认值，环境变量优先于配置文件
+func LoadConfig(path string) (*AgentConfig, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("read agent config %v error: %v", path, err)
+    }
+
+    conf := DefaultConfig()
+    if err = yaml.UnmarshalStrict(data, conf); err != nil {
+        return nil, fmt.Errorf("parse agent config %v error: %v", path, err)
+    }
+
+    if err = conf.ApplyEnv(); err != nil {
+        return nil, fmt.Errorf("agent config %v: %v", path, err)
+    }
+
+    if err = conf.Validate(); err != nil {
+        return nil, fmt.Errorf("agent config %v: %v", path, err)
+    }
+    return conf, nil
+}
+
+//ETCD_NODES：逗号分隔的etcd地址，ETCD_TIMEOUT：连接超时秒数
+func (c *AgentConfig) ApplyEnv() error {
+    if nodes := os.Getenv("ETCD_NODES"); nodes != "" {
+        c.Endpoints = strings.Split(nodes, ",")
+    }
+
+    if timeout := os.Getenv("ETCD_TIMEOUT"); timeout != "" {
+        seconds, err := strconv.Atoi(timeout)
+        if err != nil || seconds <= 0 {
+            return fmt.Errorf("ETCD_TIMEOUT must be a positive number of seconds, got %q", timeout)
+        }
+        c.DialTimeout = Duration{time.Duration(seconds) * time.Second}
+    }
+    return nil
+}
+
+//一次返回所有错误，便于一次修改完配置
+func (c *AgentConfig) Validate() error {
+    errs := make([]string, 0)
+    check := func(ok bool, format string, args ...interface{}) {
+        if !ok {
+            errs = append(errs, fmt.Sprintf(format, args...))
+        }
+    }
+
+    check(len(c.Endpoints) != 0, "endpoints: must not be empty")
+    for i, endpoint := range c.Endpoints {
+        _, _, err := net.SplitHostPort(strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://"))
+        check(err == nil, "endpoints[%v]: %q is not a valid host:port address", i, endpoint)
+    }
+
+    check(c.DialTimeout.Duration > 0, "dialTimeout: must be positive, got %v", c.DialTimeout)
+    check(c.Namespace == "" || (strings.HasPrefix(c.Namespace, "/") && !strings.HasSuffix(c.Namespace, "/")),
+        "namespace: %q must start with '/' and must not end with '/'", c.Namespace)
+    check(c.Node.TTL >= 1, "node.ttl: must be at least 1 second, got %v", c.Node.TTL)
+    check(c.Node.IdGrace >= 1, "node.idGrace: must be at least 1 second, got %v", c.Node.IdGrace)
+    check(c.Node.Timeout.Duration >= 0, "node.timeout: must not be negative, got %v", c.Node.Timeout)
+    check(c.MS.TTL >= 1, "ms.ttl: must be at least 1 second, got %v", c.MS.TTL)
+    check(c.MS.PreemptDelay.Duration >= 0, "ms.preemptDelay: must not be negative, got %v", c.MS.PreemptDelay)
+    check(c.MS.Timeout.Duration >= 0, "ms.timeout: must not be negative, got %v", c.MS.Timeout)
+    check(c.Lock.TTL >= 1, "lock.ttl: must be at least 1 second, got %v", c.Lock.TTL)
+    check(c.Barrier.TTL >= 1, "barrier.ttl: must be at least 1 second, got %v", c.Barrier.TTL)
+
+    check(c.Retry.MaxAttempts >= 1, "retry.maxAttempts: must be at least 1, got %v", c.Retry.MaxAttempts)
+    check(c.Retry.InitialBackoff.Duration > 0, "retry.initialBackoff: must be positive, got %v", c.Retry.InitialBackoff)
+    check(c.Retry.MaxBackoff.Duration >= c.Retry.InitialBackoff.Duration, "retry.maxBackoff: must not be less than initialBackoff, got %v",
+        c.Retry.MaxBackoff)
+    check(c.Retry.Multiplier >= 1, "retry.multiplier: must be at least 1, got %v", c.Retry.Multiplier)
+    check(c.Retry.Jitter >= 0 && c.Retry.Jitter <= 1, "retry.jitter: must be between 0 and 1, got %v", c.Retry.Jitter)
+
+    check(strings.HasPrefix(c.Mq.Name, "/") && !strings.Contains(c.Mq.Name[1:], "/"),
+        "mqueue.name: %q must start with '/' and contain no other '/'", c.Mq.Name)
+    check(len(c.Mq.Name) <= event.MQ_MAX_NAMELENGTH, "mqueue.name: longer than %v characters", event.MQ_MAX_NAMELENGTH)
+    check(c.Mq.MaxMsg >= 1, "mqueue.maxMsg: must be at least 1, got %v", c.Mq.MaxMsg)
+    check(c.Mq.MsgSize >= event.MQ_MIN_MSGSIZE, "mqueue.msgSize: must be at least %v bytes to hold one event, got %v",
+        event.MQ_MIN_MSGSIZE, c.Mq.MsgSize)
+    check(c.Mq.Mode != 0 && c.Mq.Mode <= 0777, "mqueue.mode: must be between 0001 and 0777, got %04o", uint32(c.Mq.Mode))
+    check(c.Mq.Overflow == event.MQ_OVERFLOW_DROP_NEWEST || c.Mq.Overflow == event.MQ_OVERFLOW_DROP_OLDEST ||
+        c.Mq.Overflow == event.MQ_OVERFLOW_RESYNC, "mqueue.overflow: must be %v, %v or %v, got %q",
+        event.MQ_OVERFLOW_DROP_NEWEST, event.MQ_OVERFLOW_DROP_OLDEST, event.MQ_OVERFLOW_RESYNC, c.Mq.Overflow)
+    check(c.Mq.SendTimeout.Duration >= 0, "mqueue.sendTimeout: must not be negative, got %v", c.Mq.SendTimeout)
+
+    check(c.Journal.Path == "" || strings.HasPrefix(c.Journal.Path, "/"), "journal.path: %q must be an absolute path", c.Journal.Path)
+    check(c.Journal.MaxSize >= event.JOURNAL_MIN_MAXSIZE, "journal.maxSize: must be at least %v bytes, got %v",
+        event.JOURNAL_MIN_MAXSIZE, c.Journal.MaxSize)
+
+    if c.Rpc.Address != "" && !strings.HasPrefix(c.Rpc.Address, "unix:") {
+        host, _, err := net.SplitHostPort(c.Rpc.Address)
+        ip := net.ParseIP(host)
+        check(err == nil && (host == "localhost" || ip != nil && ip.IsLoopback()),
+            "rpc.address: %q must be unix:<path> or <loopback host>:<port>", c.Rpc.Address)
+    }
+    check(strings.HasPrefix(c.Ipc.Socket, "/") && len(c.Ipc.Socket) <= MAX_SOCKET_LENGTH,
+        "ipc.socket: %q must be an absolute path of at most %v characters", c.Ipc.Socket, MAX_SOCKET_LENGTH)
+
+    if len(errs) != 0 {
+        return fmt.Errorf("invalid agent config:\n  %s", strings.Join(errs, "\n  "))
+    }
+    return nil
+}
@@ -0,0 +1,49 @@
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+#include <errno.h>
+#include "config.h"
+
+struct ConfigValue *CConfigValue(char *value, uint32_t len, int64_t revision)
+{
+    size_t size = sizeof(struct ConfigValue);
+    struct ConfigValue *p = malloc(size);
+    if (p != NULL)
+    {
+        memset(p, 0, size);
+        strncpy(p->value, value, MAX_CONFIG_VALUELENGTH - 1);
+        p->length = len;
+        p->revision = revision;
+        return p;
+    }
+    return NULL;
+}
+
+/*
+ * 为变长列表申请内存，使用完后需主动释放
+ */
+struct ConfigList *CConfigList(uint32_t maxItems)
+{
+    size_t size = sizeof(struct ConfigList) + maxItems * sizeof(struct ConfigItem);
+    struct ConfigList *p = malloc(size);
+    if (p != NULL)
+    {
+        memset(p, 0, size);
+        return p;
+    }
+    return NULL;
+}
+
+void AddConfigItem(struct ConfigList *p, char *key, char *value, int64_t revision)
+{
+    if (p == NULL)
+    {
+        errno = EINVAL;
+        return;
+    }
+
+    strncpy(p->items[p->length].key, key, MAX_CONFIG_KEYLENGTH - 1);
+    strncpy(p->items[p->length].value, value, MAX_CONFIG_VALUELENGTH - 1);
+    p->items[p->length].revision = revision;
+    p->length++;
+}
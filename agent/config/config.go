@@ -0,0 +1,222 @@
+package config
+
+/*
+#include "config.h"
+*/
+import "C"
+import (
+    "context"
+    "errors"
+    "etcdagent/agent/log"
+    "fmt"
+    "strings"
+    "time"
+    "unsafe"
+
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+const (
+    CONFIG_PREFIX          = "/CoreNet/Config/"
+    CONFIG_MAX_KEYLENGTH   = C.MAX_CONFIG_KEYLENGTH - 1
+    CONFIG_MAX_VALUELENGTH = C.MAX_CONFIG_VALUELENGTH - 1
+)
+
+var (
+    ErrConfigNotFound = errors.New("Config not found")
+    ErrConfigConflict = errors.New("Config revision mismatch")
+)
+
+type KeyValue struct {
+    Key      string
+    Value    string
+    Revision int64 //mod revision，用于CompareAndSwap
+}
+
+//集群配置保存在 /CoreNet/Config/<key>，key可以包含多级路径
+type Config interface {
+    ConfigGet(key string) (string, int64, error)
+    ConfigPut(key string, value string) (int64, error)
+    ConfigDelete(key string) error
+    ConfigList(prefix string) ([]KeyValue, error)
+    ConfigCompareAndSwap(key string, value string, revision int64) (int64, error)
+    CConfigGet(key string) (*C.struct_ConfigValue, error)
+    CConfigList(prefix string) (*C.struct_ConfigList, error)
+}
+
+type config struct {
+    client *clientv3.Client
+}
+
+func NewConfig(client *clientv3.Client) Config {
+    return &config{
+        client: client,
+    }
+}
+
+func checkKey(key string) error {
+    if key == "" || strings.HasPrefix(key, "/") || len(key) > CONFIG_MAX_KEYLENGTH {
+        return fmt.Errorf("Invalid config key: %q", key)
+    }
+    return nil
+}
+
+func checkValue(value string) error {
+    if len(value) > CONFIG_MAX_VALUELENGTH {
+        return fmt.Errorf("Config value too long, length: %v, max: %v", len(value), CONFIG_MAX_VALUELENGTH)
+    }
+    return nil
+}
+
+func configKey(key string) string {
+    return fmt.Sprintf("%s%s", CONFIG_PREFIX, key)
+}
+
+func (c *config) ConfigGet(key string) (string, int64, error) {
+    if err := checkKey(key); err != nil {
+        return "", 0, err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    resp, err := c.client.Get(ctx, configKey(key))
+    if err != nil {
+        log.Warn("Get config %v error, reason: %v", key, err.Error())
+        return "", 0, err
+    }
+
+    if len(resp.Kvs) == 0 {
+        return "", 0, ErrConfigNotFound
+    }
+    return string(resp.Kvs[0].Value), resp.Kvs[0].ModRevision, nil
+}
+
+func (c *config) ConfigPut(key string, value string) (int64, error) {
+    if err := checkKey(key); err != nil {
+        return 0, err
+    }
+
+    if err := checkValue(value); err != nil {
+        return 0, err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    resp, err := c.client.Put(ctx, configKey(key), value)
+    if err != nil {
+        log.Warn("Put config %v error, reason: %v", key, err.Error())
+        return 0, err
+    }
+
+    log.Info("Put config %v = %v, revision: %v", key, value, resp.Header.Revision)
+    return resp.Header.Revision, nil
+}
+
+func (c *config) ConfigDelete(key string) error {
+    if err := checkKey(key); err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    resp, err := c.client.Delete(ctx, configKey(key))
+    if err != nil {
+        log.Warn("Delete config %v error, reason: %v", key, err.Error())
+        return err
+    }
+
+    if resp.Deleted == 0 {
+        return ErrConfigNotFound
+    }
+
+    log.Info("Delete config %v", key)
+    return nil
+}
+
+//prefix为空时返回全部配置
+func (c *config) ConfigList(prefix string) ([]KeyValue, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    resp, err := c.client.Get(ctx, configKey(prefix), clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+    if err != nil {
+        log.Warn("List config %v error, reason: %v", prefix, err.Error())
+        return nil, err
+    }
+
+    kvs := make([]KeyValue, 0, len(resp.Kvs))
+    for _, kv := range resp.Kvs {
+        kvs = append(kvs, KeyValue{
+            Key:      strings.TrimPrefix(string(kv.Key), CONFIG_PREFIX),
+            Value:    string(kv.Value),
+            Revision: kv.ModRevision,
+        })
+    }
+    return kvs, nil
+}
+
+//revision为ConfigGet返回的mod revision，revision为0表示key必须不存在
+func (c *config) ConfigCompareAndSwap(key string, value string, revision int64) (int64, error) {
+    if err := checkKey(key); err != nil {
+        return 0, err
+    }
+
+    if err := checkValue(value); err != nil {
+        return 0, err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    k := configKey(key)
+    resp, err := c.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.ModRevision(k), "=", revision)).
+        Then(clientv3.OpPut(k, value)).
+        Commit()
+    if err != nil {
+        log.Warn("Compare and swap config %v error, reason: %v", key, err.Error())
+        return 0, err
+    }
+
+    if !resp.Succeeded {
+        return 0, ErrConfigConflict
+    }
+
+    log.Info("Compare and swap config %v = %v, revision: %v -> %v", key, value, revision, resp.Header.Revision)
+    return resp.Header.Revision, nil
+}
+
+func (c *config) CConfigGet(key string) (*C.struct_ConfigValue, error) {
+    value, revision, err := c.ConfigGet(key)
+    if err != nil {
+        return nil, err
+    }
+
+    cstr := C.CString(value)
+    defer C.free(unsafe.Pointer(cstr))
+
+    var p *C.struct_ConfigValue
+    if p, err = C.CConfigValue(cstr, C.uint32_t(len(value)), C.int64_t(revision)); err != nil {
+        return nil, err
+    }
+    return p, nil
+}
+
+func (c *config) CConfigList(prefix string) (*C.struct_ConfigList, error) {
+    kvs, err := c.ConfigList(prefix)
+    if err != nil {
+        return nil, err
+    }
+
+    var p *C.struct_ConfigList
+    if p, err = C.CConfigList(C.uint32_t(len(kvs))); err != nil {
+        return nil, err
+    }
+
+    for _, kv := range kvs {
+        kstr := C.CString(kv.Key)
+        vstr := C.CString(kv.Value)
+        C.AddConfigItem(p, kstr, vstr, C.int64_t(kv.Revision))
+        C.free(unsafe.Pointer(kstr))
+        C.free(unsafe.Pointer(vstr))
+    }
+    return p, nil
+}
@@ -0,0 +1,29 @@
+#include <stdint.h>
+#include <stdlib.h>
+
+#define MAX_CONFIG_KEYLENGTH 128
+#define MAX_CONFIG_VALUELENGTH 1024
+
+struct ConfigValue
+{
+    char value[MAX_CONFIG_VALUELENGTH];
+    uint32_t length;
+    int64_t revision;
+};
+
+struct ConfigItem
+{
+    char key[MAX_CONFIG_KEYLENGTH];
+    char value[MAX_CONFIG_VALUELENGTH];
+    int64_t revision;
+};
+
+struct ConfigList
+{
+    uint32_t length; //变长数组长度
+    struct ConfigItem items[0];
+};
+
+struct ConfigValue *CConfigValue(char *value, uint32_t len, int64_t revision);
+struct ConfigList *CConfigList(uint32_t maxItems);
+void AddConfigItem(struct ConfigList *p, char *key, char *value, int64_t revision);
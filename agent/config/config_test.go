@@ -0,0 +1,108 @@
+package config
+
+import (
+    "fmt"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+const ETCDADDR = "172.100.1.239:2379"
+
+func TestConfig(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    config := NewConfig(client)
+    data := []struct {
+        key   string
+        value string
+    }{
+        {"test/mtu", "1500"},
+        {"test/loglevel", "info"},
+        {"test/peers", "1,2,3"},
+    }
+
+    for _, info := range data {
+        if _, err := config.ConfigPut(info.key, info.value); err != nil {
+            t.Errorf("Config put error, key: %v, reason: %v", info.key, err.Error())
+        }
+
+        if acctually, _, err := config.ConfigGet(info.key); err != nil {
+            t.Errorf("Config get error, key: %v, reason: %v", info.key, err.Error())
+        } else if acctually != info.value {
+            t.Errorf("Test config failed, expected = %v, acctually = %v", info.value, acctually)
+        }
+    }
+
+    if kvs, err := config.ConfigList("test/"); err != nil {
+        t.Errorf("Config list error, reason: %v", err.Error())
+    } else if len(kvs) != len(data) {
+        t.Errorf("Test config list failed, expected = %v, acctually = %v", len(data), kvs)
+    }
+
+    for _, info := range data {
+        if err := config.ConfigDelete(info.key); err != nil {
+            t.Errorf("Config delete error, key: %v, reason: %v", info.key, err.Error())
+        }
+    }
+
+    if _, _, err := config.ConfigGet("test/mtu"); err != ErrConfigNotFound {
+        t.Errorf("Test config delete failed, expected = %v, acctually = %v", ErrConfigNotFound, err)
+    }
+    client.Close()
+}
+
+func TestConfigCompareAndSwap(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    config := NewConfig(client)
+    //revision为0时只允许创建
+    rev, err := config.ConfigCompareAndSwap("test/cas", "1", 0)
+    if err != nil {
+        t.Errorf("Config compare and swap error, reason: %v", err.Error())
+    }
+
+    if _, err := config.ConfigCompareAndSwap("test/cas", "1", 0); err != ErrConfigConflict {
+        t.Errorf("Test config compare and swap failed, expected = %v, acctually = %v", ErrConfigConflict, err)
+    }
+
+    if _, err := config.ConfigCompareAndSwap("test/cas", "2", rev); err != nil {
+        t.Errorf("Config compare and swap error, reason: %v", err.Error())
+    }
+
+    //使用过期的revision更新失败
+    if _, err := config.ConfigCompareAndSwap("test/cas", "3", rev); err != ErrConfigConflict {
+        t.Errorf("Test config compare and swap failed, expected = %v, acctually = %v", ErrConfigConflict, err)
+    }
+
+    if acctually, _, err := config.ConfigGet("test/cas"); err != nil {
+        t.Errorf("Config get error, reason: %v", err.Error())
+    } else if acctually != "2" {
+        t.Errorf("Test config compare and swap failed, expected = 2, acctually = %v", acctually)
+    }
+
+    config.ConfigDelete("test/cas")
+    client.Close()
+}
@@ -0,0 +1,86 @@
+package event
+
+import (
+    "context"
+    "etcdagent/agent/backend"
+    "strings"
+    "time"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    "github.com/coreos/etcd/mvcc/mvccpb"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+//主动下线时与删除在同一事务中写入的标记，使用被删除key的租约，租约到期后自动删除
+//如 /CoreNet/Node/3 对应 /CoreNet/Offline/Node/3
+const EVENT_OFFLINE_PREFIX = "/CoreNet/Offline/"
+
+func OfflineKey(key string) string {
+    return EVENT_OFFLINE_PREFIX + strings.TrimPrefix(key, EVENT_ROOT_PREFIX)
+}
+
+//与mq.h中EventCauseName一致
+func CauseName(cause uint8) string {
+    switch cause {
+    case EVENT_CAUSE_OFFLINE:
+        return "offline"
+    case EVENT_CAUSE_LEASE:
+        return "lease"
+    case EVENT_CAUSE_EXTERNAL:
+        return "external"
+    default:
+        return "none"
+    }
+}
+
+func isOfflineKey(key string) bool {
+    return strings.HasPrefix(key, EVENT_OFFLINE_PREFIX)
+}
+
+//判断删除原因，events为同一个Watch响应中的所有事件
+//1）同一revision中写入了下线标记：主动下线
+//2）key的租约已不存在：租约过期或者被撤销
+//3）其他情况：被其他程序删除
+func DeleteCause(ctx context.Context, lease backend.Lease, ev *clientv3.Event, events []*clientv3.Event) uint8 {
+    if ev.Type != mvccpb.DELETE {
+        return EVENT_CAUSE_NONE
+    }
+
+    marker := OfflineKey(string(ev.Kv.Key))
+    for _, other := range events {
+        if other.Type == mvccpb.PUT && other.Kv.ModRevision == ev.Kv.ModRevision && string(other.Kv.Key) == marker {
+            return EVENT_CAUSE_OFFLINE
+        }
+    }
+
+    if ev.PrevKv == nil || ev.PrevKv.Lease == 0 {
+        return EVENT_CAUSE_EXTERNAL
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+    defer cancel()
+    resp, err := lease.TimeToLive(ctx, clientv3.LeaseID(ev.PrevKv.Lease))
+    switch {
+    case err == rpctypes.ErrLeaseNotFound || (err == nil && resp.TTL <= 0):
+        return EVENT_CAUSE_LEASE
+    case err == nil:
+        return EVENT_CAUSE_EXTERNAL
+    default:
+        return EVENT_CAUSE_NONE
+    }
+}
+
+//转换一次Watch响应中的事件并判断删除原因，下线标记本身不作为事件
+func NewMessages(ctx context.Context, lease backend.Lease, events []*clientv3.Event) []Message {
+    messages := make([]Message, 0, len(events))
+    for _, ev := range events {
+        if isOfflineKey(string(ev.Kv.Key)) {
+            continue
+        }
+
+        m := NewMessage(ev)
+        m.Cause = DeleteCause(ctx, lease, ev, events)
+        messages = append(messages, m)
+    }
+    return messages
+}
@@ -2,78 +2,491 @@ package event
 
 /*
 #cgo LDFLAGS: -lrt
+#include <mqueue.h>
 #include "mq.h"
 */
 import "C"
 import (
     "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/config"
     "etcdagent/agent/log"
+    "fmt"
     "unsafe"
     "strings"
+    "sync"
+    "syscall"
+    "time"
     "github.com/coreos/etcd/mvcc/mvccpb"
     "github.com/etcd-io/etcd/clientv3"
     //mvccpb "github.com/coreos/etcd/mvcc/mvccpb"
 )
 
 type Event interface {
-    Watch(ctx context.Context, eventChan chan<- *clientv3.Event)
+    Watch(ctx context.Context, eventChan chan<- Message)
+    Notify(ctx context.Context, key string, value string, evtType uint8) error
+    NotifyMessage(ctx context.Context, m Message) error
+    EventSetMq(name string, maxMsg int64, msgSize int64, mode uint32)
+    EventSetOverflow(policy string, timeout time.Duration) error
+    EventStats() Stats
+    EventSetJournal(path string, maxSize int64) error
+    EventsSince(ctx context.Context, revision int64, limit int) ([]Message, error)
+    CEventsSince(ctx context.Context, revision int64, limit int) (*C.Message, error)
 }
 
 type event struct {
-    client *clientv3.Client
+    client      backend.Backend
+    openMu      sync.Mutex
+    opened      bool
+    mqName      string
+    maxMsg      int64
+    msgSize     int64
+    mode        uint32
+    mu          sync.Mutex
+    overflow    string
+    sendTimeout time.Duration
+    stats       Stats
+    pending     uint64 //resync策略下尚未通知的丢弃事件数
+    full        bool
+    journal     Journal
 }
 
 const (
-    EVENT_ROOT_PREFIX = "/CoreNet/"
+    EVENT_ROOT_PREFIX  = "/CoreNet/"
+    MQ_DEFAULT_NAME    = C.ETCDMQ
+    MQ_DEFAULT_MAXMSG  = C.MQ_DEFAULT_MAXMSG
+    MQ_DEFAULT_MSGSIZE = C.MQ_DEFAULT_MSGSIZE
+    MQ_DEFAULT_MODE    = C.MQ_DEFAULT_MODE
+    MQ_MAX_NAMELENGTH  = C.MAX_MQNAMELENGTH - 1
+    MQ_MIN_MSGSIZE     = C.sizeof_Message + C.sizeof_Event
 )
 
+//与mq.h中Event.type保持一致
+const (
+    EVENT_TYPE_PUT           = 0
+    EVENT_TYPE_DELETE        = 1
+    EVENT_TYPE_CONFLICT      = 2
+    EVENT_TYPE_LOCK_LOST     = 3
+    EVENT_TYPE_CONFIG_PUT    = 4
+    EVENT_TYPE_CONFIG_DELETE = 5
+    EVENT_TYPE_RESYNC        = 6
+    EVENT_TYPE_RECONCILE     = 7
+)
+
+//EVENT_TYPE_RECONCILE事件的value，本agent注册的key被删除或者覆盖后的处理结果
+const (
+    RECONCILE_NONE     = ""         //不属于本agent，或者事件已过期
+    RECONCILE_RESTORED = "restored" //lease仍然有效，已使用原lease恢复
+    RECONCILE_DROPPED  = "dropped"  //lease已失效或者key已被其他进程注册，已删除本地状态
+)
+
+//与mq.h中Event.category保持一致
+const (
+    EVENT_CATEGORY_UNKNOWN = C.EVENT_CATEGORY_UNKNOWN
+    EVENT_CATEGORY_NODE    = C.EVENT_CATEGORY_NODE
+    EVENT_CATEGORY_MS      = C.EVENT_CATEGORY_MS
+    EVENT_CATEGORY_CONFIG  = C.EVENT_CATEGORY_CONFIG
+    EVENT_CATEGORY_LOCK    = C.EVENT_CATEGORY_LOCK
+    EVENT_CATEGORY_BARRIER = C.EVENT_CATEGORY_BARRIER
+)
+
+//与mq.h中Event.cause保持一致
+const (
+    EVENT_CAUSE_NONE     = C.EVENT_CAUSE_NONE
+    EVENT_CAUSE_OFFLINE  = C.EVENT_CAUSE_OFFLINE
+    EVENT_CAUSE_LEASE    = C.EVENT_CAUSE_LEASE
+    EVENT_CAUSE_EXTERNAL = C.EVENT_CAUSE_EXTERNAL
+)
+
+//与MQ中Event内容一致的Go结构，用于MQ之外的事件分发
+//FullKey为etcd中的完整key，HasNodeId为false时NodeId无效
+//PrevValue为修改或删除之前的value，Cause为删除原因
+//ModRevision、CreateRevision、Lease来自etcd，本地通知为0，Timestamp为agent收到事件的时间
+type Message struct {
+    Key            string    `json:"key"`
+    Value          string    `json:"value"`
+    Type           uint8     `json:"type"`
+    FullKey        string    `json:"fullKey"`
+    Category       uint8     `json:"category"`
+    NodeId         uint32    `json:"nodeId"`
+    HasNodeId      bool      `json:"hasNodeId"`
+    PrevValue      string    `json:"prevValue,omitempty"`
+    Cause          uint8     `json:"cause,omitempty"`
+    ModRevision    int64     `json:"modRevision"`
+    CreateRevision int64     `json:"createRevision"`
+    Lease          int64     `json:"lease,omitempty"`
+    Timestamp      time.Time `json:"timestamp"`
+}
+
+//删除事件的nodeId、创建revision和租约从删除前的kv中获取，如Master/<group>
+func NewMessage(ev *clientv3.Event) Message {
+    m := MakeMessage(string(ev.Kv.Key), string(ev.Kv.Value), eventType(ev))
+    m.ModRevision = ev.Kv.ModRevision
+    m.CreateRevision = ev.Kv.CreateRevision
+    m.Lease = ev.Kv.Lease
+    if ev.PrevKv != nil {
+        m.PrevValue = string(ev.PrevKv.Value)
+        if ev.Type == mvccpb.DELETE {
+            m.CreateRevision = ev.PrevKv.CreateRevision
+            m.Lease = ev.PrevKv.Lease
+            if !m.HasNodeId {
+                m.NodeId, m.HasNodeId = nodeId(m.FullKey, m.PrevValue, m.Category)
+            }
+        }
+    }
+    return m
+}
+
+//与mq.h中Event.timestamp一致，未设置时为0
+func (m Message) UnixNano() int64 {
+    if m.Timestamp.IsZero() {
+        return 0
+    }
+    return m.Timestamp.UnixNano()
+}
+
+//冲突、锁丢失等本地通知使用对应的etcd key构造事件
+func MakeMessage(key string, value string, evtType uint8) Message {
+    m := Message{
+        Key:       shortKey(key),
+        Value:     value,
+        Type:      evtType,
+        FullKey:   key,
+        Category:  category(key),
+        Timestamp: time.Now(),
+    }
+    m.NodeId, m.HasNodeId = nodeId(key, value, m.Category)
+    return m
+}
+
 func NewEvent(client *clientv3.Client) Event {
+    return NewEventFromBackend(backend.NewEtcd(client))
+}
+
+//使用其他存储，如单元测试中的backend.NewMemory
+func NewEventFromBackend(b backend.Backend) Event {
     return &event{
-        client: client,
+        client:   b,
+        mqName:   MQ_DEFAULT_NAME,
+        maxMsg:   MQ_DEFAULT_MAXMSG,
+        msgSize:  MQ_DEFAULT_MSGSIZE,
+        mode:     MQ_DEFAULT_MODE,
+        overflow: MQ_OVERFLOW_RESYNC,
+    }
+}
+
+//去掉 /CoreNet/<类别>/ 前缀，如 /CoreNet/Node/3 -> 3，/CoreNet/MS/billing/3 -> billing/3
+func shortKey(key string) string {
+    tmp := strings.SplitN(strings.TrimPrefix(key, EVENT_ROOT_PREFIX), "/", 2)
+    return tmp[len(tmp)-1]
+}
+
+//配置变更使用单独的事件类型，value超过MAX_VALUELENGTH时会被截断，需要通过ConfigGet读取完整值
+func eventType(ev *clientv3.Event) uint8 {
+    isConfig := strings.HasPrefix(string(ev.Kv.Key), config.CONFIG_PREFIX)
+    switch {
+    case ev.Type == mvccpb.DELETE && isConfig:
+        return EVENT_TYPE_CONFIG_DELETE
+    case ev.Type == mvccpb.DELETE:
+        return EVENT_TYPE_DELETE
+    case isConfig:
+        return EVENT_TYPE_CONFIG_PUT
+    default:
+        return EVENT_TYPE_PUT
     }
 }
 
-func (e *event) Watch(ctx context.Context, eventChan chan<- *clientv3.Event) {
-    if _, err := C.MqOpen(); err != nil {
-        log.Warn("Open message queue error, reason: %v", err.Error())
-        return
+//需要在Watch之前设置，MQ已存在时沿用原有属性
+func (e *event) EventSetMq(name string, maxMsg int64, msgSize int64, mode uint32) {
+    e.mqName = name
+    e.maxMsg = maxMsg
+    e.msgSize = msgSize
+    e.mode = mode
+    log.Info("Set mq name = %v, maxmsg = %v, msgsize = %v, mode = %04o", name, maxMsg, msgSize, mode)
+}
+
+//打开失败时下次调用重新打开
+func (e *event) open() error {
+    e.openMu.Lock()
+    defer e.openMu.Unlock()
+
+    if e.opened {
+        return nil
+    }
+
+    if err := e.openMq(); err != nil {
+        return err
+    }
+    e.opened = true
+    return nil
+}
+
+//优先打开已存在的MQ，不存在时创建，超过系统限制时按系统限制创建
+func (e *event) openMq() error {
+    name := C.CString(e.mqName)
+    defer C.free(unsafe.Pointer(name))
+
+    if err := e.attach(name); err != syscall.ENOENT {
+        return err
+    }
+
+    ret, err := C.MqCreate(name, C.long(e.maxMsg), C.long(e.msgSize), C.uint(e.mode))
+    if ret != 0 && (err == syscall.EINVAL || err == syscall.EMFILE) {
+        maxMsg, msgSize, lerr := mqLimits(e.maxMsg, e.msgSize)
+        if lerr != nil {
+            return fmt.Errorf("Create message queue %v error, reason: %v, %v", e.mqName, err, lerr.Error())
+        }
+
+        log.Warn("Create message queue %v exceeds system limits, reason: %v, fall back to maxmsg = %v, msgsize = %v",
+            e.mqName, err, maxMsg, msgSize)
+        e.maxMsg, e.msgSize = maxMsg, msgSize
+        ret, err = C.MqCreate(name, C.long(e.maxMsg), C.long(e.msgSize), C.uint(e.mode))
+    }
+
+    //其他进程同时创建了该MQ
+    if ret != 0 && err == syscall.EEXIST {
+        return e.attach(name)
+    }
+
+    if ret != 0 {
+        return fmt.Errorf("Create message queue %v error, reason: %v", e.mqName, err)
+    }
+    log.Info("Create message queue %v, maxmsg = %v, msgsize = %v, mode = %04o", e.mqName, e.maxMsg, e.msgSize, e.mode)
+    return nil
+}
+
+//不存在时返回syscall.ENOENT
+func (e *event) attach(name *C.char) error {
+    var maxMsg, msgSize C.long
+    if ret, err := C.MqAttach(name, &maxMsg, &msgSize); ret != 0 {
+        if err == syscall.ENOENT {
+            return err
+        }
+        return fmt.Errorf("Open message queue %v error, reason: %v", e.mqName, err)
+    }
+
+    if int64(msgSize) < MQ_MIN_MSGSIZE {
+        C.MqClose()
+        return fmt.Errorf("Existing message queue %v is too small, msgsize = %v, at least %v bytes are needed",
+            e.mqName, msgSize, MQ_MIN_MSGSIZE)
+    }
+
+    if int64(maxMsg) != e.maxMsg || int64(msgSize) != e.msgSize {
+        log.Warn("Reuse existing message queue %v with maxmsg = %v, msgsize = %v, configured maxmsg = %v, msgsize = %v",
+            e.mqName, maxMsg, msgSize, e.maxMsg, e.msgSize)
+    }
+    e.maxMsg, e.msgSize = int64(maxMsg), int64(msgSize)
+    log.Info("Reuse message queue %v", e.mqName)
+    return nil
+}
+
+//按系统限制调整队列属性，调整后仍不能容纳一个事件时返回错误
+func mqLimits(maxMsg int64, msgSize int64) (int64, int64, error) {
+    var limitMaxMsg, limitMsgSize, limitBytes C.long
+    C.MqLimits(&limitMaxMsg, &limitMsgSize, &limitBytes)
+
+    if limitMaxMsg > 0 && maxMsg > int64(limitMaxMsg) {
+        maxMsg = int64(limitMaxMsg)
+    }
+
+    if limitMsgSize > 0 && msgSize > int64(limitMsgSize) {
+        msgSize = int64(limitMsgSize)
     }
 
+    if limitBytes > 0 && maxMsg*(msgSize+C.MQ_MSG_OVERHEAD) > int64(limitBytes) {
+        maxMsg = int64(limitBytes) / (msgSize + C.MQ_MSG_OVERHEAD)
+    }
+
+    if maxMsg < 1 || msgSize < MQ_MIN_MSGSIZE {
+        return 0, 0, fmt.Errorf("system limits msg_max = %v, msgsize_max = %v, RLIMIT_MSGQUEUE = %v are too small",
+            limitMaxMsg, limitMsgSize, limitBytes)
+    }
+    return maxMsg, msgSize, nil
+}
+
+//删除指定的MQ，已经打开的描述符仍然有效
+func mqUnlink(name string) error {
+    cname := C.CString(name)
+    defer C.free(unsafe.Pointer(cname))
+    if ret, err := C.mq_unlink(cname); ret != 0 {
+        return err
+    }
+    return nil
+}
+
+//直接向MQ发送单个事件，不经过etcd
+func (e *event) Notify(ctx context.Context, key string, value string, evtType uint8) error {
+    return e.NotifyMessage(ctx, Message{Key: key, Value: value, Type: evtType, FullKey: key, Timestamp: time.Now()})
+}
+
+//带类别和nodeId的本地通知，使用MakeMessage构造，MQ满时最多等待到ctx的deadline
+func (e *event) NotifyMessage(ctx context.Context, m Message) error {
+    if err := e.open(); err != nil {
+        log.Warn("Notify event error, key: %v, reason: %v", m.Key, err.Error())
+        return err
+    }
+
+    if err := e.send(ctx, []Message{m}); err != nil {
+        log.Warn("Notify event error, key: %v, reason: %v", m.Key, err.Error())
+        return fmt.Errorf("Notify event error, key: %v, reason: %v", m.Key, err.Error())
+    }
+    return nil
+}
+
+//MQ打开失败时仍然Watch并分发给订阅者，每批事件发送前重新打开MQ
+func (e *event) Watch(ctx context.Context, eventChan chan<- Message) {
+    if err := e.open(); err != nil {
+        log.Warn("Open message queue error, events are only dispatched to subscribers, reason: %v", err.Error())
+    }
+
+    //删除事件需要删除前的value和租约
     prefix := EVENT_ROOT_PREFIX
-    wChan := e.client.Watch(ctx, prefix, clientv3.WithPrefix())
+    opts := []backend.OpOption{backend.WithPrefix(), backend.WithPrevKV()}
+
+    //启用journal时从journal最后的revision继续，agent停止期间的事件只补写到journal
+    var replay int64
+    if e.journal != nil {
+        var rev int64
+        if rev, replay = e.journalRevision(ctx); rev == 0 {
+            log.Info("Event watch done before journal revision is known")
+            return
+        }
+        opts = append(opts, backend.WithRev(rev))
+    }
+
+    wChan := e.client.Watch(ctx, prefix, opts...)
     for {
         select {
         case <-ctx.Done():
             log.Info("Event watch done")
             return
-        case wResp := <-wChan:
-            l := len(wResp.Events)
-            message := C.NewMessage(C.uint32_t(l))
-            for _, ev := range wResp.Events {
-                evtKey := string(ev.Kv.Key)
-                tmp := strings.Split(evtKey, "/")
-                nodeId := tmp[len(tmp) - 1]
-                kstr := C.CString(nodeId)
-                vstr := C.CString(string(ev.Kv.Value))
-                var evtType uint8
-                if ev.Type == mvccpb.DELETE {
-                    evtType = 1
-                } else {
-                    evtType = 0
+        case wResp, ok := <-wChan:
+            //存储已关闭
+            if !ok {
+                log.Warn("Event watch closed")
+                return
+            }
+
+            //journal需要的revision已被压缩，从最早可用的revision重新记录
+            if wResp.CompactRevision != 0 && e.journal != nil {
+                log.Warn("Journal revision has been compacted, restart journal from revision %v", wResp.CompactRevision)
+                if err := e.journal.Reset(wResp.CompactRevision - 1); err != nil {
+                    log.Warn("Reset journal error, reason: %v", err.Error())
+                }
+                wChan = e.client.Watch(ctx, prefix, backend.WithPrefix(), backend.WithPrevKV(), backend.WithRev(wResp.CompactRevision))
+                continue
+            }
+
+            messages := NewMessages(ctx, e.client, wResp.Events)
+            if e.journal != nil {
+                if err := e.journal.Append(messages); err != nil {
+                    log.Warn("Append events to journal error, reason: %v", err.Error())
                 }
-                
-                C.AddEvent(message, kstr, vstr, C.uint8_t(evtType))
-                C.free(unsafe.Pointer(kstr))
-                C.free(unsafe.Pointer(vstr))
+                messages = liveMessages(messages, replay)
+            }
+
+            if len(messages) == 0 {
+                continue
             }
 
-            C.DumpMessage(message)
-            C.MqSend(message, C.GetMessageSize(message))
-            C.free(unsafe.Pointer(message))
+            //MQ满时按溢出策略处理，不阻塞Run
+            if err := e.open(); err != nil {
+                log.Warn("Open message queue error, skip %v events, reason: %v", len(messages), err.Error())
+            } else if err := e.send(ctx, messages); err != nil && err != ErrQueueFull {
+                log.Warn("Send events to message queue error, reason: %v", err.Error())
+            }
 
-            for _, ev := range wResp.Events {
-                eventChan <- ev
+            for _, m := range messages {
+                select {
+                case eventChan <- m:
+                case <-ctx.Done():
+                    log.Info("Event watch done")
+                    return
+                }
             }
         }
     }
 }
+
+//需要在Watch之前设置，path为空时不记录journal
+func (e *event) EventSetJournal(path string, maxSize int64) error {
+    if e.journal != nil {
+        e.journal.Close()
+        e.journal = nil
+    }
+
+    if path == "" {
+        return nil
+    }
+
+    j, err := NewJournal(path, maxSize)
+    if err != nil {
+        log.Warn("Open event journal %v error, reason: %v", path, err.Error())
+        return err
+    }
+    e.journal = j
+    log.Info("Set event journal = %v, maxsize = %v", path, maxSize)
+    return nil
+}
+
+//返回Watch开始的revision和当前的revision，ctx结束时返回0
+func (e *event) journalRevision(ctx context.Context) (int64, int64) {
+    for {
+        resp, err := e.client.Get(ctx, EVENT_ROOT_PREFIX, backend.WithPrefix(), backend.WithCountOnly())
+        if err == nil {
+            current := resp.Header.Revision
+            if _, last := e.journal.Revision(); last > 0 && last <= current {
+                log.Info("Resume event journal from revision %v, current revision %v", last, current)
+                return last + 1, current
+            }
+
+            if err = e.journal.Reset(current); err != nil {
+                log.Warn("Reset journal error, reason: %v", err.Error())
+            }
+            return current + 1, current
+        }
+
+        log.Warn("Get current revision error, reason: %v", err.Error())
+        select {
+        case <-ctx.Done():
+            return 0, 0
+        case <-time.After(time.Second):
+        }
+    }
+}
+
+//agent启动前的事件已经过期，只记录到journal
+func liveMessages(messages []Message, replay int64) []Message {
+    live := messages[:0]
+    for _, m := range messages {
+        if m.ModRevision > replay {
+            live = append(live, m)
+        }
+    }
+    return live
+}
+
+func (e *event) EventsSince(ctx context.Context, revision int64, limit int) ([]Message, error) {
+    if e.journal == nil {
+        return nil, ErrJournalDisabled
+    }
+
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    return e.journal.Since(revision, limit)
+}
+
+//格式与MQ中的消息一致，使用完后需要释放
+func (e *event) CEventsSince(ctx context.Context, revision int64, limit int) (*C.Message, error) {
+    messages, err := e.EventsSince(ctx, revision, limit)
+    if err != nil {
+        return nil, err
+    }
+
+    message := newCMessage(messages...)
+    if message == nil {
+        return nil, fmt.Errorf("Cannot allocate message for %v events", len(messages))
+    }
+    return message, nil
+}
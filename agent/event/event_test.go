@@ -2,13 +2,15 @@ package event
 
 import (
     "context"
+    "etcdagent/agent/backend"
     "fmt"
+    "io/ioutil"
     "os"
+    "path/filepath"
     "sync"
     "testing"
     "time"
 
-    mvccpb "github.com/coreos/etcd/mvcc/mvccpb"
     "github.com/etcd-io/etcd/clientv3"
 )
 
@@ -28,7 +30,7 @@ func TestWatch(t *testing.T) {
     }
 
     evt := NewEvent(client)
-    evtCh := make(chan *clientv3.Event, 100)
+    evtCh := make(chan Message, 100)
 
     ctx, cancel := context.WithCancel(context.Background())
     go evt.Watch(ctx, evtCh)
@@ -48,9 +50,10 @@ func TestWatch(t *testing.T) {
     //校验结果
 
     wg.Add(1)
-    go func(wg *sync.WaitGroup, evtCh <-chan *clientv3.Event) {
+    go func(wg *sync.WaitGroup, evtCh <-chan Message) {
         var putCnt uint32
         var delCnt uint32
+        var revision int64
         for {
             select {
             case <-time.After(10 * time.Second):
@@ -62,17 +65,24 @@ func TestWatch(t *testing.T) {
 
             case ev := <-evtCh:
                 fmt.Println(ev)
+                //revision单调递增，删除事件的创建revision取删除前的值
+                if ev.ModRevision <= revision || ev.CreateRevision == 0 || ev.CreateRevision > ev.ModRevision || ev.Timestamp.IsZero() {
+                    t.Errorf("Test Watch revision failed, last revision = %v, acctually = %+v", revision, ev)
+                }
+                revision = ev.ModRevision
+
                 switch ev.Type {
-                case mvccpb.PUT:
+                case EVENT_TYPE_PUT:
                     for _, v := range data {
-                        if string(ev.Kv.Key) == v.key && string(ev.Kv.Value) == v.value {
+                        if ev.FullKey == v.key && ev.Value == v.value {
                             putCnt += 1
                         }
                     }
 
-                case mvccpb.DELETE:
+                //删除事件带有删除前的value，没有租约的key被删除时原因为external
+                case EVENT_TYPE_DELETE:
                     for _, v := range data {
-                        if string(ev.Kv.Key) == v.key {
+                        if ev.FullKey == v.key && ev.PrevValue == v.value && ev.Cause == EVENT_CAUSE_EXTERNAL {
                             delCnt += 1
                         }
                     }
@@ -108,3 +118,483 @@ func TestWatch(t *testing.T) {
     cancel()
     client.Close()
 }
+
+func TestMakeMessage(t *testing.T) {
+    data := []struct {
+        key       string
+        value     string
+        short     string
+        category  uint8
+        nodeId    uint32
+        hasNodeId bool
+    }{
+        {"/CoreNet/Node/3", "1.1.1.1:80", "3", EVENT_CATEGORY_NODE, 3, true},
+        {"/CoreNet/NodeId/4", "host-a", "4", EVENT_CATEGORY_NODE, 4, true},
+        {"/CoreNet/MS/billing/3", "1", "billing/3", EVENT_CATEGORY_MS, 3, true},
+        {"/CoreNet/Master/billing", "5", "billing", EVENT_CATEGORY_MS, 5, true},
+        {"/CoreNet/Master/billing", "", "billing", EVENT_CATEGORY_MS, 0, false},
+        {"/CoreNet/Config/timeout/3", "10", "timeout/3", EVENT_CATEGORY_CONFIG, 0, false},
+        {"/CoreNet/Lock/db/6", "", "db/6", EVENT_CATEGORY_LOCK, 6, true},
+        {"/CoreNet/Other/7", "", "7", EVENT_CATEGORY_UNKNOWN, 0, false},
+    }
+
+    for _, d := range data {
+        m := MakeMessage(d.key, d.value, EVENT_TYPE_PUT)
+        if m.Key != d.short || m.Category != d.category || m.NodeId != d.nodeId || m.HasNodeId != d.hasNodeId || m.FullKey != d.key ||
+            m.ModRevision != 0 || m.Timestamp.IsZero() {
+            t.Errorf("Test make message %v failed, acctually = %+v", d.key, m)
+        }
+    }
+}
+
+func TestFilter(t *testing.T) {
+    data := []struct {
+        filter   string
+        key      string
+        value    string
+        expected bool
+    }{
+        {"", "/CoreNet/Node/3", "1.1.1.1:80", true},
+        {"category=node", "/CoreNet/Node/3", "1.1.1.1:80", true},
+        {"category=node", "/CoreNet/NodeId/3", "host-a", true},
+        {"category=ms,config", "/CoreNet/Node/3", "1.1.1.1:80", false},
+        {"category=ms;node=3", "/CoreNet/MS/billing/3", "1", true},
+        {"category=ms;node=3", "/CoreNet/Master/billing", "3", true},
+        {"category=ms;node=3", "/CoreNet/Master/billing", "4", false},
+        {"node=3,4", "/CoreNet/Node/4", "", true},
+        {"node=3,4", "/CoreNet/Config/timeout/3", "", false},
+        {"key=MS/billing/*", "/CoreNet/MS/billing/3", "1", true},
+        {"key=MS/billing/*", "/CoreNet/MS/order/3", "1", false},
+        {"key=MS/billing/*,Master/*", "/CoreNet/Master/order", "3", true},
+    }
+
+    for _, d := range data {
+        f, err := ParseFilter(d.filter)
+        if err != nil {
+            t.Errorf("Parse filter %q error: %v", d.filter, err)
+            continue
+        }
+
+        if acctually := f.Match(MakeMessage(d.key, d.value, EVENT_TYPE_PUT)); acctually != d.expected {
+            t.Errorf("Test filter %q failed, key = %v, expected = %v, acctually = %v", d.filter, d.key, d.expected, acctually)
+        }
+
+        if f2, _ := ParseFilter(f.String()); f2.String() != f.String() {
+            t.Errorf("Test filter string failed, expected = %q, acctually = %q", f.String(), f2.String())
+        }
+    }
+
+    for _, invalid := range []string{"category=unknown", "node=abc", "key=[", "foo=bar", "category"} {
+        if _, err := ParseFilter(invalid); err == nil {
+            t.Errorf("Test filter %q failed, expected error", invalid)
+        }
+    }
+}
+
+func TestDispatcher(t *testing.T) {
+    d := NewDispatcher()
+    nodeFilter, _ := ParseFilter("category=node;node=3")
+    nodeTarget, nodeCh := NewChanTarget(10)
+    allTarget, allCh := NewChanTarget(10)
+    nodeId := d.Subscribe(nodeFilter, nodeTarget)
+    d.Subscribe(Filter{}, allTarget)
+
+    d.Dispatch(MakeMessage("/CoreNet/Node/3", "1.1.1.1:80", EVENT_TYPE_PUT))
+    d.Dispatch(MakeMessage("/CoreNet/Node/4", "1.1.1.2:80", EVENT_TYPE_PUT))
+    d.Dispatch(MakeMessage("/CoreNet/MS/billing/3", "1", EVENT_TYPE_PUT))
+
+    if acctually := len(nodeCh); acctually != 1 {
+        t.Errorf("Test dispatcher failed, expected = 1, acctually = %v", acctually)
+    }
+    if m := <-nodeCh; m.Key != "3" || m.Category != EVENT_CATEGORY_NODE {
+        t.Errorf("Test dispatcher failed, acctually = %+v", m)
+    }
+
+    if acctually := len(allCh); acctually != 3 {
+        t.Errorf("Test dispatcher failed, expected = 3, acctually = %v", acctually)
+    }
+
+    //取消订阅后关闭通道
+    if err := d.Unsubscribe(nodeId); err != nil {
+        t.Errorf("Unsubscribe error: %v", err)
+    }
+    if _, ok := <-nodeCh; ok {
+        t.Errorf("Test unsubscribe failed, channel should be closed")
+    }
+    if err := d.Unsubscribe(nodeId); err != ErrSubscriptionNotFound {
+        t.Errorf("Test unsubscribe failed, expected = %v, acctually = %v", ErrSubscriptionNotFound, err)
+    }
+}
+
+func TestMqReuse(t *testing.T) {
+    name := "/etcdmqreuse"
+    mqUnlink(name)
+    defer mqUnlink(name)
+
+    first := NewEvent(nil).(*event)
+    first.EventSetMq(name, 8, 512, 0640)
+    if err := first.open(); err != nil {
+        t.Fatalf("Open message queue error: %v", err)
+    }
+
+    //已存在的队列沿用原有属性，不删除重建
+    second := NewEvent(nil).(*event)
+    second.EventSetMq(name, 16, 1024, 0666)
+    if err := second.open(); err != nil {
+        t.Fatalf("Reopen message queue error: %v", err)
+    }
+
+    if second.maxMsg != 8 || second.msgSize != 512 {
+        t.Errorf("Test mq reuse failed, expected = 8/512, acctually = %v/%v", second.maxMsg, second.msgSize)
+    }
+
+    if info, err := os.Stat("/dev/mqueue" + name); err == nil && info.Mode().Perm() != 0640 {
+        t.Errorf("Test mq mode failed, expected = 0640, acctually = %04o", info.Mode().Perm())
+    }
+}
+
+func TestOverflow(t *testing.T) {
+    name := "/etcdmqoverflow"
+    mqUnlink(name)
+    defer mqUnlink(name)
+
+    e := NewEvent(nil).(*event)
+    e.EventSetMq(name, 2, 512, 0600)
+    if err := e.EventSetOverflow("drop-all", 0); err == nil {
+        t.Errorf("Test overflow failed, invalid policy should be rejected")
+    }
+
+    //队列满时丢弃新事件，不阻塞
+    e.EventSetOverflow(MQ_OVERFLOW_RESYNC, 10*time.Millisecond)
+    for i := 0; i < 5; i++ {
+        e.Notify(context.TODO(), fmt.Sprintf("%v", i), "", EVENT_TYPE_PUT)
+    }
+
+    if acctually := e.EventStats(); acctually.Sent != 2 || acctually.Dropped != 3 || acctually.Resyncs != 0 {
+        t.Errorf("Test overflow failed, expected = {2 3 0}, acctually = %v", acctually)
+    }
+
+    //队列有空间后先发送resync标记，再发送新事件
+    dropOldest()
+    if err := e.Notify(context.TODO(), "5", "", EVENT_TYPE_PUT); err == nil {
+        t.Errorf("Test resync failed, queue should be full after resync marker")
+    }
+
+    if acctually := e.EventStats(); acctually.Sent != 3 || acctually.Dropped != 4 || acctually.Resyncs != 1 {
+        t.Errorf("Test resync failed, expected = {3 4 1}, acctually = %v", acctually)
+    }
+
+    //丢弃最早的消息，新事件总能发送，之前欠下的resync标记也会发送
+    e.EventSetOverflow(MQ_OVERFLOW_DROP_OLDEST, 0)
+    for i := 6; i < 9; i++ {
+        if err := e.Notify(context.TODO(), fmt.Sprintf("%v", i), "", EVENT_TYPE_PUT); err != nil {
+            t.Errorf("Test drop oldest failed, err = %v", err)
+        }
+    }
+
+    if acctually := e.EventStats(); acctually.Sent != 7 || acctually.Dropped != 8 || acctually.Resyncs != 2 {
+        t.Errorf("Test drop oldest failed, expected = {7 8 2}, acctually = %v", acctually)
+    }
+}
+
+func TestDeleteCause(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+    defer client.Close()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    key := "/CoreNet/Node/61"
+    wChan := client.Watch(ctx, EVENT_ROOT_PREFIX, clientv3.WithPrefix(), clientv3.WithPrevKV())
+
+    //依次模拟主动下线、租约撤销和外部删除
+    remove := []func(lease clientv3.LeaseID){
+        func(lease clientv3.LeaseID) {
+            client.Txn(ctx).Then(clientv3.OpPut(OfflineKey(key), "", clientv3.WithLease(lease)), clientv3.OpDelete(key)).Commit()
+        },
+        func(lease clientv3.LeaseID) {
+            client.Revoke(ctx, lease)
+        },
+        func(lease clientv3.LeaseID) {
+            client.Delete(ctx, key)
+        },
+    }
+    expected := []uint8{EVENT_CAUSE_OFFLINE, EVENT_CAUSE_LEASE, EVENT_CAUSE_EXTERNAL}
+
+    for i, f := range remove {
+        lease, err := client.Grant(ctx, 10)
+        if err != nil {
+            t.Fatalf("Grant lease error: %v", err)
+        }
+        client.Put(ctx, key, "10.0.0.61:80", clientv3.WithLease(lease.ID))
+        f(lease.ID)
+
+        var deleted *Message
+        for deleted == nil {
+            wResp, ok := <-wChan
+            if !ok {
+                t.Fatalf("Watch closed")
+            }
+
+            for _, m := range NewMessages(ctx, client, wResp.Events) {
+                if isOfflineKey(m.FullKey) {
+                    t.Errorf("Test delete cause failed, offline marker should not be an event")
+                }
+
+                if m.FullKey == key && m.Type == EVENT_TYPE_DELETE {
+                    deleted = &m
+                }
+            }
+        }
+
+        if deleted.Cause != expected[i] || deleted.PrevValue != "10.0.0.61:80" || !deleted.HasNodeId || deleted.NodeId != 61 {
+            t.Errorf("Test delete cause failed, expected = %v, acctually = %+v", expected[i], *deleted)
+        }
+        client.Revoke(ctx, lease.ID)
+    }
+}
+
+func TestJournal(t *testing.T) {
+    dir, err := ioutil.TempDir("", "journal")
+    if err != nil {
+        t.Fatalf("Create temp dir error: %v", err)
+    }
+    defer os.RemoveAll(dir)
+
+    path := filepath.Join(dir, "events")
+    j, err := NewJournal(path, JOURNAL_MIN_MAXSIZE)
+    if err != nil {
+        t.Fatalf("New journal error: %v", err)
+    }
+
+    if _, err := j.Since(0, 0); err != ErrJournalCompacted {
+        t.Errorf("Since on empty journal expected = %v, acctually = %v", ErrJournalCompacted, err)
+    }
+
+    if err := j.Reset(100); err != nil {
+        t.Fatalf("Reset journal error: %v", err)
+    }
+
+    //同一revision的两个事件，以及没有revision的本地通知
+    messages := []Message{
+        MakeMessage("/CoreNet/Node/1", "10.0.0.1:80", EVENT_TYPE_PUT),
+        MakeMessage("/CoreNet/MS/g/1", "1", EVENT_TYPE_PUT),
+        MakeMessage("/CoreNet/Node/2", "", EVENT_TYPE_CONFLICT),
+        MakeMessage("/CoreNet/Node/3", "10.0.0.3:80", EVENT_TYPE_PUT),
+    }
+    messages[0].ModRevision, messages[1].ModRevision, messages[3].ModRevision = 101, 101, 102
+    if err := j.Append(messages); err != nil {
+        t.Fatalf("Append journal error: %v", err)
+    }
+
+    data := []struct {
+        revision int64
+        limit    int
+        expected []string
+        err      error
+    }{
+        {99, 0, nil, ErrJournalCompacted},
+        {100, 0, []string{"/CoreNet/Node/1", "/CoreNet/MS/g/1", "/CoreNet/Node/3"}, nil},
+        {100, 1, []string{"/CoreNet/Node/1", "/CoreNet/MS/g/1"}, nil},
+        {101, 0, []string{"/CoreNet/Node/3"}, nil},
+        {102, 0, []string{}, nil},
+    }
+
+    check := func(j Journal) {
+        for _, d := range data {
+            acctually, err := j.Since(d.revision, d.limit)
+            keys := make([]string, 0)
+            for _, m := range acctually {
+                keys = append(keys, m.FullKey)
+            }
+            if err != d.err || (err == nil && fmt.Sprint(keys) != fmt.Sprint(d.expected)) {
+                t.Errorf("Since %v limit %v expected = %v %v, acctually = %v %v", d.revision, d.limit, d.expected, d.err, keys, err)
+            }
+        }
+    }
+    check(j)
+
+    //重新打开后沿用已有的事件，不完整的最后一行被丢弃
+    j.Close()
+    f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+    f.WriteString("{\"key\":")
+    f.Close()
+    if j, err = NewJournal(path, JOURNAL_MIN_MAXSIZE); err != nil {
+        t.Fatalf("Reopen journal error: %v", err)
+    }
+    if from, last := j.Revision(); from != 100 || last != 102 {
+        t.Errorf("Reopen journal revision expected = 100 102, acctually = %v %v", from, last)
+    }
+    check(j)
+
+    //超过maxSize后轮转，最早的事件被丢弃
+    var revision int64 = 103
+    for ; revision < 200; revision++ {
+        m := MakeMessage(fmt.Sprintf("/CoreNet/Node/%v", revision), "10.0.0.1:80", EVENT_TYPE_PUT)
+        m.ModRevision = revision
+        if err := j.Append([]Message{m}); err != nil {
+            t.Fatalf("Append journal error: %v", err)
+        }
+    }
+
+    from, last := j.Revision()
+    if from <= 100 || last != 199 {
+        t.Errorf("Rotate journal revision expected from > 100 and last = 199, acctually = %v %v", from, last)
+    }
+    if _, err := j.Since(100, 0); err != ErrJournalCompacted {
+        t.Errorf("Since rotated revision expected = %v, acctually = %v", ErrJournalCompacted, err)
+    }
+    if acctually, err := j.Since(from, 0); err != nil || int64(len(acctually)) != last-from || acctually[0].ModRevision != from+1 {
+        t.Errorf("Since %v expected %v events, acctually = %v, err = %v", from, last-from, len(acctually), err)
+    }
+    if info, err := os.Stat(path); err != nil || info.Size() > JOURNAL_MIN_MAXSIZE+1024 {
+        t.Errorf("Journal size should be bounded, acctually = %v, err = %v", info, err)
+    }
+    j.Close()
+}
+
+func TestWatchJournal(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+    defer client.Close()
+
+    dir, err := ioutil.TempDir("", "journal")
+    if err != nil {
+        t.Fatalf("Create temp dir error: %v", err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "events")
+
+    watch := func() (chan Message, Event, context.CancelFunc) {
+        evt := NewEvent(client)
+        if err := evt.EventSetJournal(path, JOURNAL_DEFAULT_MAXSIZE); err != nil {
+            t.Fatalf("Set journal error: %v", err)
+        }
+        evtCh := make(chan Message, 100)
+        ctx, cancel := context.WithCancel(context.Background())
+        go evt.Watch(ctx, evtCh)
+        time.Sleep(500 * time.Millisecond)
+        return evtCh, evt, cancel
+    }
+
+    evtCh, evt, cancel := watch()
+    resp, err := client.Put(context.TODO(), "/CoreNet/Node/201", "10.0.0.201:80")
+    if err != nil {
+        t.Fatalf("Put error: %v", err)
+    }
+    select {
+    case m := <-evtCh:
+        if m.ModRevision != resp.Header.Revision {
+            t.Errorf("Watch journal revision expected = %v, acctually = %+v", resp.Header.Revision, m)
+        }
+    case <-time.After(5 * time.Second):
+        t.Errorf("Watch journal expected an event")
+    }
+    cancel()
+    evt.EventSetJournal("", 0)
+
+    //agent停止期间的事件只补写到journal，不重复发送
+    since := resp.Header.Revision
+    client.Delete(context.TODO(), "/CoreNet/Node/201")
+    evtCh, evt, cancel = watch()
+    defer cancel()
+
+    select {
+    case m := <-evtCh:
+        t.Errorf("Events before watch should not be sent again, acctually = %+v", m)
+    case <-time.After(time.Second):
+    }
+
+    acctually, err := evt.EventsSince(context.TODO(), since, 0)
+    if err != nil || len(acctually) != 1 || acctually[0].FullKey != "/CoreNet/Node/201" || acctually[0].Type != EVENT_TYPE_DELETE {
+        t.Errorf("Events since %v expected the delete of node 201, acctually = %+v, err = %v", since, acctually, err)
+    }
+
+    if _, err := evt.EventsSince(context.TODO(), since-1000000, 0); err != ErrJournalCompacted {
+        t.Errorf("Events since an old revision expected = %v, acctually = %v", ErrJournalCompacted, err)
+    }
+}
+
+//使用Memory模拟lease到期，删除原因为lease
+func TestWatchMemory(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    evt := NewEventFromBackend(m)
+    evtCh := make(chan Message, 10)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go evt.Watch(ctx, evtCh)
+    time.Sleep(100 * time.Millisecond)
+
+    grantResp, _ := m.Grant(context.TODO(), 1)
+    putResp, _ := m.Put(context.TODO(), "/CoreNet/Node/1", "192.168.0.1:50051", backend.WithLease(grantResp.ID))
+    m.Put(context.TODO(), "/CoreNet/Node/2", "192.168.0.2:50051")
+    m.Delete(context.TODO(), "/CoreNet/Node/2")
+    m.Advance(time.Second)
+
+    data := []struct {
+        key      string
+        evtType  uint8
+        cause    uint8
+        revision int64
+    }{
+        {"1", EVENT_TYPE_PUT, EVENT_CAUSE_NONE, putResp.Header.Revision},
+        {"2", EVENT_TYPE_PUT, EVENT_CAUSE_NONE, putResp.Header.Revision + 1},
+        {"2", EVENT_TYPE_DELETE, EVENT_CAUSE_EXTERNAL, putResp.Header.Revision + 2},
+        {"1", EVENT_TYPE_DELETE, EVENT_CAUSE_LEASE, putResp.Header.Revision + 3},
+    }
+    for _, d := range data {
+        select {
+        case acctually := <-evtCh:
+            if acctually.Key != d.key || acctually.Type != d.evtType || acctually.Cause != d.cause || acctually.ModRevision != d.revision {
+                t.Errorf("Test watch memory failed, expected = %+v, acctually = %+v", d, acctually)
+            }
+        case <-time.After(time.Second):
+            t.Errorf("Test watch memory failed, expected = %+v", d)
+        }
+    }
+}
+
+//MQ无法打开时事件仍然分发给订阅者
+func TestWatchWithoutMq(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    evt := NewEventFromBackend(m)
+    evt.EventSetMq("/etcdmq/invalid", 8, 512, 0600)
+    evtCh := make(chan Message, 10)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go evt.Watch(ctx, evtCh)
+    time.Sleep(100 * time.Millisecond)
+
+    m.Put(context.TODO(), "/CoreNet/Node/3", "192.168.0.3:50051")
+    select {
+    case acctually := <-evtCh:
+        if acctually.Key != "3" || acctually.Type != EVENT_TYPE_PUT {
+            t.Errorf("Test watch without mq failed, expected = 3, acctually = %+v", acctually)
+        }
+    case <-time.After(time.Second):
+        t.Errorf("Test watch without mq failed, no event received")
+    }
+}
@@ -0,0 +1,191 @@
+package event
+
+import (
+    "fmt"
+    "path"
+    "strconv"
+    "strings"
+)
+
+var categories = map[string]uint8{
+    "Node":     EVENT_CATEGORY_NODE,
+    "NodeId":   EVENT_CATEGORY_NODE,
+    "MS":       EVENT_CATEGORY_MS,
+    "Master":   EVENT_CATEGORY_MS,
+    "Transfer": EVENT_CATEGORY_MS,
+    "Config":   EVENT_CATEGORY_CONFIG,
+    "Lock":     EVENT_CATEGORY_LOCK,
+    "Barrier":  EVENT_CATEGORY_BARRIER,
+}
+
+var categoryNames = map[uint8]string{
+    EVENT_CATEGORY_UNKNOWN: "unknown",
+    EVENT_CATEGORY_NODE:    "node",
+    EVENT_CATEGORY_MS:      "ms",
+    EVENT_CATEGORY_CONFIG:  "config",
+    EVENT_CATEGORY_LOCK:    "lock",
+    EVENT_CATEGORY_BARRIER: "barrier",
+}
+
+//订阅条件，各字段之间为"与"关系，字段内为"或"关系，字段为空表示不限制
+//Keys为path.Match模式，匹配去掉 /CoreNet/ 之后的key，如 MS/billing/*
+type Filter struct {
+    Categories []uint8
+    NodeIds    []uint32
+    Keys       []string
+}
+
+//与mq.h中EventCategoryName一致
+func CategoryName(c uint8) string {
+    if name, ok := categoryNames[c]; ok {
+        return name
+    }
+    return categoryNames[EVENT_CATEGORY_UNKNOWN]
+}
+
+func parseCategory(name string) (uint8, bool) {
+    for c, n := range categoryNames {
+        if n == name && c != EVENT_CATEGORY_UNKNOWN {
+            return c, true
+        }
+    }
+    return EVENT_CATEGORY_UNKNOWN, false
+}
+
+//根据完整的key得到类别，如 /CoreNet/Master/billing -> EVENT_CATEGORY_MS
+func category(key string) uint8 {
+    if !strings.HasPrefix(key, EVENT_ROOT_PREFIX) {
+        return EVENT_CATEGORY_UNKNOWN
+    }
+
+    dir := strings.SplitN(strings.TrimPrefix(key, EVENT_ROOT_PREFIX), "/", 2)[0]
+    return categories[dir]
+}
+
+//事件相关的nodeId：Master/<group>取value，配置没有nodeId，其余取key的最后一段
+func nodeId(key string, value string, category uint8) (uint32, bool) {
+    var s string
+    switch {
+    case category == EVENT_CATEGORY_CONFIG || category == EVENT_CATEGORY_UNKNOWN:
+        return 0, false
+    case strings.HasPrefix(key, EVENT_ROOT_PREFIX+"Master/"):
+        s = value
+    default:
+        s = key[strings.LastIndex(key, "/")+1:]
+    }
+
+    id, err := strconv.ParseUint(s, 10, 32)
+    if err != nil {
+        return 0, false
+    }
+    return uint32(id), true
+}
+
+func (f *Filter) Match(m Message) bool {
+    if len(f.Categories) != 0 {
+        matched := false
+        for _, c := range f.Categories {
+            if c == m.Category {
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            return false
+        }
+    }
+
+    if len(f.NodeIds) != 0 {
+        if !m.HasNodeId {
+            return false
+        }
+
+        matched := false
+        for _, n := range f.NodeIds {
+            if n == m.NodeId {
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            return false
+        }
+    }
+
+    if len(f.Keys) != 0 {
+        key := strings.TrimPrefix(m.FullKey, EVENT_ROOT_PREFIX)
+        for _, pattern := range f.Keys {
+            if ok, _ := path.Match(pattern, key); ok {
+                return true
+            }
+        }
+        return false
+    }
+    return true
+}
+
+//文本格式供C接口和IPC使用，如 "category=node,ms;node=1,2;key=MS/billing/*"，空字符串匹配所有事件
+func ParseFilter(s string) (Filter, error) {
+    var f Filter
+    for _, item := range strings.Split(s, ";") {
+        item = strings.TrimSpace(item)
+        if item == "" {
+            continue
+        }
+
+        kv := strings.SplitN(item, "=", 2)
+        if len(kv) != 2 || kv[1] == "" {
+            return f, fmt.Errorf("Invalid filter item %q, expected <name>=<value>[,<value>...]", item)
+        }
+
+        for _, v := range strings.Split(kv[1], ",") {
+            v = strings.TrimSpace(v)
+            switch strings.TrimSpace(kv[0]) {
+            case "category":
+                c, ok := parseCategory(v)
+                if !ok {
+                    return f, fmt.Errorf("Invalid filter category %q", v)
+                }
+                f.Categories = append(f.Categories, c)
+            case "node":
+                id, err := strconv.ParseUint(v, 10, 32)
+                if err != nil {
+                    return f, fmt.Errorf("Invalid filter nodeId %q", v)
+                }
+                f.NodeIds = append(f.NodeIds, uint32(id))
+            case "key":
+                if _, err := path.Match(v, ""); err != nil {
+                    return f, fmt.Errorf("Invalid filter key pattern %q", v)
+                }
+                f.Keys = append(f.Keys, v)
+            default:
+                return f, fmt.Errorf("Unknown filter item %q", kv[0])
+            }
+        }
+    }
+    return f, nil
+}
+
+func (f Filter) String() string {
+    var items []string
+    if len(f.Categories) != 0 {
+        names := make([]string, len(f.Categories))
+        for i, c := range f.Categories {
+            names[i] = CategoryName(c)
+        }
+        items = append(items, "category="+strings.Join(names, ","))
+    }
+
+    if len(f.NodeIds) != 0 {
+        ids := make([]string, len(f.NodeIds))
+        for i, id := range f.NodeIds {
+            ids[i] = strconv.FormatUint(uint64(id), 10)
+        }
+        items = append(items, "node="+strings.Join(ids, ","))
+    }
+
+    if len(f.Keys) != 0 {
+        items = append(items, "key="+strings.Join(f.Keys, ","))
+    }
+    return strings.Join(items, ";")
+}
@@ -0,0 +1,273 @@
+package event
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+const (
+    JOURNAL_DEFAULT_MAXSIZE = 4 << 20
+    JOURNAL_MIN_MAXSIZE     = 4096
+    JOURNAL_OLD_SUFFIX      = ".1"
+)
+
+var (
+    ErrJournalCompacted = fmt.Errorf("Events since the revision are no longer in the journal, please resync")
+    ErrJournalDisabled  = fmt.Errorf("Event journal is not enabled")
+)
+
+//按etcd revision记录已发送的事件，消费者重启后从上次处理的revision继续
+//journal由当前文件和一个历史文件组成，当前文件超过maxSize后替换历史文件，占用空间不超过2*maxSize
+//每个文件第一行为起始revision，之后每行一个JSON格式的事件，本地通知没有revision，不记录
+type Journal interface {
+    Append(messages []Message) error
+    Since(revision int64, limit int) ([]Message, error)
+    Revision() (int64, int64)
+    Reset(revision int64) error
+    Close() error
+}
+
+type journalHeader struct {
+    From int64 `json:"from"`
+}
+
+type journal struct {
+    mu      sync.Mutex
+    path    string
+    maxSize int64
+    file    *os.File
+    size    int64
+    from    int64 //大于from的事件完整记录在journal中
+    last    int64 //最后记录的revision，为0时journal为空
+}
+
+//已有journal时沿用，agent重启后从最后的revision继续记录
+func NewJournal(path string, maxSize int64) (Journal, error) {
+    if maxSize < JOURNAL_MIN_MAXSIZE {
+        return nil, fmt.Errorf("Journal max size must be at least %v bytes, got %v", JOURNAL_MIN_MAXSIZE, maxSize)
+    }
+
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, fmt.Errorf("Create journal directory error, reason: %v", err)
+    }
+
+    j := &journal{path: path, maxSize: maxSize}
+    if err := j.load(); err != nil {
+        return nil, err
+    }
+    return j, nil
+}
+
+func (j *journal) load() error {
+    var from int64
+    if header, _, err := readJournal(j.path+JOURNAL_OLD_SUFFIX, nil); err == nil {
+        from = header.From
+    } else if !os.IsNotExist(err) {
+        return err
+    }
+
+    var last int64
+    header, valid, err := readJournal(j.path, func(m Message) {
+        last = m.ModRevision
+    })
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    if from == 0 {
+        from = header.From
+    }
+    if last == 0 {
+        last = header.From
+    }
+
+    //agent异常退出时最后一行可能不完整
+    if j.file, err = os.OpenFile(j.path, os.O_WRONLY, 0644); err != nil {
+        return err
+    }
+    if err = j.file.Truncate(valid); err != nil {
+        j.file.Close()
+        return err
+    }
+    if _, err = j.file.Seek(valid, io.SeekStart); err != nil {
+        j.file.Close()
+        return err
+    }
+    j.size, j.from, j.last = valid, from, last
+    return nil
+}
+
+//依次读取文件中的事件，返回文件头和最后一个完整行之后的偏移
+func readJournal(path string, fn func(m Message)) (journalHeader, int64, error) {
+    var header journalHeader
+    f, err := os.Open(path)
+    if err != nil {
+        return header, 0, err
+    }
+    defer f.Close()
+
+    reader := bufio.NewReader(f)
+    line, err := reader.ReadBytes('\n')
+    if err != nil || json.Unmarshal(line, &header) != nil {
+        return header, 0, fmt.Errorf("Invalid journal header in %v", path)
+    }
+
+    valid := int64(len(line))
+    for {
+        line, err := reader.ReadBytes('\n')
+        if err != nil {
+            break
+        }
+
+        var m Message
+        if json.Unmarshal(line, &m) != nil {
+            break
+        }
+        if fn != nil {
+            fn(m)
+        }
+        valid += int64(len(line))
+    }
+    return header, valid, nil
+}
+
+func (j *journal) Append(messages []Message) error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    if j.file == nil {
+        return fmt.Errorf("Journal %v is not started", j.path)
+    }
+
+    for _, m := range messages {
+        if m.ModRevision == 0 {
+            continue
+        }
+
+        line, err := json.Marshal(m)
+        if err != nil {
+            return err
+        }
+        n, err := j.file.Write(append(line, '\n'))
+        j.size += int64(n)
+        if err != nil {
+            return fmt.Errorf("Write journal %v error, reason: %v", j.path, err)
+        }
+        j.last = m.ModRevision
+    }
+
+    //同一个Watch响应中的事件写入同一个文件，保证revision不被拆分
+    if j.size > j.maxSize {
+        return j.rotate()
+    }
+    return nil
+}
+
+func (j *journal) rotate() error {
+    j.file.Close()
+    j.file = nil
+    if err := os.Rename(j.path, j.path+JOURNAL_OLD_SUFFIX); err != nil {
+        return fmt.Errorf("Rotate journal %v error, reason: %v", j.path, err)
+    }
+
+    //历史文件的起始revision成为journal的起始revision
+    header, _, err := readJournal(j.path+JOURNAL_OLD_SUFFIX, nil)
+    if err != nil {
+        return err
+    }
+    j.from = header.From
+    return j.create(j.last)
+}
+
+func (j *journal) create(revision int64) error {
+    f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return fmt.Errorf("Create journal %v error, reason: %v", j.path, err)
+    }
+
+    line, _ := json.Marshal(journalHeader{From: revision})
+    n, err := f.Write(append(line, '\n'))
+    if err != nil {
+        f.Close()
+        return fmt.Errorf("Write journal %v error, reason: %v", j.path, err)
+    }
+    j.file, j.size = f, int64(n)
+    return nil
+}
+
+//丢弃已有的事件，之后从revision之后开始记录
+func (j *journal) Reset(revision int64) error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    if j.file != nil {
+        j.file.Close()
+        j.file = nil
+    }
+    if err := os.Remove(j.path + JOURNAL_OLD_SUFFIX); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+
+    j.from, j.last = revision, revision
+    return j.create(revision)
+}
+
+//返回journal的起始revision和最后的revision，journal为空时均为0
+func (j *journal) Revision() (int64, int64) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.from, j.last
+}
+
+//返回revision之后的事件，limit为0时不限制个数，同一revision的事件总是一起返回
+//revision之后的事件已不完整时返回ErrJournalCompacted
+func (j *journal) Since(revision int64, limit int) ([]Message, error) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    if j.file == nil || revision < j.from {
+        return nil, ErrJournalCompacted
+    }
+
+    messages := make([]Message, 0)
+    full := false
+    collect := func(m Message) {
+        if full || m.ModRevision <= revision {
+            return
+        }
+
+        n := len(messages)
+        if limit > 0 && n >= limit && messages[n-1].ModRevision != m.ModRevision {
+            full = true
+            return
+        }
+        messages = append(messages, m)
+    }
+
+    for _, path := range []string{j.path + JOURNAL_OLD_SUFFIX, j.path} {
+        if _, _, err := readJournal(path, collect); err != nil && !os.IsNotExist(err) {
+            return nil, err
+        }
+    }
+    return messages, nil
+}
+
+func (j *journal) Close() error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    if j.file == nil {
+        return nil
+    }
+    err := j.file.Close()
+    j.file = nil
+    return err
+}
@@ -1,5 +1,7 @@
 #include <mqueue.h>
 #include <sys/stat.h>
+#include <sys/resource.h>
+#include <time.h>
 #include <fcntl.h>
 #include <pthread.h>
 #include <unistd.h>
@@ -10,9 +12,9 @@
 #include <stdint.h>
 #include "mq.h"
 
-#define MQ_MAX_MSGSIZE 1024 //系统配置为8192字节
-
 mqd_t etcdmqd;
+char etcdmqname[MAX_MQNAMELENGTH] = ETCDMQ;
+long etcdmqmsgsize = MQ_DEFAULT_MSGSIZE; //系统配置为8192字节
 
 /*
  * 1）为变长消息申请内存，使用完后需主动释放
@@ -21,16 +23,26 @@ mqd_t etcdmqd;
 Message *NewMessage(uint32_t maxEvents)
 {
     uint32_t size = sizeof(Message) + maxEvents * sizeof(Event);
-    if (size >= MQ_MAX_MSGSIZE)
+    if (size > etcdmqmsgsize)
     {
         errno = EINVAL;
         return NULL;
     }
+    return AllocMessage(maxEvents);
+}
 
+/*
+ * 不受MQ消息大小限制，用于EtcdEventsSince等直接返回给调用者的消息
+ */
+Message *AllocMessage(uint32_t maxEvents)
+{
+    size_t size = sizeof(Message) + (size_t)maxEvents * sizeof(Event);
     Message *ptMessage = (Message *)malloc(size);
     if (ptMessage != NULL)
     {
         memset(ptMessage, 0, size);
+        ptMessage->version = MESSAGE_VERSION;
+        ptMessage->eventSize = sizeof(Event);
         return ptMessage;
     }
 
@@ -56,12 +68,42 @@ uint32_t GetMessageSize(Message *ptMessage)
  */
 void AddEvent(Message *ptMessage, char *key, char *value, uint8_t type)
 {
-    strncpy(ptMessage->evts[ptMessage->length].key, key, MAX_KEYLENGTH);
-    strncpy(ptMessage->evts[ptMessage->length].value, value, MAX_VALUELENGTH);
+    strncpy(ptMessage->evts[ptMessage->length].key, key, MAX_KEYLENGTH - 1);
+    strncpy(ptMessage->evts[ptMessage->length].value, value, MAX_VALUELENGTH - 1);
     ptMessage->evts[ptMessage->length].type = type;
     ptMessage->length++;
 }
 
+/*
+ * 构建带类别、nodeId、完整key和删除原因的事件，超长的key和value被截断
+ */
+void AddTypedEvent(Message *ptMessage, char *key, char *value, uint8_t type,
+                   uint8_t category, uint8_t flags, uint32_t nodeId, char *fullKey,
+                   uint8_t cause, char *prevValue)
+{
+    Event *event = &ptMessage->evts[ptMessage->length];
+    AddEvent(ptMessage, key, value, type);
+    event->category = category;
+    event->flags = flags;
+    event->cause = cause;
+    event->nodeId = nodeId;
+    strncpy(event->fullKey, fullKey, MAX_FULLKEYLENGTH - 1);
+    strncpy(event->prevValue, prevValue, MAX_VALUELENGTH - 1);
+}
+
+/*
+ * 设置最后一个事件的revision、租约和收到的时间
+ */
+void SetEventRevision(Message *ptMessage, int64_t modRevision, int64_t createRevision,
+                      int64_t lease, int64_t timestamp)
+{
+    Event *event = &ptMessage->evts[ptMessage->length - 1];
+    event->modRevision = modRevision;
+    event->createRevision = createRevision;
+    event->lease = lease;
+    event->timestamp = timestamp;
+}
+
 void DumpMessage(Message *ptMessage)
 {
     if (ptMessage == NULL)
@@ -76,38 +118,104 @@ void DumpMessage(Message *ptMessage)
         printf("Events: %u\n", ptMessage->length);
         for (; i < ptMessage->length; i++)
         {
-            printf("%u: %s -> %s\n", i, ptMessage->evts[i].key, ptMessage->evts[i].value);
+            printf("%u: %s %s -> %s, revision %lld\n", i, EventCategoryName(ptMessage->evts[i].category),
+                   ptMessage->evts[i].fullKey, ptMessage->evts[i].value, (long long)ptMessage->evts[i].modRevision);
         }
     }
 }
 
 /*
- * 创建消息队列，mq_maxmsg 最大消息数，mq_msgsize 单个消息最大长度
- * dingrui@dingrui-PC:~/Programes$ grep msg /proc/self/limits 
- * Max msgqueue size         819200               819200               bytes     
- *  
+ * 打开已存在的MQ并沿用原有属性，通过maxmsg、msgsize返回实际属性
+ * 不删除队列，已经打开该队列的消费者不受影响
  */
-int MqOpen()
+int MqAttach(const char *name, long *maxmsg, long *msgsize)
 {
-    int flags;
-    mode_t perms;
+    struct mq_attr attrs;
     mqd_t mqd;
+
+    mqd = mq_open(name, O_RDWR);
+    if (mqd == (mqd_t)-1)
+    {
+        return -1;
+    }
+
+    if (mq_getattr(mqd, &attrs) == -1)
+    {
+        int err = errno;
+        mq_close(mqd);
+        errno = err;
+        return -1;
+    }
+
+    strncpy(etcdmqname, name, MAX_MQNAMELENGTH - 1);
+    etcdmqmsgsize = attrs.mq_msgsize;
+    etcdmqd = mqd;
+    *maxmsg = attrs.mq_maxmsg;
+    *msgsize = attrs.mq_msgsize;
+    return 0;
+}
+
+/*
+ * 创建新的MQ，name 队列名称，mq_maxmsg 最大消息数，mq_msgsize 单个消息最大长度
+ * 队列已存在时返回EEXIST，超过系统限制时返回EINVAL或者EMFILE
+ * 创建后按mode设置权限，不受umask影响
+ */
+int MqCreate(const char *name, long maxmsg, long msgsize, unsigned int mode)
+{
     struct mq_attr attrs;
+    mqd_t mqd;
+
+    attrs.mq_maxmsg = maxmsg;
+    attrs.mq_msgsize = msgsize;
+    mqd = mq_open(name, O_RDWR | O_CREAT | O_EXCL, mode, &attrs);
+    if (mqd == (mqd_t)-1)
+    {
+        return -1;
+    }
 
-    attrs.mq_maxmsg = 512;
-    attrs.mq_msgsize = 1024;
-    flags = O_RDWR | O_CREAT;
-    mq_unlink(ETCDMQ);
-    etcdmqd = mq_open(ETCDMQ, flags, 0666, &attrs);
-    if (etcdmqd == (mqd_t)-1)
+    if (fchmod(mqd, mode) == -1)
     {
-        perror("mq_open");
-        return 1;
+        perror("fchmod");
     }
 
+    strncpy(etcdmqname, name, MAX_MQNAMELENGTH - 1);
+    etcdmqmsgsize = msgsize;
+    etcdmqd = mqd;
     return 0;
 }
 
+static long ReadLimit(const char *path)
+{
+    long value = -1;
+    FILE *fp = fopen(path, "r");
+    if (fp != NULL)
+    {
+        if (fscanf(fp, "%ld", &value) != 1)
+        {
+            value = -1;
+        }
+        fclose(fp);
+    }
+    return value;
+}
+
+/*
+ * 非特权进程受以下限制，读取失败或者不限制时返回-1
+ * /proc/sys/fs/mqueue/msg_max、msgsize_max，以及RLIMIT_MSGQUEUE（同一用户所有队列的总字节数）
+ */
+void MqLimits(long *maxmsg, long *msgsize, long *bytes)
+{
+    struct rlimit rlim;
+
+    *maxmsg = ReadLimit("/proc/sys/fs/mqueue/msg_max");
+    *msgsize = ReadLimit("/proc/sys/fs/mqueue/msgsize_max");
+    *bytes = -1;
+    if (getrlimit(RLIMIT_MSGQUEUE, &rlim) == 0 && rlim.rlim_cur != RLIM_INFINITY)
+    {
+        *bytes = (long)rlim.rlim_cur;
+    }
+}
+
 /* 
  * 向MQ发送消息
  */
@@ -116,6 +224,46 @@ int MqSend(Message *message, uint32_t size)
     return mq_send(etcdmqd, (char *)message, size, 0);
 }
 
+/*
+ * 队列满时最多等待timeoutMs毫秒，为0时不等待，超时返回ETIMEDOUT
+ */
+int MqSendTimeout(Message *message, uint32_t size, long timeoutMs)
+{
+    struct timespec ts;
+
+    clock_gettime(CLOCK_REALTIME, &ts);
+    ts.tv_sec += timeoutMs / 1000;
+    ts.tv_nsec += (timeoutMs % 1000) * 1000000;
+    if (ts.tv_nsec >= 1000000000)
+    {
+        ts.tv_sec++;
+        ts.tv_nsec -= 1000000000;
+    }
+    return mq_timedsend(etcdmqd, (char *)message, size, 0, &ts);
+}
+
+/*
+ * 丢弃队列中最早的消息，返回其中的事件数，队列为空时返回-1
+ */
+int MqDropOldest()
+{
+    struct timespec ts;
+    int events = -1;
+    char *buf = (char *)malloc(etcdmqmsgsize);
+    if (buf == NULL)
+    {
+        return -1;
+    }
+
+    clock_gettime(CLOCK_REALTIME, &ts);
+    if (mq_timedreceive(etcdmqd, buf, etcdmqmsgsize, NULL, &ts) >= 0)
+    {
+        events = (int)((Message *)buf)->length;
+    }
+    free(buf);
+    return events;
+}
+
 /* 
  * 关闭MQ描述符
  */
@@ -129,5 +277,40 @@ int MqClose()
  */
 int MqUnlink()
 {
-    return mq_unlink(ETCDMQ);
-}
\ No newline at end of file
+    return mq_unlink(etcdmqname);
+}
+
+/*
+ * 打开订阅者的MQ，队列已存在时沿用原有属性，不删除队列中的消息
+ * 以非阻塞方式发送，订阅者处理不及时不影响agent
+ */
+int MqOpenTarget(const char *name, long maxmsg, long msgsize)
+{
+    struct mq_attr attrs;
+
+    attrs.mq_maxmsg = maxmsg;
+    attrs.mq_msgsize = msgsize;
+    return mq_open(name, O_WRONLY | O_CREAT | O_NONBLOCK, 0666, &attrs);
+}
+
+int MqSendTarget(int mqd, Message *message, uint32_t size)
+{
+    return mq_send(mqd, (char *)message, size, 0);
+}
+
+int MqCloseTarget(int mqd)
+{
+    return mq_close(mqd);
+}
+
+/*
+ * Go不能直接调用C函数指针，依次回调消息中的事件
+ */
+void CallEventCallback(EventCallback callback, Message *message, void *arg)
+{
+    uint32_t i = 0;
+    for (; i < message->length; i++)
+    {
+        callback(&message->evts[i], arg);
+    }
+}
@@ -1,28 +1,171 @@
 #include <stdint.h>
 #include <stdlib.h>
+#include <errno.h>
+#include <sys/types.h>
 
 #define MAX_KEYLENGTH 64
 #define MAX_VALUELENGTH 64
+#define MAX_FULLKEYLENGTH 256
 #define ETCDMQ "/etcdmq"
+#define MAX_MQNAMELENGTH 256
+#define MQ_DEFAULT_MAXMSG 512
+#define MQ_DEFAULT_MSGSIZE 1024
+#define MQ_DEFAULT_MODE 0666
+#define MQ_MSG_OVERHEAD 128 /* 内核中每个消息的管理开销，按RLIMIT_MSGQUEUE估算队列长度时使用 */
+
+/* 事件类别，由key的一级目录决定 */
+#define EVENT_CATEGORY_UNKNOWN 0
+#define EVENT_CATEGORY_NODE 1    /* /CoreNet/Node/<id>、/CoreNet/NodeId/<id> */
+#define EVENT_CATEGORY_MS 2      /* /CoreNet/MS/<group>/<id>、/CoreNet/Master/<group>、/CoreNet/Transfer/<group> */
+#define EVENT_CATEGORY_CONFIG 3  /* /CoreNet/Config/<key> */
+#define EVENT_CATEGORY_LOCK 4    /* /CoreNet/Lock/<name>/<id> */
+#define EVENT_CATEGORY_BARRIER 5 /* /CoreNet/Barrier/<name>/<id> */
+
+#define EVENT_FLAG_NODEID 0x01 /* nodeId有效 */
+
+/*
+ * 消息格式版本，Event中追加字段时只增大eventSize，不兼容的修改才增加版本号
+ * 1：Event增加category、flags、nodeId、fullKey，Message增加version、eventSize
+ */
+#define MESSAGE_VERSION 1
+
+/* 删除原因，仅删除事件有效 */
+#define EVENT_CAUSE_NONE 0     /* 非删除事件，或者无法判断 */
+#define EVENT_CAUSE_OFFLINE 1  /* 主动下线：NodeOffline、MSGiveUp */
+#define EVENT_CAUSE_LEASE 2    /* 租约过期或者被撤销，如进程退出后未保活 */
+#define EVENT_CAUSE_EXTERNAL 3 /* 被其他程序删除 */
 
 typedef struct _Event
 {
-    char key[MAX_KEYLENGTH];
+    char key[MAX_KEYLENGTH];     /* 去掉 /CoreNet/<类别>/ 之后的key，如 3、billing/3 */
     char value[MAX_VALUELENGTH];
-    uint8_t type; /*0：put 1:delete */
+    uint8_t type; /*0：put 1:delete 2:conflict 3:lock lost 4:config put 5:config delete 6:resync 7:reconcile */
+    uint8_t category; /* EVENT_CATEGORY_* */
+    uint8_t flags;    /* EVENT_FLAG_* */
+    uint8_t cause;    /* EVENT_CAUSE_* */
+    uint32_t nodeId;  /* 事件相关的nodeId，Master/<group>取value中的master */
+    char fullKey[MAX_FULLKEYLENGTH]; /* etcd中的完整key，如 /CoreNet/MS/billing/3 */
+    char prevValue[MAX_VALUELENGTH]; /* 修改或删除之前的value，如下线node的服务地址 */
+    int64_t modRevision;    /* etcd中的修改revision，同一个key单调递增，本地通知为0 */
+    int64_t createRevision; /* key的创建revision，删除事件取删除前的值 */
+    int64_t lease;          /* key的租约ID，删除事件取删除前的值，没有租约为0 */
+    int64_t timestamp;      /* agent收到事件的时间，Unix纳秒 */
 } Event;
 
+/* 订阅回调，event在回调返回后释放 */
+typedef void (*EventCallback)(const Event *event, void *arg);
+
 typedef struct _Message
 {
     uint32_t length; //变长数组长度
+    uint16_t version;   /* MESSAGE_VERSION */
+    uint16_t eventSize; /* 每个Event的大小，消费者使用MessageEvent按该大小遍历 */
     Event evts[0];
 } Message;
 
 Message *NewMessage(uint32_t maxEvents);
+Message *AllocMessage(uint32_t maxEvents);
 uint32_t GetMessageSize(Message *ptMessage);
 void AddEvent(Message *ptMessage, char *key, char *value, uint8_t type);
+void AddTypedEvent(Message *ptMessage, char *key, char *value, uint8_t type,
+                   uint8_t category, uint8_t flags, uint32_t nodeId, char *fullKey,
+                   uint8_t cause, char *prevValue);
+void SetEventRevision(Message *ptMessage, int64_t modRevision, int64_t createRevision,
+                      int64_t lease, int64_t timestamp);
 void DumpMessage(Message *ptMessage);
-int MqOpen();
+int MqAttach(const char *name, long *maxmsg, long *msgsize);
+int MqCreate(const char *name, long maxmsg, long msgsize, unsigned int mode);
+void MqLimits(long *maxmsg, long *msgsize, long *bytes);
 int MqSend(Message *message, uint32_t size);
+int MqSendTimeout(Message *message, uint32_t size, long timeoutMs);
+int MqDropOldest();
 int MqClose();
-int MqUnlink();
\ No newline at end of file
+int MqUnlink();
+int MqOpenTarget(const char *name, long maxmsg, long msgsize);
+int MqSendTarget(int mqd, Message *message, uint32_t size);
+int MqCloseTarget(int mqd);
+void CallEventCallback(EventCallback callback, Message *message, void *arg);
+
+/*
+ * 消费者解析mq_receive收到的数据，不需要链接libetcd
+ * 长度与事件个数不一致时返回NULL，errno为EINVAL；版本不一致时返回NULL，errno为EPROTO
+ * 新版本agent追加的字段不影响旧的消费者，Event需要通过MessageEvent读取
+ */
+static inline const Message *DecodeMessage(const void *buf, ssize_t len)
+{
+    const Message *message = (const Message *)buf;
+    if (buf == NULL || len < (ssize_t)sizeof(Message))
+    {
+        errno = EINVAL;
+        return NULL;
+    }
+
+    if (message->version != MESSAGE_VERSION || message->eventSize < sizeof(Event))
+    {
+        errno = EPROTO;
+        return NULL;
+    }
+
+    if ((size_t)len != sizeof(Message) + (size_t)message->length * message->eventSize)
+    {
+        errno = EINVAL;
+        return NULL;
+    }
+    return message;
+}
+
+static inline const Event *MessageEvent(const Message *message, uint32_t index)
+{
+    return (const Event *)((const char *)message->evts + (size_t)index * message->eventSize);
+}
+
+/*
+ * 事件相关的nodeId，没有时返回0
+ */
+static inline int EventNodeId(const Event *event, uint32_t *nodeId)
+{
+    if ((event->flags & EVENT_FLAG_NODEID) == 0)
+    {
+        return 0;
+    }
+
+    if (nodeId != NULL)
+    {
+        *nodeId = event->nodeId;
+    }
+    return 1;
+}
+
+static inline const char *EventCategoryName(uint8_t category)
+{
+    switch (category)
+    {
+    case EVENT_CATEGORY_NODE:
+        return "node";
+    case EVENT_CATEGORY_MS:
+        return "ms";
+    case EVENT_CATEGORY_CONFIG:
+        return "config";
+    case EVENT_CATEGORY_LOCK:
+        return "lock";
+    case EVENT_CATEGORY_BARRIER:
+        return "barrier";
+    default:
+        return "unknown";
+    }
+}
+
+static inline const char *EventCauseName(uint8_t cause)
+{
+    switch (cause)
+    {
+    case EVENT_CAUSE_OFFLINE:
+        return "offline";
+    case EVENT_CAUSE_LEASE:
+        return "lease";
+    case EVENT_CAUSE_EXTERNAL:
+        return "external";
+    default:
+        return "none";
+    }
+}
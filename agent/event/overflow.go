@@ -0,0 +1,191 @@
+package event
+
+/*
+#include "mq.h"
+*/
+import "C"
+import (
+    "context"
+    "errors"
+    "etcdagent/agent/log"
+    "fmt"
+    "strconv"
+    "syscall"
+    "time"
+    "unsafe"
+)
+
+//MQ满时的处理策略
+const (
+    MQ_OVERFLOW_DROP_NEWEST = "drop-newest" //丢弃新事件
+    MQ_OVERFLOW_DROP_OLDEST = "drop-oldest" //丢弃队列中最早的消息
+    MQ_OVERFLOW_RESYNC      = "resync"      //丢弃新事件，队列恢复后先发送EVENT_TYPE_RESYNC，value为丢弃的事件数
+    MQ_RESYNC_KEY           = "resync"
+)
+
+var ErrQueueFull = errors.New("Message queue is full")
+
+//MQ发送统计，单位为事件个数
+type Stats struct {
+    Sent    uint64
+    Dropped uint64
+    Resyncs uint64
+}
+
+//timeout为MQ满时的最长等待时间，为0时不等待
+func (e *event) EventSetOverflow(policy string, timeout time.Duration) error {
+    switch policy {
+    case MQ_OVERFLOW_DROP_NEWEST, MQ_OVERFLOW_DROP_OLDEST, MQ_OVERFLOW_RESYNC:
+    default:
+        return fmt.Errorf("Invalid overflow policy %q, expected %v, %v or %v", policy,
+            MQ_OVERFLOW_DROP_NEWEST, MQ_OVERFLOW_DROP_OLDEST, MQ_OVERFLOW_RESYNC)
+    }
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.overflow = policy
+    e.sendTimeout = timeout
+    log.Info("Set mq overflow policy = %v, send timeout = %v", policy, timeout)
+    return nil
+}
+
+func (e *event) EventStats() Stats {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    return e.stats
+}
+
+//按MQ的消息大小分批发送，MQ满时的等待时间不超过ctx的deadline
+func (e *event) send(ctx context.Context, messages []Message) error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    timeout := e.sendTimeout
+    if deadline, ok := ctx.Deadline(); ok {
+        if remain := time.Until(deadline); remain < timeout {
+            timeout = remain
+        }
+    }
+    if timeout < 0 {
+        timeout = 0
+    }
+
+    batch := int((e.msgSize - C.sizeof_Message) / C.sizeof_Event)
+    var err error
+    for len(messages) > 0 {
+        if cerr := ctx.Err(); cerr != nil {
+            return cerr
+        }
+
+        n := len(messages)
+        if n > batch {
+            n = batch
+        }
+
+        if serr := e.sendBatch(messages[:n], timeout); serr != nil {
+            err = serr
+        }
+        messages = messages[n:]
+    }
+    return err
+}
+
+func (e *event) sendBatch(messages []Message, timeout time.Duration) error {
+    n := uint64(len(messages))
+
+    //resync策略下，先发送标记再恢复正常发送
+    if e.pending != 0 && !e.sendResync() {
+        e.drop(n)
+        return ErrQueueFull
+    }
+
+    message := newCMessage(messages...)
+    if message == nil {
+        return fmt.Errorf("Cannot allocate message for %v events", n)
+    }
+    defer C.free(unsafe.Pointer(message))
+    C.DumpMessage(message)
+
+    for {
+        ret, err := C.MqSendTimeout(message, C.GetMessageSize(message), C.long(timeout/time.Millisecond))
+        if ret == 0 {
+            e.sent(n)
+            return nil
+        }
+
+        if err != syscall.EAGAIN && err != syscall.ETIMEDOUT {
+            e.stats.Dropped += n
+            return fmt.Errorf("Send to message queue %v error, reason: %v", e.mqName, err)
+        }
+
+        //丢弃最早的消息后重试，队列已空时丢弃新事件
+        if e.overflow == MQ_OVERFLOW_DROP_OLDEST {
+            if dropped := dropOldest(); dropped >= 0 {
+                e.drop(uint64(dropped))
+                continue
+            }
+        }
+
+        e.drop(n)
+        return ErrQueueFull
+    }
+}
+
+//切换为drop-oldest策略后，丢弃最早的消息为标记腾出空间
+func (e *event) sendResync() bool {
+    for {
+        message := newCMessage(Message{Key: MQ_RESYNC_KEY, Value: strconv.FormatUint(e.pending, 10), Type: EVENT_TYPE_RESYNC, FullKey: MQ_RESYNC_KEY,
+            Timestamp: time.Now()})
+        if message == nil {
+            return false
+        }
+
+        ret := C.MqSendTimeout(message, C.GetMessageSize(message), 0)
+        C.free(unsafe.Pointer(message))
+        if ret == 0 {
+            break
+        }
+
+        if e.overflow != MQ_OVERFLOW_DROP_OLDEST {
+            return false
+        }
+
+        dropped := dropOldest()
+        if dropped < 0 {
+            return false
+        }
+        e.stats.Dropped += uint64(dropped)
+        e.pending += uint64(dropped)
+    }
+
+    log.Warn("Message queue %v resync required, dropped %v events", e.mqName, e.pending)
+    e.stats.Resyncs++
+    e.pending = 0
+    e.sent(1)
+    return true
+}
+
+//返回丢弃的事件数，队列为空时返回-1
+func dropOldest() int {
+    return int(C.MqDropOldest())
+}
+
+func (e *event) drop(n uint64) {
+    if !e.full {
+        log.Warn("Message queue %v is full, overflow policy: %v", e.mqName, e.overflow)
+        e.full = true
+    }
+
+    e.stats.Dropped += n
+    if e.overflow == MQ_OVERFLOW_RESYNC {
+        e.pending += n
+    }
+}
+
+func (e *event) sent(n uint64) {
+    if e.full {
+        log.Warn("Message queue %v recovered, dropped %v events in total", e.mqName, e.stats.Dropped)
+        e.full = false
+    }
+    e.stats.Sent += n
+}
@@ -0,0 +1,203 @@
+package event
+
+/*
+#include "mq.h"
+*/
+import "C"
+import (
+    "errors"
+    "etcdagent/agent/log"
+    "fmt"
+    "sync"
+    "unsafe"
+)
+
+var ErrSubscriptionNotFound = errors.New("Subscription not found")
+
+//事件的投递目标，Deliver在agent的事件协程中调用，不能长时间阻塞
+type Target interface {
+    Deliver(m Message) error
+    Close() error
+}
+
+//按订阅条件把事件分发到各个目标
+type Dispatcher interface {
+    Subscribe(filter Filter, target Target) uint32
+    Unsubscribe(id uint32) error
+    Dispatch(m Message)
+}
+
+type subscription struct {
+    filter Filter
+    target Target
+}
+
+type dispatcher struct {
+    mu     sync.Mutex
+    nextId uint32
+    subs   map[uint32]*subscription
+}
+
+func NewDispatcher() Dispatcher {
+    return &dispatcher{
+        subs: make(map[uint32]*subscription),
+    }
+}
+
+func (d *dispatcher) Subscribe(filter Filter, target Target) uint32 {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    d.nextId++
+    d.subs[d.nextId] = &subscription{filter: filter, target: target}
+    log.Info("Add subscription, id: %v, filter: %q", d.nextId, filter.String())
+    return d.nextId
+}
+
+func (d *dispatcher) Unsubscribe(id uint32) error {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    sub, ok := d.subs[id]
+    if !ok {
+        return ErrSubscriptionNotFound
+    }
+    delete(d.subs, id)
+    log.Info("Remove subscription, id: %v", id)
+    return sub.target.Close()
+}
+
+//投递失败只记录日志，不影响其他订阅者
+func (d *dispatcher) Dispatch(m Message) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    for id, sub := range d.subs {
+        if !sub.filter.Match(m) {
+            continue
+        }
+
+        if err := sub.target.Deliver(m); err != nil {
+            log.Warn("Deliver event error, subscription: %v, key: %v, reason: %v", id, m.Key, err.Error())
+        }
+    }
+}
+
+//Go协程订阅，缓冲区满时丢弃事件
+type chanTarget struct {
+    ch chan Message
+}
+
+func NewChanTarget(size int) (Target, <-chan Message) {
+    ch := make(chan Message, size)
+    return &chanTarget{ch: ch}, ch
+}
+
+func (t *chanTarget) Deliver(m Message) error {
+    select {
+    case t.ch <- m:
+        return nil
+    default:
+        return fmt.Errorf("Subscriber is too slow, drop event type %v", m.Type)
+    }
+}
+
+func (t *chanTarget) Close() error {
+    close(t.ch)
+    return nil
+}
+
+//投递到订阅者指定的MQ，每个消息包含一个事件，队列满时丢弃
+type mqTarget struct {
+    name string
+    mqd  C.int
+}
+
+func NewMqTarget(name string, maxMsg int64, msgSize int64) (Target, error) {
+    if name == "" || len(name) > MQ_MAX_NAMELENGTH {
+        return nil, fmt.Errorf("Invalid message queue name %q", name)
+    }
+
+    cname := C.CString(name)
+    defer C.free(unsafe.Pointer(cname))
+    mqd, err := C.MqOpenTarget(cname, C.long(maxMsg), C.long(msgSize))
+    if mqd < 0 {
+        return nil, fmt.Errorf("Open message queue %v error, reason: %v", name, err)
+    }
+    return &mqTarget{name: name, mqd: mqd}, nil
+}
+
+func (t *mqTarget) Deliver(m Message) error {
+    message := newCMessage(m)
+    if message == nil {
+        return fmt.Errorf("Cannot allocate message")
+    }
+    defer C.free(unsafe.Pointer(message))
+
+    if ret, err := C.MqSendTarget(t.mqd, message, C.GetMessageSize(message)); ret != 0 {
+        return fmt.Errorf("Send to message queue %v error, reason: %v", t.name, err)
+    }
+    return nil
+}
+
+func (t *mqTarget) Close() error {
+    C.MqCloseTarget(t.mqd)
+    return nil
+}
+
+//C回调函数，回调中不能再调用订阅相关的接口
+type callbackTarget struct {
+    callback C.EventCallback
+    arg      unsafe.Pointer
+}
+
+func NewCallbackTarget(callback unsafe.Pointer, arg unsafe.Pointer) (Target, error) {
+    if callback == nil {
+        return nil, fmt.Errorf("Callback is NULL")
+    }
+    return &callbackTarget{callback: C.EventCallback(callback), arg: arg}, nil
+}
+
+func (t *callbackTarget) Deliver(m Message) error {
+    message := newCMessage(m)
+    if message == nil {
+        return fmt.Errorf("Cannot allocate message")
+    }
+    defer C.free(unsafe.Pointer(message))
+
+    C.CallEventCallback(t.callback, message, t.arg)
+    return nil
+}
+
+func (t *callbackTarget) Close() error {
+    return nil
+}
+
+//构造C消息，使用完后需要释放
+func newCMessage(messages ...Message) *C.Message {
+    message := C.AllocMessage(C.uint32_t(len(messages)))
+    if message == nil {
+        return nil
+    }
+
+    for _, m := range messages {
+        var flags uint8
+        if m.HasNodeId {
+            flags |= C.EVENT_FLAG_NODEID
+        }
+
+        kstr := C.CString(m.Key)
+        vstr := C.CString(m.Value)
+        fstr := C.CString(m.FullKey)
+        pstr := C.CString(m.PrevValue)
+        C.AddTypedEvent(message, kstr, vstr, C.uint8_t(m.Type), C.uint8_t(m.Category), C.uint8_t(flags), C.uint32_t(m.NodeId), fstr,
+            C.uint8_t(m.Cause), pstr)
+        C.SetEventRevision(message, C.int64_t(m.ModRevision), C.int64_t(m.CreateRevision), C.int64_t(m.Lease),
+            C.int64_t(m.UnixNano()))
+        C.free(unsafe.Pointer(kstr))
+        C.free(unsafe.Pointer(vstr))
+        C.free(unsafe.Pointer(fstr))
+        C.free(unsafe.Pointer(pstr))
+    }
+    return message
+}
@@ -0,0 +1,657 @@
+package ipc
+
+import (
+    "bufio"
+    "context"
+    "etcdagent/agent"
+    "etcdagent/agent/event"
+    "etcdagent/agent/log"
+    "etcdagent/agent/ms"
+    "etcdagent/agent/node"
+    "fmt"
+    "net"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+)
+
+//与main.go中C接口的返回值保持一致
+const (
+    ETCD_SUCCESS   = 0
+    ETCD_ERROR     = 1
+    ETCD_CONFLICT  = 2
+    ETCD_BUSY      = 3
+    ETCD_TIMEOUT   = 4
+    ETCD_COMPACTED = 5
+)
+
+const (
+    IPC_EVENT_BUFFER = 1024
+)
+
+//本机所有进程共享一个agent，通过Unix socket访问node、ms和事件接口
+type Server interface {
+    Serve() error
+    Close() error
+}
+
+type server struct {
+    sync.Mutex
+    agent    *agent.Agent
+    path     string
+    listener net.Listener
+    conns    map[*conn]bool
+    nodes    map[uint32]*conn //nodeId -> 最近一次上线该node的连接
+    msNodes  map[msNode]*conn //最近一次参与选主的连接
+    closed   bool
+}
+
+type msNode struct {
+    group  string
+    nodeId uint32
+}
+
+type conn struct {
+    net.Conn
+    server *server
+    writer *bufio.Writer
+}
+
+type command struct {
+    argc    int //最少参数个数
+    handler func(c *conn, args []string) (int, []string, error)
+}
+
+var commands map[string]command
+
+func init() {
+    commands = map[string]command{
+        "PING":               {0, ping},
+        "NODE_ONLINE":        {2, nodeOnline},
+        "NODE_OFFLINE":       {1, nodeOffline},
+        "NODE_KEEPALIVE":     {1, nodeKeepalive},
+        "NODE_LIST":          {0, nodeList},
+        "NODE_ADDR":          {1, nodeAddr},
+        "NODE_ALLOCATE":      {1, nodeAllocate},
+        "NODE_RELEASE":       {1, nodeRelease},
+        "NODE_WAIT":          {1, nodeWait},
+        "NODE_WAIT_COUNT":    {2, nodeWaitCount},
+        "MS_COMPETE":         {3, msCompete},
+        "MS_GIVEUP":          {2, msGiveUp},
+        "MS_KEEPALIVE":       {2, msKeepalive},
+        "MS_TRANSFER":        {4, msTransfer},
+        "MS_TRANSFER_ACK":    {2, msTransferAck},
+        "MS_IS_MASTER":       {2, msIsMaster},
+        "MS_IS_MASTER_UNTIL": {2, msIsMasterUntil},
+        "MS_GET_MASTER":      {1, msGetMaster},
+        "MS_WAIT_MASTER":     {2, msWaitMaster},
+    }
+}
+
+func NewServer(a *agent.Agent, path string) Server {
+    return &server{
+        agent:   a,
+        path:    path,
+        conns:   make(map[*conn]bool),
+        nodes:   make(map[uint32]*conn),
+        msNodes: make(map[msNode]*conn),
+    }
+}
+
+//socket文件已存在时，如果仍能连接说明已有agent在运行，否则删除残留文件
+//rpc的unix socket同样使用
+func ListenUnix(path string) (net.Listener, error) {
+    if _, err := os.Stat(path); err == nil {
+        if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+            c.Close()
+            return nil, fmt.Errorf("Another etcdagent is serving on %v", path)
+        }
+
+        if err = os.Remove(path); err != nil {
+            return nil, fmt.Errorf("Remove stale socket %v error: %v", path, err)
+        }
+    }
+
+    l, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, err
+    }
+
+    //与MQ权限一致，本机所有进程都可以访问
+    if err = os.Chmod(path, 0666); err != nil {
+        l.Close()
+        return nil, err
+    }
+    return l, nil
+}
+
+func (s *server) Serve() error {
+    l, err := ListenUnix(s.path)
+    if err != nil {
+        log.Warn("Listen on %v error, reason: %v", s.path, err.Error())
+        return err
+    }
+
+    s.Lock()
+    s.listener = l
+    s.Unlock()
+    log.Info("Serve ipc on %v", s.path)
+
+    for {
+        c, err := l.Accept()
+        if err != nil {
+            s.Lock()
+            closed := s.closed
+            s.Unlock()
+            if closed {
+                return nil
+            }
+
+            log.Warn("Accept ipc connection error, reason: %v", err.Error())
+            return err
+        }
+
+        conn := &conn{Conn: c, server: s, writer: bufio.NewWriter(c)}
+        s.Lock()
+        s.conns[conn] = true
+        s.Unlock()
+        go conn.serve()
+    }
+}
+
+func (s *server) Close() error {
+    s.Lock()
+    s.closed = true
+    l := s.listener
+    conns := make([]*conn, 0, len(s.conns))
+    for c := range s.conns {
+        conns = append(conns, c)
+    }
+    s.Unlock()
+
+    //退出前主动下线，其他agent无需等待lease过期
+    for _, c := range conns {
+        c.Close()
+        s.release(c)
+    }
+
+    if l != nil {
+        return l.Close()
+    }
+    return nil
+}
+
+//进程退出或者连接断开时，释放该连接注册的node和ms候选者，无需等待lease过期
+func (s *server) release(c *conn) {
+    s.Lock()
+    delete(s.conns, c)
+    nodes := make([]uint32, 0)
+    for nodeId, owner := range s.nodes {
+        if owner == c {
+            nodes = append(nodes, nodeId)
+            delete(s.nodes, nodeId)
+        }
+    }
+
+    msNodes := make([]msNode, 0)
+    for key, owner := range s.msNodes {
+        if owner == c {
+            msNodes = append(msNodes, key)
+            delete(s.msNodes, key)
+        }
+    }
+    s.Unlock()
+
+    for _, nodeId := range nodes {
+        log.Warn("Ipc connection closed, offline node: %v", nodeId)
+        s.agent.NodeOffline(context.Background(), nodeId)
+    }
+
+    for _, m := range msNodes {
+        log.Warn("Ipc connection closed, give up ms, group: %v, node: %v", m.group, m.nodeId)
+        s.agent.MSGiveUp(context.Background(), m.group, m.nodeId)
+    }
+}
+
+func (c *conn) serve() {
+    defer func() {
+        c.Close()
+        c.server.release(c)
+    }()
+
+    scanner, lines := newLineScanner(c)
+    for scanner.Scan() {
+        if lines.tooLong {
+            c.reply(ETCD_ERROR, fmt.Sprintf("Request longer than %v bytes", IPC_MAX_LINE))
+            continue
+        }
+
+        args, err := parseLine(scanner.Text())
+        if err != nil {
+            c.reply(ETCD_ERROR, err.Error())
+            continue
+        }
+
+        if len(args) == 0 {
+            continue
+        }
+
+        //订阅之后该连接只用于推送事件，可选参数为订阅条件和开始的revision
+        if args[0] == "SUBSCRIBE" {
+            filter, since := "", ""
+            if len(args) > 1 {
+                filter = args[1]
+            }
+            if len(args) > 2 {
+                since = args[2]
+            }
+            c.subscribe(scanner, filter, since)
+            return
+        }
+
+        cmd, ok := commands[args[0]]
+        if !ok {
+            c.reply(ETCD_ERROR, fmt.Sprintf("Unknown command: %v", args[0]))
+            continue
+        }
+
+        if len(args)-1 < cmd.argc {
+            c.reply(ETCD_ERROR, fmt.Sprintf("Command %v needs %v arguments", args[0], cmd.argc))
+            continue
+        }
+
+        code, result, err := cmd.handler(c, args[1:])
+        if err != nil {
+            c.reply(code, err.Error())
+            continue
+        }
+
+        if err = c.reply(code, result...); err != nil {
+            return
+        }
+    }
+
+    if err := scanner.Err(); err != nil {
+        log.Warn("Read ipc request error, reason: %v", err.Error())
+    }
+}
+
+func (c *conn) reply(code int, result ...string) error {
+    fields := append([]string{strconv.Itoa(code)}, result...)
+    if _, err := c.writer.WriteString(formatLine(fields...)); err != nil {
+        return err
+    }
+    return c.writer.Flush()
+}
+
+func (c *conn) subscribe(scanner *bufio.Scanner, f string, since string) {
+    filter, err := event.ParseFilter(f)
+    if err != nil {
+        c.reply(ETCD_ERROR, err.Error())
+        return
+    }
+
+    //先订阅再读取journal，避免遗漏两者之间的事件
+    events, cancel := c.server.agent.Subscribe(filter, IPC_EVENT_BUFFER)
+    defer cancel()
+
+    var replay []event.Message
+    if since != "" {
+        revision, err := strconv.ParseInt(since, 10, 64)
+        if err != nil {
+            c.reply(ETCD_ERROR, fmt.Sprintf("Invalid revision: %q", since))
+            return
+        }
+
+        if replay, err = c.server.agent.EventsSince(context.Background(), revision, 0); err != nil {
+            code := ETCD_ERROR
+            if err == event.ErrJournalCompacted {
+                code = ETCD_COMPACTED
+            }
+            c.reply(code, err.Error())
+            return
+        }
+    }
+
+    if err := c.reply(ETCD_SUCCESS); err != nil {
+        return
+    }
+
+    //journal中已经发送过的事件不再重复推送
+    var last int64
+    for _, m := range replay {
+        if !filter.Match(m) {
+            continue
+        }
+        if err := c.sendEvent(m); err != nil {
+            return
+        }
+        last = m.ModRevision
+    }
+
+    //订阅者关闭连接时结束推送
+    done := make(chan struct{})
+    go func() {
+        for scanner.Scan() {
+        }
+        close(done)
+    }()
+
+    for {
+        select {
+        case <-done:
+            return
+        case m, ok := <-events:
+            if !ok {
+                return
+            }
+
+            if m.ModRevision != 0 && m.ModRevision <= last {
+                continue
+            }
+            if err := c.sendEvent(m); err != nil {
+                return
+            }
+        }
+    }
+}
+
+func (c *conn) sendEvent(m event.Message) error {
+    nodeId := ""
+    if m.HasNodeId {
+        nodeId = strconv.FormatUint(uint64(m.NodeId), 10)
+    }
+    line := formatLine("EVENT", strconv.Itoa(int(m.Type)), m.Key, m.Value,
+        strconv.Itoa(int(m.Category)), nodeId, m.FullKey, strconv.Itoa(int(m.Cause)), m.PrevValue,
+        strconv.FormatInt(m.ModRevision, 10), strconv.FormatInt(m.CreateRevision, 10), strconv.FormatInt(m.Lease, 10),
+        strconv.FormatInt(m.UnixNano(), 10))
+    if _, err := c.writer.WriteString(line); err != nil {
+        return err
+    }
+    return c.writer.Flush()
+}
+
+func result(err error) int {
+    if err == nil {
+        return ETCD_SUCCESS
+    }
+
+    if _, ok := err.(*node.ConflictError); ok {
+        return ETCD_CONFLICT
+    }
+
+    if _, ok := err.(*ms.ConflictError); ok {
+        return ETCD_CONFLICT
+    }
+
+    if err == context.DeadlineExceeded {
+        return ETCD_TIMEOUT
+    }
+    return ETCD_ERROR
+}
+
+func milliseconds(s string) (time.Duration, error) {
+    v, err := parseUint32(s)
+    return time.Duration(v) * time.Millisecond, err
+}
+
+func ping(c *conn, args []string) (int, []string, error) {
+    return ETCD_SUCCESS, nil, nil
+}
+
+func nodeOnline(c *conn, args []string) (int, []string, error) {
+    nodeId, err := parseUint32(args[0])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    if err = c.server.agent.NodeOnline(context.Background(), nodeId, args[1]); err != nil {
+        return result(err), nil, err
+    }
+
+    c.server.Lock()
+    c.server.nodes[nodeId] = c
+    c.server.Unlock()
+    return ETCD_SUCCESS, nil, nil
+}
+
+func nodeOffline(c *conn, args []string) (int, []string, error) {
+    nodeId, err := parseUint32(args[0])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    if err = c.server.agent.NodeOffline(context.Background(), nodeId); err != nil {
+        return result(err), nil, err
+    }
+
+    c.server.Lock()
+    delete(c.server.nodes, nodeId)
+    c.server.Unlock()
+    return ETCD_SUCCESS, nil, nil
+}
+
+func nodeKeepalive(c *conn, args []string) (int, []string, error) {
+    nodeId, err := parseUint32(args[0])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    err = c.server.agent.NodeKeepalive(context.Background(), nodeId)
+    return result(err), nil, err
+}
+
+func nodeList(c *conn, args []string) (int, []string, error) {
+    nodes, err := c.server.agent.GetAllNodes(context.Background())
+    if err != nil {
+        return result(err), nil, err
+    }
+
+    ids := make([]string, 0, len(nodes))
+    for _, nodeId := range nodes {
+        ids = append(ids, strconv.FormatUint(uint64(nodeId), 10))
+    }
+    return ETCD_SUCCESS, ids, nil
+}
+
+func nodeAddr(c *conn, args []string) (int, []string, error) {
+    nodeId, err := parseUint32(args[0])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    addr, err := c.server.agent.GetNodeServiceAddr(context.Background(), nodeId)
+    if err != nil {
+        return result(err), nil, err
+    }
+    return ETCD_SUCCESS, []string{addr}, nil
+}
+
+func nodeAllocate(c *conn, args []string) (int, []string, error) {
+    nodeId, err := c.server.agent.NodeAllocateId(context.Background(), args[0])
+    if err != nil {
+        return result(err), nil, err
+    }
+    return ETCD_SUCCESS, []string{strconv.FormatUint(uint64(nodeId), 10)}, nil
+}
+
+func nodeRelease(c *conn, args []string) (int, []string, error) {
+    nodeId, err := parseUint32(args[0])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    err = c.server.agent.NodeReleaseId(nodeId)
+    return result(err), nil, err
+}
+
+//NODE_WAIT <timeoutMs> <nodeId>...
+func nodeWait(c *conn, args []string) (int, []string, error) {
+    timeout, err := milliseconds(args[0])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    ids := make([]uint32, 0, len(args)-1)
+    for _, arg := range args[1:] {
+        nodeId, err := parseUint32(arg)
+        if err != nil {
+            return ETCD_ERROR, nil, err
+        }
+        ids = append(ids, nodeId)
+    }
+
+    err = c.server.agent.WaitForNodes(context.Background(), ids, timeout)
+    return result(err), nil, err
+}
+
+//NODE_WAIT_COUNT <count> <timeoutMs>
+func nodeWaitCount(c *conn, args []string) (int, []string, error) {
+    count, err := parseUint32(args[0])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    timeout, err := milliseconds(args[1])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    err = c.server.agent.WaitForNodeCount(context.Background(), int(count), timeout)
+    return result(err), nil, err
+}
+
+func parseGroupNode(args []string) (string, uint32, error) {
+    nodeId, err := parseUint32(args[1])
+    return args[0], nodeId, err
+}
+
+func msCompete(c *conn, args []string) (int, []string, error) {
+    group, nodeId, err := parseGroupNode(args)
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    priority, err := parseUint32(args[2])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    if err = c.server.agent.MSCompete(context.Background(), group, nodeId, priority); err != nil {
+        return result(err), nil, err
+    }
+
+    c.server.Lock()
+    c.server.msNodes[msNode{group, nodeId}] = c
+    c.server.Unlock()
+    return ETCD_SUCCESS, nil, nil
+}
+
+func msGiveUp(c *conn, args []string) (int, []string, error) {
+    group, nodeId, err := parseGroupNode(args)
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    if err = c.server.agent.MSGiveUp(context.Background(), group, nodeId); err != nil {
+        return result(err), nil, err
+    }
+
+    c.server.Lock()
+    delete(c.server.msNodes, msNode{group, nodeId})
+    c.server.Unlock()
+    return ETCD_SUCCESS, nil, nil
+}
+
+func msKeepalive(c *conn, args []string) (int, []string, error) {
+    group, nodeId, err := parseGroupNode(args)
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    err = c.server.agent.MSKeepalive(context.Background(), group, nodeId)
+    return result(err), nil, err
+}
+
+//MS_TRANSFER <group> <from> <to> <timeoutMs>
+func msTransfer(c *conn, args []string) (int, []string, error) {
+    group, from, err := parseGroupNode(args)
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    to, err := parseUint32(args[2])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    timeout, err := milliseconds(args[3])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    err = c.server.agent.MSTransfer(context.Background(), group, from, to, timeout)
+    return result(err), nil, err
+}
+
+func msTransferAck(c *conn, args []string) (int, []string, error) {
+    group, nodeId, err := parseGroupNode(args)
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    err = c.server.agent.MSTransferAck(context.Background(), group, nodeId)
+    return result(err), nil, err
+}
+
+func msIsMaster(c *conn, args []string) (int, []string, error) {
+    group, nodeId, err := parseGroupNode(args)
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    if c.server.agent.IsMaster(context.Background(), group, nodeId) {
+        return ETCD_SUCCESS, []string{"1"}, nil
+    }
+    return ETCD_SUCCESS, []string{"0"}, nil
+}
+
+//结果与EtcdIsMasterUntil一致：剩余毫秒数，不是master时为0，无法估算时为-1
+func msIsMasterUntil(c *conn, args []string) (int, []string, error) {
+    group, nodeId, err := parseGroupNode(args)
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    remain := int64(0)
+    if master, deadline := c.server.agent.IsMasterUntil(context.Background(), group, nodeId); master {
+        remain = -1
+        if !deadline.IsZero() {
+            remain = int64(time.Until(deadline) / time.Millisecond)
+        }
+    }
+    return ETCD_SUCCESS, []string{strconv.FormatInt(remain, 10)}, nil
+}
+
+func msGetMaster(c *conn, args []string) (int, []string, error) {
+    master, err := c.server.agent.GetMaster(context.Background(), args[0])
+    if err != nil {
+        return result(err), nil, err
+    }
+    return ETCD_SUCCESS, []string{strconv.FormatUint(uint64(master), 10)}, nil
+}
+
+//MS_WAIT_MASTER <group> <timeoutMs>
+func msWaitMaster(c *conn, args []string) (int, []string, error) {
+    timeout, err := milliseconds(args[1])
+    if err != nil {
+        return ETCD_ERROR, nil, err
+    }
+
+    master, err := c.server.agent.WaitForMaster(context.Background(), args[0], timeout)
+    if err != nil {
+        return result(err), nil, err
+    }
+    return ETCD_SUCCESS, []string{strconv.FormatUint(uint64(master), 10)}, nil
+}
@@ -0,0 +1,177 @@
+package ipc
+
+import (
+    "bufio"
+    "context"
+    "etcdagent/agent"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+const ETCDADDR = "172.100.1.239:2379"
+
+func request(t *testing.T, c net.Conn, r *bufio.Reader, fields ...string) []string {
+    if _, err := c.Write([]byte(formatLine(fields...))); err != nil {
+        t.Fatalf("Send request %v error: %v", fields, err)
+    }
+
+    line, err := r.ReadString('\n')
+    if err != nil {
+        t.Fatalf("Read response of %v error: %v", fields, err)
+    }
+
+    result, err := parseLine(line)
+    if err != nil {
+        t.Fatalf("Parse response %q error: %v", line, err)
+    }
+    return result
+}
+
+func TestEscape(t *testing.T) {
+    data := []string{"", "-", "a b", "100%", "line\nbreak\r", "1.1.1.1:80", "tab\tvalue", "\v\f", "a\u00a0b"}
+    for _, d := range data {
+        line := formatLine("CMD", d)
+        if acctually, err := parseLine(line); err != nil || len(acctually) != 2 || acctually[1] != d {
+            t.Errorf("Test escape failed, expected = %q, acctually = %q, line = %q", d, acctually, line)
+        }
+    }
+}
+
+func TestLineTooLong(t *testing.T) {
+    long := strings.Repeat("a", IPC_MAX_LINE)
+    scanner, lines := newLineScanner(strings.NewReader(long + "\nPING\n" + long))
+
+    expected := []bool{true, false, true}
+    for i, tooLong := range expected {
+        if !scanner.Scan() {
+            t.Fatalf("Test line too long failed, line %v not scanned, err = %v", i, scanner.Err())
+        }
+        if lines.tooLong != tooLong || (!tooLong && scanner.Text() != "PING") {
+            t.Errorf("Test line too long failed, line %v expected tooLong = %v, acctually = %v, %q", i, tooLong, lines.tooLong, scanner.Text())
+        }
+    }
+}
+
+func TestServer(t *testing.T) {
+    a, err := agent.NewAgent([]string{ETCDADDR}, 5*time.Second)
+    if err != nil {
+        fmt.Println("New agent failed")
+        os.Exit(1)
+    }
+    defer a.Close()
+    go a.Run()
+
+    dir, _ := ioutil.TempDir("", "etcdagent")
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "agent.sock")
+
+    server := NewServer(a, path)
+    go server.Serve()
+    defer server.Close()
+    <-time.After(100 * time.Millisecond)
+
+    //同一个socket上只能运行一个agent
+    if err := NewServer(a, path).Serve(); err == nil {
+        t.Errorf("Test server failed, second server should not serve on %v", path)
+    }
+
+    subscriber, err := net.Dial("unix", path)
+    if err != nil {
+        t.Fatalf("Dial %v error: %v", path, err)
+    }
+    defer subscriber.Close()
+    events := bufio.NewReader(subscriber)
+    if acctually := request(t, subscriber, events, "SUBSCRIBE"); acctually[0] != "0" {
+        t.Errorf("Subscribe failed, acctually = %v", acctually)
+    }
+
+    //只订阅ms事件
+    msSubscriber, err := net.Dial("unix", path)
+    if err != nil {
+        t.Fatalf("Dial %v error: %v", path, err)
+    }
+    defer msSubscriber.Close()
+    msEvents := bufio.NewReader(msSubscriber)
+    if acctually := request(t, msSubscriber, msEvents, "SUBSCRIBE", "category=ms;key=MS/ipc/*"); acctually[0] != "0" {
+        t.Errorf("Subscribe with filter failed, acctually = %v", acctually)
+    }
+
+    //未启用journal时不能从指定的revision订阅
+    replay, err := net.Dial("unix", path)
+    if err != nil {
+        t.Fatalf("Dial %v error: %v", path, err)
+    }
+    if acctually := request(t, replay, bufio.NewReader(replay), "SUBSCRIBE", "", "1"); acctually[0] != "1" {
+        t.Errorf("Subscribe since revision without journal should fail, acctually = %v", acctually)
+    }
+    replay.Close()
+
+    c, err := net.Dial("unix", path)
+    if err != nil {
+        t.Fatalf("Dial %v error: %v", path, err)
+    }
+    r := bufio.NewReader(c)
+
+    data := []struct {
+        request  []string
+        expected []string
+    }{
+        {[]string{"PING"}, []string{"0"}},
+        {[]string{"NODE_ONLINE", "31", "10.0.0.31:8080 tcp"}, []string{"0"}},
+        {[]string{"NODE_ADDR", "31"}, []string{"0", "10.0.0.31:8080 tcp"}},
+        {[]string{"NODE_KEEPALIVE", "31"}, []string{"0"}},
+        {[]string{"NODE_WAIT", "1000", "31"}, []string{"0"}},
+        {[]string{"NODE_WAIT", "100", "32"}, []string{"4"}},
+        {[]string{"MS_COMPETE", "ipc", "31", "1"}, []string{"0"}},
+        {[]string{"MS_GET_MASTER", "ipc"}, []string{"0", "31"}},
+        {[]string{"MS_IS_MASTER", "ipc", "31"}, []string{"0", "1"}},
+        {[]string{"NODE_KEEPALIVE", "abc"}, []string{"1"}},
+        {[]string{"UNKNOWN"}, []string{"1"}},
+    }
+
+    for _, d := range data {
+        acctually := request(t, c, r, d.request...)
+        for i, expected := range d.expected {
+            if i >= len(acctually) || acctually[i] != expected {
+                t.Errorf("Test request %q failed, expected = %q, acctually = %q", d.request, d.expected, acctually)
+                break
+            }
+        }
+    }
+
+    //订阅者收到node上线事件
+    line, err := events.ReadString('\n')
+    if err != nil {
+        t.Fatalf("Read event error: %v", err)
+    }
+    if acctually, _ := parseLine(line); len(acctually) != 13 || acctually[0] != "EVENT" || acctually[2] != "31" || acctually[3] != "10.0.0.31:8080 tcp" ||
+        acctually[4] != "1" || acctually[5] != "31" || acctually[6] != "/CoreNet/Node/31" || acctually[9] == "0" || acctually[9] != acctually[10] ||
+        acctually[11] == "0" || acctually[12] == "0" {
+        t.Errorf("Test subscribe failed, acctually = %q", acctually)
+    }
+
+    line, err = msEvents.ReadString('\n')
+    if err != nil {
+        t.Fatalf("Read event error: %v", err)
+    }
+    if acctually, _ := parseLine(line); len(acctually) != 13 || acctually[2] != "ipc/31" || acctually[4] != "2" {
+        t.Errorf("Test subscribe with filter failed, acctually = %q", acctually)
+    }
+
+    //连接断开后node下线，候选者退出
+    c.Close()
+    <-time.After(200 * time.Millisecond)
+    if _, err := a.GetNodeServiceAddr(context.TODO(), 31); err == nil {
+        t.Errorf("Test server failed, node 31 should be offline after connection closed")
+    }
+
+    if acctually, _ := a.GetMaster(context.TODO(), "ipc"); acctually == 31 {
+        t.Errorf("Test server failed, node 31 should give up ms after connection closed")
+    }
+}
@@ -0,0 +1,134 @@
+package ipc
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+)
+
+//请求和响应均为一行文本，以空格分隔参数：
+//请求：<命令> <参数>...
+//响应：<返回码> <结果>...，返回码与C接口一致，失败时结果为错误描述
+//事件：EVENT <类型> <key> <value> <类别> <nodeId> <完整key> <删除原因> <之前的value> <modRevision> <createRevision> <lease> <时间戳>，仅在SUBSCRIBE [订阅条件] [revision] 之后推送，没有nodeId时为空
+//指定revision时先推送journal中该revision之后的事件，journal中已没有这些事件时返回5
+//一行最长IPC_MAX_LINE字节（含'\n'），与cgo/etcdclient.h中的ETCDC_MAX_LINE一致，超长的请求返回1，连接保持可用
+//参数中的 '%' 和空白字符（空格、'\t'、'\n'、'\v'、'\f'、'\r'）转义为 %XX，空字符串编码为 "-"，参数之间只以空格分隔
+const (
+    IPC_MAX_LINE = 8192
+    IPC_EMPTY    = "-"
+)
+
+func escape(s string) string {
+    if s == "" {
+        return IPC_EMPTY
+    }
+    if s == IPC_EMPTY {
+        return "%2D"
+    }
+
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        switch c := s[i]; c {
+        case '%', ' ', '\t', '\n', '\v', '\f', '\r':
+            fmt.Fprintf(&b, "%%%02X", c)
+        default:
+            b.WriteByte(c)
+        }
+    }
+    return b.String()
+}
+
+func unescape(s string) (string, error) {
+    if s == IPC_EMPTY {
+        return "", nil
+    }
+
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        if s[i] != '%' {
+            b.WriteByte(s[i])
+            continue
+        }
+
+        if i+2 >= len(s) {
+            return "", fmt.Errorf("Invalid escape in %q", s)
+        }
+        c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+        if err != nil {
+            return "", fmt.Errorf("Invalid escape in %q", s)
+        }
+        b.WriteByte(byte(c))
+        i += 2
+    }
+    return b.String(), nil
+}
+
+//将一行拆分为参数并反转义
+func parseLine(line string) ([]string, error) {
+    line = strings.TrimRight(line, "\r\n")
+    fields := strings.FieldsFunc(line, func(r rune) bool { return r == ' ' })
+    for i, f := range fields {
+        arg, err := unescape(f)
+        if err != nil {
+            return nil, err
+        }
+        fields[i] = arg
+    }
+    return fields, nil
+}
+
+//按行拆分请求，超过IPC_MAX_LINE的行被丢弃到下一个'\n'为止，并返回一个设置tooLong的空行
+type lineSplitter struct {
+    discard bool
+    tooLong bool
+}
+
+func (s *lineSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+    s.tooLong = false
+    i := bytes.IndexByte(data, '\n')
+    if s.discard {
+        if i < 0 && !atEOF {
+            return len(data), nil, nil
+        }
+
+        s.discard = false
+        s.tooLong = true
+        if i < 0 {
+            return len(data), []byte{}, nil
+        }
+        return i + 1, []byte{}, nil
+    }
+
+    if i < 0 && len(data) >= IPC_MAX_LINE {
+        s.discard = true
+        return len(data), nil, nil
+    }
+    return bufio.ScanLines(data, atEOF)
+}
+
+func newLineScanner(r io.Reader) (*bufio.Scanner, *lineSplitter) {
+    lines := &lineSplitter{}
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, IPC_MAX_LINE), IPC_MAX_LINE)
+    scanner.Split(lines.split)
+    return scanner, lines
+}
+
+func formatLine(fields ...string) string {
+    escaped := make([]string, len(fields))
+    for i, f := range fields {
+        escaped[i] = escape(f)
+    }
+    return strings.Join(escaped, " ") + "\n"
+}
+
+func parseUint32(s string) (uint32, error) {
+    v, err := strconv.ParseUint(s, 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("Invalid number: %q", s)
+    }
+    return uint32(v), nil
+}
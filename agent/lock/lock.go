@@ -0,0 +1,320 @@
+package lock
+
+import (
+    "context"
+    "errors"
+    "etcdagent/agent/log"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+const (
+    LOCK_PREFIX          = "/CoreNet/Lock/"
+    LOCK_DEFAULT_TTL     = 3
+    LOCK_DEFAULT_TIMEOUT = 2 * time.Second
+)
+
+var (
+    ErrLocked      = errors.New("Lock is held by another owner")
+    ErrLockTimeout = errors.New("Lock wait timeout")
+    ErrLockLost    = errors.New("Lock lost")
+)
+
+//Lock/TryLock成功后返回fencing revision，即锁key的create revision，
+//持有者需要定期调用LockKeepalive，lease失效即视为锁丢失
+type Lock interface {
+    Lock(name string, nodeId uint32, timeout time.Duration) (int64, error)
+    TryLock(name string, nodeId uint32) (int64, error)
+    Unlock(name string, nodeId uint32) error
+    LockKeepalive(name string, nodeId uint32) error
+    LockLost(name string, nodeId uint32) (int64, bool)
+    LockSetTTL(ttl int64) error
+}
+
+type holder struct {
+    lease clientv3.LeaseID
+    fence int64
+}
+
+type lock struct {
+    mu      sync.Mutex
+    client  *clientv3.Client
+    holders map[string]*holder //key: /CoreNet/Lock/<name>/<nodeId>
+    ttl     int64
+}
+
+func NewLock(client *clientv3.Client) Lock {
+    return &lock{
+        client:  client,
+        holders: make(map[string]*holder),
+        ttl:     LOCK_DEFAULT_TTL,
+    }
+}
+
+func checkName(name string) error {
+    if name == "" || strings.Contains(name, "/") {
+        return fmt.Errorf("Invalid lock name: %q", name)
+    }
+    return nil
+}
+
+func lockPrefix(name string) string {
+    return fmt.Sprintf("%s%s/", LOCK_PREFIX, name)
+}
+
+func lockKey(name string, nodeId uint32) string {
+    return fmt.Sprintf("%s%v", lockPrefix(name), nodeId)
+}
+
+//从 /CoreNet/Lock/<name>/<nodeId> 中解析name和nodeId
+func ParseKey(key string) (string, uint32, bool) {
+    re := regexp.MustCompile(fmt.Sprintf("^%s([^/]+)/([0-9]+)$", LOCK_PREFIX))
+    match := re.FindStringSubmatch(key)
+    if match == nil {
+        return "", 0, false
+    }
+
+    inodeId, err := strconv.ParseUint(match[2], 10, 32)
+    if err != nil {
+        return "", 0, false
+    }
+    return match[1], uint32(inodeId), true
+}
+
+//timeout为0时使用LOCK_DEFAULT_TIMEOUT
+func (l *lock) Lock(name string, nodeId uint32, timeout time.Duration) (int64, error) {
+    if timeout == 0 {
+        timeout = LOCK_DEFAULT_TIMEOUT
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    return l.acquire(ctx, name, nodeId, true)
+}
+
+func (l *lock) TryLock(name string, nodeId uint32) (int64, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), LOCK_DEFAULT_TIMEOUT)
+    defer cancel()
+    return l.acquire(ctx, name, nodeId, false)
+}
+
+//每个等待者按create revision排队，排在最前面的持有锁
+func (l *lock) acquire(ctx context.Context, name string, nodeId uint32, wait bool) (int64, error) {
+    if err := checkName(name); err != nil {
+        return 0, err
+    }
+
+    key := lockKey(name, nodeId)
+    l.mu.Lock()
+    if h, ok := l.holders[key]; ok {
+        l.mu.Unlock()
+        return h.fence, nil
+    }
+    ttl := l.ttl
+    l.mu.Unlock()
+
+    var err error
+    var grantResp *clientv3.LeaseGrantResponse
+    if grantResp, err = l.client.Grant(ctx, ttl); err != nil {
+        log.Warn("Lease grant error, lock: %v, nodeId: %v, reason: %v\n", name, nodeId, err.Error())
+        return 0, err
+    }
+    lease := grantResp.ID
+
+    var txnResp *clientv3.TxnResponse
+    if txnResp, err = l.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+        Then(clientv3.OpPut(key, "", clientv3.WithLease(lease))).
+        Commit(); err != nil {
+        l.revoke(lease)
+        log.Warn("Put %v with lease: %v error, reason: %v\n", key, lease, err.Error())
+        return 0, err
+    }
+
+    if !txnResp.Succeeded {
+        l.revoke(lease)
+        return 0, fmt.Errorf("Lock error, %v is already queued by another process", key)
+    }
+
+    fence := txnResp.Header.Revision
+    for {
+        var predecessor string
+        if predecessor, err = l.predecessor(ctx, name, fence); err != nil {
+            break
+        }
+
+        if predecessor == "" {
+            l.mu.Lock()
+            l.holders[key] = &holder{lease: lease, fence: fence}
+            l.mu.Unlock()
+            log.Info("Lock acquired, lock: %v, nodeId: %v, fence: %v", name, nodeId, fence)
+            return fence, nil
+        }
+
+        if !wait {
+            err = ErrLocked
+            break
+        }
+
+        if err = l.waitDelete(ctx, predecessor, lease, ttl); err != nil {
+            break
+        }
+    }
+
+    //未获得锁时撤销排队
+    l.revoke(lease)
+    return 0, err
+}
+
+//返回排在自己之前的最后一个key，为空表示已持有锁
+func (l *lock) predecessor(ctx context.Context, name string, fence int64) (string, error) {
+    opts := append(clientv3.WithLastCreate(), clientv3.WithMaxCreateRev(fence-1))
+    resp, err := l.client.Get(ctx, lockPrefix(name), opts...)
+    if err != nil {
+        return "", err
+    }
+
+    if len(resp.Kvs) == 0 {
+        return "", nil
+    }
+    return string(resp.Kvs[0].Key), nil
+}
+
+//等待前一个key被删除，期间保持自己的lease
+func (l *lock) waitDelete(ctx context.Context, key string, lease clientv3.LeaseID, ttl int64) error {
+    resp, err := l.client.Get(ctx, key)
+    if err != nil {
+        return err
+    }
+
+    if len(resp.Kvs) == 0 {
+        return nil
+    }
+
+    wctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    wChan := l.client.Watch(wctx, key, clientv3.WithRev(resp.Header.Revision+1))
+    ticker := time.NewTicker(time.Duration(ttl) * time.Second / 3)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return ErrLockTimeout
+        case <-ticker.C:
+            if _, err := l.client.KeepAliveOnce(ctx, lease); err != nil {
+                return err
+            }
+        case wResp, ok := <-wChan:
+            if !ok {
+                return ErrLockTimeout
+            }
+
+            if err := wResp.Err(); err != nil {
+                return err
+            }
+
+            for _, ev := range wResp.Events {
+                if ev.Type == clientv3.EventTypeDelete {
+                    return nil
+                }
+            }
+        }
+    }
+}
+
+func (l *lock) revoke(lease clientv3.LeaseID) {
+    ctx, cancel := context.WithTimeout(context.Background(), LOCK_DEFAULT_TIMEOUT)
+    defer cancel()
+    if _, err := l.client.Revoke(ctx, lease); err != nil {
+        log.Warn("Revoke lease: %v error, reason: %v\n", lease, err.Error())
+    }
+}
+
+func (l *lock) Unlock(name string, nodeId uint32) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    key := lockKey(name, nodeId)
+    h, ok := l.holders[key]
+    if !ok {
+        return fmt.Errorf("Unlock error, lock: %v is not held by node: %v", name, nodeId)
+    }
+
+    //先删除本地状态，Watch到的DELETE不再视为锁丢失
+    delete(l.holders, key)
+    ctx, cancel := context.WithTimeout(context.Background(), LOCK_DEFAULT_TIMEOUT)
+    defer cancel()
+    if _, err := l.client.Revoke(ctx, h.lease); err != nil {
+        log.Warn("Unlock error, lock: %v, nodeId: %v, reason: %v\n", name, nodeId, err.Error())
+        return err
+    }
+
+    log.Info("Lock released, lock: %v, nodeId: %v, fence: %v", name, nodeId, h.fence)
+    return nil
+}
+
+func (l *lock) LockKeepalive(name string, nodeId uint32) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    key := lockKey(name, nodeId)
+    h, ok := l.holders[key]
+    if !ok {
+        return fmt.Errorf("Lock keepalive error, lock: %v is not held by node: %v", name, nodeId)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), LOCK_DEFAULT_TIMEOUT)
+    defer cancel()
+    resp, err := l.client.KeepAliveOnce(ctx, h.lease)
+    if err == nil && resp.TTL > 0 {
+        return nil
+    }
+
+    if err != nil {
+        log.Warn("Lock keepalive error, lock: %v, nodeId: %v, reason: %v\n", name, nodeId, err.Error())
+    }
+
+    //lease已失效，锁已被释放
+    if err == nil || err == rpctypes.ErrLeaseNotFound {
+        delete(l.holders, key)
+        log.Warn("Lock lost, lock: %v, nodeId: %v, fence: %v", name, nodeId, h.fence)
+        return ErrLockLost
+    }
+    return err
+}
+
+//锁key被删除时调用，返回是否为本地持有的锁
+func (l *lock) LockLost(name string, nodeId uint32) (int64, bool) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    key := lockKey(name, nodeId)
+    h, ok := l.holders[key]
+    if !ok {
+        return 0, false
+    }
+
+    delete(l.holders, key)
+    log.Warn("Lock lost, lock: %v, nodeId: %v, fence: %v", name, nodeId, h.fence)
+    return h.fence, true
+}
+
+//ttl至少为1秒，等待锁时按ttl/3续约
+func (l *lock) LockSetTTL(ttl int64) error {
+    if ttl < 1 {
+        return fmt.Errorf("Invalid lock ttl %v, must be at least 1 second", ttl)
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    l.ttl = ttl
+    log.Info("Set lock ttl = %v", ttl)
+    return nil
+}
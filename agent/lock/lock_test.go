@@ -0,0 +1,114 @@
+package lock
+
+import (
+    "fmt"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+const ETCDADDR = "172.100.1.239:2379"
+
+func TestLock(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    lock := NewLock(client)
+    first, err := lock.Lock("storage", 1, time.Second)
+    if err != nil {
+        t.Errorf("Lock error, node: 1, reason: %v", err.Error())
+    }
+
+    //锁被占用时TryLock立即返回，Lock超时返回
+    if _, err := lock.TryLock("storage", 2); err != ErrLocked {
+        t.Errorf("Test lock failed, expected = %v, acctually = %v", ErrLocked, err)
+    }
+
+    if _, err := lock.Lock("storage", 2, time.Second); err != ErrLockTimeout {
+        t.Errorf("Test lock failed, expected = %v, acctually = %v", ErrLockTimeout, err)
+    }
+
+    //释放后等待者获得锁，fencing revision递增
+    go func() {
+        <-time.After(500 * time.Millisecond)
+        if err := lock.Unlock("storage", 1); err != nil {
+            t.Errorf("Unlock error, node: 1, reason: %v", err.Error())
+        }
+    }()
+
+    second, err := lock.Lock("storage", 2, 3*time.Second)
+    if err != nil {
+        t.Errorf("Lock error, node: 2, reason: %v", err.Error())
+    }
+
+    if second <= first {
+        t.Errorf("Test lock failed, fence should increase, first = %v, second = %v", first, second)
+    }
+
+    if err := lock.Unlock("storage", 2); err != nil {
+        t.Errorf("Unlock error, node: 2, reason: %v", err.Error())
+    }
+
+    //timeout为0时使用默认超时
+    if _, err := lock.Lock("storage", 1, 0); err != nil {
+        t.Errorf("Lock with default timeout error, reason: %v", err.Error())
+    }
+    if err := lock.Unlock("storage", 1); err != nil {
+        t.Errorf("Unlock error, node: 1, reason: %v", err.Error())
+    }
+    client.Close()
+}
+
+func TestLockKeepalive(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    lock := NewLock(client)
+    if err := lock.LockSetTTL(0); err == nil {
+        t.Errorf("Test lock set ttl failed, ttl 0 should be rejected")
+    }
+    if err := lock.LockSetTTL(1); err != nil {
+        t.Errorf("Lock set ttl error, reason: %v", err.Error())
+    }
+    if _, err := lock.Lock("keepalive", 1, time.Second); err != nil {
+        t.Errorf("Lock error, node: 1, reason: %v", err.Error())
+    }
+
+    for i := 0; i < 10; i++ {
+        if err := lock.LockKeepalive("keepalive", 1); err != nil {
+            t.Errorf("Lock keepalive error, reason: %v", err.Error())
+        }
+        <-time.After(300 * time.Millisecond)
+    }
+
+    //停止保活后锁丢失
+    <-time.After(3 * time.Second)
+    if err := lock.LockKeepalive("keepalive", 1); err != ErrLockLost {
+        t.Errorf("Test lock keepalive failed, expected = %v, acctually = %v", ErrLockLost, err)
+    }
+
+    if _, held := lock.LockLost("keepalive", 1); held {
+        t.Errorf("Test lock keepalive failed, lost lock should not be held")
+    }
+    client.Close()
+}
@@ -0,0 +1,105 @@
+package ms
+
+import (
+    "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/log"
+    "fmt"
+    "sort"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+//按选主顺序返回group内的候选者，第一个即为没有master记录时的继任者
+func (m *ms) GetCandidates(ctx context.Context, group string) ([]Candidate, error) {
+    if err := checkGroup(group); err != nil {
+        return nil, err
+    }
+
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+
+    candidates, _, err := m.getCandidates(ctx, group)
+    if err != nil {
+        log.Warn("Get candidates of group: %v error, reason: %v", group, err.Error())
+        return nil, err
+    }
+    return candidates, nil
+}
+
+//返回存在候选者或者master记录的所有group
+func (m *ms) GetGroups(ctx context.Context) ([]string, error) {
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+
+    resp, err := m.client.Txn(ctx).
+        Then(backend.OpGet(MS_PREFIX, backend.WithPrefix(), backend.WithKeysOnly()),
+            backend.OpGet(MS_MASTER_PREFIX, backend.WithPrefix(), backend.WithKeysOnly())).
+        Commit()
+    if err != nil {
+        log.Warn("Get ms groups error, reason: %v", err.Error())
+        return nil, err
+    }
+
+    found := make(map[string]bool)
+    for _, kv := range resp.Responses[0].GetResponseRange().Kvs {
+        if group, _, ok := ParseKey(string(kv.Key)); ok {
+            found[group] = true
+        }
+    }
+    for _, kv := range resp.Responses[1].GetResponseRange().Kvs {
+        if group, ok := ParseMasterKey(string(kv.Key)); ok {
+            found[group] = true
+        }
+    }
+
+    groups := make([]string, 0, len(found))
+    for group := range found {
+        groups = append(groups, group)
+    }
+    sort.Strings(groups)
+    return groups, nil
+}
+
+//管理员强制master退位：回收master的候选lease，候选key和master记录同时删除，
+//由剩余候选者重新选主，原master需要重新MSCompete才能再次参与
+func (m *ms) MSForceStepDown(ctx context.Context, group string) (uint32, error) {
+    if err := checkGroup(group); err != nil {
+        return INVALID_NODE, err
+    }
+
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+
+    mkey := masterKey(group)
+    resp, err := m.client.Get(ctx, mkey)
+    if err != nil {
+        log.Warn("Get %v error, reason: %v", mkey, err.Error())
+        return INVALID_NODE, err
+    }
+
+    if len(resp.Kvs) == 0 {
+        return INVALID_NODE, fmt.Errorf("MS force step down error, group has no master: %v", group)
+    }
+
+    kv := resp.Kvs[0]
+    master := parseNodeId(kv.Value)
+    if lease := clientv3.LeaseID(kv.Lease); lease != clientv3.NoLease {
+        if _, err = m.client.Revoke(ctx, lease); err != nil && err != rpctypes.ErrLeaseNotFound {
+            log.Warn("Revoke lease: %v error, group: %v, master: %v, reason: %v", lease, group, master, err.Error())
+            return INVALID_NODE, err
+        }
+    } else {
+        if _, err = m.client.Txn(ctx).
+            If(clientv3.Compare(clientv3.ModRevision(mkey), "=", kv.ModRevision)).
+            Then(backend.OpDelete(mkey)).
+            Commit(); err != nil {
+            log.Warn("Delete %v error, reason: %v", mkey, err.Error())
+            return INVALID_NODE, err
+        }
+    }
+
+    log.Warn("MS force step down, group: %v, master: %v, lease: %v", group, master, kv.Lease)
+    return master, nil
+}
@@ -2,156 +2,883 @@ package ms
 
 import (
     "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/event"
     "etcdagent/agent/log"
     "fmt"
     "regexp"
+    "sort"
     "strconv"
     "strings"
     "sync"
+    "time"
 
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
     "github.com/etcd-io/etcd/clientv3"
 )
 
 const (
-    MS_PREFIX      = "/CoreNet/MS/"
-    MS_DEFAULT_TTL = 1
-    INVALID_NODE   = 0xffffffff
+    MS_PREFIX          = "/CoreNet/MS/"
+    MS_MASTER_PREFIX   = "/CoreNet/Master/"
+    MS_TRANSFER_PREFIX = "/CoreNet/Transfer/"
+    MS_DEFAULT_TTL     = 1
+    MS_DEFAULT_TIMEOUT = 2 * time.Second
+    INVALID_NODE       = 0xffffffff
+)
+
+//交接状态：master准备退出 -> 继任者已就绪
+const (
+    TRANSFER_STEPDOWN = "stepdown"
+    TRANSFER_READY    = "ready"
 )
 
 type MS interface {
-    MSCompete(nodeId uint32) error
-    MSGiveUp(nodeId uint32) error
-    MSKeepalive(nodeId uint32) error
-    IsMaster(nodeId uint32) bool
-    GetMaster() (uint32, error)
+    MSCompete(ctx context.Context, group string, nodeId uint32, priority uint32) error
+    MSGiveUp(ctx context.Context, group string, nodeId uint32) error
+    MSKeepalive(ctx context.Context, group string, nodeId uint32) error
+    MSElect(ctx context.Context, group string) error
+    MSTransfer(ctx context.Context, group string, from uint32, to uint32, timeout time.Duration) error
+    MSTransferAck(ctx context.Context, group string, nodeId uint32) error
+    IsMaster(ctx context.Context, group string, nodeId uint32) bool
+    IsMasterUntil(ctx context.Context, group string, nodeId uint32) (bool, time.Time)
+    GetMaster(ctx context.Context, group string) (uint32, error)
+    WaitForMaster(ctx context.Context, group string, timeout time.Duration) (uint32, error)
     MSSetTTL(int64)
+    MSSetTimeout(timeout time.Duration)
+    MSSetPreempt(preempt bool, delay time.Duration)
+    GetCandidates(ctx context.Context, group string) ([]Candidate, error)
+    GetGroups(ctx context.Context) ([]string, error)
+    MSForceStepDown(ctx context.Context, group string) (uint32, error)
+    WatchMaster(ctx context.Context, group string) (<-chan Master, error)
+    MSReconcile(ctx context.Context, group string, nodeId uint32, revision int64) (string, error)
+    LocalCandidates() map[string][]uint32
+}
+
+type Candidate struct {
+    NodeId   uint32
+    Priority uint32
+    Revision int64 //create revision，优先级相同时先创建者优先
+}
+
+type Master struct {
+    Group    string
+    NodeId   uint32 //没有master时为INVALID_NODE
+    Revision int64
+}
+
+//候选key已被其他lease注册，例如另一个agent使用了相同的group和nodeId
+type ConflictError struct {
+    Group  string
+    NodeId uint32
+    Lease  clientv3.LeaseID
+}
+
+func (e *ConflictError) Error() string {
+    return fmt.Sprintf("MS compete conflict, group: %v, nodeId: %v is owned by lease: %v", e.Group, e.NodeId, e.Lease)
+}
+
+type candidate struct {
+    group    string
+    nodeId   uint32
+    priority uint32
+    lease    clientv3.LeaseID
+    since    time.Time
+    deadline time.Time //本地估算的lease到期时间
+    master   bool      //最近一次确认的master状态
+    revision int64     //最近一次写入候选key的revision
 }
 
 type ms struct {
     sync.Mutex
-    client *clientv3.Client
-    leases map[uint32]clientv3.LeaseID
-    ttl    int64
+    client     backend.Backend
+    candidates map[string]*candidate //key: /CoreNet/MS/<group>/<nodeId>
+    ttl        int64
+    preempt    bool
+    delay      time.Duration
+    timeout    time.Duration //ctx没有deadline时每次请求的超时
 }
 
 func NewMS(client *clientv3.Client) MS {
+    return NewMSFromBackend(backend.NewEtcd(client))
+}
+
+//使用其他存储，如单元测试中的backend.NewMemory
+func NewMSFromBackend(b backend.Backend) MS {
     return &ms{
-        client: client,
-        leases: make(map[uint32]clientv3.LeaseID),
-        ttl:    MS_DEFAULT_TTL,
+        client:     b,
+        candidates: make(map[string]*candidate),
+        ttl:        MS_DEFAULT_TTL,
+        timeout:    MS_DEFAULT_TIMEOUT,
+    }
+}
+
+//每个group独立选主，候选者key为 /CoreNet/MS/<group>/<nodeId>，value为优先级
+//当前master记录在 /CoreNet/Master/<group>，value为nodeId，与master的候选lease绑定
+func checkGroup(group string) error {
+    if group == "" || strings.Contains(group, "/") {
+        return fmt.Errorf("Invalid ms group: %q", group)
+    }
+    return nil
+}
+
+func groupPrefix(group string) string {
+    return fmt.Sprintf("%s%s/", MS_PREFIX, group)
+}
+
+func msKey(group string, nodeId uint32) string {
+    return fmt.Sprintf("%s%v", groupPrefix(group), nodeId)
+}
+
+func masterKey(group string) string {
+    return fmt.Sprintf("%s%s", MS_MASTER_PREFIX, group)
+}
+
+func transferKey(group string) string {
+    return fmt.Sprintf("%s%s", MS_TRANSFER_PREFIX, group)
+}
+
+//交接记录value格式为 <from>:<to>:<state>
+func transferValue(from uint32, to uint32, state string) string {
+    return fmt.Sprintf("%v:%v:%s", from, to, state)
+}
+
+func parseTransferValue(value []byte) (uint32, uint32, string, bool) {
+    tmp := strings.Split(string(value), ":")
+    if len(tmp) != 3 {
+        return INVALID_NODE, INVALID_NODE, "", false
     }
+    return parseNodeId([]byte(tmp[0])), parseNodeId([]byte(tmp[1])), tmp[2], true
 }
 
-func (m *ms) MSCompete(nodeId uint32) error {
+//从 /CoreNet/MS/<group>/<nodeId> 中解析group和nodeId
+func ParseKey(key string) (string, uint32, bool) {
+    re := regexp.MustCompile(fmt.Sprintf("^%s([^/]+)/([0-9]+)$", MS_PREFIX))
+    match := re.FindStringSubmatch(key)
+    if match == nil {
+        return "", INVALID_NODE, false
+    }
+
+    inodeId, err := strconv.ParseUint(match[2], 10, 32)
+    if err != nil {
+        return "", INVALID_NODE, false
+    }
+    return match[1], uint32(inodeId), true
+}
+
+//从 /CoreNet/Master/<group> 中解析group
+func ParseMasterKey(key string) (string, bool) {
+    if !strings.HasPrefix(key, MS_MASTER_PREFIX) {
+        return "", false
+    }
+
+    group := strings.TrimPrefix(key, MS_MASTER_PREFIX)
+    if checkGroup(group) != nil {
+        return "", false
+    }
+    return group, true
+}
+
+func parseNodeId(value []byte) uint32 {
+    inodeId, err := strconv.ParseUint(string(value), 10, 32)
+    if err != nil {
+        return INVALID_NODE
+    }
+    return uint32(inodeId)
+}
+
+func (m *ms) MSCompete(ctx context.Context, group string, nodeId uint32, priority uint32) error {
     m.Lock()
     defer m.Unlock()
 
     var err error
-    var grantResp *clientv3.LeaseGrantResponse
-    if grantResp, err = m.client.Grant(context.TODO(), m.ttl); err != nil {
-        log.Warn("Lease grant error, reason: %v\n", err.Error())
+    if err = checkGroup(group); err != nil {
         return err
     }
 
-    key := fmt.Sprintf("%s%v", MS_PREFIX, nodeId)
-    if _, err = m.client.Put(context.TODO(), key, "", clientv3.WithLease(grantResp.ID)); err != nil {
-        log.Warn("Put %v with lease %v error, reason: %v", key, grantResp.ID, err.Error())
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+
+    //以发起请求的时间计算到期时间，保证本地估算不晚于etcd
+    start := m.client.Now()
+    key := msKey(group, nodeId)
+    value := strconv.FormatUint(uint64(priority), 10)
+
+    //重复竞选时沿用原lease，master记录同样绑定该lease，lease已失效时重新申请
+    var lease, owner clientv3.LeaseID
+    var ttl int64
+    var master bool
+    if old, ok := m.candidates[key]; ok {
+        owner = old.lease
+        var kaResp *clientv3.LeaseKeepAliveResponse
+        if kaResp, err = m.client.KeepAliveOnce(ctx, old.lease); err == nil {
+            lease, ttl, master = old.lease, kaResp.TTL, old.master
+        } else if err != rpctypes.ErrLeaseNotFound {
+            log.Warn("MS keepalive error, lease: %v, group: %v, node: %v, reason: %v", old.lease, group, nodeId, err.Error())
+            return err
+        }
+    }
+
+    if lease == 0 {
+        var grantResp *clientv3.LeaseGrantResponse
+        if grantResp, err = m.client.Grant(ctx, m.ttl); err != nil {
+            log.Warn("Lease grant error, reason: %v\n", err.Error())
+            return err
+        }
+        lease, ttl = grantResp.ID, grantResp.TTL
+    }
+
+    var revision int64
+    if revision, err = m.register(ctx, group, nodeId, key, value, lease, owner); err != nil {
+        //注册失败时回收新申请的lease，避免残留
+        if lease != owner {
+            if _, rerr := m.client.Revoke(ctx, lease); rerr != nil {
+                log.Warn("Revoke lease: %v error, reason: %v", lease, rerr.Error())
+            }
+        }
         return err
     }
 
-    m.leases[nodeId] = grantResp.ID
-    log.Info("MS compete, node: %v", nodeId)
+    m.candidates[key] = &candidate{
+        group:    group,
+        nodeId:   nodeId,
+        priority: priority,
+        lease:    lease,
+        since:    start,
+        deadline: start.Add(time.Duration(ttl) * time.Second),
+        master:   master,
+        revision: revision,
+    }
+    log.Info("MS compete, group: %v, node: %v, priority: %v", group, nodeId, priority)
+
+    if err = m.elect(ctx, m.candidates[key]); err != nil {
+        log.Warn("MS elect error, group: %v, node: %v, reason: %v", group, nodeId, err.Error())
+    }
     return nil
 }
 
-func (m *ms) MSGiveUp(nodeId uint32) error {
+//只有候选key不存在或者属于本候选者原有的lease时才写入，返回写入的revision
+func (m *ms) register(ctx context.Context, group string, nodeId uint32, key string, value string,
+    lease clientv3.LeaseID, owner clientv3.LeaseID) (int64, error) {
+    var err error
+    var resp *clientv3.TxnResponse
+    if resp, err = m.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+        Then(backend.OpPut(key, value, backend.WithLease(lease))).
+        Else(backend.OpGet(key)).
+        Commit(); err != nil {
+        log.Warn("Put %v with lease %v error, reason: %v", key, lease, err.Error())
+        return 0, err
+    }
+
+    if !resp.Succeeded && owner != 0 {
+        if resp, err = m.client.Txn(ctx).
+            If(clientv3.Compare(clientv3.LeaseValue(key), "=", owner)).
+            Then(backend.OpPut(key, value, backend.WithLease(lease))).
+            Else(backend.OpGet(key)).
+            Commit(); err != nil {
+            log.Warn("Put %v with lease %v error, reason: %v", key, lease, err.Error())
+            return 0, err
+        }
+    }
+
+    if resp.Succeeded {
+        return resp.Header.Revision, nil
+    }
+
+    conflict := &ConflictError{Group: group, NodeId: nodeId}
+    if kvs := resp.Responses[0].GetResponseRange().Kvs; len(kvs) != 0 {
+        conflict.Lease = clientv3.LeaseID(kvs[0].Lease)
+    }
+    log.Warn("MS compete conflict, group: %v, node: %v, owner lease: %v", group, nodeId, conflict.Lease)
+    return 0, conflict
+}
+
+func (m *ms) MSGiveUp(ctx context.Context, group string, nodeId uint32) error {
     m.Lock()
     defer m.Unlock()
 
     var err error
-    var resp *clientv3.DeleteResponse
-    key := fmt.Sprintf("%s%v", MS_PREFIX, nodeId)
+    if err = checkGroup(group); err != nil {
+        return err
+    }
+
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+
+    var resp *clientv3.TxnResponse
+    key := msKey(group, nodeId)
+    mkey := masterKey(group)
+
+    //本地候选者在同一事务中写入下线标记，订阅者据此区分主动放弃和租约过期
+    var mark, mmark []backend.Op
+    if c, ok := m.candidates[key]; ok {
+        put := backend.OpPut(event.OfflineKey(key), "", backend.WithLease(c.lease))
+        mark = []backend.Op{put}
+        mmark = []backend.Op{put, backend.OpPut(event.OfflineKey(mkey), "", backend.WithLease(c.lease))}
+    }
+
+    //如果key不存在，Delete也不会返回错误；当前是master时同时删除master记录
+    //租约已过期时key已被删除，不再写入标记
+    for {
+        resp, err = m.client.Txn(ctx).
+            If(clientv3.Compare(clientv3.Value(mkey), "=", strconv.FormatUint(uint64(nodeId), 10))).
+            Then(append(mmark, backend.OpDelete(key), backend.OpDelete(mkey))...).
+            Else(append(mark, backend.OpDelete(key))...).
+            Commit()
+        if err == rpctypes.ErrLeaseNotFound && mark != nil {
+            mark, mmark = nil, nil
+            continue
+        }
+        break
+    }
 
-    //如果key不存在，Delete也不会返回错误，resp中 Deleted = 0
-    if resp, err = m.client.Delete(context.TODO(), key); err != nil {
-        log.Warn("Put %v error, reason: %v", key, err.Error())
+    if err != nil {
+        log.Warn("Delete %v error, reason: %v", key, err.Error())
         return err
     }
 
-    delete(m.leases, nodeId)
-    log.Info("MS give up, node: %v, deleted count: %v", nodeId, resp.Deleted)
+    delete(m.candidates, key)
+    log.Info("MS give up, group: %v, node: %v, was master: %v", group, nodeId, resp.Succeeded)
+
+    //本地其他候选者立即重新选主，无需等待保活
+    m.electGroup(ctx, group)
     return nil
 }
 
-func (m *ms) MSKeepalive(nodeId uint32) error {
+func (m *ms) MSKeepalive(ctx context.Context, group string, nodeId uint32) error {
     m.Lock()
     defer m.Unlock()
 
-    if lease, ok := m.leases[nodeId]; ok {
-        if _, err := m.client.KeepAliveOnce(context.TODO(), lease); err != nil {
-            log.Warn("MS keepalive error, nodeId: %v, reason: %v\n", nodeId, err.Error())
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+    if c, ok := m.candidates[msKey(group, nodeId)]; ok {
+        start := m.client.Now()
+        resp, err := m.client.KeepAliveOnce(ctx, c.lease)
+        if err != nil {
+            log.Warn("MS keepalive error, group: %v, nodeId: %v, reason: %v\n", group, nodeId, err.Error())
             return err
         }
+        c.deadline = start.Add(time.Duration(resp.TTL) * time.Second)
+
+        if err := m.elect(ctx, c); err != nil {
+            log.Warn("MS elect error, group: %v, node: %v, reason: %v", group, nodeId, err.Error())
+        }
         return nil
     }
 
-    return fmt.Errorf("Not ms node, nodeId:%v", nodeId)
+    return fmt.Errorf("Not ms node, group: %v, nodeId:%v", group, nodeId)
+}
+
+//master记录被删除等场景下，由本地候选者重新选主
+func (m *ms) MSElect(ctx context.Context, group string) error {
+    m.Lock()
+    defer m.Unlock()
+
+    if err := checkGroup(group); err != nil {
+        return err
+    }
+
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+    return m.electGroup(ctx, group)
+}
+
+func (m *ms) electGroup(ctx context.Context, group string) error {
+    var lastErr error
+    for _, c := range m.candidates {
+        if c.group != group {
+            continue
+        }
+
+        if err := m.elect(ctx, c); err != nil {
+            log.Warn("MS elect error, group: %v, node: %v, reason: %v", group, c.nodeId, err.Error())
+            lastErr = err
+        }
+    }
+    return lastErr
+}
+
+//读取group内候选者，按优先级从高到低排序，优先级相同按创建顺序
+func (m *ms) getCandidates(ctx context.Context, group string) ([]Candidate, *clientv3.GetResponse, error) {
+    candidates, master, _, err := m.getGroup(ctx, group)
+    return candidates, master, err
 }
 
-func (m *ms) IsMaster(nodeId uint32) bool {
+//同一revision下读取候选者、master记录和交接记录
+func (m *ms) getGroup(ctx context.Context, group string) ([]Candidate, *clientv3.GetResponse, *clientv3.GetResponse, error) {
     var err error
-    var resp *clientv3.GetResponse
+    var resp *clientv3.TxnResponse
+    if resp, err = m.client.Txn(ctx).
+        Then(backend.OpGet(groupPrefix(group), backend.WithPrefix()),
+            backend.OpGet(masterKey(group)),
+            backend.OpGet(transferKey(group))).
+        Commit(); err != nil {
+        return nil, nil, nil, err
+    }
 
-    //查找第一个创建的key， etcd中保存有全局的revision
-    if resp, err = m.client.Get(context.TODO(), MS_PREFIX, clientv3.WithFirstCreate()...); err != nil {
-        log.Warn("Get prefix: %v with first create error, reason: %v\n", MS_PREFIX, err.Error())
-        return false
+    candidates := make([]Candidate, 0)
+    for _, kv := range resp.Responses[0].GetResponseRange().Kvs {
+        if _, nodeId, ok := ParseKey(string(kv.Key)); ok {
+            priority, _ := strconv.ParseUint(string(kv.Value), 10, 32)
+            candidates = append(candidates, Candidate{
+                NodeId:   nodeId,
+                Priority: uint32(priority),
+                Revision: kv.CreateRevision,
+            })
+        }
     }
 
-    pattern := fmt.Sprintf("%s%s", MS_PREFIX, "[0-9]+") //至少一位数字的nodeId
-    re := regexp.MustCompile(pattern)
+    sort.Slice(candidates, func(i, j int) bool {
+        if candidates[i].Priority != candidates[j].Priority {
+            return candidates[i].Priority > candidates[j].Priority
+        }
+        return candidates[i].Revision < candidates[j].Revision
+    })
 
-    if len(resp.Kvs) != 0 {
-        var rnodeId uint32
-        key := string(resp.Kvs[0].Key)
-        if re.MatchString(key) {
-            tmp := strings.Split(key, "/")
-            inodeId, _ := strconv.Atoi(tmp[len(tmp)-1])
-            rnodeId = uint32(inodeId)
+    return candidates,
+        (*clientv3.GetResponse)(resp.Responses[1].GetResponseRange()),
+        (*clientv3.GetResponse)(resp.Responses[2].GetResponseRange()),
+        nil
+}
+
+//1）没有master时，优先级最高的候选者写入master记录
+//2）开启抢占时，优先级更高的候选者在稳定时间后替换当前master
+func (m *ms) elect(ctx context.Context, c *candidate) error {
+    candidates, master, transfer, err := m.getGroup(ctx, c.group)
+    if err != nil {
+        return err
+    }
+
+    if len(candidates) == 0 || candidates[0].NodeId != c.nodeId {
+        return nil
+    }
+
+    mkey := masterKey(c.group)
+    value := strconv.FormatUint(uint64(c.nodeId), 10)
+    var cmp clientv3.Cmp
+    if len(master.Kvs) == 0 {
+        cmp = clientv3.Compare(clientv3.CreateRevision(mkey), "=", 0)
+    } else {
+        //交接过程中不抢占
+        current := parseNodeId(master.Kvs[0].Value)
+        if current == c.nodeId || !m.preempt || m.client.Now().Sub(c.since) < m.delay || len(transfer.Kvs) != 0 {
+            return nil
+        }
+
+        for _, other := range candidates {
+            if other.NodeId == current && other.Priority >= c.priority {
+                return nil
+            }
+        }
+        cmp = clientv3.Compare(clientv3.ModRevision(mkey), "=", master.Kvs[0].ModRevision)
+        log.Info("MS preempt, group: %v, node: %v, old master: %v", c.group, c.nodeId, current)
+    }
+
+    var resp *clientv3.TxnResponse
+    if resp, err = m.client.Txn(ctx).
+        If(cmp).
+        Then(backend.OpPut(mkey, value, backend.WithLease(c.lease))).
+        Commit(); err != nil {
+        return err
+    }
+
+    if resp.Succeeded {
+        log.Info("MS become master, group: %v, node: %v, priority: %v", c.group, c.nodeId, c.priority)
+    }
+    return nil
+}
+
+//master主动交接给指定的继任者：
+//1）写入交接记录，标记master准备退出
+//2）等待继任者通过MSTransferAck确认就绪
+//3）原子地将master记录切换给继任者，并删除交接记录
+func (m *ms) MSTransfer(ctx context.Context, group string, from uint32, to uint32, timeout time.Duration) error {
+    if err := checkGroup(group); err != nil {
+        return err
+    }
+
+    m.Lock()
+    c, ok := m.candidates[msKey(group, from)]
+    m.Unlock()
+    if !ok {
+        return fmt.Errorf("MS transfer error, not ms node, group: %v, nodeId: %v", group, from)
+    }
+
+    //timeout为0时与其他接口一致，使用ms.timeout
+    var cancel context.CancelFunc
+    if timeout == 0 {
+        ctx, cancel = m.withTimeout(ctx)
+    } else {
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+    }
+    defer cancel()
+
+    var err error
+    var resp *clientv3.TxnResponse
+    mkey := masterKey(group)
+    tkey := transferKey(group)
+    toKey := msKey(group, to)
+    if resp, err = m.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.Value(mkey), "=", strconv.FormatUint(uint64(from), 10)),
+            clientv3.Compare(clientv3.CreateRevision(tkey), "=", 0),
+            clientv3.Compare(clientv3.CreateRevision(toKey), ">", 0)).
+        Then(backend.OpPut(tkey, transferValue(from, to, TRANSFER_STEPDOWN), backend.WithLease(c.lease))).
+        Commit(); err != nil {
+        log.Warn("MS transfer error, group: %v, from: %v, to: %v, reason: %v", group, from, to, err.Error())
+        return err
+    }
+
+    if !resp.Succeeded {
+        return fmt.Errorf("MS transfer error, group: %v, from: %v is not master, transfer in progress or %v is not candidate",
+            group, from, to)
+    }
+    rev := resp.Header.Revision
+    log.Info("MS transfer start, group: %v, from: %v, to: %v", group, from, to)
+
+    if err = m.waitTransferReady(ctx, group, from, to, rev+1); err != nil {
+        m.abortTransfer(group, rev)
+        return err
+    }
+
+    //继任者的候选key必须仍然存在，master记录绑定到继任者的lease
+    var getResp *clientv3.GetResponse
+    if getResp, err = m.client.Get(ctx, toKey); err != nil {
+        m.abortTransfer(group, rev)
+        return err
+    }
+
+    if len(getResp.Kvs) == 0 {
+        m.abortTransfer(group, rev)
+        return fmt.Errorf("MS transfer error, group: %v, successor %v is gone", group, to)
+    }
+
+    if resp, err = m.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.Value(mkey), "=", strconv.FormatUint(uint64(from), 10)),
+            clientv3.Compare(clientv3.Value(tkey), "=", transferValue(from, to, TRANSFER_READY)),
+            clientv3.Compare(clientv3.ModRevision(toKey), "=", getResp.Kvs[0].ModRevision)).
+        Then(backend.OpPut(mkey, strconv.FormatUint(uint64(to), 10), backend.WithLease(clientv3.LeaseID(getResp.Kvs[0].Lease))),
+            backend.OpDelete(tkey)).
+        Commit(); err != nil {
+        m.abortTransfer(group, rev)
+        return err
+    }
+
+    if !resp.Succeeded {
+        m.abortTransfer(group, rev)
+        return fmt.Errorf("MS transfer error, group: %v, master or successor changed during transfer", group)
+    }
+
+    log.Info("MS transfer done, group: %v, from: %v, to: %v", group, from, to)
+    return nil
+}
+
+func (m *ms) waitTransferReady(ctx context.Context, group string, from uint32, to uint32, rev int64) error {
+    ready := transferValue(from, to, TRANSFER_READY)
+    wChan := m.client.Watch(ctx, transferKey(group), backend.WithRev(rev))
+    for {
+        select {
+        case <-ctx.Done():
+            return fmt.Errorf("MS transfer error, group: %v, wait for %v ready: %v", group, to, ctx.Err())
+        case wResp, ok := <-wChan:
+            if !ok {
+                return fmt.Errorf("MS transfer error, group: %v, watch closed", group)
+            }
+
+            if err := wResp.Err(); err != nil {
+                return err
+            }
+
+            for _, ev := range wResp.Events {
+                if ev.Type == clientv3.EventTypeDelete {
+                    return fmt.Errorf("MS transfer error, group: %v, transfer record deleted", group)
+                }
+
+                if string(ev.Kv.Value) == ready {
+                    return nil
+                }
+            }
         }
-        return rnodeId == nodeId
     }
+}
+
+//交接失败时删除本次交接记录，master保持不变
+//调用者的ctx可能已经超时，因此单独计算超时
+func (m *ms) abortTransfer(group string, rev int64) {
+    tkey := transferKey(group)
+    ctx, cancel := m.withTimeout(context.Background())
+    defer cancel()
 
-    return false
+    if _, err := m.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.CreateRevision(tkey), "=", rev)).
+        Then(backend.OpDelete(tkey)).
+        Commit(); err != nil {
+        log.Warn("MS transfer abort error, group: %v, reason: %v", group, err.Error())
+        return
+    }
+    log.Info("MS transfer abort, group: %v", group)
 }
 
-func (m *ms) GetMaster() (uint32, error) {
+//继任者确认已就绪
+func (m *ms) MSTransferAck(ctx context.Context, group string, nodeId uint32) error {
+    if err := checkGroup(group); err != nil {
+        return err
+    }
+
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+
     var err error
-    var resp *clientv3.GetResponse
+    var getResp *clientv3.GetResponse
+    tkey := transferKey(group)
+    if getResp, err = m.client.Get(ctx, tkey); err != nil {
+        return err
+    }
 
-    //查找第一个创建的key， etcd中保存有全局的revision
-    if resp, err = m.client.Get(context.TODO(), MS_PREFIX, clientv3.WithFirstCreate()...); err != nil {
-        return INVALID_NODE, err
+    if len(getResp.Kvs) == 0 {
+        return fmt.Errorf("MS transfer ack error, no transfer in progress, group: %v", group)
     }
 
-    var nodeId uint32
-    pattern := fmt.Sprintf("%s%s", MS_PREFIX, "[0-9]+") //至少一位数字的nodeId
-    re := regexp.MustCompile(pattern)
+    from, to, state, ok := parseTransferValue(getResp.Kvs[0].Value)
+    if !ok || to != nodeId || state != TRANSFER_STEPDOWN {
+        return fmt.Errorf("MS transfer ack error, group: %v, node: %v is not the successor of %q",
+            group, nodeId, string(getResp.Kvs[0].Value))
+    }
 
-    if len(resp.Kvs) != 0 {
-        key := string(resp.Kvs[0].Key)
-        if re.MatchString(key) {
-            tmp := strings.Split(key, "/")
-            inodeId, _ := strconv.Atoi(tmp[len(tmp)-1])
-            nodeId = uint32(inodeId)
+    var resp *clientv3.TxnResponse
+    if resp, err = m.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.ModRevision(tkey), "=", getResp.Kvs[0].ModRevision)).
+        Then(backend.OpPut(tkey, transferValue(from, to, TRANSFER_READY), backend.WithIgnoreLease())).
+        Commit(); err != nil {
+        return err
+    }
+
+    if !resp.Succeeded {
+        return fmt.Errorf("MS transfer ack error, transfer record changed, group: %v", group)
+    }
+
+    log.Info("MS transfer ack, group: %v, from: %v, to: %v", group, from, to)
+    return nil
+}
+
+func (m *ms) IsMaster(ctx context.Context, group string, nodeId uint32) bool {
+    master, _ := m.IsMasterUntil(ctx, group, nodeId)
+    return master
+}
+
+//只以已提交的master记录为准，选举尚未完成时所有候选者都不是master
+//本地候选者的master身份只在lease本地到期时间之前有效：
+//到期后即使etcd不可达也返回false，etcd不可达但未到期时沿用最近一次确认的状态
+func (m *ms) IsMasterUntil(ctx context.Context, group string, nodeId uint32) (bool, time.Time) {
+    if err := checkGroup(group); err != nil {
+        log.Warn("Is master error, reason: %v", err.Error())
+        return false, time.Time{}
+    }
+
+    m.Lock()
+    defer m.Unlock()
+
+    c, ok := m.candidates[msKey(group, nodeId)]
+    if !ok {
+        ctx, cancel := m.withTimeout(ctx)
+        defer cancel()
+
+        master, err := m.committedMaster(ctx, group)
+        if err != nil {
+            log.Warn("Get master of group: %v error, reason: %v\n", group, err.Error())
+            return false, time.Time{}
         }
-        return nodeId, nil
+        return master == nodeId, time.Time{}
+    }
+
+    now := m.client.Now()
+    if !now.Before(c.deadline) {
+        c.master = false
+        return false, time.Time{}
     }
 
-    log.Info("Get %s response kvs is empty", MS_PREFIX)
+    ctx, cancel := context.WithTimeout(ctx, c.deadline.Sub(now))
+    defer cancel()
+
+    master, err := m.committedMaster(ctx, group)
+    if err != nil {
+        log.Warn("Get master of group: %v error, reason: %v\n", group, err.Error())
+        if c.master && m.client.Now().Before(c.deadline) {
+            return true, c.deadline
+        }
+        return false, time.Time{}
+    }
+
+    c.master = master == nodeId
+    if c.master {
+        return true, c.deadline
+    }
+    return false, time.Time{}
+}
+
+//优先返回master记录，master刚失效尚未重新选出时返回优先级最高的候选者
+//后者只是提示，该候选者不一定能当选，判断自己是否为master应使用IsMaster
+func (m *ms) GetMaster(ctx context.Context, group string) (uint32, error) {
+    if err := checkGroup(group); err != nil {
+        return INVALID_NODE, err
+    }
+
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+    return m.getMaster(ctx, group)
+}
+
+func (m *ms) getMaster(ctx context.Context, group string) (uint32, error) {
+    var err error
+    var candidates []Candidate
+    var master *clientv3.GetResponse
+    if candidates, master, err = m.getCandidates(ctx, group); err != nil {
+        return INVALID_NODE, err
+    }
+
+    if len(master.Kvs) != 0 {
+        return parseNodeId(master.Kvs[0].Value), nil
+    }
+
+    if len(candidates) != 0 {
+        return candidates[0].NodeId, nil
+    }
+
+    log.Info("Get %s response kvs is empty", groupPrefix(group))
     return INVALID_NODE, nil
 }
 
+//已提交的master记录，不存在时返回INVALID_NODE
+func (m *ms) committedMaster(ctx context.Context, group string) (uint32, error) {
+    resp, err := m.client.Get(ctx, masterKey(group))
+    if err != nil {
+        return INVALID_NODE, err
+    }
+
+    if len(resp.Kvs) == 0 {
+        return INVALID_NODE, nil
+    }
+    return parseNodeId(resp.Kvs[0].Value), nil
+}
+
+//等待master记录提交，候选者或master记录变化时重新读取，超时返回context.DeadlineExceeded，timeout为0时使用ms.timeout
+func (m *ms) WaitForMaster(ctx context.Context, group string, timeout time.Duration) (uint32, error) {
+    if err := checkGroup(group); err != nil {
+        return INVALID_NODE, err
+    }
+
+    var cancel context.CancelFunc
+    if timeout == 0 {
+        ctx, cancel = m.withTimeout(ctx)
+    } else {
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+    }
+    defer cancel()
+
+    //Watch在服务端建立之后再读取，避免遗漏两者之间的变化
+    candidates := m.client.Watch(ctx, groupPrefix(group), backend.WithPrefix(), backend.WithCreatedNotify())
+    master := m.client.Watch(ctx, masterKey(group), backend.WithCreatedNotify())
+    for _, wChan := range []clientv3.WatchChan{candidates, master} {
+        select {
+        case <-ctx.Done():
+            return INVALID_NODE, ctx.Err()
+        case <-wChan:
+        }
+    }
+
+    for {
+        nodeId, err := m.committedMaster(ctx, group)
+        if err != nil {
+            return INVALID_NODE, err
+        }
+
+        if nodeId != INVALID_NODE {
+            return nodeId, nil
+        }
+
+        var ok bool
+        select {
+        case <-ctx.Done():
+            log.Warn("Wait for master of group: %v timeout", group)
+            return INVALID_NODE, ctx.Err()
+        case _, ok = <-candidates:
+        case _, ok = <-master:
+        }
+
+        //Watch随ctx结束关闭时仍然返回ctx的错误
+        if !ok {
+            if err := ctx.Err(); err != nil {
+                return INVALID_NODE, err
+            }
+            return INVALID_NODE, fmt.Errorf("Wait for master of group: %v error, watch closed", group)
+        }
+    }
+}
+
+//先推送当前master，之后master记录每次变化推送一次，ctx取消或者Watch出错时关闭channel
+func (m *ms) WatchMaster(ctx context.Context, group string) (<-chan Master, error) {
+    if err := checkGroup(group); err != nil {
+        return nil, err
+    }
+
+    mkey := masterKey(group)
+    resp, err := m.client.Get(ctx, mkey)
+    if err != nil {
+        log.Warn("Get %v error, reason: %v", mkey, err.Error())
+        return nil, err
+    }
+
+    masters := make(chan Master, 16)
+    current := Master{group, INVALID_NODE, resp.Header.Revision}
+    if len(resp.Kvs) != 0 {
+        current = Master{group, parseNodeId(resp.Kvs[0].Value), resp.Kvs[0].ModRevision}
+    }
+    masters <- current
+
+    go func() {
+        defer close(masters)
+        wChan := m.client.Watch(ctx, mkey, backend.WithRev(resp.Header.Revision+1))
+        for wResp := range wChan {
+            if err := wResp.Err(); err != nil {
+                log.Warn("Watch master of group: %v error, reason: %v", group, err.Error())
+                return
+            }
+
+            for _, ev := range wResp.Events {
+                master := Master{group, INVALID_NODE, ev.Kv.ModRevision}
+                if ev.Type == clientv3.EventTypePut {
+                    master.NodeId = parseNodeId(ev.Kv.Value)
+                }
+
+                select {
+                case masters <- master:
+                case <-ctx.Done():
+                    return
+                }
+            }
+        }
+    }()
+    return masters, nil
+}
+
 func (m *ms) MSSetTTL(ttl int64) {
     m.ttl = ttl
 }
+
+//为0时只使用调用者ctx的deadline
+func (m *ms) MSSetTimeout(timeout time.Duration) {
+    m.timeout = timeout
+    log.Info("Set ms timeout = %v", timeout)
+}
+
+func (m *ms) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+    return backend.WithTimeout(ctx, m.timeout)
+}
+
+func (m *ms) MSSetPreempt(preempt bool, delay time.Duration) {
+    m.Lock()
+    defer m.Unlock()
+
+    m.preempt = preempt
+    m.delay = delay
+    log.Info("Set preempt = %v, delay = %v", preempt, delay)
+}
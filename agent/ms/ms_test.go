@@ -2,6 +2,7 @@ package ms
 
 import (
     "context"
+    "etcdagent/agent/backend"
     "fmt"
     "os"
     "sync"
@@ -12,6 +13,7 @@ import (
 )
 
 const ETCDADDR = "172.100.1.239:2379"
+const GROUP = "test"
 
 func TestMSCompete(t *testing.T) {
     var client *clientv3.Client
@@ -37,11 +39,11 @@ func TestMSCompete(t *testing.T) {
         {3, 1},
         {4, 1},
     } {
-        if err := ms.MSCompete(info.nodeId); err != nil {
+        if err := ms.MSCompete(context.TODO(), GROUP, info.nodeId, 0); err != nil {
             t.Errorf("MS compete error, node: %v, reason: %v", info.nodeId, err.Error())
         }
 
-        if acctually, err := ms.GetMaster(); err != nil {
+        if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
             t.Errorf("Get master error, reason: %v", err.Error())
         } else {
             if acctually != info.expected {
@@ -59,11 +61,11 @@ func TestMSCompete(t *testing.T) {
         {3, 4},
         {4, 0xff},
     } {
-        if err := ms.MSGiveUp(info.nodeId); err != nil {
+        if err := ms.MSGiveUp(context.TODO(), GROUP, info.nodeId); err != nil {
             t.Errorf("MS give up error, node: %v, reason:%v", info.nodeId, err.Error())
         }
 
-        if acctually, err := ms.GetMaster(); err != nil {
+        if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
             t.Errorf("Get master error, reason: %v", err.Error())
         } else {
             if acctually != info.expected {
@@ -103,16 +105,16 @@ func TestMSKeepalive(t *testing.T) {
         wg.Add(1)
         go func(nodeId uint32, expected uint32) {
             <-time.After(time.Duration(nodeId) * time.Millisecond * 100) //随机延时，确保nodeId=1为master
-            if err := ms.MSCompete(nodeId); err != nil {
+            if err := ms.MSCompete(context.TODO(), GROUP, nodeId, 0); err != nil {
                 t.Errorf("MS compete error, reason: %v", err.Error())
             }
 
             for i := 0; i < 100; i++ {
-                if err := ms.MSKeepalive(nodeId); err != nil {
+                if err := ms.MSKeepalive(context.TODO(), GROUP, nodeId); err != nil {
                     t.Errorf("MS keepalive error, reason: %v", err.Error())
                 }
 
-                if acctually, err := ms.GetMaster(); err != nil {
+                if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
                     t.Errorf("Get master error, reason: %v", err.Error())
                 } else {
                     if acctually != expected {
@@ -124,7 +126,7 @@ func TestMSKeepalive(t *testing.T) {
 
             //3s后GetMaster获取到无效值
             <-time.After(3 * time.Second)
-            if acctually, err := ms.GetMaster(); err != nil {
+            if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
                 t.Errorf("Get master error, reason: %v", err.Error())
             } else {
                 if acctually != 0xff {
@@ -166,16 +168,16 @@ func TestIsMaster(t *testing.T) {
         wg.Add(1)
         go func(nodeId uint32, expected bool) {
             <-time.After(time.Duration(nodeId) * time.Millisecond * 100) //随机延时，确保nodeId=1为master
-            if err := ms.MSCompete(nodeId); err != nil {
+            if err := ms.MSCompete(context.TODO(), GROUP, nodeId, 0); err != nil {
                 t.Errorf("MS compete error, node: %v, reason: %v", nodeId, err.Error())
             }
 
             for i := 0; i < 100; i++ {
-                if err := ms.MSKeepalive(nodeId); err != nil {
+                if err := ms.MSKeepalive(context.TODO(), GROUP, nodeId); err != nil {
                     t.Errorf("MS keepalive error, node: %v, reason: %v", nodeId, err.Error())
                 }
 
-                if acctually := ms.IsMaster(nodeId); acctually != expected {
+                if acctually := ms.IsMaster(context.TODO(), GROUP, nodeId); acctually != expected {
                     t.Errorf("Test isMaster failed, nodeId = %v, expected = %v, acctually = %v", nodeId, expected, acctually)
                 }
                 <-time.After(100 * time.Millisecond)
@@ -208,7 +210,7 @@ func TestMSSetTTL(t *testing.T) {
         fmt.Printf("Start watch, time = %v\n", time.Now())
         ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
         defer cancel()
-        wChan := client.Watch(ctx, "/CoreNet/MS/", clientv3.WithPrefix())
+        wChan := client.Watch(ctx, "/CoreNet/MS/"+GROUP+"/", clientv3.WithPrefix())
 
         for wResp := range wChan {
             fmt.Printf("Receive event, time = %v\n", time.Now())
@@ -220,7 +222,7 @@ func TestMSSetTTL(t *testing.T) {
 
     var nodeId uint32 = 100
     fmt.Printf("Start MS compete, time = %v\n", time.Now())
-    if err := ms.MSCompete(nodeId); err != nil {
+    if err := ms.MSCompete(context.TODO(), GROUP, nodeId, 0); err != nil {
         t.Errorf("Test MS Set TTL error, reason: %v\n", err.Error())
     }
     <-time.After(5 * time.Second)
@@ -235,3 +237,555 @@ func TestMSSetTTL(t *testing.T) {
 // --- PASS: TestTTL (2.06s)
 // PASS
 // ok      etcdagent/agent/ms      2.066s
+
+func TestMSGroups(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    ms := NewMS(client)
+    ms.MSSetTTL(10)
+    data := []struct {
+        group  string
+        nodeId uint32
+    }{
+        {"control", 1},
+        {"billing", 2},
+        {"stats", 3},
+    }
+
+    //不同group之间互不影响，各自都有master
+    for _, info := range data {
+        if err := ms.MSCompete(context.TODO(), info.group, info.nodeId, 0); err != nil {
+            t.Errorf("MS compete error, group: %v, node: %v, reason: %v", info.group, info.nodeId, err.Error())
+        }
+    }
+
+    for _, info := range data {
+        if acctually, err := ms.GetMaster(context.TODO(), info.group); err != nil {
+            t.Errorf("Get master error, group: %v, reason: %v", info.group, err.Error())
+        } else if acctually != info.nodeId {
+            t.Errorf("Test MS groups failed, group: %v, expected = %v, acctually = %v", info.group, info.nodeId, acctually)
+        }
+
+        if !ms.IsMaster(context.TODO(), info.group, info.nodeId) {
+            t.Errorf("Test MS groups failed, node: %v should be master of group: %v", info.nodeId, info.group)
+        }
+    }
+
+    for _, info := range data {
+        if err := ms.MSGiveUp(context.TODO(), info.group, info.nodeId); err != nil {
+            t.Errorf("MS give up error, group: %v, node: %v, reason: %v", info.group, info.nodeId, err.Error())
+        }
+    }
+
+    if err := ms.MSCompete(context.TODO(), "a/b", 1, 0); err == nil {
+        t.Errorf("Test MS groups failed, group with '/' should be rejected")
+    }
+    client.Close()
+}
+
+func TestMSPriority(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    ms := NewMS(client)
+    ms.MSSetTTL(10)
+
+    //未开启抢占时，高优先级候选者不替换当前master
+    if err := ms.MSCompete(context.TODO(), GROUP, 1, 1); err != nil {
+        t.Errorf("MS compete error, node: 1, reason: %v", err.Error())
+    }
+
+    if err := ms.MSCompete(context.TODO(), GROUP, 2, 10); err != nil {
+        t.Errorf("MS compete error, node: 2, reason: %v", err.Error())
+    }
+
+    if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
+        t.Errorf("Get master error, reason: %v", err.Error())
+    } else if acctually != 1 {
+        t.Errorf("Test MS priority failed, expected = 1, acctually = %v", acctually)
+    }
+
+    //master放弃后，优先级最高的候选者成为master
+    if err := ms.MSCompete(context.TODO(), GROUP, 3, 5); err != nil {
+        t.Errorf("MS compete error, node: 3, reason: %v", err.Error())
+    }
+
+    if err := ms.MSGiveUp(context.TODO(), GROUP, 1); err != nil {
+        t.Errorf("MS give up error, node: 1, reason: %v", err.Error())
+    }
+
+    if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
+        t.Errorf("Get master error, reason: %v", err.Error())
+    } else if acctually != 2 {
+        t.Errorf("Test MS priority failed, expected = 2, acctually = %v", acctually)
+    }
+
+    //开启抢占，稳定时间后更高优先级的候选者成为master
+    ms.MSSetPreempt(true, time.Second)
+    if err := ms.MSCompete(context.TODO(), GROUP, 1, 20); err != nil {
+        t.Errorf("MS compete error, node: 1, reason: %v", err.Error())
+    }
+
+    if acctually := ms.IsMaster(context.TODO(), GROUP, 1); acctually {
+        t.Errorf("Test MS priority failed, node 1 should not preempt before delay")
+    }
+
+    <-time.After(1500 * time.Millisecond)
+    if err := ms.MSKeepalive(context.TODO(), GROUP, 1); err != nil {
+        t.Errorf("MS keepalive error, node: 1, reason: %v", err.Error())
+    }
+
+    if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
+        t.Errorf("Get master error, reason: %v", err.Error())
+    } else if acctually != 1 {
+        t.Errorf("Test MS priority failed, expected = 1, acctually = %v", acctually)
+    }
+
+    for _, nodeId := range []uint32{1, 2, 3} {
+        if err := ms.MSGiveUp(context.TODO(), GROUP, nodeId); err != nil {
+            t.Errorf("MS give up error, node: %v, reason: %v", nodeId, err.Error())
+        }
+    }
+    client.Close()
+}
+
+func TestMSTransfer(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    ms := NewMS(client)
+    ms.MSSetTTL(10)
+    for _, nodeId := range []uint32{1, 2} {
+        if err := ms.MSCompete(context.TODO(), GROUP, nodeId, 0); err != nil {
+            t.Errorf("MS compete error, node: %v, reason: %v", nodeId, err.Error())
+        }
+    }
+
+    //继任者未确认时交接超时，master不变
+    if err := ms.MSTransfer(context.TODO(), GROUP, 1, 2, time.Second); err == nil {
+        t.Errorf("Test MS transfer failed, transfer without ack should fail")
+    }
+
+    if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
+        t.Errorf("Get master error, reason: %v", err.Error())
+    } else if acctually != 1 {
+        t.Errorf("Test MS transfer failed, expected = 1, acctually = %v", acctually)
+    }
+
+    //继任者确认后完成交接
+    go func() {
+        <-time.After(500 * time.Millisecond)
+        if err := ms.MSTransferAck(context.TODO(), GROUP, 2); err != nil {
+            t.Errorf("MS transfer ack error, reason: %v", err.Error())
+        }
+    }()
+
+    if err := ms.MSTransfer(context.TODO(), GROUP, 1, 2, 3*time.Second); err != nil {
+        t.Errorf("MS transfer error, reason: %v", err.Error())
+    }
+
+    if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
+        t.Errorf("Get master error, reason: %v", err.Error())
+    } else if acctually != 2 {
+        t.Errorf("Test MS transfer failed, expected = 2, acctually = %v", acctually)
+    }
+
+    for _, nodeId := range []uint32{1, 2} {
+        if err := ms.MSGiveUp(context.TODO(), GROUP, nodeId); err != nil {
+            t.Errorf("MS give up error, node: %v, reason: %v", nodeId, err.Error())
+        }
+    }
+    client.Close()
+}
+
+func TestMSLeaseDeadline(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    ms := NewMS(client)
+    ms.MSSetTTL(2)
+    var nodeId uint32 = 1
+    if err := ms.MSCompete(context.TODO(), GROUP, nodeId, 0); err != nil {
+        t.Errorf("MS compete error, node: %v, reason: %v", nodeId, err.Error())
+    }
+
+    master, until := ms.IsMasterUntil(context.TODO(), GROUP, nodeId)
+    if !master {
+        t.Errorf("Test MS lease deadline failed, node: %v should be master", nodeId)
+    }
+
+    if until.After(time.Now().Add(2 * time.Second)) {
+        t.Errorf("Test MS lease deadline failed, deadline: %v exceeds ttl", until)
+    }
+
+    //保活后到期时间延后
+    <-time.After(500 * time.Millisecond)
+    if err := ms.MSKeepalive(context.TODO(), GROUP, nodeId); err != nil {
+        t.Errorf("MS keepalive error, node: %v, reason: %v", nodeId, err.Error())
+    }
+
+    if _, renewed := ms.IsMasterUntil(context.TODO(), GROUP, nodeId); !renewed.After(until) {
+        t.Errorf("Test MS lease deadline failed, deadline should be renewed, before: %v, after: %v", until, renewed)
+    }
+
+    //不再保活，本地到期后不再是master
+    <-time.After(2500 * time.Millisecond)
+    if ms.IsMaster(context.TODO(), GROUP, nodeId) {
+        t.Errorf("Test MS lease deadline failed, node: %v should not be master after deadline", nodeId)
+    }
+
+    ms.MSGiveUp(context.TODO(), GROUP, nodeId)
+    client.Close()
+}
+
+func TestWaitForMaster(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    ms := NewMS(client)
+    ms.MSSetTTL(10)
+    if _, err := ms.WaitForMaster(context.TODO(), "wait", 500*time.Millisecond); err != context.DeadlineExceeded {
+        t.Errorf("Test wait for master failed, expected = %v, acctually = %v", context.DeadlineExceeded, err)
+    }
+
+    go func() {
+        <-time.After(500 * time.Millisecond)
+        if err := ms.MSCompete(context.TODO(), "wait", 7, 0); err != nil {
+            t.Errorf("MS compete error, reason: %v", err.Error())
+        }
+    }()
+
+    if acctually, err := ms.WaitForMaster(context.TODO(), "wait", 3*time.Second); err != nil {
+        t.Errorf("Wait for master error, reason: %v", err.Error())
+    } else if acctually != 7 {
+        t.Errorf("Test wait for master failed, expected = 7, acctually = %v", acctually)
+    }
+
+    //timeout为0时使用ms.timeout
+    if acctually, err := ms.WaitForMaster(context.TODO(), "wait", 0); err != nil || acctually != 7 {
+        t.Errorf("Test wait for master with zero timeout failed, expected = 7, acctually = %v, err = %v", acctually, err)
+    }
+
+    ms.MSSetTimeout(300 * time.Millisecond)
+    start := time.Now()
+    if _, err := ms.WaitForMaster(context.TODO(), "wait-none", 0); err != context.DeadlineExceeded || time.Since(start) < 200*time.Millisecond {
+        t.Errorf("Test wait for master with zero timeout failed, expected = %v after ms.timeout, acctually = %v after %v", context.DeadlineExceeded, err, time.Since(start))
+    }
+
+    ms.MSGiveUp(context.TODO(), "wait", 7)
+    client.Close()
+}
+
+func TestMSForceStepDown(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    ms := NewMS(client)
+    ms.MSSetTTL(10)
+    for _, c := range []Candidate{{NodeId: 1, Priority: 10}, {NodeId: 2, Priority: 1}, {NodeId: 3, Priority: 5}} {
+        if err := ms.MSCompete(context.TODO(), GROUP, c.NodeId, c.Priority); err != nil {
+            t.Errorf("MS compete error, node: %v, reason: %v", c.NodeId, err.Error())
+        }
+    }
+
+    //按选主顺序返回候选者
+    if candidates, err := ms.GetCandidates(context.TODO(), GROUP); err != nil {
+        t.Errorf("Get candidates error, reason: %v", err.Error())
+    } else if len(candidates) != 3 || candidates[0].NodeId != 1 || candidates[1].NodeId != 3 || candidates[2].NodeId != 2 {
+        t.Errorf("Test get candidates failed, expected = [1 3 2], acctually = %v", candidates)
+    }
+
+    if groups, err := ms.GetGroups(context.TODO()); err != nil {
+        t.Errorf("Get groups error, reason: %v", err.Error())
+    } else if len(groups) != 1 || groups[0] != GROUP {
+        t.Errorf("Test get groups failed, expected = [%v], acctually = %v", GROUP, groups)
+    }
+
+    if acctually, err := ms.MSForceStepDown(context.TODO(), GROUP); err != nil {
+        t.Errorf("MS force step down error, reason: %v", err.Error())
+    } else if acctually != 1 {
+        t.Errorf("Test MS force step down failed, expected old master = 1, acctually = %v", acctually)
+    }
+
+    //原master的候选记录同时被删除，剩余候选者重新选主
+    if err := ms.MSElect(context.TODO(), GROUP); err != nil {
+        t.Errorf("MS elect error, reason: %v", err.Error())
+    }
+
+    if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil {
+        t.Errorf("Get master error, reason: %v", err.Error())
+    } else if acctually != 3 {
+        t.Errorf("Test MS force step down failed, expected = 3, acctually = %v", acctually)
+    }
+
+    for _, nodeId := range []uint32{1, 2, 3} {
+        if err := ms.MSGiveUp(context.TODO(), GROUP, nodeId); err != nil {
+            t.Errorf("MS give up error, node: %v, reason: %v", nodeId, err.Error())
+        }
+    }
+
+    if _, err := ms.MSForceStepDown(context.TODO(), GROUP); err == nil {
+        t.Errorf("Test MS force step down failed, group has no master")
+    }
+    client.Close()
+}
+
+func TestMSReconcile(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    ms := NewMS(client)
+    ms.MSSetTTL(10)
+    for _, c := range []Candidate{{NodeId: 1, Priority: 10}, {NodeId: 2, Priority: 5}} {
+        if err := ms.MSCompete(context.TODO(), GROUP, c.NodeId, c.Priority); err != nil {
+            t.Errorf("MS compete error, node: %v, reason: %v", c.NodeId, err.Error())
+        }
+    }
+
+    //候选key被误删时使用原lease恢复，master记录随之恢复
+    resp, err := client.Delete(context.TODO(), msKey(GROUP, 1))
+    if err != nil {
+        t.Fatalf("Delete candidate error, reason: %v", err.Error())
+    }
+
+    if acctually, err := ms.MSReconcile(context.TODO(), GROUP, 3, resp.Header.Revision); err != nil || acctually != "" {
+        t.Errorf("Test MS reconcile failed, node 3 is not local, acctually = %q, err = %v", acctually, err)
+    }
+
+    if acctually, err := ms.MSReconcile(context.TODO(), GROUP, 1, resp.Header.Revision); err != nil || acctually != "restored" {
+        t.Errorf("Test MS reconcile failed, expected = restored, acctually = %q, err = %v", acctually, err)
+    }
+
+    if candidates, err := ms.GetCandidates(context.TODO(), GROUP); err != nil || len(candidates) != 2 || candidates[0].NodeId != 1 || candidates[0].Priority != 10 {
+        t.Errorf("Test MS reconcile failed, candidate 1 should be restored, acctually = %v, err = %v", candidates, err)
+    }
+
+    //master的lease被回收后删除本地候选者，本地其他候选者成为master
+    if _, err := ms.MSForceStepDown(context.TODO(), GROUP); err != nil {
+        t.Errorf("MS force step down error, reason: %v", err.Error())
+    }
+
+    if acctually, err := ms.MSReconcile(context.TODO(), GROUP, 1, 0); err != nil || acctually != "dropped" {
+        t.Errorf("Test MS reconcile failed, expected = dropped, acctually = %q, err = %v", acctually, err)
+    }
+
+    if acctually := ms.LocalCandidates(); fmt.Sprint(acctually) != fmt.Sprintf("map[%v:[2]]", GROUP) {
+        t.Errorf("Test MS reconcile failed, expected local candidates = [2], acctually = %v", acctually)
+    }
+
+    if acctually, err := ms.GetMaster(context.TODO(), GROUP); err != nil || acctually != 2 {
+        t.Errorf("Test MS reconcile failed, expected master = 2, acctually = %v, err = %v", acctually, err)
+    }
+
+    ms.MSGiveUp(context.TODO(), GROUP, 2)
+    client.Close()
+}
+
+//相同group和nodeId的候选key被其他agent注册时返回冲突，重复竞选沿用原lease
+func TestMSCompeteConflict(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    first := NewMSFromBackend(m)
+    second := NewMSFromBackend(m)
+    if err := first.MSCompete(context.TODO(), GROUP, 1, 10); err != nil {
+        t.Fatalf("MS compete error, reason: %v", err.Error())
+    }
+
+    err := second.MSCompete(context.TODO(), GROUP, 1, 20)
+    if _, ok := err.(*ConflictError); !ok {
+        t.Errorf("Test ms compete conflict failed, expected ConflictError, acctually = %v", err)
+    }
+
+    lease := first.(*ms).candidates[msKey(GROUP, 1)].lease
+    if err := first.MSCompete(context.TODO(), GROUP, 1, 30); err != nil {
+        t.Errorf("MS compete again error, reason: %v", err.Error())
+    }
+
+    resp, err := m.Get(context.TODO(), msKey(GROUP, 1))
+    if err != nil || len(resp.Kvs) != 1 || clientv3.LeaseID(resp.Kvs[0].Lease) != lease || string(resp.Kvs[0].Value) != "30" {
+        t.Errorf("Test ms compete conflict failed, expected priority 30 with lease %v, acctually = %v, err = %v", lease, resp, err)
+    }
+    if !first.IsMaster(context.TODO(), GROUP, 1) {
+        t.Errorf("Test ms compete conflict failed, 1 should still be master after compete again")
+    }
+}
+
+//使用Memory模拟master的lease到期，不依赖etcd和真实时间
+func TestMSMemory(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    first := NewMSFromBackend(m)
+    first.MSSetTTL(2)
+    second := NewMSFromBackend(m)
+    second.MSSetTTL(2)
+
+    if err := first.MSCompete(context.TODO(), GROUP, 1, 10); err != nil {
+        t.Errorf("MS compete error, reason: %v", err.Error())
+    }
+    //未开启抢占时优先级更高的候选者不替换当前master
+    if err := second.MSCompete(context.TODO(), GROUP, 2, 20); err != nil {
+        t.Errorf("MS compete error, reason: %v", err.Error())
+    }
+
+    if !first.IsMaster(context.TODO(), GROUP, 1) || second.IsMaster(context.TODO(), GROUP, 2) {
+        t.Errorf("Test ms memory failed, expected master = 1")
+    }
+
+    //只有2续约，1的lease到期后由2接管
+    m.Advance(time.Second)
+    if err := second.MSKeepalive(context.TODO(), GROUP, 2); err != nil {
+        t.Errorf("MS keepalive error, reason: %v", err.Error())
+    }
+    m.Advance(1500 * time.Millisecond)
+
+    if first.IsMaster(context.TODO(), GROUP, 1) {
+        t.Errorf("Test ms memory failed, 1 should not be master after lease expired")
+    }
+    if acctually, err := second.GetMaster(context.TODO(), GROUP); err != nil || acctually != 2 {
+        t.Errorf("Test ms memory failed, expected = 2, acctually = %v, err = %v", acctually, err)
+    }
+
+    if err := second.MSElect(context.TODO(), GROUP); err != nil {
+        t.Errorf("MS elect error, reason: %v", err.Error())
+    }
+    if master, until := second.IsMasterUntil(context.TODO(), GROUP, 2); !master || !until.Equal(m.Now().Add(500*time.Millisecond)) {
+        t.Errorf("Test ms memory failed, expected master 2 until %v, acctually = %v, %v", m.Now().Add(500*time.Millisecond), master, until)
+    }
+}
+
+func TestMSContext(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    ms := NewMSFromBackend(m)
+    ctx, cancel := context.WithTimeout(context.Background(), 0)
+    defer cancel()
+    if err := ms.MSCompete(ctx, GROUP, 1, 10); err != context.DeadlineExceeded {
+        t.Errorf("MS compete with expired ctx expected = %v, acctually = %v", context.DeadlineExceeded, err)
+    }
+    if _, err := ms.GetMaster(ctx, GROUP); err != context.DeadlineExceeded {
+        t.Errorf("Get master with expired ctx expected = %v, acctually = %v", context.DeadlineExceeded, err)
+    }
+
+    //ctx先于timeout结束
+    start := time.Now()
+    ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    if _, err := ms.WaitForMaster(ctx, GROUP, 10*time.Second); err != context.DeadlineExceeded || time.Since(start) > time.Second {
+        t.Errorf("Wait for master expected = %v within ctx deadline, acctually = %v after %v", context.DeadlineExceeded, err, time.Since(start))
+    }
+
+    if err := ms.MSCompete(context.TODO(), GROUP, 1, 10); err != nil {
+        t.Errorf("MS compete error, reason: %v", err.Error())
+    }
+    if !ms.IsMaster(context.TODO(), GROUP, 1) {
+        t.Errorf("Test ms context failed, expected master = 1")
+    }
+
+    //timeout为0时使用ms.timeout
+    if err := ms.MSCompete(context.TODO(), GROUP, 2, 5); err != nil {
+        t.Errorf("MS compete error, reason: %v", err.Error())
+    }
+    go func() {
+        <-time.After(100 * time.Millisecond)
+        if err := ms.MSTransferAck(context.TODO(), GROUP, 2); err != nil {
+            t.Errorf("MS transfer ack error, reason: %v", err.Error())
+        }
+    }()
+    if err := ms.MSTransfer(context.TODO(), GROUP, 1, 2, 0); err != nil {
+        t.Errorf("MS transfer with default timeout error, reason: %v", err.Error())
+    }
+}
+
+func TestMSUncommitted(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    ms := NewMSFromBackend(m)
+    if err := ms.MSCompete(context.TODO(), GROUP, 1, 10); err != nil {
+        t.Errorf("MS compete error, reason: %v", err.Error())
+    }
+
+    //master记录已删除、尚未重新选举时，候选者不是master
+    if _, err := m.Delete(context.TODO(), masterKey(GROUP)); err != nil {
+        t.Errorf("Delete master error, reason: %v", err.Error())
+    }
+    if ms.IsMaster(context.TODO(), GROUP, 1) {
+        t.Errorf("Test ms uncommitted failed, expected not master, acctually master")
+    }
+    if master, deadline := ms.IsMasterUntil(context.TODO(), GROUP, 1); master || !deadline.IsZero() {
+        t.Errorf("Test ms uncommitted failed, expected = false, acctually = %v until %v", master, deadline)
+    }
+    if master, err := ms.GetMaster(context.TODO(), GROUP); err != nil || master != 1 {
+        t.Errorf("Test ms uncommitted failed, expected hint = 1, acctually = %v, err = %v", master, err)
+    }
+    if _, err := ms.WaitForMaster(context.TODO(), GROUP, 100*time.Millisecond); err != context.DeadlineExceeded {
+        t.Errorf("Wait for master expected = %v, acctually = %v", context.DeadlineExceeded, err)
+    }
+}
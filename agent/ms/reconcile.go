@@ -0,0 +1,93 @@
+package ms
+
+import (
+    "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/event"
+    "etcdagent/agent/log"
+    "sort"
+    "strconv"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+//本agent的候选者，key为group，nodeId从小到大排序
+func (m *ms) LocalCandidates() map[string][]uint32 {
+    m.Lock()
+    defer m.Unlock()
+
+    groups := make(map[string][]uint32)
+    for _, c := range m.candidates {
+        groups[c.group] = append(groups[c.group], c.nodeId)
+    }
+    for _, nodes := range groups {
+        sort.Slice(nodes, func(i, j int) bool {
+            return nodes[i] < nodes[j]
+        })
+    }
+    return groups
+}
+
+//本agent的候选key在etcd中被删除或者覆盖时：
+//1）不是本agent的候选者，或者revision不晚于本地最近一次写入，不处理
+//2）lease仍然有效时使用原lease和优先级恢复候选key，并重新选主
+//3）lease已失效或者key已被其他lease写入时，删除本地候选者，本地其他候选者重新选主
+//revision为0时直接比较etcd中的当前状态，用于定期检查
+func (m *ms) MSReconcile(ctx context.Context, group string, nodeId uint32, revision int64) (string, error) {
+    m.Lock()
+    defer m.Unlock()
+
+    key := msKey(group, nodeId)
+    c, ok := m.candidates[key]
+    if !ok || (revision != 0 && revision <= c.revision) {
+        return event.RECONCILE_NONE, nil
+    }
+
+    ctx, cancel := m.withTimeout(ctx)
+    defer cancel()
+
+    value := strconv.FormatUint(uint64(c.priority), 10)
+    resp, err := m.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+        Then(backend.OpPut(key, value, backend.WithLease(c.lease))).
+        Else(backend.OpGet(key)).
+        Commit()
+    if err == rpctypes.ErrLeaseNotFound {
+        log.Warn("MS lease: %v expired, drop local candidate, group: %v, node: %v", c.lease, group, nodeId)
+        m.drop(ctx, key, c)
+        return event.RECONCILE_DROPPED, nil
+    }
+
+    if err != nil {
+        log.Warn("Reconcile ms candidate error, group: %v, node: %v, reason: %v", group, nodeId, err.Error())
+        return event.RECONCILE_NONE, err
+    }
+
+    if resp.Succeeded {
+        c.revision = resp.Header.Revision
+        log.Warn("MS candidate was deleted while lease: %v is alive, restored, group: %v, node: %v", c.lease, group, nodeId)
+        if err = m.elect(ctx, c); err != nil {
+            log.Warn("MS elect error, group: %v, node: %v, reason: %v", group, nodeId, err.Error())
+        }
+        return event.RECONCILE_RESTORED, nil
+    }
+
+    kv := resp.Responses[0].GetResponseRange().Kvs[0]
+    if clientv3.LeaseID(kv.Lease) == c.lease {
+        return event.RECONCILE_NONE, nil
+    }
+
+    //本地lease不再使用，绑定该lease的master记录一起删除
+    log.Warn("MS candidate is overwritten with lease: %v, drop local candidate, group: %v, node: %v", kv.Lease, group, nodeId)
+    if _, err = m.client.Revoke(ctx, c.lease); err != nil && err != rpctypes.ErrLeaseNotFound {
+        log.Warn("Revoke lease: %v error, group: %v, node: %v, reason: %v", c.lease, group, nodeId, err.Error())
+    }
+    m.drop(ctx, key, c)
+    return event.RECONCILE_DROPPED, nil
+}
+
+func (m *ms) drop(ctx context.Context, key string, c *candidate) {
+    delete(m.candidates, key)
+    m.electGroup(ctx, c.group)
+}
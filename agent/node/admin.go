@@ -0,0 +1,99 @@
+package node
+
+import (
+    "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/log"
+    "fmt"
+    "sort"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+type NodeInfo struct {
+    NodeId      uint32
+    ServiceAddr string
+    Lease       clientv3.LeaseID
+    TTL         int64 //lease剩余秒数，-1表示没有lease或者lease已过期
+}
+
+//列出所有在线node及其lease剩余时间，按nodeId排序
+func (n *node) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+    ctx, cancel := n.withTimeout(ctx)
+    defer cancel()
+
+    resp, err := n.client.Get(ctx, NODE_PREFIX, backend.WithPrefix())
+    if err != nil {
+        log.Warn("Get %v with prefix error, reason: %v", NODE_PREFIX, err.Error())
+        return nil, err
+    }
+
+    nodes := make([]NodeInfo, 0, len(resp.Kvs))
+    for _, kv := range resp.Kvs {
+        nodeId, ok := parseId(string(kv.Key), NODE_PREFIX)
+        if !ok {
+            continue
+        }
+
+        info := NodeInfo{
+            NodeId:      nodeId,
+            ServiceAddr: string(kv.Value),
+            Lease:       clientv3.LeaseID(kv.Lease),
+            TTL:         -1,
+        }
+
+        if info.Lease != clientv3.NoLease {
+            ttlResp, err := n.client.TimeToLive(ctx, info.Lease)
+            if err != nil {
+                log.Warn("Get lease: %v ttl error, nodeId: %v, reason: %v", info.Lease, nodeId, err.Error())
+                return nil, err
+            }
+            info.TTL = ttlResp.TTL
+        }
+        nodes = append(nodes, info)
+    }
+
+    sort.Slice(nodes, func(i, j int) bool {
+        return nodes[i].NodeId < nodes[j].NodeId
+    })
+    return nodes, nil
+}
+
+//管理员强制下线：回收node注册使用的lease，owner的下一次保活会失败
+func (n *node) NodeForceOffline(ctx context.Context, nodeId uint32) error {
+    ctx, cancel := n.withTimeout(ctx)
+    defer cancel()
+
+    key := fmt.Sprintf("%s%v", NODE_PREFIX, nodeId)
+    resp, err := n.client.Get(ctx, key)
+    if err != nil {
+        log.Warn("Get %v error, reason: %v", key, err.Error())
+        return err
+    }
+
+    if len(resp.Kvs) == 0 {
+        return fmt.Errorf("Node force offline error, node is not online: %v", nodeId)
+    }
+
+    kv := resp.Kvs[0]
+    if lease := clientv3.LeaseID(kv.Lease); lease != clientv3.NoLease {
+        //lease已过期时key也已被删除
+        if _, err = n.client.Revoke(ctx, lease); err != nil && err != rpctypes.ErrLeaseNotFound {
+            log.Warn("Revoke lease: %v error, nodeId: %v, reason: %v", lease, nodeId, err.Error())
+            return err
+        }
+    } else {
+        //只删除读取到的这一次注册，避免误删之后重新上线的记录
+        if _, err = n.client.Txn(ctx).
+            If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+            Then(backend.OpDelete(key)).
+            Commit(); err != nil {
+            log.Warn("Delete %v error, reason: %v", key, err.Error())
+            return err
+        }
+    }
+
+    log.Warn("Node force offline, nodeId: %v, service: %v, lease: %v", nodeId, string(kv.Value), kv.Lease)
+    return nil
+}
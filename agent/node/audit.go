@@ -0,0 +1,169 @@
+package node
+
+/*
+#include "node.h"
+*/
+import "C"
+import (
+    "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/event"
+    "etcdagent/agent/log"
+    "sort"
+
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+//本地状态与etcd中node注册的差异，nodeId均按从小到大排序
+type AuditReport struct {
+    Revision  int64    //检查时etcd的revision
+    Missing   []uint32 //本agent注册但etcd中不存在或者已被其他lease注册的node
+    Forgotten []uint32 //使用本agent的lease注册、但本地没有记录的node
+    Malformed []string //无法解析nodeId的key
+    Orphaned  []uint32 //没有lease或者lease已不存在的node，不会随租约过期删除
+    Repaired  int      //repair模式下已修复的个数
+}
+
+func (r AuditReport) Consistent() bool {
+    return len(r.Missing) == 0 && len(r.Forgotten) == 0 && len(r.Malformed) == 0 && len(r.Orphaned) == 0
+}
+
+//比较本地注册的node与etcd中 /CoreNet/Node/ 下的key，repair为true时修复差异：
+//1）Missing：与定期协调相同，lease有效时恢复注册，否则删除本地状态
+//2）Forgotten、Malformed、Orphaned：删除key，key在检查后被修改时不处理
+func (n *node) NodeAudit(ctx context.Context, repair bool) (AuditReport, error) {
+    ctx, cancel := n.withTimeout(ctx)
+    defer cancel()
+
+    report, guards, err := n.audit(ctx)
+    if err != nil || !repair {
+        return report, err
+    }
+
+    //先删除残留的key，本地node才能恢复注册
+    for key, cmp := range guards {
+        resp, err := n.client.Txn(ctx).If(cmp).Then(backend.OpDelete(key)).Commit()
+        if err != nil {
+            log.Warn("Repair %v error, reason: %v", key, err.Error())
+            return report, err
+        }
+        if resp.Succeeded {
+            log.Warn("Repair node registry, delete %v", key)
+            report.Repaired++
+        }
+    }
+
+    for _, nodeId := range report.Missing {
+        action, err := n.NodeReconcile(ctx, nodeId, 0)
+        if err != nil {
+            return report, err
+        }
+        if action != event.RECONCILE_NONE {
+            report.Repaired++
+        }
+    }
+    return report, nil
+}
+
+//返回检查结果，以及修复时删除每个key的前提条件
+func (n *node) audit(ctx context.Context) (AuditReport, map[string]clientv3.Cmp, error) {
+    var report AuditReport
+    resp, err := n.client.Get(ctx, NODE_PREFIX, backend.WithPrefix())
+    if err != nil {
+        log.Warn("Get %v with prefix error, reason: %v", NODE_PREFIX, err.Error())
+        return report, nil, err
+    }
+    report.Revision = resp.Header.Revision
+
+    n.Lock()
+    local := make(map[uint32]clientv3.LeaseID, len(n.leases))
+    owned := make(map[clientv3.LeaseID]bool)
+    for nodeId, lease := range n.leases {
+        local[nodeId] = lease
+        owned[lease] = true
+    }
+    for _, lease := range n.ids {
+        owned[lease] = true
+    }
+    n.Unlock()
+
+    guards := make(map[string]clientv3.Cmp)
+    remote := make(map[uint32]bool, len(resp.Kvs))
+    for _, kv := range resp.Kvs {
+        key := string(kv.Key)
+        nodeId, ok := parseId(key, NODE_PREFIX)
+        if !ok {
+            report.Malformed = append(report.Malformed, key)
+            guards[key] = clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)
+            continue
+        }
+
+        lease := clientv3.LeaseID(kv.Lease)
+        if l, ok := local[nodeId]; ok && l == lease {
+            remote[nodeId] = true
+            continue
+        }
+
+        if owned[lease] {
+            report.Forgotten = append(report.Forgotten, nodeId)
+            guards[key] = clientv3.Compare(clientv3.LeaseValue(key), "=", lease)
+            continue
+        }
+
+        orphaned := lease == clientv3.NoLease
+        if !orphaned {
+            ttlResp, err := n.client.TimeToLive(ctx, lease)
+            if err != nil {
+                log.Warn("Get lease: %v ttl error, nodeId: %v, reason: %v", lease, nodeId, err.Error())
+                return report, nil, err
+            }
+            orphaned = ttlResp.TTL == -1
+        }
+        if orphaned {
+            report.Orphaned = append(report.Orphaned, nodeId)
+            guards[key] = clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)
+        }
+    }
+
+    for nodeId := range local {
+        if !remote[nodeId] {
+            report.Missing = append(report.Missing, nodeId)
+        }
+    }
+
+    for _, ids := range [][]uint32{report.Missing, report.Forgotten, report.Orphaned} {
+        sort.Slice(ids, func(i, j int) bool {
+            return ids[i] < ids[j]
+        })
+    }
+    if !report.Consistent() {
+        log.Warn("Node registry inconsistent, missing: %v, forgotten: %v, malformed: %v, orphaned: %v",
+            report.Missing, report.Forgotten, report.Malformed, report.Orphaned)
+    }
+    return report, guards, nil
+}
+
+func (n *node) CNodeAudit(ctx context.Context, repair bool) (*C.struct_NodeAudit, error) {
+    report, err := n.NodeAudit(ctx, repair)
+    if err != nil {
+        return nil, err
+    }
+
+    var p *C.struct_NodeAudit
+    if p, err = C.CNodeAudit(); err != nil {
+        return nil, err
+    }
+
+    for _, nodeId := range report.Missing {
+        C.AddNode(&p.missing, C.uint32_t(nodeId))
+    }
+    for _, nodeId := range report.Forgotten {
+        C.AddNode(&p.forgotten, C.uint32_t(nodeId))
+    }
+    for _, nodeId := range report.Orphaned {
+        C.AddNode(&p.orphaned, C.uint32_t(nodeId))
+    }
+    p.malformed = C.uint32_t(len(report.Malformed))
+    p.repaired = C.uint32_t(report.Repaired)
+    return p, nil
+}
@@ -0,0 +1,187 @@
+package node
+
+import (
+    "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/log"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+const (
+    NODE_ID_PREFIX        = "/CoreNet/NodeId/"
+    NODE_ID_DEFAULT_GRACE = 60
+    NODE_ID_MIN           = 1
+    NODE_ID_MAX           = 0xfffffffe
+    NODE_ID_RETRY         = 8
+)
+
+//分配记录为 /CoreNet/NodeId/<nodeId>，value为主机标识，绑定一个TTL为宽限期的lease：
+//node在线期间随NodeKeepalive续约，释放或进程退出后超过宽限期才回收
+func (n *node) NodeAllocateId(ctx context.Context, identity string) (uint32, error) {
+    n.Lock()
+    defer n.Unlock()
+
+    ctx, cancel := n.withTimeout(ctx)
+    defer cancel()
+
+    var err error
+    for i := 0; i < NODE_ID_RETRY; i++ {
+        var nodeId uint32
+        var lease clientv3.LeaseID
+        var done bool
+        if nodeId, lease, done, err = n.allocate(ctx, identity); err != nil {
+            log.Warn("Node allocate id error, identity: %v, reason: %v", identity, err.Error())
+            return 0, err
+        }
+
+        if done {
+            n.ids[nodeId] = lease
+            log.Info("Node allocate id, identity: %v, nodeId: %v", identity, nodeId)
+            return nodeId, nil
+        }
+    }
+
+    return 0, fmt.Errorf("Node allocate id error, too many conflicts, identity: %v", identity)
+}
+
+func (n *node) allocate(ctx context.Context, identity string) (uint32, clientv3.LeaseID, bool, error) {
+    var err error
+    var resp *clientv3.TxnResponse
+    if resp, err = n.client.Txn(ctx).
+        Then(backend.OpGet(NODE_ID_PREFIX, backend.WithPrefix()), backend.OpGet(NODE_PREFIX, backend.WithPrefix())).
+        Commit(); err != nil {
+        return 0, 0, false, err
+    }
+
+    //同一主机重启后沿用原来的nodeId
+    used := make(map[uint32]bool)
+    for _, kv := range resp.Responses[0].GetResponseRange().Kvs {
+        nodeId, ok := parseId(string(kv.Key), NODE_ID_PREFIX)
+        if !ok {
+            continue
+        }
+
+        if identity != "" && string(kv.Value) == identity {
+            lease := clientv3.LeaseID(kv.Lease)
+            _, err = n.client.KeepAliveOnce(ctx, lease)
+            if err == rpctypes.ErrLeaseNotFound {
+                return n.rebind(ctx, nodeId, identity, kv.ModRevision)
+            }
+            if err != nil {
+                return 0, 0, false, err
+            }
+            return nodeId, lease, true, nil
+        }
+        used[nodeId] = true
+    }
+
+    //静态配置的nodeId同样视为已占用
+    for _, kv := range resp.Responses[1].GetResponseRange().Kvs {
+        if nodeId, ok := parseId(string(kv.Key), NODE_PREFIX); ok {
+            used[nodeId] = true
+        }
+    }
+
+    nodeId := uint32(NODE_ID_MIN)
+    for used[nodeId] {
+        if nodeId == NODE_ID_MAX {
+            return 0, 0, false, fmt.Errorf("No free node id")
+        }
+        nodeId++
+    }
+
+    var grantResp *clientv3.LeaseGrantResponse
+    if grantResp, err = n.client.Grant(ctx, n.grace); err != nil {
+        return 0, 0, false, err
+    }
+
+    key := fmt.Sprintf("%s%v", NODE_ID_PREFIX, nodeId)
+    if resp, err = n.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0),
+            clientv3.Compare(clientv3.CreateRevision(fmt.Sprintf("%s%v", NODE_PREFIX, nodeId)), "=", 0)).
+        Then(backend.OpPut(key, identity, backend.WithLease(grantResp.ID))).
+        Commit(); err != nil {
+        n.client.Revoke(ctx, grantResp.ID)
+        return 0, 0, false, err
+    }
+
+    //被其他进程抢先分配，重试
+    if !resp.Succeeded {
+        n.client.Revoke(ctx, grantResp.ID)
+        return 0, 0, false, nil
+    }
+    return nodeId, grantResp.ID, true, nil
+}
+
+//lease已失效但分配记录尚未删除时，使用新的lease重写，记录已变化时重试
+func (n *node) rebind(ctx context.Context, nodeId uint32, identity string, modRevision int64) (uint32, clientv3.LeaseID, bool, error) {
+    grantResp, err := n.client.Grant(ctx, n.grace)
+    if err != nil {
+        return 0, 0, false, err
+    }
+
+    key := fmt.Sprintf("%s%v", NODE_ID_PREFIX, nodeId)
+    resp, err := n.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)).
+        Then(backend.OpPut(key, identity, backend.WithLease(grantResp.ID))).
+        Commit()
+    if err != nil {
+        n.client.Revoke(ctx, grantResp.ID)
+        return 0, 0, false, err
+    }
+
+    if !resp.Succeeded {
+        n.client.Revoke(ctx, grantResp.ID)
+        return 0, 0, false, nil
+    }
+
+    log.Warn("Node id lease expired, rebind nodeId: %v to lease: %v, identity: %v", nodeId, grantResp.ID, identity)
+    return nodeId, grantResp.ID, true, nil
+}
+
+func parseId(key string, prefix string) (uint32, bool) {
+    if !strings.HasPrefix(key, prefix) {
+        return 0, false
+    }
+
+    inodeId, err := strconv.ParseUint(strings.TrimPrefix(key, prefix), 10, 32)
+    if err != nil {
+        return 0, false
+    }
+    return uint32(inodeId), true
+}
+
+//停止续约，宽限期过后nodeId被回收
+func (n *node) NodeReleaseId(nodeId uint32) error {
+    n.Lock()
+    defer n.Unlock()
+
+    if _, ok := n.ids[nodeId]; !ok {
+        return fmt.Errorf("Node release id error, node id is not allocated by this agent: %v", nodeId)
+    }
+
+    delete(n.ids, nodeId)
+    log.Info("Node release id, nodeId: %v, grace: %v", nodeId, n.grace)
+    return nil
+}
+
+func (n *node) NodeSetIdGrace(grace int64) {
+    n.Lock()
+    defer n.Unlock()
+
+    n.grace = grace
+    log.Info("Set node id grace = %v", grace)
+}
+
+func (n *node) renewId(ctx context.Context, nodeId uint32) {
+    if lease, ok := n.ids[nodeId]; ok {
+        if _, err := n.client.KeepAliveOnce(ctx, lease); err != nil {
+            log.Warn("Node id keepalive error, lease: %v, nodeId: %v, reason: %v\n", lease, nodeId, err.Error())
+        }
+    }
+}
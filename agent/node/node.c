@@ -31,6 +31,18 @@ struct Nodes *CNodes()
     return NULL;
 }
 
+struct NodeAudit *CNodeAudit()
+{
+    size_t size = sizeof(struct NodeAudit);
+    struct NodeAudit *p = malloc(size);
+    if (p != NULL)
+    {
+        memset(p, 0, size);
+        return p;
+    }
+    return NULL;
+}
+
 void AddNode(struct Nodes *p, uint32_t node)
 {
     if (p == NULL)
@@ -39,6 +51,12 @@ void AddNode(struct Nodes *p, uint32_t node)
         return;
     }
 
+    if (p->length >= sizeof(p->nodes) / sizeof(p->nodes[0]))
+    {
+        errno = ENOSPC;
+        return;
+    }
+
     p->nodes[p->length] = node;
     p->length++;
 }
@@ -6,46 +6,85 @@ package node
 import "C"
 import (
     "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/event"
     "etcdagent/agent/log"
     "fmt"
-    "reflect"
-    "regexp"
-    "strconv"
-    "strings"
+    "sort"
     "sync"
     "unsafe"
     "time"
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
     "github.com/etcd-io/etcd/clientv3"
 )
 
 const (
-    NODE_PREFIX      = "/CoreNet/Node/"
-    NODE_DEFAULT_TTL = 1
+    NODE_PREFIX          = "/CoreNet/Node/"
+    NODE_DEFAULT_TTL     = 1
+    NODE_DEFAULT_TIMEOUT = 2 * time.Second
 )
 
 type Node interface {
-    NodeOnline(nodeId uint32, serviceAddr string) error
-    NodeOffline(nodeId uint32) error
-    NodeKeepalive(nodeId uint32) error
-    GetAllNodes() ([]uint32, error)
-    GetNodeServiceAddr(nodeId uint32) (string, error)
+    NodeOnline(ctx context.Context, nodeId uint32, serviceAddr string) error
+    NodeOffline(ctx context.Context, nodeId uint32) error
+    NodeKeepalive(ctx context.Context, nodeId uint32) error
+    GetAllNodes(ctx context.Context) ([]uint32, error)
+    GetNodeServiceAddr(ctx context.Context, nodeId uint32) (string, error)
     NodeSetTTL(ttl int64)
-    CGetNodeServiceAddr(nodeId uint32) (*C.struct_ServiceAddr, error)
-    CGetAllNodes() (*C.struct_Nodes, error)
+    NodeSetTimeout(timeout time.Duration)
+    NodeAllocateId(ctx context.Context, identity string) (uint32, error)
+    NodeReleaseId(nodeId uint32) error
+    NodeSetIdGrace(grace int64)
+    WaitForNodes(ctx context.Context, ids []uint32, timeout time.Duration) error
+    WaitForNodeCount(ctx context.Context, count int, timeout time.Duration) error
+    ListNodes(ctx context.Context) ([]NodeInfo, error)
+    NodeForceOffline(ctx context.Context, nodeId uint32) error
+    NodeReconcile(ctx context.Context, nodeId uint32, revision int64) (string, error)
+    NodeAudit(ctx context.Context, repair bool) (AuditReport, error)
+    LocalNodes() []uint32
+    WatchNodes(ctx context.Context) (<-chan NodeEvent, error)
+    CGetNodeServiceAddr(ctx context.Context, nodeId uint32) (*C.struct_ServiceAddr, error)
+    CGetAllNodes(ctx context.Context) (*C.struct_Nodes, error)
+    CNodeAudit(ctx context.Context, repair bool) (*C.struct_NodeAudit, error)
+}
+
+//nodeId已被其他进程注册
+type ConflictError struct {
+    NodeId      uint32
+    ServiceAddr string
+    Lease       clientv3.LeaseID
+}
+
+func (e *ConflictError) Error() string {
+    return fmt.Sprintf("Node online conflict, nodeId: %v is owned by service: %v, lease: %v",
+        e.NodeId, e.ServiceAddr, e.Lease)
 }
 
 type node struct {
     sync.Mutex
-    client *clientv3.Client
-    leases map[uint32]clientv3.LeaseID
-    ttl    int64
+    client  backend.Backend
+    leases  map[uint32]clientv3.LeaseID
+    regs    map[uint32]registration     //本agent注册的服务地址和revision，用于恢复被误删的注册
+    ids     map[uint32]clientv3.LeaseID //本agent分配的nodeId
+    ttl     int64
+    grace   int64
+    timeout time.Duration //ctx没有deadline时每次请求的超时
 }
 
 func NewNode(client *clientv3.Client) Node {
+    return NewNodeFromBackend(backend.NewEtcd(client))
+}
+
+//使用其他存储，如单元测试中的backend.NewMemory
+func NewNodeFromBackend(b backend.Backend) Node {
     return &node{
-        client: client,
-        leases: make(map[uint32]clientv3.LeaseID),
-        ttl:    NODE_DEFAULT_TTL,
+        client:  b,
+        leases:  make(map[uint32]clientv3.LeaseID),
+        regs:    make(map[uint32]registration),
+        ids:     make(map[uint32]clientv3.LeaseID),
+        ttl:     NODE_DEFAULT_TTL,
+        grace:   NODE_ID_DEFAULT_GRACE,
+        timeout: NODE_DEFAULT_TIMEOUT,
     }
 }
 
@@ -54,13 +93,23 @@ func (n *node) NodeSetTTL(ttl int64) {
     log.Info("Set ttl = %v", ttl)
 }
 
-func (n *node) NodeOnline(nodeId uint32, serviceAddr string) error {
+//为0时只使用调用者ctx的deadline
+func (n *node) NodeSetTimeout(timeout time.Duration) {
+    n.timeout = timeout
+    log.Info("Set node timeout = %v", timeout)
+}
+
+func (n *node) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+    return backend.WithTimeout(ctx, n.timeout)
+}
+
+func (n *node) NodeOnline(ctx context.Context, nodeId uint32, serviceAddr string) error {
     n.Lock()
     defer n.Unlock()
     log.Warn("receive nodeonline request, nodeid=%v, serivce=%v\n", nodeId, serviceAddr)
     var err error
     var resp *clientv3.LeaseGrantResponse
-    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    ctx, cancel := n.withTimeout(ctx)
     defer cancel()
     if resp, err = n.client.Grant(ctx, n.ttl); err != nil {
         log.Warn("Lease grant error, nodeId: %v, reason: %v\n", nodeId, err.Error())
@@ -69,46 +118,108 @@ func (n *node) NodeOnline(nodeId uint32, serviceAddr string) error {
 
     lease := resp.ID
     key := fmt.Sprintf("%s%v", NODE_PREFIX, nodeId)
-    if _, err = n.client.Put(context.TODO(), key, serviceAddr, clientv3.WithLease(lease)); err != nil {
-        log.Warn("Put %v with lease: %v error, nodeId: %v, reason: %v\n", key, lease, nodeId, err.Error())
+    var revision int64
+    if revision, err = n.register(ctx, nodeId, key, serviceAddr, lease); err != nil {
+        //注册失败时回收新申请的lease，避免残留
+        if _, rerr := n.client.Revoke(ctx, lease); rerr != nil {
+            log.Warn("Revoke lease: %v error, nodeId: %v, reason: %v\n", lease, nodeId, rerr.Error())
+        }
         return err
     }
 
+    //重复上线时旧lease已不再使用
+    if old, ok := n.leases[nodeId]; ok && old != lease {
+        if _, err = n.client.Revoke(ctx, old); err != nil {
+            log.Warn("Revoke old lease: %v error, nodeId: %v, reason: %v\n", old, nodeId, err.Error())
+        }
+    }
+
     n.leases[nodeId] = lease
+    n.regs[nodeId] = registration{serviceAddr: serviceAddr, revision: revision}
+    n.renewId(ctx, nodeId)
     return nil
 }
 
-func (n *node) NodeKeepalive(nodeId uint32) error {
+//只有key不存在或者key属于本node已有的lease时才写入，返回写入的revision
+func (n *node) register(ctx context.Context, nodeId uint32, key string, serviceAddr string, lease clientv3.LeaseID) (int64, error) {
+    var err error
+    var txnResp *clientv3.TxnResponse
+    if txnResp, err = n.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+        Then(backend.OpPut(key, serviceAddr, backend.WithLease(lease))).
+        Else(backend.OpGet(key)).
+        Commit(); err != nil {
+        log.Warn("Put %v with lease: %v error, nodeId: %v, reason: %v\n", key, lease, nodeId, err.Error())
+        return 0, err
+    }
+
+    if txnResp.Succeeded {
+        return txnResp.Header.Revision, nil
+    }
+
+    if old, ok := n.leases[nodeId]; ok {
+        if txnResp, err = n.client.Txn(ctx).
+            If(clientv3.Compare(clientv3.LeaseValue(key), "=", old)).
+            Then(backend.OpPut(key, serviceAddr, backend.WithLease(lease))).
+            Else(backend.OpGet(key)).
+            Commit(); err != nil {
+            log.Warn("Put %v with lease: %v error, nodeId: %v, reason: %v\n", key, lease, nodeId, err.Error())
+            return 0, err
+        }
+
+        if txnResp.Succeeded {
+            return txnResp.Header.Revision, nil
+        }
+    }
+
+    conflict := &ConflictError{NodeId: nodeId}
+    if kvs := txnResp.Responses[0].GetResponseRange().Kvs; len(kvs) != 0 {
+        conflict.ServiceAddr = string(kvs[0].Value)
+        conflict.Lease = clientv3.LeaseID(kvs[0].Lease)
+    }
+    log.Warn("Node online conflict, nodeId: %v, owner service: %v, owner lease: %v\n",
+        nodeId, conflict.ServiceAddr, conflict.Lease)
+    return 0, conflict
+}
+
+func (n *node) NodeKeepalive(ctx context.Context, nodeId uint32) error {
     n.Lock()
     defer n.Unlock()
 
-    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    ctx, cancel := n.withTimeout(ctx)
     defer cancel()
     if lease, ok := n.leases[nodeId]; ok {
         if _, err := n.client.KeepAliveOnce(ctx, lease); err != nil {
             log.Warn("Node keepalive error, lease: %v, nodeId: %v, reason: %v\n", lease, nodeId, err.Error())
             return err
         }
+        n.renewId(ctx, nodeId)
         return nil
     }
 
     return fmt.Errorf("Node keepalive error, cannot find lease for the node: %v", nodeId)
 }
 
-func (n *node) NodeOffline(nodeId uint32) error {
+func (n *node) NodeOffline(ctx context.Context, nodeId uint32) error {
     n.Lock()
     defer n.Unlock()
 
-    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    ctx, cancel := n.withTimeout(ctx)
     defer cancel()
-    if _, ok := n.leases[nodeId]; ok {
+    if lease, ok := n.leases[nodeId]; ok {
+        //同一事务中写入下线标记，订阅者据此区分主动下线和租约过期
+        //只删除本node的lease注册的key，租约已过期或已被其他进程注册时不删除
         key := fmt.Sprintf("%s%v", NODE_PREFIX, nodeId)
-        if _, err := n.client.Delete(ctx, key); err != nil {
+        if _, err := n.client.Txn(ctx).
+            If(clientv3.Compare(clientv3.LeaseValue(key), "=", lease)).
+            Then(backend.OpPut(event.OfflineKey(key), "", backend.WithLease(lease)), backend.OpDelete(key)).
+            Commit(); err != nil && err != rpctypes.ErrLeaseNotFound {
             log.Warn("Node offline error, nodeId: %v, reason: %v\n", nodeId, err.Error())
             return err
         }
 
         delete(n.leases, nodeId)
+        delete(n.regs, nodeId)
         log.Warn("Node offline, nodeId = %v", nodeId)
         return nil
     }
@@ -116,45 +227,44 @@ func (n *node) NodeOffline(nodeId uint32) error {
     return fmt.Errorf("Node offline error, cannot find lease for the node: %v", nodeId)
 }
 
-func (n *node) GetAllNodes() ([]uint32, error) {
+func (n *node) GetAllNodes(ctx context.Context) ([]uint32, error) {
     var err error
     var resp *clientv3.GetResponse
 
-    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    ctx, cancel := n.withTimeout(ctx)
     defer cancel()
-    if resp, err = n.client.Get(ctx, NODE_PREFIX, clientv3.WithPrefix()); err != nil {
+    if resp, err = n.client.Get(ctx, NODE_PREFIX, backend.WithPrefix()); err != nil {
         log.Warn("Get %v with prefix error, reason: %v", NODE_PREFIX, err.Error())
         return nil, err
     }
 
-    remote := make([]uint32, 0)
-    for _, v := range resp.Kvs {
-        var nodeId uint32
-        pattern := fmt.Sprintf("%s%s", NODE_PREFIX, "[0-9]+") //至少一位数字的nodeId
-        re := regexp.MustCompile(pattern)
-        key := string(v.Key)
-        if re.MatchString(key) {
-            tmp := strings.Split(key, "/")
-            inodeId, _ := strconv.Atoi(tmp[len(tmp)-1])
-            nodeId = uint32(inodeId)
+    remote := make([]uint32, 0, len(resp.Kvs))
+    exists := make(map[uint32]bool, len(resp.Kvs))
+    for _, kv := range resp.Kvs {
+        nodeId, ok := parseId(string(kv.Key), NODE_PREFIX)
+        if !ok {
+            log.Warn("Skip malformed node key: %v", string(kv.Key))
+            continue
         }
 
         remote = append(remote, nodeId)
+        exists[nodeId] = true
     }
-
-    local := make([]uint32, 0)
-    for node := range n.leases {
-        local = append(local, node)
-    }
-
-    if !reflect.DeepEqual(remote, local) {
-        log.Warn("Data inconsistent, local nodes:%v, remote nodes:%v", local, remote)
+    sort.Slice(remote, func(i, j int) bool {
+        return remote[i] < remote[j]
+    })
+
+    //只提示本地注册但etcd中不存在的node，完整检查和修复使用NodeAudit
+    for _, nodeId := range n.LocalNodes() {
+        if !exists[nodeId] {
+            log.Warn("Data inconsistent, local node: %v is missing in etcd", nodeId)
+        }
     }
 
     return remote, nil
 }
 
-func (n *node) GetNodeServiceAddr(nodeId uint32) (string, error) {
+func (n *node) GetNodeServiceAddr(ctx context.Context, nodeId uint32) (string, error) {
     // n.Lock()
     // defer n.Unlock()
 
@@ -163,7 +273,7 @@ func (n *node) GetNodeServiceAddr(nodeId uint32) (string, error) {
 
     // if _, ok := n.leases[nodeId]; ok {
     // }
-    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    ctx, cancel := n.withTimeout(ctx)
     defer cancel()
     key := fmt.Sprintf("%s%v", NODE_PREFIX, nodeId)
     if resp, err = n.client.Get(ctx, key); err != nil {
@@ -182,10 +292,10 @@ func (n *node) GetNodeServiceAddr(nodeId uint32) (string, error) {
     //return "", fmt.Errorf("Get node service error, node doesn't exist in local: %v", nodeId)
 }
 
-func (n *node) CGetAllNodes() (*C.struct_Nodes, error) {
+func (n *node) CGetAllNodes(ctx context.Context) (*C.struct_Nodes, error) {
     var err error
     var nodes []uint32
-    if nodes, err = n.GetAllNodes(); err != nil {
+    if nodes, err = n.GetAllNodes(ctx); err != nil {
         return nil, err
     }
 
@@ -201,10 +311,10 @@ func (n *node) CGetAllNodes() (*C.struct_Nodes, error) {
     return p, nil
 }
 
-func (n *node) CGetNodeServiceAddr(nodeId uint32) (*C.struct_ServiceAddr, error) {
+func (n *node) CGetNodeServiceAddr(ctx context.Context, nodeId uint32) (*C.struct_ServiceAddr, error) {
     var err error
     var addr string
-    if addr, err = n.GetNodeServiceAddr(nodeId); err != nil {
+    if addr, err = n.GetNodeServiceAddr(ctx, nodeId); err != nil {
         return nil, err
     }
 
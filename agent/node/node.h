@@ -13,6 +13,16 @@ struct Nodes
     uint32_t length;
 };
 
+struct NodeAudit
+{
+    struct Nodes missing;
+    struct Nodes forgotten;
+    struct Nodes orphaned;
+    uint32_t malformed;
+    uint32_t repaired;
+};
+
 struct ServiceAddr *CServiceAddr(char *addr, uint8_t len);
 struct Nodes *CNodes();
+struct NodeAudit *CNodeAudit();
 void AddNode(struct Nodes* p, uint32_t node);
\ No newline at end of file
@@ -2,6 +2,8 @@ package node
 
 import (
     "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/event"
     "fmt"
     "os"
     "testing"
@@ -39,12 +41,12 @@ func TestNodeOnline(t *testing.T) {
     }
 
     for _, info := range data {
-        if err := node.NodeOnline(info.nodeId, info.serviceAddr); err != nil {
+        if err := node.NodeOnline(context.TODO(), info.nodeId, info.serviceAddr); err != nil {
             t.Errorf("Node online error, nodeId: %v, serviceAddr: %v, reason: %v",
                 info.nodeId, info.serviceAddr, err.Error())
         }
 
-        if acctually, err := node.GetNodeServiceAddr(info.nodeId); err != nil {
+        if acctually, err := node.GetNodeServiceAddr(context.TODO(), info.nodeId); err != nil {
             t.Errorf("Get node error, nodeId: %v, reason: %v", info.nodeId, err.Error())
         } else {
             if acctually != info.expected {
@@ -53,6 +55,11 @@ func TestNodeOnline(t *testing.T) {
         }
     }
     <-time.After(2 * time.Second)
+
+    //重复注册会冲突，测试结束时主动下线
+    for _, info := range data {
+        node.NodeOffline(context.TODO(), info.nodeId)
+    }
     client.Close()
 }
 
@@ -83,7 +90,7 @@ func TestNodeKeepalive(t *testing.T) {
     }
 
     for _, info := range data {
-        if err := node.NodeOnline(info.nodeId, info.serviceAddr); err != nil {
+        if err := node.NodeOnline(context.TODO(), info.nodeId, info.serviceAddr); err != nil {
             t.Errorf("Node online error, reason: %v", err.Error())
         }
 
@@ -93,7 +100,7 @@ func TestNodeKeepalive(t *testing.T) {
                 case <-ctx.Done():
                     return
                 case <-time.After(500 * time.Millisecond):
-                    if err := node.NodeKeepalive(nodeId); err != nil {
+                    if err := node.NodeKeepalive(context.TODO(), nodeId); err != nil {
                         t.Errorf("Node keepalive error, nodeId: %v, reason：%v",
                             nodeId, err.Error())
                     }
@@ -107,7 +114,7 @@ func TestNodeKeepalive(t *testing.T) {
 
     for _, info := range data {
         //测试获取单个node服务
-        if acctually, err := node.GetNodeServiceAddr(info.nodeId); err != nil {
+        if acctually, err := node.GetNodeServiceAddr(context.TODO(), info.nodeId); err != nil {
             t.Errorf("Get node error, reason:%v", err.Error())
         } else {
             if acctually != info.expected {
@@ -119,7 +126,7 @@ func TestNodeKeepalive(t *testing.T) {
 
     //测试获取所有nodes
     expected := []uint32{1, 2, 3, 4}
-    if acctually, err := node.GetAllNodes(); err != nil {
+    if acctually, err := node.GetAllNodes(context.TODO()); err != nil {
         t.Errorf("Get all nodes error, reason: %v", err.Error())
     } else {
         if len(expected) != len(acctually) {
@@ -178,7 +185,7 @@ func TestNodeOffline(t *testing.T) {
     node := NewNode(client)
     for _, info := range data {
         node.NodeSetTTL(10)
-        if err := node.NodeOnline(info.nodeId, info.serviceAddr); err != nil {
+        if err := node.NodeOnline(context.TODO(), info.nodeId, info.serviceAddr); err != nil {
             t.Errorf("Node online error, nodeId: %v, serviceAddr: %v, reason: %v",
                 info.nodeId, info.serviceAddr, err.Error())
         }
@@ -186,13 +193,13 @@ func TestNodeOffline(t *testing.T) {
 
     time.After(5 * time.Second)
     for _, info := range data {
-        if err := node.NodeOffline(info.nodeId); err != nil {
+        if err := node.NodeOffline(context.TODO(), info.nodeId); err != nil {
             t.Errorf("Node offline error, nodeId: %v, reason: %v", info.nodeId, err.Error())
         }
     }
 
     //测试获取所有nodes
-    if acctually, err := node.GetAllNodes(); err != nil {
+    if acctually, err := node.GetAllNodes(context.TODO()); err != nil {
         t.Errorf("Get all nodes error, reason:%v", err.Error())
     } else {
         if len(acctually) != 0 {
@@ -200,5 +207,494 @@ func TestNodeOffline(t *testing.T) {
         }
     }
 
+    //主动下线写入下线标记，随node的租约过期
+    for _, info := range data {
+        key := event.OfflineKey(fmt.Sprintf("%s%v", NODE_PREFIX, info.nodeId))
+        if resp, err := client.Get(context.TODO(), key); err != nil || len(resp.Kvs) != 1 || resp.Kvs[0].Lease == 0 {
+            t.Errorf("Node offline error, expected offline marker %v with lease, acctually = %v, err = %v", key, resp, err)
+        }
+    }
+
+    client.Close()
+}
+
+func TestNodeOnlineConflict(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    var nodeId uint32 = 10
+    owner := NewNode(client)
+    owner.NodeSetTTL(10)
+    if err := owner.NodeOnline(context.TODO(), nodeId, "192.168.0.10:50060"); err != nil {
+        t.Errorf("Node online error, nodeId: %v, reason: %v", nodeId, err.Error())
+    }
+
+    //同一个nodeId被其他进程注册时返回冲突
+    other := NewNode(client)
+    other.NodeSetTTL(10)
+    err = other.NodeOnline(context.TODO(), nodeId, "192.168.0.11:50061")
+    if conflict, ok := err.(*ConflictError); !ok {
+        t.Errorf("Test node online conflict failed, expected ConflictError, acctually = %v", err)
+    } else if conflict.ServiceAddr != "192.168.0.10:50060" {
+        t.Errorf("Test node online conflict failed, expected owner = 192.168.0.10:50060, acctually = %v",
+            conflict.ServiceAddr)
+    }
+
+    //owner重复上线不受影响
+    if err := owner.NodeOnline(context.TODO(), nodeId, "192.168.0.10:50062"); err != nil {
+        t.Errorf("Node online again error, nodeId: %v, reason: %v", nodeId, err.Error())
+    }
+
+    if acctually, err := owner.GetNodeServiceAddr(context.TODO(), nodeId); err != nil {
+        t.Errorf("Get node error, nodeId: %v, reason: %v", nodeId, err.Error())
+    } else if acctually != "192.168.0.10:50062" {
+        t.Errorf("Test node online conflict failed, expected = 192.168.0.10:50062, acctually = %v", acctually)
+    }
+
+    if err := owner.NodeOffline(context.TODO(), nodeId); err != nil {
+        t.Errorf("Node offline error, nodeId: %v, reason: %v", nodeId, err.Error())
+    }
+    client.Close()
+}
+
+func TestNodeAllocateId(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    node := NewNode(client)
+    node.NodeSetIdGrace(2)
+    first, err := node.NodeAllocateId(context.TODO(), "host-a")
+    if err != nil {
+        t.Errorf("Node allocate id error, reason: %v", err.Error())
+    }
+
+    second, err := node.NodeAllocateId(context.TODO(), "host-b")
+    if err != nil {
+        t.Errorf("Node allocate id error, reason: %v", err.Error())
+    }
+
+    if first == second {
+        t.Errorf("Test node allocate id failed, duplicate id: %v", first)
+    }
+
+    //同一主机重启后获得相同的nodeId
+    restarted := NewNode(client)
+    if acctually, err := restarted.NodeAllocateId(context.TODO(), "host-a"); err != nil {
+        t.Errorf("Node allocate id error, reason: %v", err.Error())
+    } else if acctually != first {
+        t.Errorf("Test node allocate id failed, expected = %v, acctually = %v", first, acctually)
+    }
+
+    //释放后超过宽限期被回收
+    for _, nodeId := range []uint32{first, second} {
+        if err := node.NodeReleaseId(nodeId); err != nil {
+            t.Errorf("Node release id error, nodeId: %v, reason: %v", nodeId, err.Error())
+        }
+    }
+    restarted.NodeReleaseId(first)
+
+    <-time.After(4 * time.Second)
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    if resp, err := client.Get(ctx, fmt.Sprintf("%s%v", NODE_ID_PREFIX, first)); err != nil {
+        t.Errorf("Get node id error, reason: %v", err.Error())
+    } else if len(resp.Kvs) != 0 {
+        t.Errorf("Test node release id failed, id: %v should be released after grace", first)
+    }
+    client.Close()
+}
+
+func TestWaitForNodes(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    node := NewNode(client)
+    node.NodeSetTTL(10)
+    ids := []uint32{21, 22}
+    go func() {
+        for _, nodeId := range ids {
+            <-time.After(300 * time.Millisecond)
+            if err := node.NodeOnline(context.TODO(), nodeId, "192.168.0.21:50071"); err != nil {
+                t.Errorf("Node online error, nodeId: %v, reason: %v", nodeId, err.Error())
+            }
+        }
+    }()
+
+    if err := node.WaitForNodes(context.TODO(), ids, 3*time.Second); err != nil {
+        t.Errorf("Wait for nodes error, reason: %v", err.Error())
+    }
+
+    if err := node.WaitForNodeCount(context.TODO(), len(ids), time.Second); err != nil {
+        t.Errorf("Wait for node count error, reason: %v", err.Error())
+    }
+
+    //未上线的node等待超时
+    if err := node.WaitForNodes(context.TODO(), []uint32{23}, 500*time.Millisecond); err != context.DeadlineExceeded {
+        t.Errorf("Test wait for nodes failed, expected = %v, acctually = %v", context.DeadlineExceeded, err)
+    }
+
+    //timeout为0时使用node.timeout
+    if err := node.WaitForNodes(context.TODO(), ids, 0); err != nil {
+        t.Errorf("Wait for online nodes with zero timeout error, reason: %v", err.Error())
+    }
+
+    node.NodeSetTimeout(300 * time.Millisecond)
+    start := time.Now()
+    if err := node.WaitForNodes(context.TODO(), []uint32{23}, 0); err != context.DeadlineExceeded || time.Since(start) < 200*time.Millisecond {
+        t.Errorf("Test wait for nodes with zero timeout failed, expected = %v after node.timeout, acctually = %v after %v", context.DeadlineExceeded, err, time.Since(start))
+    }
+
+    for _, nodeId := range ids {
+        node.NodeOffline(context.TODO(), nodeId)
+    }
+    client.Close()
+}
+
+func TestNodeForceOffline(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    owner := NewNode(client)
+    owner.NodeSetTTL(10)
+    for _, nodeId := range []uint32{21, 3} {
+        if err := owner.NodeOnline(context.TODO(), nodeId, fmt.Sprintf("192.168.0.%v:50060", nodeId)); err != nil {
+            t.Errorf("Node online error, nodeId: %v, reason: %v", nodeId, err.Error())
+        }
+    }
+
+    //按nodeId排序，带有lease剩余时间
+    admin := NewNode(client)
+    if nodes, err := admin.ListNodes(context.TODO()); err != nil {
+        t.Errorf("List nodes error, reason: %v", err.Error())
+    } else if len(nodes) != 2 || nodes[0].NodeId != 3 || nodes[1].NodeId != 21 {
+        t.Errorf("Test list nodes failed, expected = [3 21], acctually = %v", nodes)
+    } else if nodes[0].ServiceAddr != "192.168.0.3:50060" || nodes[0].TTL <= 0 || nodes[0].TTL > 10 {
+        t.Errorf("Test list nodes failed, unexpected node info = %+v", nodes[0])
+    }
+
+    if err := admin.NodeForceOffline(context.TODO(), 21); err != nil {
+        t.Errorf("Node force offline error, nodeId: 21, reason: %v", err.Error())
+    }
+
+    if _, err := admin.GetNodeServiceAddr(context.TODO(), 21); err == nil {
+        t.Errorf("Test node force offline failed, node 21 should be offline")
+    }
+
+    //owner的lease已被回收，保活失败
+    if err := owner.NodeKeepalive(context.TODO(), 21); err == nil {
+        t.Errorf("Test node force offline failed, keepalive of node 21 should fail")
+    }
+
+    if err := admin.NodeForceOffline(context.TODO(), 21); err == nil {
+        t.Errorf("Test node force offline failed, node 21 is not online")
+    }
+
+    if err := owner.NodeOffline(context.TODO(), 3); err != nil {
+        t.Errorf("Node offline error, nodeId: 3, reason: %v", err.Error())
+    }
     client.Close()
 }
+
+func TestNodeReconcile(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    owner := NewNode(client)
+    owner.NodeSetTTL(10)
+    for _, nodeId := range []uint32{41, 42, 43} {
+        if err := owner.NodeOnline(context.TODO(), nodeId, fmt.Sprintf("192.168.0.%v:50070", nodeId)); err != nil {
+            t.Errorf("Node online error, nodeId: %v, reason: %v", nodeId, err.Error())
+        }
+    }
+
+    //lease仍然有效时恢复被误删的注册，过期的事件和其他agent的node不处理
+    resp, err := client.Delete(context.TODO(), "/CoreNet/Node/41")
+    if err != nil {
+        t.Fatalf("Delete node 41 error, reason: %v", err.Error())
+    }
+
+    data := []struct {
+        nodeId   uint32
+        revision int64
+        expected string
+    }{
+        {41, 1, event.RECONCILE_NONE},
+        {41, resp.Header.Revision, event.RECONCILE_RESTORED},
+        {41, resp.Header.Revision, event.RECONCILE_NONE},
+        {44, resp.Header.Revision, event.RECONCILE_NONE},
+    }
+    for _, d := range data {
+        if acctually, err := owner.NodeReconcile(context.TODO(), d.nodeId, d.revision); err != nil || acctually != d.expected {
+            t.Errorf("Test node reconcile %v at %v failed, expected = %q, acctually = %q, err = %v", d.nodeId, d.revision, d.expected, acctually, err)
+        }
+    }
+
+    if addr, err := owner.GetNodeServiceAddr(context.TODO(), 41); err != nil || addr != "192.168.0.41:50070" {
+        t.Errorf("Test node reconcile failed, node 41 should be restored, acctually = %v, err = %v", addr, err)
+    }
+
+    //lease被回收或者key被其他lease注册时删除本地状态
+    if err := NewNode(client).NodeForceOffline(context.TODO(), 42); err != nil {
+        t.Errorf("Node force offline error, nodeId: 42, reason: %v", err.Error())
+    }
+
+    other := NewNode(client)
+    other.NodeSetTTL(10)
+    client.Delete(context.TODO(), "/CoreNet/Node/43")
+    if err := other.NodeOnline(context.TODO(), 43, "192.168.1.43:50070"); err != nil {
+        t.Errorf("Node online error, nodeId: 43, reason: %v", err.Error())
+    }
+
+    for _, nodeId := range []uint32{42, 43} {
+        if acctually, err := owner.NodeReconcile(context.TODO(), nodeId, 0); err != nil || acctually != event.RECONCILE_DROPPED {
+            t.Errorf("Test node reconcile %v failed, expected = %q, acctually = %q, err = %v", nodeId, event.RECONCILE_DROPPED, acctually, err)
+        }
+    }
+
+    if acctually := owner.LocalNodes(); fmt.Sprint(acctually) != "[41]" {
+        t.Errorf("Test node reconcile failed, expected local nodes = [41], acctually = %v", acctually)
+    }
+
+    if addr, err := other.GetNodeServiceAddr(context.TODO(), 43); err != nil || addr != "192.168.1.43:50070" {
+        t.Errorf("Test node reconcile failed, node 43 should be kept by other, acctually = %v, err = %v", addr, err)
+    }
+
+    owner.NodeOffline(context.TODO(), 41)
+    other.NodeOffline(context.TODO(), 43)
+    client.Close()
+}
+
+func TestNodeAudit(t *testing.T) {
+    var client *clientv3.Client
+    conf := clientv3.Config{
+        Endpoints:   []string{ETCDADDR},
+        DialTimeout: 5 * time.Second,
+    }
+
+    var err error
+    if client, err = clientv3.New(conf); err != nil {
+        fmt.Println("New client failed")
+        os.Exit(1)
+    }
+
+    owner := NewNode(client)
+    owner.NodeSetTTL(10)
+    for _, nodeId := range []uint32{51, 52} {
+        if err := owner.NodeOnline(context.TODO(), nodeId, fmt.Sprintf("192.168.0.%v:50080", nodeId)); err != nil {
+            t.Errorf("Node online error, nodeId: %v, reason: %v", nodeId, err.Error())
+        }
+    }
+
+    //51被误删，53使用52的lease，54没有lease，以及无法解析的key
+    resp, err := client.Get(context.TODO(), "/CoreNet/Node/52")
+    if err != nil || len(resp.Kvs) != 1 {
+        t.Fatalf("Get node 52 error, resp = %v, err = %v", resp, err)
+    }
+    client.Delete(context.TODO(), "/CoreNet/Node/51")
+    client.Put(context.TODO(), "/CoreNet/Node/53", "192.168.0.53:50080", clientv3.WithLease(clientv3.LeaseID(resp.Kvs[0].Lease)))
+    client.Put(context.TODO(), "/CoreNet/Node/54", "192.168.0.54:50080")
+    client.Put(context.TODO(), "/CoreNet/Node/audit", "192.168.0.55:50080")
+
+    contains := func(s []uint32, v uint32) bool {
+        for _, m := range s {
+            if m == v {
+                return true
+            }
+        }
+        return false
+    }
+
+    report, err := owner.NodeAudit(context.TODO(), false)
+    if err != nil {
+        t.Fatalf("Node audit error, reason: %v", err.Error())
+    }
+    if fmt.Sprint(report.Missing) != "[51]" || fmt.Sprint(report.Forgotten) != "[53]" ||
+        !contains(report.Orphaned, 54) || fmt.Sprint(report.Malformed) != "[/CoreNet/Node/audit]" || report.Repaired != 0 {
+        t.Errorf("Test node audit failed, expected missing [51], forgotten [53], orphaned 54, malformed audit, acctually = %+v", report)
+    }
+
+    //GetAllNodes跳过无法解析的key，按nodeId排序
+    if acctually, err := owner.GetAllNodes(context.TODO()); err != nil || contains(acctually, 0) || contains(acctually, 51) {
+        t.Errorf("Test get all nodes failed, acctually = %v, err = %v", acctually, err)
+    }
+
+    if report, err = owner.NodeAudit(context.TODO(), true); err != nil || report.Repaired < 4 {
+        t.Errorf("Test node audit repair failed, acctually = %+v, err = %v", report, err)
+    }
+
+    if report, err = owner.NodeAudit(context.TODO(), false); err != nil || len(report.Missing) != 0 || len(report.Forgotten) != 0 ||
+        len(report.Malformed) != 0 || contains(report.Orphaned, 54) {
+        t.Errorf("Test node audit failed after repair, acctually = %+v, err = %v", report, err)
+    }
+
+    if addr, err := owner.GetNodeServiceAddr(context.TODO(), 51); err != nil || addr != "192.168.0.51:50080" {
+        t.Errorf("Test node audit failed, node 51 should be restored, acctually = %v, err = %v", addr, err)
+    }
+
+    owner.NodeOffline(context.TODO(), 51)
+    owner.NodeOffline(context.TODO(), 52)
+    client.Close()
+}
+
+//使用Memory模拟lease到期，不依赖etcd和真实时间
+func TestNodeMemory(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    node := NewNodeFromBackend(m)
+    node.NodeSetTTL(2)
+    if err := node.NodeOnline(context.TODO(), 1, "192.168.0.1:50051"); err != nil {
+        t.Fatalf("Node online error, reason: %v", err.Error())
+    }
+
+    data := []struct {
+        advance   time.Duration
+        keepalive bool
+        expected  string
+    }{
+        {time.Second, true, "[1]"},
+        {1500 * time.Millisecond, false, "[1]"},
+        {time.Second, false, "[]"},
+    }
+    for _, d := range data {
+        m.Advance(d.advance)
+        if d.keepalive {
+            if err := node.NodeKeepalive(context.TODO(), 1); err != nil {
+                t.Errorf("Node keepalive error, reason: %v", err.Error())
+            }
+        }
+
+        if acctually, err := node.GetAllNodes(context.TODO()); err != nil || fmt.Sprint(acctually) != d.expected {
+            t.Errorf("Test node memory failed, expected = %v, acctually = %v, err = %v", d.expected, acctually, err)
+        }
+    }
+
+    //lease过期后保活失败，协调时删除本地状态
+    if err := node.NodeKeepalive(context.TODO(), 1); err == nil {
+        t.Errorf("Test node memory failed, keepalive should fail after lease expired")
+    }
+    if acctually, err := node.NodeReconcile(context.TODO(), 1, 0); err != nil || acctually != event.RECONCILE_DROPPED {
+        t.Errorf("Test node memory failed, expected = %q, acctually = %q, err = %v", event.RECONCILE_DROPPED, acctually, err)
+    }
+}
+
+//key已被其他进程注册时，下线不删除其注册
+func TestNodeOfflineOwner(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    first := NewNodeFromBackend(m)
+    if err := first.NodeOnline(context.TODO(), 4, "192.168.0.4:50051"); err != nil {
+        t.Fatalf("Node online error, reason: %v", err.Error())
+    }
+
+    key := fmt.Sprintf("%s%v", NODE_PREFIX, 4)
+    m.Delete(context.TODO(), key)
+    second := NewNodeFromBackend(m)
+    if err := second.NodeOnline(context.TODO(), 4, "192.168.0.5:50051"); err != nil {
+        t.Fatalf("Node online error, reason: %v", err.Error())
+    }
+
+    if err := first.NodeOffline(context.TODO(), 4); err != nil {
+        t.Errorf("Node offline error, reason: %v", err.Error())
+    }
+    if acctually, err := second.GetNodeServiceAddr(context.TODO(), 4); err != nil || acctually != "192.168.0.5:50051" {
+        t.Errorf("Test node offline owner failed, expected = 192.168.0.5:50051, acctually = %v, err = %v", acctually, err)
+    }
+}
+
+//分配记录的lease已失效时沿用原nodeId并绑定新的lease
+func TestNodeAllocateIdRebind(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    key := fmt.Sprintf("%s%v", NODE_ID_PREFIX, 5)
+    m.Put(context.TODO(), key, "host-c")
+
+    node := NewNodeFromBackend(m)
+    if acctually, err := node.NodeAllocateId(context.TODO(), "host-c"); err != nil || acctually != 5 {
+        t.Errorf("Test node allocate id rebind failed, expected = 5, acctually = %v, err = %v", acctually, err)
+    }
+
+    resp, err := m.Get(context.TODO(), key)
+    if err != nil || len(resp.Kvs) != 1 || resp.Kvs[0].Lease == 0 || string(resp.Kvs[0].Value) != "host-c" {
+        t.Fatalf("Test node allocate id rebind failed, acctually = %v, err = %v", resp, err)
+    }
+    if _, err := m.KeepAliveOnce(context.TODO(), clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+        t.Errorf("Test node allocate id rebind failed, keepalive new lease error: %v", err)
+    }
+}
+
+//调用者ctx的deadline优先于默认超时
+func TestNodeContext(t *testing.T) {
+    m := backend.NewMemory()
+    defer m.Close()
+
+    node := NewNodeFromBackend(m)
+    ctx, cancel := context.WithTimeout(context.Background(), 0)
+    defer cancel()
+    if err := node.NodeOnline(ctx, 1, "192.168.0.1:50051"); err != context.DeadlineExceeded {
+        t.Errorf("Node online with expired ctx expected = %v, acctually = %v", context.DeadlineExceeded, err)
+    }
+    if acctually := node.LocalNodes(); len(acctually) != 0 {
+        t.Errorf("Node online with expired ctx should not register, acctually = %v", acctually)
+    }
+
+    //ctx先于timeout结束
+    start := time.Now()
+    ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    if err := node.WaitForNodes(ctx, []uint32{1}, 10*time.Second); err != context.DeadlineExceeded || time.Since(start) > time.Second {
+        t.Errorf("Wait for nodes expected = %v within ctx deadline, acctually = %v after %v", context.DeadlineExceeded, err, time.Since(start))
+    }
+
+    node.NodeSetTimeout(0)
+    if err := node.NodeOnline(context.TODO(), 1, "192.168.0.1:50051"); err != nil {
+        t.Errorf("Node online without timeout error, reason: %v", err.Error())
+    }
+}
@@ -0,0 +1,98 @@
+package node
+
+import (
+    "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/event"
+    "etcdagent/agent/log"
+    "fmt"
+    "sort"
+
+    "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+type registration struct {
+    serviceAddr string
+    revision    int64 //最近一次写入的revision，早于该revision的事件已过期
+}
+
+//从 /CoreNet/Node/<nodeId> 中解析nodeId
+func ParseKey(key string) (uint32, bool) {
+    return parseId(key, NODE_PREFIX)
+}
+
+//本agent注册的node，按nodeId排序
+func (n *node) LocalNodes() []uint32 {
+    n.Lock()
+    defer n.Unlock()
+
+    nodes := make([]uint32, 0, len(n.leases))
+    for nodeId := range n.leases {
+        nodes = append(nodes, nodeId)
+    }
+    sort.Slice(nodes, func(i, j int) bool {
+        return nodes[i] < nodes[j]
+    })
+    return nodes
+}
+
+//本agent注册的node在etcd中被删除或者覆盖时：
+//1）不是本agent注册的node，或者revision不晚于本地最近一次写入，不处理
+//2）lease仍然有效时使用原lease和服务地址恢复注册
+//3）lease已失效或者key已被其他lease注册时，删除本地状态
+//revision为0时直接比较etcd中的当前状态，用于定期检查
+func (n *node) NodeReconcile(ctx context.Context, nodeId uint32, revision int64) (string, error) {
+    n.Lock()
+    defer n.Unlock()
+
+    lease, ok := n.leases[nodeId]
+    reg := n.regs[nodeId]
+    if !ok || (revision != 0 && revision <= reg.revision) {
+        return event.RECONCILE_NONE, nil
+    }
+
+    ctx, cancel := n.withTimeout(ctx)
+    defer cancel()
+
+    key := fmt.Sprintf("%s%v", NODE_PREFIX, nodeId)
+    resp, err := n.client.Txn(ctx).
+        If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+        Then(backend.OpPut(key, reg.serviceAddr, backend.WithLease(lease))).
+        Else(backend.OpGet(key)).
+        Commit()
+    if err == rpctypes.ErrLeaseNotFound {
+        log.Warn("Node lease: %v expired, drop local node: %v", lease, nodeId)
+        n.drop(nodeId)
+        return event.RECONCILE_DROPPED, nil
+    }
+
+    if err != nil {
+        log.Warn("Reconcile node: %v error, reason: %v", nodeId, err.Error())
+        return event.RECONCILE_NONE, err
+    }
+
+    if resp.Succeeded {
+        n.regs[nodeId] = registration{serviceAddr: reg.serviceAddr, revision: resp.Header.Revision}
+        log.Warn("Node: %v was deleted while lease: %v is alive, restored", nodeId, lease)
+        return event.RECONCILE_RESTORED, nil
+    }
+
+    kv := resp.Responses[0].GetResponseRange().Kvs[0]
+    if clientv3.LeaseID(kv.Lease) == lease {
+        return event.RECONCILE_NONE, nil
+    }
+
+    //其他进程已注册该nodeId，本地lease不再使用
+    log.Warn("Node: %v is owned by service: %v, lease: %v, drop local node", nodeId, string(kv.Value), kv.Lease)
+    if _, err = n.client.Revoke(ctx, lease); err != nil && err != rpctypes.ErrLeaseNotFound {
+        log.Warn("Revoke lease: %v error, nodeId: %v, reason: %v", lease, nodeId, err.Error())
+    }
+    n.drop(nodeId)
+    return event.RECONCILE_DROPPED, nil
+}
+
+func (n *node) drop(nodeId uint32) {
+    delete(n.leases, nodeId)
+    delete(n.regs, nodeId)
+}
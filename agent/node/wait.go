@@ -0,0 +1,92 @@
+package node
+
+import (
+    "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/log"
+    "time"
+
+    "github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+//等待指定的node全部上线，超时或者ctx结束时返回ctx的错误，timeout为0时与其他接口一致，使用node.timeout
+func (n *node) WaitForNodes(ctx context.Context, ids []uint32, timeout time.Duration) error {
+    return n.waitNodes(ctx, timeout, func(online map[uint32]bool) bool {
+        for _, nodeId := range ids {
+            if !online[nodeId] {
+                return false
+            }
+        }
+        return true
+    })
+}
+
+//等待在线node数量达到count
+func (n *node) WaitForNodeCount(ctx context.Context, count int, timeout time.Duration) error {
+    return n.waitNodes(ctx, timeout, func(online map[uint32]bool) bool {
+        return len(online) >= count
+    })
+}
+
+//先Get当前在线的node，再从下一个revision开始Watch，每个事件后重新判断条件
+func (n *node) waitNodes(ctx context.Context, timeout time.Duration, done func(map[uint32]bool) bool) error {
+    var cancel context.CancelFunc
+    if timeout == 0 {
+        ctx, cancel = n.withTimeout(ctx)
+    } else {
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+    }
+    defer cancel()
+
+    resp, err := n.client.Get(ctx, NODE_PREFIX, backend.WithPrefix(), backend.WithKeysOnly())
+    if err != nil {
+        log.Warn("Get %v with prefix error, reason: %v", NODE_PREFIX, err.Error())
+        return err
+    }
+
+    online := make(map[uint32]bool)
+    for _, kv := range resp.Kvs {
+        if nodeId, ok := parseId(string(kv.Key), NODE_PREFIX); ok {
+            online[nodeId] = true
+        }
+    }
+
+    if done(online) {
+        return nil
+    }
+
+    wChan := n.client.Watch(ctx, NODE_PREFIX, backend.WithPrefix(), backend.WithRev(resp.Header.Revision+1))
+    for {
+        select {
+        case <-ctx.Done():
+            log.Warn("Wait for nodes timeout, online nodes: %v", online)
+            return ctx.Err()
+        case wResp, ok := <-wChan:
+            if !ok {
+                return ctx.Err()
+            }
+
+            if err := wResp.Err(); err != nil {
+                return err
+            }
+
+            //同一个响应中可能先满足后又不满足，逐个事件判断
+            for _, ev := range wResp.Events {
+                nodeId, ok := parseId(string(ev.Kv.Key), NODE_PREFIX)
+                if !ok {
+                    continue
+                }
+
+                if ev.Type == mvccpb.DELETE {
+                    delete(online, nodeId)
+                } else {
+                    online[nodeId] = true
+                }
+
+                if done(online) {
+                    return nil
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,72 @@
+package node
+
+import (
+    "context"
+    "etcdagent/agent/backend"
+    "etcdagent/agent/log"
+
+    "github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+const (
+    NODE_EVENT_ONLINE  = 0
+    NODE_EVENT_OFFLINE = 1
+)
+
+type NodeEvent struct {
+    Type        int
+    NodeId      uint32
+    ServiceAddr string
+    Revision    int64
+}
+
+//先推送当前在线的node，再从下一个revision开始推送上下线变化
+//ctx取消或者Watch出错时关闭channel
+func (n *node) WatchNodes(ctx context.Context) (<-chan NodeEvent, error) {
+    resp, err := n.client.Get(ctx, NODE_PREFIX, backend.WithPrefix())
+    if err != nil {
+        log.Warn("Get %v with prefix error, reason: %v", NODE_PREFIX, err.Error())
+        return nil, err
+    }
+
+    events := make(chan NodeEvent, len(resp.Kvs)+16)
+    for _, kv := range resp.Kvs {
+        if nodeId, ok := parseId(string(kv.Key), NODE_PREFIX); ok {
+            events <- NodeEvent{NODE_EVENT_ONLINE, nodeId, string(kv.Value), kv.ModRevision}
+        }
+    }
+
+    go func() {
+        defer close(events)
+        wChan := n.client.Watch(ctx, NODE_PREFIX, backend.WithPrefix(), backend.WithPrevKV(),
+            backend.WithRev(resp.Header.Revision+1))
+        for wResp := range wChan {
+            if err := wResp.Err(); err != nil {
+                log.Warn("Watch nodes error, reason: %v", err.Error())
+                return
+            }
+
+            for _, ev := range wResp.Events {
+                nodeId, ok := parseId(string(ev.Kv.Key), NODE_PREFIX)
+                if !ok {
+                    continue
+                }
+
+                e := NodeEvent{NODE_EVENT_ONLINE, nodeId, string(ev.Kv.Value), ev.Kv.ModRevision}
+                if ev.Type == mvccpb.DELETE {
+                    e.Type = NODE_EVENT_OFFLINE
+                    if ev.PrevKv != nil {
+                        e.ServiceAddr = string(ev.PrevKv.Value)
+                    }
+                }
+
+                select {
+                case events <- e:
+                case <-ctx.Done():
+                    return
+                }
+            }
+        }
+    }()
+    return events, nil
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+    "context"
+    "etcdagent/agent/event"
+    "etcdagent/agent/log"
+    "etcdagent/agent/ms"
+    "etcdagent/agent/node"
+    "fmt"
+    "time"
+)
+
+//定期比较本地状态与etcd，补齐Watch中断期间遗漏的删除
+const AGENT_RECONCILE_INTERVAL = 10 * time.Second
+
+//本agent注册的node和候选者被删除或者覆盖时，恢复或者删除本地状态
+func (a *Agent) reconcile(ctx context.Context, e event.Message) {
+    if nodeId, ok := node.ParseKey(e.FullKey); ok {
+        action, err := a.NodeReconcile(ctx, nodeId, e.ModRevision)
+        a.report(ctx, e.FullKey, action, e.Cause, err)
+    }
+
+    if group, nodeId, ok := ms.ParseKey(e.FullKey); ok {
+        action, err := a.MSReconcile(ctx, group, nodeId, e.ModRevision)
+        a.report(ctx, e.FullKey, action, e.Cause, err)
+    }
+}
+
+func (a *Agent) reconcileAll(ctx context.Context) {
+    for _, nodeId := range a.LocalNodes() {
+        action, err := a.NodeReconcile(ctx, nodeId, 0)
+        a.report(ctx, fmt.Sprintf("%s%v", node.NODE_PREFIX, nodeId), action, event.EVENT_CAUSE_NONE, err)
+    }
+
+    for group, nodes := range a.LocalCandidates() {
+        for _, nodeId := range nodes {
+            action, err := a.MSReconcile(ctx, group, nodeId, 0)
+            a.report(ctx, fmt.Sprintf("%s%s/%v", ms.MS_PREFIX, group, nodeId), action, event.EVENT_CAUSE_NONE, err)
+        }
+    }
+}
+
+//处理结果作为EVENT_TYPE_RECONCILE事件通知，value为restored或者dropped，cause为触发的删除原因
+func (a *Agent) report(ctx context.Context, key string, action string, cause uint8, err error) {
+    if err != nil || action == event.RECONCILE_NONE {
+        return
+    }
+
+    m := event.MakeMessage(key, action, event.EVENT_TYPE_RECONCILE)
+    m.Cause = cause
+    if nerr := a.notify(ctx, m); nerr != nil {
+        log.Warn("Notify reconcile error, key: %v, action: %v, reason: %v", key, action, nerr.Error())
+    }
+}
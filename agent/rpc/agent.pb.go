@@ -0,0 +1,2126 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: agent.proto
+
+package rpc
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type NodeEvent_Type int32
+
+const (
+	NodeEvent_ONLINE  NodeEvent_Type = 0
+	NodeEvent_OFFLINE NodeEvent_Type = 1
+)
+
+var NodeEvent_Type_name = map[int32]string{
+	0: "ONLINE",
+	1: "OFFLINE",
+}
+
+var NodeEvent_Type_value = map[string]int32{
+	"ONLINE":  0,
+	"OFFLINE": 1,
+}
+
+func (x NodeEvent_Type) String() string {
+	return proto.EnumName(NodeEvent_Type_name, int32(x))
+}
+
+func (NodeEvent_Type) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{10, 0}
+}
+
+type Empty struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Empty) Reset()         { *m = Empty{} }
+func (m *Empty) String() string { return proto.CompactTextString(m) }
+func (*Empty) ProtoMessage()    {}
+func (*Empty) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{0}
+}
+
+func (m *Empty) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Empty.Unmarshal(m, b)
+}
+func (m *Empty) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Empty.Marshal(b, m, deterministic)
+}
+func (m *Empty) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Empty.Merge(m, src)
+}
+func (m *Empty) XXX_Size() int {
+	return xxx_messageInfo_Empty.Size(m)
+}
+func (m *Empty) XXX_DiscardUnknown() {
+	xxx_messageInfo_Empty.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Empty proto.InternalMessageInfo
+
+type NodeRequest struct {
+	NodeId               uint32   `protobuf:"varint,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NodeRequest) Reset()         { *m = NodeRequest{} }
+func (m *NodeRequest) String() string { return proto.CompactTextString(m) }
+func (*NodeRequest) ProtoMessage()    {}
+func (*NodeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{1}
+}
+
+func (m *NodeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeRequest.Unmarshal(m, b)
+}
+func (m *NodeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeRequest.Marshal(b, m, deterministic)
+}
+func (m *NodeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeRequest.Merge(m, src)
+}
+func (m *NodeRequest) XXX_Size() int {
+	return xxx_messageInfo_NodeRequest.Size(m)
+}
+func (m *NodeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeRequest proto.InternalMessageInfo
+
+func (m *NodeRequest) GetNodeId() uint32 {
+	if m != nil {
+		return m.NodeId
+	}
+	return 0
+}
+
+type NodeOnlineRequest struct {
+	NodeId               uint32   `protobuf:"varint,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	ServiceAddr          string   `protobuf:"bytes,2,opt,name=service_addr,json=serviceAddr,proto3" json:"service_addr,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NodeOnlineRequest) Reset()         { *m = NodeOnlineRequest{} }
+func (m *NodeOnlineRequest) String() string { return proto.CompactTextString(m) }
+func (*NodeOnlineRequest) ProtoMessage()    {}
+func (*NodeOnlineRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{2}
+}
+
+func (m *NodeOnlineRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeOnlineRequest.Unmarshal(m, b)
+}
+func (m *NodeOnlineRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeOnlineRequest.Marshal(b, m, deterministic)
+}
+func (m *NodeOnlineRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeOnlineRequest.Merge(m, src)
+}
+func (m *NodeOnlineRequest) XXX_Size() int {
+	return xxx_messageInfo_NodeOnlineRequest.Size(m)
+}
+func (m *NodeOnlineRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeOnlineRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeOnlineRequest proto.InternalMessageInfo
+
+func (m *NodeOnlineRequest) GetNodeId() uint32 {
+	if m != nil {
+		return m.NodeId
+	}
+	return 0
+}
+
+func (m *NodeOnlineRequest) GetServiceAddr() string {
+	if m != nil {
+		return m.ServiceAddr
+	}
+	return ""
+}
+
+type NodeList struct {
+	NodeIds              []uint32 `protobuf:"varint,1,rep,packed,name=node_ids,json=nodeIds,proto3" json:"node_ids,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NodeList) Reset()         { *m = NodeList{} }
+func (m *NodeList) String() string { return proto.CompactTextString(m) }
+func (*NodeList) ProtoMessage()    {}
+func (*NodeList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{3}
+}
+
+func (m *NodeList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeList.Unmarshal(m, b)
+}
+func (m *NodeList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeList.Marshal(b, m, deterministic)
+}
+func (m *NodeList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeList.Merge(m, src)
+}
+func (m *NodeList) XXX_Size() int {
+	return xxx_messageInfo_NodeList.Size(m)
+}
+func (m *NodeList) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeList proto.InternalMessageInfo
+
+func (m *NodeList) GetNodeIds() []uint32 {
+	if m != nil {
+		return m.NodeIds
+	}
+	return nil
+}
+
+type ServiceAddr struct {
+	Addr                 string   `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ServiceAddr) Reset()         { *m = ServiceAddr{} }
+func (m *ServiceAddr) String() string { return proto.CompactTextString(m) }
+func (*ServiceAddr) ProtoMessage()    {}
+func (*ServiceAddr) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{4}
+}
+
+func (m *ServiceAddr) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ServiceAddr.Unmarshal(m, b)
+}
+func (m *ServiceAddr) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ServiceAddr.Marshal(b, m, deterministic)
+}
+func (m *ServiceAddr) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ServiceAddr.Merge(m, src)
+}
+func (m *ServiceAddr) XXX_Size() int {
+	return xxx_messageInfo_ServiceAddr.Size(m)
+}
+func (m *ServiceAddr) XXX_DiscardUnknown() {
+	xxx_messageInfo_ServiceAddr.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ServiceAddr proto.InternalMessageInfo
+
+func (m *ServiceAddr) GetAddr() string {
+	if m != nil {
+		return m.Addr
+	}
+	return ""
+}
+
+type AllocateIdRequest struct {
+	Identity             string   `protobuf:"bytes,1,opt,name=identity,proto3" json:"identity,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AllocateIdRequest) Reset()         { *m = AllocateIdRequest{} }
+func (m *AllocateIdRequest) String() string { return proto.CompactTextString(m) }
+func (*AllocateIdRequest) ProtoMessage()    {}
+func (*AllocateIdRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{5}
+}
+
+func (m *AllocateIdRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AllocateIdRequest.Unmarshal(m, b)
+}
+func (m *AllocateIdRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AllocateIdRequest.Marshal(b, m, deterministic)
+}
+func (m *AllocateIdRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AllocateIdRequest.Merge(m, src)
+}
+func (m *AllocateIdRequest) XXX_Size() int {
+	return xxx_messageInfo_AllocateIdRequest.Size(m)
+}
+func (m *AllocateIdRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AllocateIdRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AllocateIdRequest proto.InternalMessageInfo
+
+func (m *AllocateIdRequest) GetIdentity() string {
+	if m != nil {
+		return m.Identity
+	}
+	return ""
+}
+
+type WaitForNodesRequest struct {
+	NodeIds              []uint32 `protobuf:"varint,1,rep,packed,name=node_ids,json=nodeIds,proto3" json:"node_ids,omitempty"`
+	TimeoutMs            uint32   `protobuf:"varint,2,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WaitForNodesRequest) Reset()         { *m = WaitForNodesRequest{} }
+func (m *WaitForNodesRequest) String() string { return proto.CompactTextString(m) }
+func (*WaitForNodesRequest) ProtoMessage()    {}
+func (*WaitForNodesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{6}
+}
+
+func (m *WaitForNodesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WaitForNodesRequest.Unmarshal(m, b)
+}
+func (m *WaitForNodesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WaitForNodesRequest.Marshal(b, m, deterministic)
+}
+func (m *WaitForNodesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WaitForNodesRequest.Merge(m, src)
+}
+func (m *WaitForNodesRequest) XXX_Size() int {
+	return xxx_messageInfo_WaitForNodesRequest.Size(m)
+}
+func (m *WaitForNodesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WaitForNodesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WaitForNodesRequest proto.InternalMessageInfo
+
+func (m *WaitForNodesRequest) GetNodeIds() []uint32 {
+	if m != nil {
+		return m.NodeIds
+	}
+	return nil
+}
+
+func (m *WaitForNodesRequest) GetTimeoutMs() uint32 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type WaitForNodeCountRequest struct {
+	Count                uint32   `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	TimeoutMs            uint32   `protobuf:"varint,2,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WaitForNodeCountRequest) Reset()         { *m = WaitForNodeCountRequest{} }
+func (m *WaitForNodeCountRequest) String() string { return proto.CompactTextString(m) }
+func (*WaitForNodeCountRequest) ProtoMessage()    {}
+func (*WaitForNodeCountRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{7}
+}
+
+func (m *WaitForNodeCountRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WaitForNodeCountRequest.Unmarshal(m, b)
+}
+func (m *WaitForNodeCountRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WaitForNodeCountRequest.Marshal(b, m, deterministic)
+}
+func (m *WaitForNodeCountRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WaitForNodeCountRequest.Merge(m, src)
+}
+func (m *WaitForNodeCountRequest) XXX_Size() int {
+	return xxx_messageInfo_WaitForNodeCountRequest.Size(m)
+}
+func (m *WaitForNodeCountRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WaitForNodeCountRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WaitForNodeCountRequest proto.InternalMessageInfo
+
+func (m *WaitForNodeCountRequest) GetCount() uint32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *WaitForNodeCountRequest) GetTimeoutMs() uint32 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type NodeInfo struct {
+	NodeId      uint32 `protobuf:"varint,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	ServiceAddr string `protobuf:"bytes,2,opt,name=service_addr,json=serviceAddr,proto3" json:"service_addr,omitempty"`
+	Lease       int64  `protobuf:"varint,3,opt,name=lease,proto3" json:"lease,omitempty"`
+	// lease剩余秒数，-1表示没有lease或者lease已过期
+	Ttl                  int64    `protobuf:"varint,4,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NodeInfo) Reset()         { *m = NodeInfo{} }
+func (m *NodeInfo) String() string { return proto.CompactTextString(m) }
+func (*NodeInfo) ProtoMessage()    {}
+func (*NodeInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{8}
+}
+
+func (m *NodeInfo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeInfo.Unmarshal(m, b)
+}
+func (m *NodeInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeInfo.Marshal(b, m, deterministic)
+}
+func (m *NodeInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeInfo.Merge(m, src)
+}
+func (m *NodeInfo) XXX_Size() int {
+	return xxx_messageInfo_NodeInfo.Size(m)
+}
+func (m *NodeInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeInfo proto.InternalMessageInfo
+
+func (m *NodeInfo) GetNodeId() uint32 {
+	if m != nil {
+		return m.NodeId
+	}
+	return 0
+}
+
+func (m *NodeInfo) GetServiceAddr() string {
+	if m != nil {
+		return m.ServiceAddr
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetLease() int64 {
+	if m != nil {
+		return m.Lease
+	}
+	return 0
+}
+
+func (m *NodeInfo) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+type NodeInfoList struct {
+	Nodes                []*NodeInfo `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *NodeInfoList) Reset()         { *m = NodeInfoList{} }
+func (m *NodeInfoList) String() string { return proto.CompactTextString(m) }
+func (*NodeInfoList) ProtoMessage()    {}
+func (*NodeInfoList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{9}
+}
+
+func (m *NodeInfoList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeInfoList.Unmarshal(m, b)
+}
+func (m *NodeInfoList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeInfoList.Marshal(b, m, deterministic)
+}
+func (m *NodeInfoList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeInfoList.Merge(m, src)
+}
+func (m *NodeInfoList) XXX_Size() int {
+	return xxx_messageInfo_NodeInfoList.Size(m)
+}
+func (m *NodeInfoList) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeInfoList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeInfoList proto.InternalMessageInfo
+
+func (m *NodeInfoList) GetNodes() []*NodeInfo {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+type NodeEvent struct {
+	Type                 NodeEvent_Type `protobuf:"varint,1,opt,name=type,proto3,enum=etcdagent.NodeEvent_Type" json:"type,omitempty"`
+	NodeId               uint32         `protobuf:"varint,2,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	ServiceAddr          string         `protobuf:"bytes,3,opt,name=service_addr,json=serviceAddr,proto3" json:"service_addr,omitempty"`
+	Revision             int64          `protobuf:"varint,4,opt,name=revision,proto3" json:"revision,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *NodeEvent) Reset()         { *m = NodeEvent{} }
+func (m *NodeEvent) String() string { return proto.CompactTextString(m) }
+func (*NodeEvent) ProtoMessage()    {}
+func (*NodeEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{10}
+}
+
+func (m *NodeEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeEvent.Unmarshal(m, b)
+}
+func (m *NodeEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeEvent.Marshal(b, m, deterministic)
+}
+func (m *NodeEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeEvent.Merge(m, src)
+}
+func (m *NodeEvent) XXX_Size() int {
+	return xxx_messageInfo_NodeEvent.Size(m)
+}
+func (m *NodeEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeEvent proto.InternalMessageInfo
+
+func (m *NodeEvent) GetType() NodeEvent_Type {
+	if m != nil {
+		return m.Type
+	}
+	return NodeEvent_ONLINE
+}
+
+func (m *NodeEvent) GetNodeId() uint32 {
+	if m != nil {
+		return m.NodeId
+	}
+	return 0
+}
+
+func (m *NodeEvent) GetServiceAddr() string {
+	if m != nil {
+		return m.ServiceAddr
+	}
+	return ""
+}
+
+func (m *NodeEvent) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+type GroupRequest struct {
+	Group                string   `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GroupRequest) Reset()         { *m = GroupRequest{} }
+func (m *GroupRequest) String() string { return proto.CompactTextString(m) }
+func (*GroupRequest) ProtoMessage()    {}
+func (*GroupRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{11}
+}
+
+func (m *GroupRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GroupRequest.Unmarshal(m, b)
+}
+func (m *GroupRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GroupRequest.Marshal(b, m, deterministic)
+}
+func (m *GroupRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GroupRequest.Merge(m, src)
+}
+func (m *GroupRequest) XXX_Size() int {
+	return xxx_messageInfo_GroupRequest.Size(m)
+}
+func (m *GroupRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GroupRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GroupRequest proto.InternalMessageInfo
+
+func (m *GroupRequest) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+type GroupNodeRequest struct {
+	Group                string   `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	NodeId               uint32   `protobuf:"varint,2,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GroupNodeRequest) Reset()         { *m = GroupNodeRequest{} }
+func (m *GroupNodeRequest) String() string { return proto.CompactTextString(m) }
+func (*GroupNodeRequest) ProtoMessage()    {}
+func (*GroupNodeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{12}
+}
+
+func (m *GroupNodeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GroupNodeRequest.Unmarshal(m, b)
+}
+func (m *GroupNodeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GroupNodeRequest.Marshal(b, m, deterministic)
+}
+func (m *GroupNodeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GroupNodeRequest.Merge(m, src)
+}
+func (m *GroupNodeRequest) XXX_Size() int {
+	return xxx_messageInfo_GroupNodeRequest.Size(m)
+}
+func (m *GroupNodeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GroupNodeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GroupNodeRequest proto.InternalMessageInfo
+
+func (m *GroupNodeRequest) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *GroupNodeRequest) GetNodeId() uint32 {
+	if m != nil {
+		return m.NodeId
+	}
+	return 0
+}
+
+type CompeteRequest struct {
+	Group                string   `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	NodeId               uint32   `protobuf:"varint,2,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	Priority             uint32   `protobuf:"varint,3,opt,name=priority,proto3" json:"priority,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CompeteRequest) Reset()         { *m = CompeteRequest{} }
+func (m *CompeteRequest) String() string { return proto.CompactTextString(m) }
+func (*CompeteRequest) ProtoMessage()    {}
+func (*CompeteRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{13}
+}
+
+func (m *CompeteRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CompeteRequest.Unmarshal(m, b)
+}
+func (m *CompeteRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CompeteRequest.Marshal(b, m, deterministic)
+}
+func (m *CompeteRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CompeteRequest.Merge(m, src)
+}
+func (m *CompeteRequest) XXX_Size() int {
+	return xxx_messageInfo_CompeteRequest.Size(m)
+}
+func (m *CompeteRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CompeteRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CompeteRequest proto.InternalMessageInfo
+
+func (m *CompeteRequest) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *CompeteRequest) GetNodeId() uint32 {
+	if m != nil {
+		return m.NodeId
+	}
+	return 0
+}
+
+func (m *CompeteRequest) GetPriority() uint32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+type TransferRequest struct {
+	Group                string   `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	From                 uint32   `protobuf:"varint,2,opt,name=from,proto3" json:"from,omitempty"`
+	To                   uint32   `protobuf:"varint,3,opt,name=to,proto3" json:"to,omitempty"`
+	TimeoutMs            uint32   `protobuf:"varint,4,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TransferRequest) Reset()         { *m = TransferRequest{} }
+func (m *TransferRequest) String() string { return proto.CompactTextString(m) }
+func (*TransferRequest) ProtoMessage()    {}
+func (*TransferRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{14}
+}
+
+func (m *TransferRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TransferRequest.Unmarshal(m, b)
+}
+func (m *TransferRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TransferRequest.Marshal(b, m, deterministic)
+}
+func (m *TransferRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TransferRequest.Merge(m, src)
+}
+func (m *TransferRequest) XXX_Size() int {
+	return xxx_messageInfo_TransferRequest.Size(m)
+}
+func (m *TransferRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_TransferRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TransferRequest proto.InternalMessageInfo
+
+func (m *TransferRequest) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *TransferRequest) GetFrom() uint32 {
+	if m != nil {
+		return m.From
+	}
+	return 0
+}
+
+func (m *TransferRequest) GetTo() uint32 {
+	if m != nil {
+		return m.To
+	}
+	return 0
+}
+
+func (m *TransferRequest) GetTimeoutMs() uint32 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type IsMasterResponse struct {
+	Master bool `protobuf:"varint,1,opt,name=master,proto3" json:"master,omitempty"`
+	// master身份剩余的有效毫秒数，不是master时为0，非本地候选者无法估算时为-1
+	RemainMs             int64    `protobuf:"varint,2,opt,name=remain_ms,json=remainMs,proto3" json:"remain_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IsMasterResponse) Reset()         { *m = IsMasterResponse{} }
+func (m *IsMasterResponse) String() string { return proto.CompactTextString(m) }
+func (*IsMasterResponse) ProtoMessage()    {}
+func (*IsMasterResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{15}
+}
+
+func (m *IsMasterResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IsMasterResponse.Unmarshal(m, b)
+}
+func (m *IsMasterResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IsMasterResponse.Marshal(b, m, deterministic)
+}
+func (m *IsMasterResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IsMasterResponse.Merge(m, src)
+}
+func (m *IsMasterResponse) XXX_Size() int {
+	return xxx_messageInfo_IsMasterResponse.Size(m)
+}
+func (m *IsMasterResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_IsMasterResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IsMasterResponse proto.InternalMessageInfo
+
+func (m *IsMasterResponse) GetMaster() bool {
+	if m != nil {
+		return m.Master
+	}
+	return false
+}
+
+func (m *IsMasterResponse) GetRemainMs() int64 {
+	if m != nil {
+		return m.RemainMs
+	}
+	return 0
+}
+
+type Master struct {
+	Group                string   `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	NodeId               uint32   `protobuf:"varint,2,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	Revision             int64    `protobuf:"varint,3,opt,name=revision,proto3" json:"revision,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Master) Reset()         { *m = Master{} }
+func (m *Master) String() string { return proto.CompactTextString(m) }
+func (*Master) ProtoMessage()    {}
+func (*Master) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{16}
+}
+
+func (m *Master) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Master.Unmarshal(m, b)
+}
+func (m *Master) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Master.Marshal(b, m, deterministic)
+}
+func (m *Master) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Master.Merge(m, src)
+}
+func (m *Master) XXX_Size() int {
+	return xxx_messageInfo_Master.Size(m)
+}
+func (m *Master) XXX_DiscardUnknown() {
+	xxx_messageInfo_Master.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Master proto.InternalMessageInfo
+
+func (m *Master) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *Master) GetNodeId() uint32 {
+	if m != nil {
+		return m.NodeId
+	}
+	return 0
+}
+
+func (m *Master) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+type WaitForMasterRequest struct {
+	Group                string   `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	TimeoutMs            uint32   `protobuf:"varint,2,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WaitForMasterRequest) Reset()         { *m = WaitForMasterRequest{} }
+func (m *WaitForMasterRequest) String() string { return proto.CompactTextString(m) }
+func (*WaitForMasterRequest) ProtoMessage()    {}
+func (*WaitForMasterRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{17}
+}
+
+func (m *WaitForMasterRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WaitForMasterRequest.Unmarshal(m, b)
+}
+func (m *WaitForMasterRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WaitForMasterRequest.Marshal(b, m, deterministic)
+}
+func (m *WaitForMasterRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WaitForMasterRequest.Merge(m, src)
+}
+func (m *WaitForMasterRequest) XXX_Size() int {
+	return xxx_messageInfo_WaitForMasterRequest.Size(m)
+}
+func (m *WaitForMasterRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WaitForMasterRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WaitForMasterRequest proto.InternalMessageInfo
+
+func (m *WaitForMasterRequest) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *WaitForMasterRequest) GetTimeoutMs() uint32 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type Candidate struct {
+	NodeId               uint32   `protobuf:"varint,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	Priority             uint32   `protobuf:"varint,2,opt,name=priority,proto3" json:"priority,omitempty"`
+	Revision             int64    `protobuf:"varint,3,opt,name=revision,proto3" json:"revision,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Candidate) Reset()         { *m = Candidate{} }
+func (m *Candidate) String() string { return proto.CompactTextString(m) }
+func (*Candidate) ProtoMessage()    {}
+func (*Candidate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{18}
+}
+
+func (m *Candidate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Candidate.Unmarshal(m, b)
+}
+func (m *Candidate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Candidate.Marshal(b, m, deterministic)
+}
+func (m *Candidate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Candidate.Merge(m, src)
+}
+func (m *Candidate) XXX_Size() int {
+	return xxx_messageInfo_Candidate.Size(m)
+}
+func (m *Candidate) XXX_DiscardUnknown() {
+	xxx_messageInfo_Candidate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Candidate proto.InternalMessageInfo
+
+func (m *Candidate) GetNodeId() uint32 {
+	if m != nil {
+		return m.NodeId
+	}
+	return 0
+}
+
+func (m *Candidate) GetPriority() uint32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+func (m *Candidate) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+type CandidateList struct {
+	Candidates           []*Candidate `protobuf:"bytes,1,rep,name=candidates,proto3" json:"candidates,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *CandidateList) Reset()         { *m = CandidateList{} }
+func (m *CandidateList) String() string { return proto.CompactTextString(m) }
+func (*CandidateList) ProtoMessage()    {}
+func (*CandidateList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{19}
+}
+
+func (m *CandidateList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CandidateList.Unmarshal(m, b)
+}
+func (m *CandidateList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CandidateList.Marshal(b, m, deterministic)
+}
+func (m *CandidateList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CandidateList.Merge(m, src)
+}
+func (m *CandidateList) XXX_Size() int {
+	return xxx_messageInfo_CandidateList.Size(m)
+}
+func (m *CandidateList) XXX_DiscardUnknown() {
+	xxx_messageInfo_CandidateList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CandidateList proto.InternalMessageInfo
+
+func (m *CandidateList) GetCandidates() []*Candidate {
+	if m != nil {
+		return m.Candidates
+	}
+	return nil
+}
+
+type GroupList struct {
+	Groups               []string `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GroupList) Reset()         { *m = GroupList{} }
+func (m *GroupList) String() string { return proto.CompactTextString(m) }
+func (*GroupList) ProtoMessage()    {}
+func (*GroupList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{20}
+}
+
+func (m *GroupList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GroupList.Unmarshal(m, b)
+}
+func (m *GroupList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GroupList.Marshal(b, m, deterministic)
+}
+func (m *GroupList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GroupList.Merge(m, src)
+}
+func (m *GroupList) XXX_Size() int {
+	return xxx_messageInfo_GroupList.Size(m)
+}
+func (m *GroupList) XXX_DiscardUnknown() {
+	xxx_messageInfo_GroupList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GroupList proto.InternalMessageInfo
+
+func (m *GroupList) GetGroups() []string {
+	if m != nil {
+		return m.Groups
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("etcdagent.NodeEvent_Type", NodeEvent_Type_name, NodeEvent_Type_value)
+	proto.RegisterType((*Empty)(nil), "etcdagent.Empty")
+	proto.RegisterType((*NodeRequest)(nil), "etcdagent.NodeRequest")
+	proto.RegisterType((*NodeOnlineRequest)(nil), "etcdagent.NodeOnlineRequest")
+	proto.RegisterType((*NodeList)(nil), "etcdagent.NodeList")
+	proto.RegisterType((*ServiceAddr)(nil), "etcdagent.ServiceAddr")
+	proto.RegisterType((*AllocateIdRequest)(nil), "etcdagent.AllocateIdRequest")
+	proto.RegisterType((*WaitForNodesRequest)(nil), "etcdagent.WaitForNodesRequest")
+	proto.RegisterType((*WaitForNodeCountRequest)(nil), "etcdagent.WaitForNodeCountRequest")
+	proto.RegisterType((*NodeInfo)(nil), "etcdagent.NodeInfo")
+	proto.RegisterType((*NodeInfoList)(nil), "etcdagent.NodeInfoList")
+	proto.RegisterType((*NodeEvent)(nil), "etcdagent.NodeEvent")
+	proto.RegisterType((*GroupRequest)(nil), "etcdagent.GroupRequest")
+	proto.RegisterType((*GroupNodeRequest)(nil), "etcdagent.GroupNodeRequest")
+	proto.RegisterType((*CompeteRequest)(nil), "etcdagent.CompeteRequest")
+	proto.RegisterType((*TransferRequest)(nil), "etcdagent.TransferRequest")
+	proto.RegisterType((*IsMasterResponse)(nil), "etcdagent.IsMasterResponse")
+	proto.RegisterType((*Master)(nil), "etcdagent.Master")
+	proto.RegisterType((*WaitForMasterRequest)(nil), "etcdagent.WaitForMasterRequest")
+	proto.RegisterType((*Candidate)(nil), "etcdagent.Candidate")
+	proto.RegisterType((*CandidateList)(nil), "etcdagent.CandidateList")
+	proto.RegisterType((*GroupList)(nil), "etcdagent.GroupList")
+}
+
+func init() { proto.RegisterFile("agent.proto", fileDescriptor_56ede974c0020f77) }
+
+var fileDescriptor_56ede974c0020f77 = []byte{
+	// 972 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x57, 0x6d, 0x6f, 0xdb, 0x36,
+	0x10, 0x9e, 0xfc, 0xae, 0xb3, 0x9d, 0x3a, 0x6c, 0x90, 0xb8, 0xee, 0xb6, 0xa6, 0xdc, 0x0b, 0xb2,
+	0x0f, 0xcb, 0x8a, 0x34, 0x68, 0xd7, 0x2d, 0x1b, 0xe6, 0x26, 0xb6, 0x67, 0xb4, 0x8a, 0x01, 0xa9,
+	0x43, 0x81, 0x6d, 0x40, 0xa0, 0x49, 0x74, 0xa6, 0x4d, 0x16, 0x35, 0x91, 0xf1, 0x90, 0x7f, 0xb1,
+	0xff, 0xb2, 0x3f, 0x38, 0x90, 0x92, 0x6c, 0x5a, 0x96, 0x1c, 0x38, 0xfb, 0xa6, 0x23, 0x9f, 0x7b,
+	0xc8, 0x3b, 0xde, 0xdd, 0x03, 0x41, 0xd3, 0xbe, 0x26, 0x01, 0x3f, 0x0e, 0x23, 0xca, 0x29, 0xd2,
+	0x09, 0x77, 0x5c, 0xb9, 0x80, 0xeb, 0x50, 0x1d, 0xcc, 0x42, 0x7e, 0x8b, 0x3f, 0x87, 0xe6, 0x25,
+	0x75, 0x89, 0x49, 0xfe, 0xba, 0x21, 0x8c, 0xa3, 0x03, 0xa8, 0x07, 0xd4, 0x25, 0x57, 0x9e, 0xdb,
+	0xd5, 0x0e, 0xb5, 0xa3, 0xb6, 0x59, 0x13, 0xe6, 0xd8, 0xc5, 0x13, 0xd8, 0x15, 0xb8, 0x49, 0xe0,
+	0x7b, 0xc1, 0x9d, 0x68, 0xf4, 0x14, 0x5a, 0x8c, 0x44, 0x73, 0xcf, 0x21, 0x57, 0xb6, 0xeb, 0x46,
+	0xdd, 0xd2, 0xa1, 0x76, 0xa4, 0x9b, 0xcd, 0x64, 0xad, 0xef, 0xba, 0x11, 0xfe, 0x0c, 0x1a, 0x82,
+	0xf0, 0xad, 0xc7, 0x38, 0x7a, 0x04, 0x8d, 0x84, 0x87, 0x75, 0xb5, 0xc3, 0xf2, 0x51, 0xdb, 0xac,
+	0xc7, 0x44, 0x0c, 0x3f, 0x85, 0xa6, 0xb5, 0xf4, 0x42, 0x08, 0x2a, 0x92, 0x50, 0x93, 0x84, 0xf2,
+	0x1b, 0x7f, 0x05, 0xbb, 0x7d, 0xdf, 0xa7, 0x8e, 0xcd, 0xc9, 0xd8, 0x4d, 0xaf, 0xd6, 0x83, 0x86,
+	0xe7, 0x92, 0x80, 0x7b, 0xfc, 0x36, 0x01, 0x2f, 0x6c, 0x3c, 0x81, 0x87, 0xef, 0x6d, 0x8f, 0x0f,
+	0x69, 0x24, 0x6e, 0xc0, 0x52, 0x97, 0xe2, 0x5b, 0xa0, 0x8f, 0x00, 0xb8, 0x37, 0x23, 0xf4, 0x86,
+	0x5f, 0xcd, 0x98, 0x8c, 0xa6, 0x6d, 0xea, 0xc9, 0x8a, 0xc1, 0xf0, 0x25, 0x1c, 0x28, 0x84, 0xe7,
+	0xf4, 0x26, 0xe0, 0x29, 0xe9, 0x1e, 0x54, 0x1d, 0x61, 0x27, 0x09, 0x8a, 0x8d, 0xbb, 0xf8, 0x82,
+	0x38, 0x37, 0xe3, 0x60, 0x4a, 0xff, 0x4f, 0x8e, 0xc5, 0xe1, 0x3e, 0xb1, 0x19, 0xe9, 0x96, 0x0f,
+	0xb5, 0xa3, 0xb2, 0x19, 0x1b, 0xa8, 0x03, 0x65, 0xce, 0xfd, 0x6e, 0x45, 0xae, 0x89, 0x4f, 0xfc,
+	0x0a, 0x5a, 0xe9, 0x79, 0xf2, 0x3d, 0xbe, 0x80, 0xaa, 0x38, 0x24, 0x4e, 0x43, 0xf3, 0xe4, 0xe1,
+	0xf1, 0xa2, 0x70, 0x8e, 0x53, 0x9c, 0x19, 0x23, 0xf0, 0xbf, 0x1a, 0xe8, 0x62, 0x6d, 0x30, 0x27,
+	0x01, 0x47, 0x5f, 0x42, 0x85, 0xdf, 0x86, 0x44, 0xde, 0x74, 0xe7, 0xe4, 0x51, 0xc6, 0x4f, 0x62,
+	0x8e, 0xdf, 0xdd, 0x86, 0xc4, 0x94, 0x30, 0x35, 0xb6, 0xd2, 0xc6, 0xd8, 0xca, 0xeb, 0xb1, 0xf5,
+	0xa0, 0x11, 0x91, 0xb9, 0xc7, 0x3c, 0x1a, 0x24, 0xa1, 0x2c, 0x6c, 0xfc, 0x04, 0x2a, 0xe2, 0x14,
+	0x04, 0x50, 0x9b, 0x5c, 0xbe, 0x1d, 0x5f, 0x0e, 0x3a, 0x1f, 0xa0, 0x26, 0xd4, 0x27, 0xc3, 0xa1,
+	0x34, 0x34, 0xfc, 0x29, 0xb4, 0x46, 0x11, 0xbd, 0x09, 0x95, 0x57, 0xba, 0x16, 0x76, 0x52, 0x2a,
+	0xb1, 0x81, 0xfb, 0xd0, 0x91, 0x28, 0xb5, 0x41, 0x72, 0x91, 0x85, 0x81, 0xe0, 0x5f, 0x60, 0xe7,
+	0x9c, 0xce, 0x42, 0xc2, 0xef, 0x49, 0x20, 0xc2, 0x0c, 0x23, 0x8f, 0x46, 0xa2, 0x8e, 0xcb, 0x72,
+	0x67, 0x61, 0xe3, 0x3f, 0xe0, 0xc1, 0xbb, 0xc8, 0x0e, 0xd8, 0x94, 0x44, 0x9b, 0xd9, 0x11, 0x54,
+	0xa6, 0x11, 0x9d, 0x25, 0xd4, 0xf2, 0x1b, 0xed, 0x40, 0x89, 0xd3, 0x84, 0xb2, 0xc4, 0x69, 0xa6,
+	0x24, 0x2b, 0xd9, 0x92, 0x1c, 0x41, 0x67, 0xcc, 0x0c, 0x9b, 0x71, 0x71, 0x16, 0x0b, 0x69, 0xc0,
+	0x08, 0xda, 0x87, 0xda, 0x4c, 0xae, 0xc8, 0xd3, 0x1a, 0x66, 0x62, 0xa1, 0xc7, 0xa0, 0x47, 0x64,
+	0x66, 0x7b, 0x41, 0x5a, 0xdc, 0xf2, 0x6d, 0xc4, 0x82, 0xc1, 0xb0, 0x05, 0xb5, 0x98, 0xe6, 0x1e,
+	0x99, 0x58, 0x3c, 0x78, 0x39, 0xf3, 0xe0, 0x6f, 0x60, 0x2f, 0x69, 0xc0, 0xf4, 0x8a, 0x9b, 0xd2,
+	0x71, 0x47, 0xf7, 0xfd, 0x0a, 0xfa, 0xb9, 0x1d, 0xb8, 0x9e, 0x6b, 0x73, 0x52, 0xdc, 0x7e, 0xea,
+	0xc3, 0x94, 0x56, 0x1f, 0x66, 0xe3, 0x55, 0x07, 0xd0, 0x5e, 0xb0, 0xcb, 0x66, 0x3b, 0x05, 0x70,
+	0xd2, 0x85, 0xb4, 0xe3, 0xf6, 0x94, 0xce, 0x59, 0xa0, 0x4d, 0x05, 0x87, 0x3f, 0x01, 0x5d, 0xd6,
+	0xa6, 0xa4, 0xd8, 0x87, 0x9a, 0x8c, 0x2c, 0x76, 0xd7, 0xcd, 0xc4, 0x3a, 0xf9, 0xa7, 0x05, 0xd5,
+	0xbe, 0x20, 0x41, 0x67, 0x00, 0xcb, 0xf1, 0x8d, 0x3e, 0xcc, 0x34, 0xe6, 0xca, 0x54, 0xef, 0x75,
+	0x94, 0x5d, 0x29, 0x12, 0xe8, 0x65, 0x2c, 0x12, 0x93, 0xe9, 0x54, 0xba, 0xef, 0x67, 0xdc, 0x8b,
+	0x1d, 0x5f, 0x41, 0x5b, 0x00, 0xde, 0x10, 0x12, 0xda, 0xbe, 0x37, 0xdf, 0xc6, 0xf5, 0x14, 0x9a,
+	0x23, 0xc2, 0xfb, 0xbe, 0x2f, 0x60, 0x0c, 0xad, 0x01, 0x7a, 0xd9, 0xa9, 0x24, 0x33, 0x71, 0x01,
+	0x68, 0x44, 0xb8, 0x30, 0x55, 0xd5, 0x28, 0x3a, 0x55, 0x5d, 0x57, 0xf1, 0x43, 0xd8, 0x11, 0xb0,
+	0xa5, 0xaa, 0xac, 0x64, 0x6c, 0x4d, 0x6c, 0x7a, 0x05, 0xfc, 0x69, 0xf8, 0x26, 0x91, 0x83, 0x77,
+	0xec, 0x6e, 0x11, 0xfe, 0x0f, 0xd0, 0x52, 0x35, 0x0a, 0x7d, 0xac, 0x20, 0x72, 0xc4, 0x2b, 0x87,
+	0xe1, 0x47, 0xe8, 0x64, 0x45, 0x09, 0xe1, 0x7c, 0x16, 0x55, 0xb1, 0x72, 0x98, 0x5e, 0x80, 0x2e,
+	0x92, 0x5b, 0xf4, 0x10, 0x07, 0x39, 0xf2, 0x20, 0x1f, 0xe3, 0x0c, 0x3a, 0xc2, 0x1e, 0xd2, 0xc8,
+	0xb9, 0x47, 0xed, 0xbc, 0x00, 0x78, 0x6f, 0x73, 0xe7, 0xf7, 0xa2, 0x63, 0xf7, 0xf2, 0xd4, 0xe5,
+	0x99, 0x86, 0xbe, 0x06, 0xdd, 0xb0, 0x92, 0xa1, 0x8b, 0x54, 0x09, 0x5a, 0x1d, 0xc4, 0xb9, 0xd5,
+	0xda, 0x30, 0xac, 0x91, 0x37, 0x27, 0x3f, 0x85, 0xe8, 0xb1, 0xb2, 0x9b, 0x15, 0x81, 0x1c, 0xd7,
+	0x33, 0x68, 0x1a, 0xd6, 0xb2, 0xcc, 0xb7, 0xf4, 0x3e, 0x85, 0xba, 0x61, 0x0d, 0x7c, 0xe2, 0x70,
+	0x74, 0x90, 0xf5, 0x2c, 0xf6, 0xfa, 0x06, 0xc0, 0xb0, 0x52, 0x01, 0x40, 0x3d, 0x65, 0x3f, 0xa3,
+	0x0a, 0x39, 0xbe, 0xdf, 0x43, 0x7b, 0xe9, 0xdb, 0x77, 0xfe, 0xdc, 0xf6, 0xc6, 0x17, 0xd0, 0x48,
+	0xe5, 0x60, 0xb3, 0xab, 0xba, 0xb9, 0x26, 0x20, 0x2f, 0x41, 0x1f, 0x11, 0x9e, 0xd0, 0x14, 0x46,
+	0xbe, 0xab, 0x6c, 0x24, 0xd8, 0x73, 0x68, 0xaf, 0xcc, 0x7b, 0xf4, 0x64, 0xbd, 0xb0, 0x57, 0x94,
+	0x20, 0x8f, 0xe4, 0x35, 0xb4, 0x47, 0x84, 0x2f, 0xc6, 0x2b, 0x2b, 0xbe, 0x41, 0x37, 0x6f, 0x1c,
+	0xcb, 0x12, 0x7f, 0x2e, 0x23, 0x90, 0xe0, 0xbb, 0x6a, 0x74, 0x39, 0xae, 0xbf, 0x83, 0x07, 0x86,
+	0x25, 0xbb, 0xc2, 0xe2, 0x24, 0xbc, 0xa0, 0x7f, 0x07, 0x5b, 0x05, 0xff, 0x2d, 0x34, 0x65, 0x63,
+	0x6c, 0x9f, 0xb7, 0x67, 0xda, 0xeb, 0xea, 0xcf, 0xe5, 0x28, 0x74, 0x7e, 0xab, 0xc9, 0x3f, 0x82,
+	0xe7, 0xff, 0x0d, 0x00, 0x3a, 0xc8, 0xc8, 0x9a, 0x20, 0x0c, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// AgentClient is the client API for Agent service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type AgentClient interface {
+	NodeOnline(ctx context.Context, in *NodeOnlineRequest, opts ...grpc.CallOption) (*Empty, error)
+	NodeOffline(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	NodeKeepalive(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	GetAllNodes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*NodeList, error)
+	GetNodeServiceAddr(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*ServiceAddr, error)
+	NodeAllocateId(ctx context.Context, in *AllocateIdRequest, opts ...grpc.CallOption) (*NodeRequest, error)
+	NodeReleaseId(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	WaitForNodes(ctx context.Context, in *WaitForNodesRequest, opts ...grpc.CallOption) (*Empty, error)
+	WaitForNodeCount(ctx context.Context, in *WaitForNodeCountRequest, opts ...grpc.CallOption) (*Empty, error)
+	ListNodes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*NodeInfoList, error)
+	NodeForceOffline(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	// 先推送当前在线的node，之后推送上下线变化
+	WatchNodes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Agent_WatchNodesClient, error)
+	MSCompete(ctx context.Context, in *CompeteRequest, opts ...grpc.CallOption) (*Empty, error)
+	MSGiveUp(ctx context.Context, in *GroupNodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	MSKeepalive(ctx context.Context, in *GroupNodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	MSElect(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Empty, error)
+	MSTransfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*Empty, error)
+	MSTransferAck(ctx context.Context, in *GroupNodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	IsMaster(ctx context.Context, in *GroupNodeRequest, opts ...grpc.CallOption) (*IsMasterResponse, error)
+	GetMaster(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Master, error)
+	WaitForMaster(ctx context.Context, in *WaitForMasterRequest, opts ...grpc.CallOption) (*Master, error)
+	GetCandidates(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*CandidateList, error)
+	GetGroups(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*GroupList, error)
+	MSForceStepDown(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Master, error)
+	// 先推送当前master，之后每次master记录变化推送一次，没有master时node_id为0xffffffff
+	WatchMaster(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (Agent_WatchMasterClient, error)
+}
+
+type agentClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAgentClient(cc *grpc.ClientConn) AgentClient {
+	return &agentClient{cc}
+}
+
+func (c *agentClient) NodeOnline(ctx context.Context, in *NodeOnlineRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/NodeOnline", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) NodeOffline(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/NodeOffline", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) NodeKeepalive(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/NodeKeepalive", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) GetAllNodes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*NodeList, error) {
+	out := new(NodeList)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/GetAllNodes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) GetNodeServiceAddr(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*ServiceAddr, error) {
+	out := new(ServiceAddr)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/GetNodeServiceAddr", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) NodeAllocateId(ctx context.Context, in *AllocateIdRequest, opts ...grpc.CallOption) (*NodeRequest, error) {
+	out := new(NodeRequest)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/NodeAllocateId", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) NodeReleaseId(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/NodeReleaseId", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) WaitForNodes(ctx context.Context, in *WaitForNodesRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/WaitForNodes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) WaitForNodeCount(ctx context.Context, in *WaitForNodeCountRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/WaitForNodeCount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) ListNodes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*NodeInfoList, error) {
+	out := new(NodeInfoList)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/ListNodes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) NodeForceOffline(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/NodeForceOffline", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) WatchNodes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Agent_WatchNodesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Agent_serviceDesc.Streams[0], "/etcdagent.Agent/WatchNodes", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentWatchNodesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Agent_WatchNodesClient interface {
+	Recv() (*NodeEvent, error)
+	grpc.ClientStream
+}
+
+type agentWatchNodesClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentWatchNodesClient) Recv() (*NodeEvent, error) {
+	m := new(NodeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentClient) MSCompete(ctx context.Context, in *CompeteRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/MSCompete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) MSGiveUp(ctx context.Context, in *GroupNodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/MSGiveUp", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) MSKeepalive(ctx context.Context, in *GroupNodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/MSKeepalive", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) MSElect(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/MSElect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) MSTransfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/MSTransfer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) MSTransferAck(ctx context.Context, in *GroupNodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/MSTransferAck", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) IsMaster(ctx context.Context, in *GroupNodeRequest, opts ...grpc.CallOption) (*IsMasterResponse, error) {
+	out := new(IsMasterResponse)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/IsMaster", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) GetMaster(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Master, error) {
+	out := new(Master)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/GetMaster", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) WaitForMaster(ctx context.Context, in *WaitForMasterRequest, opts ...grpc.CallOption) (*Master, error) {
+	out := new(Master)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/WaitForMaster", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) GetCandidates(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*CandidateList, error) {
+	out := new(CandidateList)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/GetCandidates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) GetGroups(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*GroupList, error) {
+	out := new(GroupList)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/GetGroups", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) MSForceStepDown(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Master, error) {
+	out := new(Master)
+	err := c.cc.Invoke(ctx, "/etcdagent.Agent/MSForceStepDown", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) WatchMaster(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (Agent_WatchMasterClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Agent_serviceDesc.Streams[1], "/etcdagent.Agent/WatchMaster", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentWatchMasterClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Agent_WatchMasterClient interface {
+	Recv() (*Master, error)
+	grpc.ClientStream
+}
+
+type agentWatchMasterClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentWatchMasterClient) Recv() (*Master, error) {
+	m := new(Master)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AgentServer is the server API for Agent service.
+type AgentServer interface {
+	NodeOnline(context.Context, *NodeOnlineRequest) (*Empty, error)
+	NodeOffline(context.Context, *NodeRequest) (*Empty, error)
+	NodeKeepalive(context.Context, *NodeRequest) (*Empty, error)
+	GetAllNodes(context.Context, *Empty) (*NodeList, error)
+	GetNodeServiceAddr(context.Context, *NodeRequest) (*ServiceAddr, error)
+	NodeAllocateId(context.Context, *AllocateIdRequest) (*NodeRequest, error)
+	NodeReleaseId(context.Context, *NodeRequest) (*Empty, error)
+	WaitForNodes(context.Context, *WaitForNodesRequest) (*Empty, error)
+	WaitForNodeCount(context.Context, *WaitForNodeCountRequest) (*Empty, error)
+	ListNodes(context.Context, *Empty) (*NodeInfoList, error)
+	NodeForceOffline(context.Context, *NodeRequest) (*Empty, error)
+	// 先推送当前在线的node，之后推送上下线变化
+	WatchNodes(*Empty, Agent_WatchNodesServer) error
+	MSCompete(context.Context, *CompeteRequest) (*Empty, error)
+	MSGiveUp(context.Context, *GroupNodeRequest) (*Empty, error)
+	MSKeepalive(context.Context, *GroupNodeRequest) (*Empty, error)
+	MSElect(context.Context, *GroupRequest) (*Empty, error)
+	MSTransfer(context.Context, *TransferRequest) (*Empty, error)
+	MSTransferAck(context.Context, *GroupNodeRequest) (*Empty, error)
+	IsMaster(context.Context, *GroupNodeRequest) (*IsMasterResponse, error)
+	GetMaster(context.Context, *GroupRequest) (*Master, error)
+	WaitForMaster(context.Context, *WaitForMasterRequest) (*Master, error)
+	GetCandidates(context.Context, *GroupRequest) (*CandidateList, error)
+	GetGroups(context.Context, *Empty) (*GroupList, error)
+	MSForceStepDown(context.Context, *GroupRequest) (*Master, error)
+	// 先推送当前master，之后每次master记录变化推送一次，没有master时node_id为0xffffffff
+	WatchMaster(*GroupRequest, Agent_WatchMasterServer) error
+}
+
+// UnimplementedAgentServer can be embedded to have forward compatible implementations.
+type UnimplementedAgentServer struct {
+}
+
+func (*UnimplementedAgentServer) NodeOnline(ctx context.Context, req *NodeOnlineRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NodeOnline not implemented")
+}
+func (*UnimplementedAgentServer) NodeOffline(ctx context.Context, req *NodeRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NodeOffline not implemented")
+}
+func (*UnimplementedAgentServer) NodeKeepalive(ctx context.Context, req *NodeRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NodeKeepalive not implemented")
+}
+func (*UnimplementedAgentServer) GetAllNodes(ctx context.Context, req *Empty) (*NodeList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAllNodes not implemented")
+}
+func (*UnimplementedAgentServer) GetNodeServiceAddr(ctx context.Context, req *NodeRequest) (*ServiceAddr, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNodeServiceAddr not implemented")
+}
+func (*UnimplementedAgentServer) NodeAllocateId(ctx context.Context, req *AllocateIdRequest) (*NodeRequest, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NodeAllocateId not implemented")
+}
+func (*UnimplementedAgentServer) NodeReleaseId(ctx context.Context, req *NodeRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NodeReleaseId not implemented")
+}
+func (*UnimplementedAgentServer) WaitForNodes(ctx context.Context, req *WaitForNodesRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WaitForNodes not implemented")
+}
+func (*UnimplementedAgentServer) WaitForNodeCount(ctx context.Context, req *WaitForNodeCountRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WaitForNodeCount not implemented")
+}
+func (*UnimplementedAgentServer) ListNodes(ctx context.Context, req *Empty) (*NodeInfoList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNodes not implemented")
+}
+func (*UnimplementedAgentServer) NodeForceOffline(ctx context.Context, req *NodeRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NodeForceOffline not implemented")
+}
+func (*UnimplementedAgentServer) WatchNodes(req *Empty, srv Agent_WatchNodesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchNodes not implemented")
+}
+func (*UnimplementedAgentServer) MSCompete(ctx context.Context, req *CompeteRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MSCompete not implemented")
+}
+func (*UnimplementedAgentServer) MSGiveUp(ctx context.Context, req *GroupNodeRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MSGiveUp not implemented")
+}
+func (*UnimplementedAgentServer) MSKeepalive(ctx context.Context, req *GroupNodeRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MSKeepalive not implemented")
+}
+func (*UnimplementedAgentServer) MSElect(ctx context.Context, req *GroupRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MSElect not implemented")
+}
+func (*UnimplementedAgentServer) MSTransfer(ctx context.Context, req *TransferRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MSTransfer not implemented")
+}
+func (*UnimplementedAgentServer) MSTransferAck(ctx context.Context, req *GroupNodeRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MSTransferAck not implemented")
+}
+func (*UnimplementedAgentServer) IsMaster(ctx context.Context, req *GroupNodeRequest) (*IsMasterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IsMaster not implemented")
+}
+func (*UnimplementedAgentServer) GetMaster(ctx context.Context, req *GroupRequest) (*Master, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMaster not implemented")
+}
+func (*UnimplementedAgentServer) WaitForMaster(ctx context.Context, req *WaitForMasterRequest) (*Master, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WaitForMaster not implemented")
+}
+func (*UnimplementedAgentServer) GetCandidates(ctx context.Context, req *GroupRequest) (*CandidateList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCandidates not implemented")
+}
+func (*UnimplementedAgentServer) GetGroups(ctx context.Context, req *Empty) (*GroupList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetGroups not implemented")
+}
+func (*UnimplementedAgentServer) MSForceStepDown(ctx context.Context, req *GroupRequest) (*Master, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MSForceStepDown not implemented")
+}
+func (*UnimplementedAgentServer) WatchMaster(req *GroupRequest, srv Agent_WatchMasterServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchMaster not implemented")
+}
+
+func RegisterAgentServer(s *grpc.Server, srv AgentServer) {
+	s.RegisterService(&_Agent_serviceDesc, srv)
+}
+
+func _Agent_NodeOnline_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeOnlineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).NodeOnline(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/NodeOnline",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).NodeOnline(ctx, req.(*NodeOnlineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_NodeOffline_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).NodeOffline(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/NodeOffline",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).NodeOffline(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_NodeKeepalive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).NodeKeepalive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/NodeKeepalive",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).NodeKeepalive(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_GetAllNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).GetAllNodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/GetAllNodes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).GetAllNodes(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_GetNodeServiceAddr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).GetNodeServiceAddr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/GetNodeServiceAddr",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).GetNodeServiceAddr(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_NodeAllocateId_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllocateIdRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).NodeAllocateId(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/NodeAllocateId",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).NodeAllocateId(ctx, req.(*AllocateIdRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_NodeReleaseId_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).NodeReleaseId(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/NodeReleaseId",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).NodeReleaseId(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_WaitForNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WaitForNodesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).WaitForNodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/WaitForNodes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).WaitForNodes(ctx, req.(*WaitForNodesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_WaitForNodeCount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WaitForNodeCountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).WaitForNodeCount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/WaitForNodeCount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).WaitForNodeCount(ctx, req.(*WaitForNodeCountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_ListNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).ListNodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/ListNodes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).ListNodes(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_NodeForceOffline_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).NodeForceOffline(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/NodeForceOffline",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).NodeForceOffline(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_WatchNodes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).WatchNodes(m, &agentWatchNodesServer{stream})
+}
+
+type Agent_WatchNodesServer interface {
+	Send(*NodeEvent) error
+	grpc.ServerStream
+}
+
+type agentWatchNodesServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentWatchNodesServer) Send(m *NodeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Agent_MSCompete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompeteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).MSCompete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/MSCompete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).MSCompete(ctx, req.(*CompeteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_MSGiveUp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).MSGiveUp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/MSGiveUp",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).MSGiveUp(ctx, req.(*GroupNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_MSKeepalive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).MSKeepalive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/MSKeepalive",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).MSKeepalive(ctx, req.(*GroupNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_MSElect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).MSElect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/MSElect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).MSElect(ctx, req.(*GroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_MSTransfer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).MSTransfer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/MSTransfer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).MSTransfer(ctx, req.(*TransferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_MSTransferAck_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).MSTransferAck(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/MSTransferAck",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).MSTransferAck(ctx, req.(*GroupNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_IsMaster_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).IsMaster(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/IsMaster",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).IsMaster(ctx, req.(*GroupNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_GetMaster_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).GetMaster(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/GetMaster",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).GetMaster(ctx, req.(*GroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_WaitForMaster_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WaitForMasterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).WaitForMaster(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/WaitForMaster",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).WaitForMaster(ctx, req.(*WaitForMasterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_GetCandidates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).GetCandidates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/GetCandidates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).GetCandidates(ctx, req.(*GroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_GetGroups_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).GetGroups(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/GetGroups",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).GetGroups(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_MSForceStepDown_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).MSForceStepDown(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/etcdagent.Agent/MSForceStepDown",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).MSForceStepDown(ctx, req.(*GroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_WatchMaster_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GroupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).WatchMaster(m, &agentWatchMasterServer{stream})
+}
+
+type Agent_WatchMasterServer interface {
+	Send(*Master) error
+	grpc.ServerStream
+}
+
+type agentWatchMasterServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentWatchMasterServer) Send(m *Master) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Agent_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "etcdagent.Agent",
+	HandlerType: (*AgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "NodeOnline",
+			Handler:    _Agent_NodeOnline_Handler,
+		},
+		{
+			MethodName: "NodeOffline",
+			Handler:    _Agent_NodeOffline_Handler,
+		},
+		{
+			MethodName: "NodeKeepalive",
+			Handler:    _Agent_NodeKeepalive_Handler,
+		},
+		{
+			MethodName: "GetAllNodes",
+			Handler:    _Agent_GetAllNodes_Handler,
+		},
+		{
+			MethodName: "GetNodeServiceAddr",
+			Handler:    _Agent_GetNodeServiceAddr_Handler,
+		},
+		{
+			MethodName: "NodeAllocateId",
+			Handler:    _Agent_NodeAllocateId_Handler,
+		},
+		{
+			MethodName: "NodeReleaseId",
+			Handler:    _Agent_NodeReleaseId_Handler,
+		},
+		{
+			MethodName: "WaitForNodes",
+			Handler:    _Agent_WaitForNodes_Handler,
+		},
+		{
+			MethodName: "WaitForNodeCount",
+			Handler:    _Agent_WaitForNodeCount_Handler,
+		},
+		{
+			MethodName: "ListNodes",
+			Handler:    _Agent_ListNodes_Handler,
+		},
+		{
+			MethodName: "NodeForceOffline",
+			Handler:    _Agent_NodeForceOffline_Handler,
+		},
+		{
+			MethodName: "MSCompete",
+			Handler:    _Agent_MSCompete_Handler,
+		},
+		{
+			MethodName: "MSGiveUp",
+			Handler:    _Agent_MSGiveUp_Handler,
+		},
+		{
+			MethodName: "MSKeepalive",
+			Handler:    _Agent_MSKeepalive_Handler,
+		},
+		{
+			MethodName: "MSElect",
+			Handler:    _Agent_MSElect_Handler,
+		},
+		{
+			MethodName: "MSTransfer",
+			Handler:    _Agent_MSTransfer_Handler,
+		},
+		{
+			MethodName: "MSTransferAck",
+			Handler:    _Agent_MSTransferAck_Handler,
+		},
+		{
+			MethodName: "IsMaster",
+			Handler:    _Agent_IsMaster_Handler,
+		},
+		{
+			MethodName: "GetMaster",
+			Handler:    _Agent_GetMaster_Handler,
+		},
+		{
+			MethodName: "WaitForMaster",
+			Handler:    _Agent_WaitForMaster_Handler,
+		},
+		{
+			MethodName: "GetCandidates",
+			Handler:    _Agent_GetCandidates_Handler,
+		},
+		{
+			MethodName: "GetGroups",
+			Handler:    _Agent_GetGroups_Handler,
+		},
+		{
+			MethodName: "MSForceStepDown",
+			Handler:    _Agent_MSForceStepDown_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchNodes",
+			Handler:       _Agent_WatchNodes_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchMaster",
+			Handler:       _Agent_WatchMaster_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "agent.proto",
+}
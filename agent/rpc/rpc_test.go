@@ -0,0 +1,148 @@
+package rpc
+
+import (
+    "context"
+    "etcdagent/agent"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc"
+)
+
+const ETCDADDR = "172.100.1.239:2379"
+
+func TestParseAddress(t *testing.T) {
+    data := []struct {
+        address string
+        network string
+        path    string
+        valid   bool
+    }{
+        {"unix:/var/run/etcdagent-rpc.sock", "unix", "/var/run/etcdagent-rpc.sock", true},
+        {"unix:///tmp/agent.sock", "unix", "/tmp/agent.sock", true},
+        {"127.0.0.1:2380", "tcp", "127.0.0.1:2380", true},
+        {"localhost:2380", "tcp", "localhost:2380", true},
+        {"[::1]:2380", "tcp", "[::1]:2380", true},
+        {":2380", "", "", false},
+        {"0.0.0.0:2380", "", "", false},
+        {"192.168.0.1:2380", "", "", false},
+        {"127.0.0.1", "", "", false},
+    }
+
+    for _, d := range data {
+        network, path, err := ParseAddress(d.address)
+        if (err == nil) != d.valid || network != d.network || path != d.path {
+            t.Errorf("Test parse %q failed, expected = %v %v, acctually = %v %v, err = %v", d.address, d.network, d.path, network, path, err)
+        }
+    }
+}
+
+func TestServer(t *testing.T) {
+    a, err := agent.NewAgent([]string{ETCDADDR}, 5*time.Second)
+    if err != nil {
+        fmt.Println("New agent failed")
+        os.Exit(1)
+    }
+    defer a.Close()
+
+    dir, _ := ioutil.TempDir("", "etcdagent")
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "rpc.sock")
+
+    server := NewServer(a, RPC_UNIX_PREFIX+path)
+    go server.Serve()
+    defer server.Close()
+    <-time.After(100 * time.Millisecond)
+
+    //已有agent在运行时不能删除其socket
+    if err := NewServer(a, RPC_UNIX_PREFIX+path).Serve(); err == nil {
+        t.Errorf("Test serve on socket in use failed, expected error, acctually = nil")
+    }
+
+    conn, err := grpc.Dial(path, grpc.WithInsecure(), grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+        return net.DialTimeout("unix", addr, timeout)
+    }))
+    if err != nil {
+        t.Fatalf("Dial %v error: %v", path, err)
+    }
+    defer conn.Close()
+    client := NewAgentClient(conn)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    nodes, err := client.WatchNodes(ctx, &Empty{})
+    if err != nil {
+        t.Fatalf("Watch nodes error: %v", err)
+    }
+
+    masters, err := client.WatchMaster(ctx, &GroupRequest{Group: "rpc"})
+    if err != nil {
+        t.Fatalf("Watch master error: %v", err)
+    }
+
+    if _, err := client.NodeOnline(ctx, &NodeOnlineRequest{NodeId: 41, ServiceAddr: "10.0.0.41:8080"}); err != nil {
+        t.Errorf("Node online failed, err = %v", err)
+    }
+    defer a.NodeOffline(context.TODO(), 41)
+
+    if acctually, err := client.GetNodeServiceAddr(ctx, &NodeRequest{NodeId: 41}); err != nil || acctually.Addr != "10.0.0.41:8080" {
+        t.Errorf("Get service addr failed, expected = 10.0.0.41:8080, acctually = %v, err = %v", acctually, err)
+    }
+
+    //订阅时先收到已在线node，再收到上线事件
+    for {
+        e, err := nodes.Recv()
+        if err != nil {
+            t.Fatalf("Receive node event error: %v", err)
+        }
+        if e.NodeId == 41 {
+            if e.Type != NodeEvent_ONLINE || e.ServiceAddr != "10.0.0.41:8080" {
+                t.Errorf("Test watch nodes failed, acctually = %v", e)
+            }
+            break
+        }
+    }
+
+    if _, err := client.MSCompete(ctx, &CompeteRequest{Group: "rpc", NodeId: 41, Priority: 1}); err != nil {
+        t.Errorf("MS compete failed, err = %v", err)
+    }
+    defer a.MSGiveUp(context.TODO(), "rpc", 41)
+
+    if acctually, err := client.WaitForMaster(ctx, &WaitForMasterRequest{Group: "rpc", TimeoutMs: 3000}); err != nil || acctually.NodeId != 41 {
+        t.Errorf("Wait for master failed, expected = 41, acctually = %v, err = %v", acctually, err)
+    }
+
+    if acctually, err := client.IsMaster(ctx, &GroupNodeRequest{Group: "rpc", NodeId: 41}); err != nil || !acctually.Master {
+        t.Errorf("Is master failed, acctually = %v, err = %v", acctually, err)
+    }
+
+    for {
+        m, err := masters.Recv()
+        if err != nil {
+            t.Fatalf("Receive master error: %v", err)
+        }
+        if m.NodeId == 41 {
+            break
+        }
+    }
+
+    if _, err := client.NodeOffline(ctx, &NodeRequest{NodeId: 41}); err != nil {
+        t.Errorf("Node offline failed, err = %v", err)
+    }
+
+    for {
+        e, err := nodes.Recv()
+        if err != nil {
+            t.Fatalf("Receive node event error: %v", err)
+        }
+        if e.NodeId == 41 && e.Type == NodeEvent_OFFLINE {
+            break
+        }
+    }
+}
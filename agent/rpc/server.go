@@ -0,0 +1,319 @@
+package rpc
+
+//go:generate protoc --go_out=plugins=grpc:. agent.proto
+
+import (
+    "context"
+    "etcdagent/agent"
+    "etcdagent/agent/ipc"
+    "etcdagent/agent/log"
+    "etcdagent/agent/ms"
+    "etcdagent/agent/node"
+    "fmt"
+    "net"
+    "strings"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+const (
+    RPC_UNIX_PREFIX = "unix:"
+)
+
+//通过gRPC提供与node.Node、ms.MS一致的接口，供Go、Python等非C服务使用
+type Server interface {
+    Serve() error
+    Close() error
+}
+
+type server struct {
+    agent   *agent.Agent
+    address string
+    grpc    *grpc.Server
+}
+
+type service struct {
+    agent *agent.Agent
+}
+
+func NewServer(a *agent.Agent, address string) Server {
+    s := &server{
+        agent:   a,
+        address: address,
+        grpc:    grpc.NewServer(),
+    }
+    RegisterAgentServer(s.grpc, &service{agent: a})
+    return s
+}
+
+//address为 unix:<路径> 或者 <host>:<port>
+//接口没有认证，host只能是localhost或者回环地址，不能监听其他网卡
+func ParseAddress(address string) (string, string, error) {
+    if strings.HasPrefix(address, RPC_UNIX_PREFIX) {
+        path := "/" + strings.TrimLeft(strings.TrimPrefix(address, RPC_UNIX_PREFIX), "/")
+        return "unix", path, nil
+    }
+
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return "", "", fmt.Errorf("Invalid rpc address %q, expected unix:<path> or <host>:<port>", address)
+    }
+
+    if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+        return "", "", fmt.Errorf("Invalid rpc address %q, host must be localhost or a loopback address", address)
+    }
+    return "tcp", address, nil
+}
+
+func (s *server) Serve() error {
+    network, address, err := ParseAddress(s.address)
+    if err != nil {
+        return err
+    }
+
+    var l net.Listener
+    if network == "unix" {
+        l, err = ipc.ListenUnix(address)
+    } else {
+        l, err = net.Listen(network, address)
+    }
+    if err != nil {
+        log.Warn("Listen on %v error, reason: %v", s.address, err.Error())
+        return err
+    }
+
+    log.Info("Serve grpc on %v", s.address)
+    return s.grpc.Serve(l)
+}
+
+func (s *server) Close() error {
+    s.grpc.Stop()
+    return nil
+}
+
+//冲突、超时和取消映射为对应的gRPC状态码，其余错误为Unknown
+func toStatus(err error) error {
+    if err == nil {
+        return nil
+    }
+
+    if _, ok := err.(*node.ConflictError); ok {
+        return status.Error(codes.AlreadyExists, err.Error())
+    }
+
+    if _, ok := err.(*ms.ConflictError); ok {
+        return status.Error(codes.AlreadyExists, err.Error())
+    }
+
+    if err == context.DeadlineExceeded {
+        return status.Error(codes.DeadlineExceeded, err.Error())
+    }
+
+    if err == context.Canceled {
+        return status.Error(codes.Canceled, err.Error())
+    }
+    return status.Error(codes.Unknown, err.Error())
+}
+
+//etcd的Watch中断时通知客户端重新订阅
+func watchClosed(ctx context.Context) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    return status.Error(codes.Unavailable, "Watch closed by etcd, please watch again")
+}
+
+func milliseconds(ms uint32) time.Duration {
+    return time.Duration(ms) * time.Millisecond
+}
+
+func (s *service) NodeOnline(ctx context.Context, req *NodeOnlineRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.NodeOnline(ctx, req.NodeId, req.ServiceAddr))
+}
+
+func (s *service) NodeOffline(ctx context.Context, req *NodeRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.NodeOffline(ctx, req.NodeId))
+}
+
+func (s *service) NodeKeepalive(ctx context.Context, req *NodeRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.NodeKeepalive(ctx, req.NodeId))
+}
+
+func (s *service) GetAllNodes(ctx context.Context, req *Empty) (*NodeList, error) {
+    nodes, err := s.agent.GetAllNodes(ctx)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+    return &NodeList{NodeIds: nodes}, nil
+}
+
+func (s *service) GetNodeServiceAddr(ctx context.Context, req *NodeRequest) (*ServiceAddr, error) {
+    addr, err := s.agent.GetNodeServiceAddr(ctx, req.NodeId)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+    return &ServiceAddr{Addr: addr}, nil
+}
+
+func (s *service) NodeAllocateId(ctx context.Context, req *AllocateIdRequest) (*NodeRequest, error) {
+    nodeId, err := s.agent.NodeAllocateId(ctx, req.Identity)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+    return &NodeRequest{NodeId: nodeId}, nil
+}
+
+func (s *service) NodeReleaseId(ctx context.Context, req *NodeRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.NodeReleaseId(req.NodeId))
+}
+
+func (s *service) WaitForNodes(ctx context.Context, req *WaitForNodesRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.WaitForNodes(ctx, req.NodeIds, milliseconds(req.TimeoutMs)))
+}
+
+func (s *service) WaitForNodeCount(ctx context.Context, req *WaitForNodeCountRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.WaitForNodeCount(ctx, int(req.Count), milliseconds(req.TimeoutMs)))
+}
+
+func (s *service) ListNodes(ctx context.Context, req *Empty) (*NodeInfoList, error) {
+    nodes, err := s.agent.ListNodes(ctx)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    list := &NodeInfoList{}
+    for _, n := range nodes {
+        list.Nodes = append(list.Nodes, &NodeInfo{
+            NodeId:      n.NodeId,
+            ServiceAddr: n.ServiceAddr,
+            Lease:       int64(n.Lease),
+            Ttl:         n.TTL,
+        })
+    }
+    return list, nil
+}
+
+func (s *service) NodeForceOffline(ctx context.Context, req *NodeRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.NodeForceOffline(ctx, req.NodeId))
+}
+
+func (s *service) WatchNodes(req *Empty, stream Agent_WatchNodesServer) error {
+    events, err := s.agent.WatchNodes(stream.Context())
+    if err != nil {
+        return toStatus(err)
+    }
+
+    for e := range events {
+        if err := stream.Send(&NodeEvent{
+            Type:        NodeEvent_Type(e.Type),
+            NodeId:      e.NodeId,
+            ServiceAddr: e.ServiceAddr,
+            Revision:    e.Revision,
+        }); err != nil {
+            return err
+        }
+    }
+    return watchClosed(stream.Context())
+}
+
+func (s *service) MSCompete(ctx context.Context, req *CompeteRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.MSCompete(ctx, req.Group, req.NodeId, req.Priority))
+}
+
+func (s *service) MSGiveUp(ctx context.Context, req *GroupNodeRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.MSGiveUp(ctx, req.Group, req.NodeId))
+}
+
+func (s *service) MSKeepalive(ctx context.Context, req *GroupNodeRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.MSKeepalive(ctx, req.Group, req.NodeId))
+}
+
+func (s *service) MSElect(ctx context.Context, req *GroupRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.MSElect(ctx, req.Group))
+}
+
+func (s *service) MSTransfer(ctx context.Context, req *TransferRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.MSTransfer(ctx, req.Group, req.From, req.To, milliseconds(req.TimeoutMs)))
+}
+
+func (s *service) MSTransferAck(ctx context.Context, req *GroupNodeRequest) (*Empty, error) {
+    return &Empty{}, toStatus(s.agent.MSTransferAck(ctx, req.Group, req.NodeId))
+}
+
+func (s *service) IsMaster(ctx context.Context, req *GroupNodeRequest) (*IsMasterResponse, error) {
+    master, deadline := s.agent.IsMasterUntil(ctx, req.Group, req.NodeId)
+    resp := &IsMasterResponse{Master: master}
+    if master {
+        resp.RemainMs = -1
+        if !deadline.IsZero() {
+            resp.RemainMs = int64(time.Until(deadline) / time.Millisecond)
+        }
+    }
+    return resp, nil
+}
+
+func (s *service) GetMaster(ctx context.Context, req *GroupRequest) (*Master, error) {
+    master, err := s.agent.GetMaster(ctx, req.Group)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+    return &Master{Group: req.Group, NodeId: master}, nil
+}
+
+func (s *service) WaitForMaster(ctx context.Context, req *WaitForMasterRequest) (*Master, error) {
+    master, err := s.agent.WaitForMaster(ctx, req.Group, milliseconds(req.TimeoutMs))
+    if err != nil {
+        return nil, toStatus(err)
+    }
+    return &Master{Group: req.Group, NodeId: master}, nil
+}
+
+func (s *service) GetCandidates(ctx context.Context, req *GroupRequest) (*CandidateList, error) {
+    candidates, err := s.agent.GetCandidates(ctx, req.Group)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    list := &CandidateList{}
+    for _, c := range candidates {
+        list.Candidates = append(list.Candidates, &Candidate{
+            NodeId:   c.NodeId,
+            Priority: c.Priority,
+            Revision: c.Revision,
+        })
+    }
+    return list, nil
+}
+
+func (s *service) GetGroups(ctx context.Context, req *Empty) (*GroupList, error) {
+    groups, err := s.agent.GetGroups(ctx)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+    return &GroupList{Groups: groups}, nil
+}
+
+func (s *service) MSForceStepDown(ctx context.Context, req *GroupRequest) (*Master, error) {
+    master, err := s.agent.MSForceStepDown(ctx, req.Group)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+    return &Master{Group: req.Group, NodeId: master}, nil
+}
+
+func (s *service) WatchMaster(req *GroupRequest, stream Agent_WatchMasterServer) error {
+    masters, err := s.agent.WatchMaster(stream.Context(), req.Group)
+    if err != nil {
+        return toStatus(err)
+    }
+
+    for m := range masters {
+        if err := stream.Send(&Master{Group: m.Group, NodeId: m.NodeId, Revision: m.Revision}); err != nil {
+            return err
+        }
+    }
+    return watchClosed(stream.Context())
+}
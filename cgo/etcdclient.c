@@ -0,0 +1,685 @@
+#include <sys/socket.h>
+#include <sys/un.h>
+#include <pthread.h>
+#include <unistd.h>
+#include <stdarg.h>
+#include <stdlib.h>
+#include <string.h>
+#include <errno.h>
+#include <stdio.h>
+#include <stdint.h>
+#include <inttypes.h>
+#include "etcdclient.h"
+
+#define ETCDC_MAX_RESULTS 128
+#define ETCDC_MAX_ERROR 256
+
+struct _EtcdClient
+{
+    int fd;
+    pthread_mutex_t lock;
+    char buf[ETCDC_MAX_LINE];  //已读取但未处理的数据
+    size_t len;
+    char line[ETCDC_MAX_LINE]; //最近一次响应，结果指向其中
+    char error[ETCDC_MAX_ERROR];
+};
+
+/*
+ * 参数中的 '%' 和空白字符（空格、'\t'、'\n'、'\v'、'\f'、'\r'）转义为 %XX，空字符串编码为 "-"，与agent/ipc/protocol.go一致
+ */
+static int Escape(const char *in, char *out, size_t size)
+{
+    size_t n = 0;
+    if (in[0] == '\0' || strcmp(in, "-") == 0)
+    {
+        if (size < 4)
+        {
+            return -1;
+        }
+        strcpy(out, in[0] == '\0' ? "-" : "%2D");
+        return 0;
+    }
+
+    for (; *in != '\0'; in++)
+    {
+        if (n + 4 > size)
+        {
+            return -1;
+        }
+
+        if (*in == '%' || *in == ' ' || *in == '\t' || *in == '\n' || *in == '\v' || *in == '\f' || *in == '\r')
+        {
+            n += sprintf(out + n, "%%%02X", (unsigned char)*in);
+        }
+        else
+        {
+            out[n++] = *in;
+        }
+    }
+    out[n] = '\0';
+    return 0;
+}
+
+static void Unescape(char *s)
+{
+    char *out = s;
+    if (strcmp(s, "-") == 0)
+    {
+        s[0] = '\0';
+        return;
+    }
+
+    for (; *s != '\0'; s++)
+    {
+        unsigned int c;
+        if (*s == '%' && sscanf(s + 1, "%2x", &c) == 1)
+        {
+            *out++ = (char)c;
+            s += 2;
+        }
+        else
+        {
+            *out++ = *s;
+        }
+    }
+    *out = '\0';
+}
+
+static int WriteAll(int fd, const char *buf, size_t len)
+{
+    while (len > 0)
+    {
+        ssize_t n = write(fd, buf, len);
+        if (n < 0)
+        {
+            if (errno == EINTR)
+            {
+                continue;
+            }
+            return -1;
+        }
+        buf += n;
+        len -= n;
+    }
+    return 0;
+}
+
+/*
+ * 读取一行到client->line，去掉结尾的'\n'
+ */
+static int ReadLine(EtcdClient *client)
+{
+    for (;;)
+    {
+        char *end = memchr(client->buf, '\n', client->len);
+        if (end != NULL)
+        {
+            size_t n = end - client->buf;
+            memcpy(client->line, client->buf, n);
+            client->line[n] = '\0';
+            client->len -= n + 1;
+            memmove(client->buf, end + 1, client->len);
+            return 0;
+        }
+
+        if (client->len == sizeof(client->buf))
+        {
+            errno = EMSGSIZE;
+            return -1;
+        }
+
+        ssize_t n = read(client->fd, client->buf + client->len, sizeof(client->buf) - client->len);
+        if (n < 0 && errno == EINTR)
+        {
+            continue;
+        }
+        if (n <= 0)
+        {
+            if (n == 0)
+            {
+                errno = ECONNRESET;
+            }
+            return -1;
+        }
+        client->len += n;
+    }
+}
+
+/*
+ * 按空格拆分client->line并反转义，返回字段个数
+ */
+static int SplitLine(EtcdClient *client, char **fields, int max)
+{
+    int n = 0;
+    char *save = NULL;
+    char *token = strtok_r(client->line, " ", &save);
+    for (; token != NULL && n < max; token = strtok_r(NULL, " ", &save))
+    {
+        Unescape(token);
+        fields[n++] = token;
+    }
+    return n;
+}
+
+static int Fail(EtcdClient *client, const char *reason)
+{
+    snprintf(client->error, sizeof(client->error), "%s: %s", reason, strerror(errno));
+    return ETCDC_ERROR;
+}
+
+/*
+ * 发送请求并等待响应，参数以NULL结尾，调用者需持有client->lock
+ * 成功时results指向响应中的结果，失败时client->error记录原因
+ */
+static int Call(EtcdClient *client, char **results, int *count, const char *command, ...)
+{
+    char request[ETCDC_MAX_LINE];
+    size_t n = strlen(command);
+    if (n + 2 > sizeof(request))
+    {
+        errno = EMSGSIZE;
+        return Fail(client, "Build request error");
+    }
+    strcpy(request, command);
+
+    va_list args;
+    va_start(args, command);
+    const char *arg = va_arg(args, const char *);
+    for (; arg != NULL; arg = va_arg(args, const char *))
+    {
+        request[n++] = ' ';
+        if (Escape(arg, request + n, sizeof(request) - n - 1) != 0)
+        {
+            va_end(args);
+            errno = EMSGSIZE;
+            return Fail(client, "Build request error");
+        }
+        n += strlen(request + n);
+    }
+    va_end(args);
+    request[n++] = '\n';
+
+    if (WriteAll(client->fd, request, n) != 0)
+    {
+        return Fail(client, "Send request error");
+    }
+
+    if (ReadLine(client) != 0)
+    {
+        return Fail(client, "Read response error");
+    }
+
+    char *fields[ETCDC_MAX_RESULTS + 1];
+    int nfields = SplitLine(client, fields, ETCDC_MAX_RESULTS + 1);
+    if (nfields == 0)
+    {
+        errno = EPROTO;
+        return Fail(client, "Invalid response");
+    }
+
+    int code = atoi(fields[0]);
+    if (code != ETCDC_SUCCESS)
+    {
+        snprintf(client->error, sizeof(client->error), "%s", nfields > 1 ? fields[1] : "");
+        return code;
+    }
+
+    if (results != NULL)
+    {
+        int i = 1;
+        for (; i < nfields; i++)
+        {
+            results[i - 1] = fields[i];
+        }
+    }
+    if (count != NULL)
+    {
+        *count = nfields - 1;
+    }
+    client->error[0] = '\0';
+    return ETCDC_SUCCESS;
+}
+
+EtcdClient *EtcdcConnect(const char *path)
+{
+    struct sockaddr_un addr;
+    if (path == NULL)
+    {
+        path = ETCDC_DEFAULT_SOCKET;
+    }
+
+    if (strlen(path) >= sizeof(addr.sun_path))
+    {
+        errno = ENAMETOOLONG;
+        return NULL;
+    }
+
+    EtcdClient *client = malloc(sizeof(EtcdClient));
+    if (client == NULL)
+    {
+        return NULL;
+    }
+    memset(client, 0, sizeof(EtcdClient));
+
+    client->fd = socket(AF_UNIX, SOCK_STREAM, 0);
+    if (client->fd < 0)
+    {
+        free(client);
+        return NULL;
+    }
+
+    memset(&addr, 0, sizeof(addr));
+    addr.sun_family = AF_UNIX;
+    strcpy(addr.sun_path, path);
+    if (connect(client->fd, (struct sockaddr *)&addr, sizeof(addr)) != 0)
+    {
+        int err = errno;
+        close(client->fd);
+        free(client);
+        errno = err;
+        return NULL;
+    }
+
+    pthread_mutex_init(&client->lock, NULL);
+    return client;
+}
+
+void EtcdcClose(EtcdClient *client)
+{
+    if (client == NULL)
+    {
+        return;
+    }
+
+    close(client->fd);
+    pthread_mutex_destroy(&client->lock);
+    free(client);
+}
+
+int EtcdcFd(EtcdClient *client)
+{
+    return client->fd;
+}
+
+/*
+ * 最近一次失败的原因，仅在同一线程中紧接失败的调用之后有效
+ */
+const char *EtcdcLastError(EtcdClient *client)
+{
+    return client->error;
+}
+
+static int CallNode(EtcdClient *client, const char *command, uint32_t nodeId)
+{
+    char id[16];
+    snprintf(id, sizeof(id), "%u", nodeId);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, NULL, NULL, command, id, NULL);
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+static int CallGroup(EtcdClient *client, const char *command, const char *group, uint32_t nodeId)
+{
+    char id[16];
+    snprintf(id, sizeof(id), "%u", nodeId);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, NULL, NULL, command, group, id, NULL);
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+static int ParseUint32(EtcdClient *client, const char *s, uint32_t *value)
+{
+    char *end = NULL;
+    unsigned long v = strtoul(s, &end, 10);
+    if (s[0] == '\0' || *end != '\0' || v > UINT32_MAX)
+    {
+        snprintf(client->error, sizeof(client->error), "Invalid number in response: %s", s);
+        return ETCDC_ERROR;
+    }
+
+    if (value != NULL)
+    {
+        *value = (uint32_t)v;
+    }
+    return ETCDC_SUCCESS;
+}
+
+int EtcdcNodeOnline(EtcdClient *client, uint32_t nodeId, const char *serviceAddr)
+{
+    char id[16];
+    snprintf(id, sizeof(id), "%u", nodeId);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, NULL, NULL, "NODE_ONLINE", id, serviceAddr, NULL);
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcNodeOffline(EtcdClient *client, uint32_t nodeId)
+{
+    return CallNode(client, "NODE_OFFLINE", nodeId);
+}
+
+int EtcdcNodeKeepalive(EtcdClient *client, uint32_t nodeId)
+{
+    return CallNode(client, "NODE_KEEPALIVE", nodeId);
+}
+
+int EtcdcGetAllNodes(EtcdClient *client, struct Nodes *nodes)
+{
+    char *results[ETCDC_MAX_RESULTS];
+    int count = 0;
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, results, &count, "NODE_LIST", NULL);
+    if (ret == ETCDC_SUCCESS)
+    {
+        int i = 0;
+        memset(nodes, 0, sizeof(struct Nodes));
+        for (; i < count && nodes->length < sizeof(nodes->nodes) / sizeof(nodes->nodes[0]); i++)
+        {
+            uint32_t nodeId;
+            if ((ret = ParseUint32(client, results[i], &nodeId)) != ETCDC_SUCCESS)
+            {
+                break;
+            }
+            nodes->nodes[nodes->length++] = nodeId;
+        }
+    }
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcGetNodeServiceAddr(EtcdClient *client, uint32_t nodeId, struct ServiceAddr *addr)
+{
+    char *results[ETCDC_MAX_RESULTS];
+    int count = 0;
+    char id[16];
+    snprintf(id, sizeof(id), "%u", nodeId);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, results, &count, "NODE_ADDR", id, NULL);
+    if (ret == ETCDC_SUCCESS)
+    {
+        memset(addr, 0, sizeof(struct ServiceAddr));
+        if (count > 0)
+        {
+            strncpy(addr->addr, results[0], sizeof(addr->addr) - 1);
+            addr->length = strlen(addr->addr);
+        }
+    }
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcNodeAllocateId(EtcdClient *client, const char *identity, uint32_t *nodeId)
+{
+    char *results[ETCDC_MAX_RESULTS];
+    int count = 0;
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, results, &count, "NODE_ALLOCATE", identity == NULL ? "" : identity, NULL);
+    if (ret == ETCDC_SUCCESS && count > 0)
+    {
+        ret = ParseUint32(client, results[0], nodeId);
+    }
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcNodeReleaseId(EtcdClient *client, uint32_t nodeId)
+{
+    return CallNode(client, "NODE_RELEASE", nodeId);
+}
+
+/*
+ * nodeId列表只包含数字，不需要转义，直接拼接到命令中
+ */
+int EtcdcWaitForNodes(EtcdClient *client, const uint32_t *ids, uint32_t count, uint32_t timeoutMs)
+{
+    char command[ETCDC_MAX_LINE];
+    size_t n = snprintf(command, sizeof(command), "NODE_WAIT %u", timeoutMs);
+    uint32_t i = 0;
+    for (; i < count; i++)
+    {
+        n += snprintf(command + n, sizeof(command) - n, " %u", ids[i]);
+        if (n >= sizeof(command))
+        {
+            snprintf(client->error, sizeof(client->error), "Too many nodes: %u", count);
+            return ETCDC_ERROR;
+        }
+    }
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, NULL, NULL, command, NULL);
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcWaitForNodeCount(EtcdClient *client, uint32_t count, uint32_t timeoutMs)
+{
+    char ncount[16];
+    char timeout[16];
+    snprintf(ncount, sizeof(ncount), "%u", count);
+    snprintf(timeout, sizeof(timeout), "%u", timeoutMs);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, NULL, NULL, "NODE_WAIT_COUNT", ncount, timeout, NULL);
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcMSCompete(EtcdClient *client, const char *group, uint32_t nodeId, uint32_t priority)
+{
+    char id[16];
+    char prio[16];
+    snprintf(id, sizeof(id), "%u", nodeId);
+    snprintf(prio, sizeof(prio), "%u", priority);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, NULL, NULL, "MS_COMPETE", group, id, prio, NULL);
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcMSGiveUp(EtcdClient *client, const char *group, uint32_t nodeId)
+{
+    return CallGroup(client, "MS_GIVEUP", group, nodeId);
+}
+
+int EtcdcMSKeepalive(EtcdClient *client, const char *group, uint32_t nodeId)
+{
+    return CallGroup(client, "MS_KEEPALIVE", group, nodeId);
+}
+
+int EtcdcMSTransfer(EtcdClient *client, const char *group, uint32_t from, uint32_t to, uint32_t timeoutMs)
+{
+    char sfrom[16];
+    char sto[16];
+    char timeout[16];
+    snprintf(sfrom, sizeof(sfrom), "%u", from);
+    snprintf(sto, sizeof(sto), "%u", to);
+    snprintf(timeout, sizeof(timeout), "%u", timeoutMs);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, NULL, NULL, "MS_TRANSFER", group, sfrom, sto, timeout, NULL);
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcMSTransferAck(EtcdClient *client, const char *group, uint32_t nodeId)
+{
+    return CallGroup(client, "MS_TRANSFER_ACK", group, nodeId);
+}
+
+int EtcdcIsMaster(EtcdClient *client, const char *group, uint32_t nodeId, int *master)
+{
+    char *results[ETCDC_MAX_RESULTS];
+    int count = 0;
+    char id[16];
+    snprintf(id, sizeof(id), "%u", nodeId);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, results, &count, "MS_IS_MASTER", group, id, NULL);
+    if (ret == ETCDC_SUCCESS && master != NULL)
+    {
+        *master = count > 0 && strcmp(results[0], "1") == 0;
+    }
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+/*
+ * remainMs与EtcdIsMasterUntil一致：剩余毫秒数，不是master时为0，无法估算时为-1
+ */
+int EtcdcIsMasterUntil(EtcdClient *client, const char *group, uint32_t nodeId, int64_t *remainMs)
+{
+    char *results[ETCDC_MAX_RESULTS];
+    int count = 0;
+    char id[16];
+    snprintf(id, sizeof(id), "%u", nodeId);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, results, &count, "MS_IS_MASTER_UNTIL", group, id, NULL);
+    if (ret == ETCDC_SUCCESS && remainMs != NULL)
+    {
+        *remainMs = count > 0 ? strtoll(results[0], NULL, 10) : 0;
+    }
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcGetMaster(EtcdClient *client, const char *group, uint32_t *master)
+{
+    char *results[ETCDC_MAX_RESULTS];
+    int count = 0;
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, results, &count, "MS_GET_MASTER", group, NULL);
+    if (ret == ETCDC_SUCCESS && count > 0)
+    {
+        ret = ParseUint32(client, results[0], master);
+    }
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcWaitForMaster(EtcdClient *client, const char *group, uint32_t timeoutMs, uint32_t *master)
+{
+    char *results[ETCDC_MAX_RESULTS];
+    int count = 0;
+    char timeout[16];
+    snprintf(timeout, sizeof(timeout), "%u", timeoutMs);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, results, &count, "MS_WAIT_MASTER", group, timeout, NULL);
+    if (ret == ETCDC_SUCCESS && count > 0)
+    {
+        ret = ParseUint32(client, results[0], master);
+    }
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+int EtcdcSubscribe(EtcdClient *client)
+{
+    return EtcdcSubscribeFilter(client, "");
+}
+
+/*
+ * filter格式：category=node,ms;node=1,2;key=Master/billing，为空时接收所有事件，格式与event.ParseFilter一致
+ */
+int EtcdcSubscribeFilter(EtcdClient *client, const char *filter)
+{
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, NULL, NULL, "SUBSCRIBE", filter == NULL ? "" : filter, NULL);
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+/*
+ * agent未启用journal时返回ETCDC_ERROR，revision之后的事件已不在journal中时返回ETCDC_COMPACTED
+ */
+int EtcdcSubscribeSince(EtcdClient *client, const char *filter, int64_t revision)
+{
+    char rev[24];
+    snprintf(rev, sizeof(rev), "%lld", (long long)revision);
+
+    pthread_mutex_lock(&client->lock);
+    int ret = Call(client, NULL, NULL, "SUBSCRIBE", filter == NULL ? "" : filter, rev, NULL);
+    pthread_mutex_unlock(&client->lock);
+    return ret;
+}
+
+/*
+ * 缓冲区中是否已有完整的事件，有则EtcdcReadEvent不会阻塞
+ * 使用poll/select时，需要先读完缓冲区中的事件再等待EtcdcFd可读
+ */
+int EtcdcPending(EtcdClient *client)
+{
+    pthread_mutex_lock(&client->lock);
+    int pending = memchr(client->buf, '\n', client->len) != NULL;
+    pthread_mutex_unlock(&client->lock);
+    return pending;
+}
+
+/*
+ * 事件行格式：EVENT <类型> <key> <value> <类别> <nodeId> <完整key> <删除原因> <之前的value> <modRevision> <createRevision> <lease> <时间戳>
+ * 超长的key和value被截断
+ */
+int EtcdcReadEvent(EtcdClient *client, Event *event)
+{
+    char *fields[13];
+
+    pthread_mutex_lock(&client->lock);
+    if (ReadLine(client) != 0)
+    {
+        int ret = Fail(client, "Read event error");
+        pthread_mutex_unlock(&client->lock);
+        return ret;
+    }
+
+    int n = SplitLine(client, fields, 13);
+    if (n < 4 || strcmp(fields[0], "EVENT") != 0)
+    {
+        snprintf(client->error, sizeof(client->error), "Invalid event");
+        pthread_mutex_unlock(&client->lock);
+        return ETCDC_ERROR;
+    }
+
+    memset(event, 0, sizeof(Event));
+    event->type = (uint8_t)atoi(fields[1]);
+    strncpy(event->key, fields[2], MAX_KEYLENGTH - 1);
+    strncpy(event->value, fields[3], MAX_VALUELENGTH - 1);
+
+    /* 旧版本的agent不发送类别、nodeId、完整key、删除原因、之前的value和revision */
+    if (n >= 7)
+    {
+        event->category = (uint8_t)atoi(fields[4]);
+        if (fields[5][0] != '\0' && ParseUint32(client, fields[5], &event->nodeId) == ETCDC_SUCCESS)
+        {
+            event->flags |= EVENT_FLAG_NODEID;
+        }
+        strncpy(event->fullKey, fields[6], MAX_FULLKEYLENGTH - 1);
+    }
+
+    if (n >= 9)
+    {
+        event->cause = (uint8_t)atoi(fields[7]);
+        strncpy(event->prevValue, fields[8], MAX_VALUELENGTH - 1);
+    }
+
+    if (n == 13)
+    {
+        event->modRevision = strtoll(fields[9], NULL, 10);
+        event->createRevision = strtoll(fields[10], NULL, 10);
+        event->lease = strtoll(fields[11], NULL, 10);
+        event->timestamp = strtoll(fields[12], NULL, 10);
+    }
+    pthread_mutex_unlock(&client->lock);
+    return ETCDC_SUCCESS;
+}
@@ -0,0 +1,64 @@
+#ifndef ETCDCLIENT_H
+#define ETCDCLIENT_H
+
+#include <stdint.h>
+#include "node.h"
+#include "mq.h"
+
+#define ETCDC_DEFAULT_SOCKET "/var/run/etcdagent.sock"
+#define ETCDC_MAX_LINE 8192 /* 与agent/ipc/protocol.go中的IPC_MAX_LINE一致 */
+
+/* 返回值与libetcd一致 */
+#define ETCDC_SUCCESS 0
+#define ETCDC_ERROR 1
+#define ETCDC_CONFLICT 2
+#define ETCDC_BUSY 3
+#define ETCDC_TIMEOUT 4
+#define ETCDC_COMPACTED 5 /* journal中已没有指定revision之后的事件，需要重新读取全量状态 */
+
+/*
+ * 通过Unix socket访问本机的etcdagent守护进程
+ * 1）同一个client的请求串行执行，可以在多个线程中共享
+ * 2）Wait类接口会阻塞该client上的其他请求，建议单独使用一个client
+ * 3）client关闭或进程退出时，守护进程下线该client注册的node并放弃选主
+ */
+typedef struct _EtcdClient EtcdClient;
+
+EtcdClient *EtcdcConnect(const char *path);
+void EtcdcClose(EtcdClient *client);
+int EtcdcFd(EtcdClient *client);
+const char *EtcdcLastError(EtcdClient *client);
+
+int EtcdcNodeOnline(EtcdClient *client, uint32_t nodeId, const char *serviceAddr);
+int EtcdcNodeOffline(EtcdClient *client, uint32_t nodeId);
+int EtcdcNodeKeepalive(EtcdClient *client, uint32_t nodeId);
+int EtcdcGetAllNodes(EtcdClient *client, struct Nodes *nodes);
+int EtcdcGetNodeServiceAddr(EtcdClient *client, uint32_t nodeId, struct ServiceAddr *addr);
+int EtcdcNodeAllocateId(EtcdClient *client, const char *identity, uint32_t *nodeId);
+int EtcdcNodeReleaseId(EtcdClient *client, uint32_t nodeId);
+int EtcdcWaitForNodes(EtcdClient *client, const uint32_t *ids, uint32_t count, uint32_t timeoutMs);
+int EtcdcWaitForNodeCount(EtcdClient *client, uint32_t count, uint32_t timeoutMs);
+
+int EtcdcMSCompete(EtcdClient *client, const char *group, uint32_t nodeId, uint32_t priority);
+int EtcdcMSGiveUp(EtcdClient *client, const char *group, uint32_t nodeId);
+int EtcdcMSKeepalive(EtcdClient *client, const char *group, uint32_t nodeId);
+int EtcdcMSTransfer(EtcdClient *client, const char *group, uint32_t from, uint32_t to, uint32_t timeoutMs);
+int EtcdcMSTransferAck(EtcdClient *client, const char *group, uint32_t nodeId);
+int EtcdcIsMaster(EtcdClient *client, const char *group, uint32_t nodeId, int *master);
+int EtcdcIsMasterUntil(EtcdClient *client, const char *group, uint32_t nodeId, int64_t *remainMs);
+int EtcdcGetMaster(EtcdClient *client, const char *group, uint32_t *master);
+int EtcdcWaitForMaster(EtcdClient *client, const char *group, uint32_t timeoutMs, uint32_t *master);
+
+/*
+ * 订阅之后该client只能用于接收事件，事件内容与MQ中的Event一致
+ * EtcdcSubscribeFilter只接收满足条件的事件，如 "category=node;node=3,4"
+ * EtcdcSubscribeSince先推送journal中revision之后的事件，用于消费者重启后从上次处理的event->modRevision继续
+ * EtcdcReadEvent阻塞等待下一个事件，也可以配合EtcdcPending对EtcdcFd使用poll/select
+ */
+int EtcdcSubscribe(EtcdClient *client);
+int EtcdcSubscribeFilter(EtcdClient *client, const char *filter);
+int EtcdcSubscribeSince(EtcdClient *client, const char *filter, int64_t revision);
+int EtcdcReadEvent(EtcdClient *client, Event *event);
+int EtcdcPending(EtcdClient *client);
+
+#endif
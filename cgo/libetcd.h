@@ -69,27 +69,110 @@ extern "C" {
 #endif
 
 
-extern void EtcdAgentInit(GoString p0);
+extern GoInt EtcdAgentInit(GoString p0);
+extern GoInt EtcdAgentInitFromFile(GoString p0);
 
 extern GoInt EtcdNodeOnline(GoUint32 p0, GoString p1);
 
+extern GoInt EtcdNodeOnlineTimeout(GoUint32 p0, GoString p1, GoUint32 p2);
+
 extern GoInt EtcdNodeKeepalive(GoUint32 p0);
 
+extern GoInt EtcdNodeKeepaliveTimeout(GoUint32 p0, GoUint32 p1);
+
 extern GoInt EtcdNodeOffline(GoUint32 p0);
 
+extern GoInt EtcdNodeOfflineTimeout(GoUint32 p0, GoUint32 p1);
+
 extern struct Nodes* EtcdGetAllNodes();
 
+extern struct Nodes* EtcdGetAllNodesTimeout(GoUint32 p0);
+
 extern struct ServiceAddr* EtcdGetNodeServiceAddr(GoUint32 p0);
 
-extern GoInt EtcdMSCompete(GoUint32 p0);
+extern struct ServiceAddr* EtcdGetNodeServiceAddrTimeout(GoUint32 p0, GoUint32 p1);
+
+extern GoInt EtcdNodeAudit(GoUint8 p0, void** p1);
+
+extern GoInt EtcdNodeAllocateId(GoString p0, GoUint32* p1);
+
+extern GoInt EtcdNodeReleaseId(GoUint32 p0);
+
+extern void EtcdNodeSetIdGrace(GoUint32 p0);
+
+extern GoInt EtcdMSCompete(GoString p0, GoUint32 p1, GoUint32 p2);
+
+extern GoInt EtcdMSCompeteTimeout(GoString p0, GoUint32 p1, GoUint32 p2, GoUint32 p3);
+
+extern void EtcdMSSetPreempt(GoUint8 p0, GoUint32 p1);
+
+extern GoInt EtcdMSGiveUp(GoString p0, GoUint32 p1);
+
+extern GoInt EtcdMSGiveUpTimeout(GoString p0, GoUint32 p1, GoUint32 p2);
+
+extern GoInt EtcdMSTransfer(GoString p0, GoUint32 p1, GoUint32 p2, GoUint32 p3);
+
+extern GoInt EtcdMSTransferAck(GoString p0, GoUint32 p1);
+
+extern GoInt EtcdMSKeepalive(GoString p0, GoUint32 p1);
+
+extern GoInt EtcdMSKeepaliveTimeout(GoString p0, GoUint32 p1, GoUint32 p2);
+
+extern GoUint8 EtcdIsMaster(GoString p0, GoUint32 p1);
+
+extern GoUint8 EtcdIsMasterTimeout(GoString p0, GoUint32 p1, GoUint32 p2);
+
+extern GoInt64 EtcdIsMasterUntil(GoString p0, GoUint32 p1);
+
+extern GoInt64 EtcdIsMasterUntilTimeout(GoString p0, GoUint32 p1, GoUint32 p2);
+
+extern GoUint32 EtcdGetMaster(GoString p0);
+
+extern GoInt EtcdGetMasterTimeout(GoString p0, GoUint32 p1, GoUint32* p2);
+
+extern GoInt EtcdLock(GoString p0, GoUint32 p1, GoUint32 p2, GoInt64* p3);
+
+extern GoInt EtcdTryLock(GoString p0, GoUint32 p1, GoInt64* p2);
+
+extern GoInt EtcdUnlock(GoString p0, GoUint32 p1);
+
+extern GoInt EtcdLockKeepalive(GoString p0, GoUint32 p1);
+
+extern GoInt EtcdLockSetTTL(GoUint32 p0);
+
+extern struct ConfigValue* EtcdConfigGet(GoString p0);
+
+extern GoInt EtcdConfigPut(GoString p0, GoString p1);
+
+extern GoInt EtcdConfigDelete(GoString p0);
+
+extern struct ConfigList* EtcdConfigList(GoString p0);
+
+extern GoInt EtcdConfigCompareAndSwap(GoString p0, GoString p1, GoInt64 p2, GoInt64* p3);
+
+extern GoInt EtcdWaitForNodes(GoUint32* p0, GoUint32 p1, GoUint32 p2);
+
+extern GoInt EtcdWaitForNodeCount(GoUint32 p0, GoUint32 p1);
+
+extern GoInt EtcdWaitForMaster(GoString p0, GoUint32 p1, GoUint32* p2);
+
+extern GoInt EtcdBarrierEnter(GoString p0, GoUint32 p1, GoUint32 p2, GoUint32 p3);
+
+extern GoInt EtcdBarrierLeave(GoString p0, GoUint32 p1, GoUint32 p2);
+
+extern GoInt EtcdSubscribeMq(GoString p0, GoString p1, GoInt64 p2, GoInt64 p3, GoUint32* p4);
+
+extern GoInt EtcdSubscribeCallback(GoString p0, void* p1, void* p2, GoUint32* p3);
+
+extern GoInt EtcdEventSetOverflow(GoString p0, GoUint32 p1);
 
-extern GoInt EtcdMSGiveUp(GoUint32 p0);
+extern void EtcdEventStats(GoUint64* p0, GoUint64* p1, GoUint64* p2);
 
-extern GoInt EtcdMSKeepalive(GoUint32 p0);
+extern void EtcdRetryStats(GoUint64* p0, GoUint64* p1, GoUint64* p2);
 
-extern GoUint8 EtcdIsMaster(GoUint32 p0);
+extern GoInt EtcdEventsSince(GoInt64 p0, GoUint32 p1, void** p2);
 
-extern GoUint32 EtcdGetMaster();
+extern GoInt EtcdUnsubscribe(GoUint32 p0);
 
 #ifdef __cplusplus
 }
@@ -90,27 +90,28 @@ void *node_thread(void *s)
 void *ms_thread(void *s)
 {
     GoUint32 data[5] = {1, 2, 3, 4, 5};
+    GoString group = {.p = "control", .n = strlen("control")};
     int i = 0;
     int len = sizeof(data) / sizeof(GoInt32);
     for (; i < len; i++)
     {
-        int ret = EtcdMSCompete(data[i]);
+        int ret = EtcdMSCompete(group, data[i], 0);
         if (ret != 0)
         {
             printf("MS compete error, nodeId = %u\n", data[i]);
         }
 
-        ret = EtcdMSKeepalive(data[i]);
+        ret = EtcdMSKeepalive(group, data[i]);
         if (ret != 0)
         {
             printf("MS keepalive error, nodeId = %u\n", data[i]);
         }
     }
 
-    GoUint32 master = EtcdGetMaster();
+    GoUint32 master = EtcdGetMaster(group);
     printf("master = %u\n", master);
 
-    GoUint8 isMaster = EtcdIsMaster(1);
+    GoUint8 isMaster = EtcdIsMaster(group, 1);
     printf("node = 1 %s master.\n", isMaster == 0 ? "isn't" : "is");
     return NULL;
 }
@@ -157,16 +158,23 @@ void *read_mq(void *s)
 
         printf("----- Receive message, len = %ld -------\n", recvd);
 
-        int i = 0;
-        int length = *((uint32_t *)msg_ptr);
-        printf("Events = %u\n", length);
+        const Message *message = DecodeMessage(msg_ptr, recvd);
+        if (message == NULL)
+        {
+            perror("DecodeMessage");
+            continue;
+        }
+        printf("Events = %u\n", message->length);
 
-        for (; i < length; i++)
+        uint32_t i = 0;
+        for (; i < message->length; i++)
         {
-            printf("%u: key = %s, value = %s type = %u\n", i,
-                   ((Message *)msg_ptr)->evts[i].key,
-                   ((Message *)msg_ptr)->evts[i].value,
-                   ((Message *)msg_ptr)->evts[i].type);
+            const Event *event = MessageEvent(message, i);
+            uint32_t nodeId = 0;
+            int hasNodeId = EventNodeId(event, &nodeId);
+            printf("%u: category = %s, node = %d:%u, key = %s, value = %s type = %u revision = %lld\n", i,
+                   EventCategoryName(event->category), hasNodeId, nodeId,
+                   event->fullKey, event->value, event->type, (long long)event->modRevision);
         }
     }
 }
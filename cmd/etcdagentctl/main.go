@@ -0,0 +1,449 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "etcdagent/agent"
+    "etcdagent/agent/event"
+    "etcdagent/agent/log"
+    "etcdagent/agent/ms"
+    "etcdagent/agent/node"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/signal"
+    "strconv"
+    "strings"
+    "syscall"
+    "text/tabwriter"
+    "time"
+
+    "github.com/coreos/etcd/mvcc/mvccpb"
+    "github.com/etcd-io/etcd/clientv3"
+)
+
+const usage = `Usage: etcdagentctl [flags] <command> [args]
+
+Commands:
+  nodes                list online nodes with service address and remaining lease ttl
+  master [group...]    show master and candidate order, all groups by default
+  events [filter]      tail node and ms events until interrupted, e.g. "category=ms;node=3"
+  offline <nodeId>     force a node offline by revoking its lease
+  stepdown <group>     force the master of a group to step down
+  audit [--repair]     check node registry for malformed keys and keys without a live lease,
+                       delete them with --repair
+
+Flags:
+`
+
+var (
+    configPath = flag.String("config", os.Getenv(agent.ETCD_CONFIG_ENV), "agent config file (YAML or JSON)")
+    endpoints  = flag.String("endpoints", "", "comma separated etcd endpoints, overrides config and ETCD_NODES")
+    jsonOutput = flag.Bool("json", false, "print output as JSON")
+    verbose    = flag.Bool("v", false, "print agent logs to stderr")
+)
+
+type nodeOutput struct {
+    NodeId      uint32 `json:"nodeId"`
+    ServiceAddr string `json:"serviceAddr"`
+    Lease       string `json:"lease"`
+    TTL         int64  `json:"ttl"`
+}
+
+type candidateOutput struct {
+    NodeId   uint32 `json:"nodeId"`
+    Priority uint32 `json:"priority"`
+    Revision int64  `json:"revision"`
+}
+
+type groupOutput struct {
+    Group      string            `json:"group"`
+    Master     *uint32           `json:"master"`
+    Candidates []candidateOutput `json:"candidates"`
+}
+
+type auditOutput struct {
+    Revision  int64    `json:"revision"`
+    Malformed []string `json:"malformed"`
+    Orphaned  []uint32 `json:"orphaned"`
+    Repaired  int      `json:"repaired"`
+}
+
+type eventOutput struct {
+    Time     string  `json:"time"`
+    Type     string  `json:"type"`
+    Category string  `json:"category"`
+    Group    string  `json:"group,omitempty"`
+    NodeId   *uint32 `json:"nodeId,omitempty"`
+    Value    string  `json:"value"`
+    Cause    string  `json:"cause,omitempty"`
+    Revision int64   `json:"revision"`
+}
+
+func main() {
+    flag.Usage = func() {
+        fmt.Fprint(os.Stderr, usage)
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+
+    if flag.NArg() == 0 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    if *verbose {
+        log.Logger.SetOutput(os.Stderr)
+    } else {
+        log.Logger.SetOutput(ioutil.Discard)
+    }
+
+    a, err := newAgent()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "etcdagentctl: %v\n", err)
+        os.Exit(1)
+    }
+    defer a.Close()
+
+    args := flag.Args()[1:]
+    switch flag.Arg(0) {
+    case "nodes":
+        err = listNodes(a)
+    case "master":
+        err = showMaster(a, args)
+    case "events":
+        err = tailEvents(a, args)
+    case "offline":
+        err = forceOffline(a, args)
+    case "stepdown":
+        err = forceStepDown(a, args)
+    case "audit":
+        err = auditNodes(a, args)
+    default:
+        err = fmt.Errorf("unknown command %q, run with -h for usage", flag.Arg(0))
+    }
+
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "etcdagentctl: %v\n", err)
+        os.Exit(1)
+    }
+}
+
+func newAgent() (*agent.Agent, error) {
+    var conf *agent.AgentConfig
+    var err error
+    if *configPath != "" {
+        if conf, err = agent.LoadConfig(*configPath); err != nil {
+            return nil, err
+        }
+    } else {
+        conf = agent.DefaultConfig()
+        if err = conf.ApplyEnv(); err != nil {
+            return nil, err
+        }
+    }
+
+    if *endpoints != "" {
+        conf.Endpoints = strings.Split(*endpoints, ",")
+    }
+    return agent.NewAgentFromConfig(conf)
+}
+
+func printJSON(v interface{}) error {
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(v)
+}
+
+func listNodes(a *agent.Agent) error {
+    nodes, err := a.ListNodes(context.Background())
+    if err != nil {
+        return err
+    }
+
+    output := make([]nodeOutput, 0, len(nodes))
+    for _, n := range nodes {
+        output = append(output, nodeOutput{
+            NodeId:      n.NodeId,
+            ServiceAddr: n.ServiceAddr,
+            Lease:       fmt.Sprintf("%x", int64(n.Lease)),
+            TTL:         n.TTL,
+        })
+    }
+
+    if *jsonOutput {
+        return printJSON(output)
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "NODE\tSERVICE\tLEASE\tTTL")
+    for _, n := range output {
+        fmt.Fprintf(w, "%v\t%v\t%v\t%vs\n", n.NodeId, n.ServiceAddr, n.Lease, n.TTL)
+    }
+    return w.Flush()
+}
+
+func showMaster(a *agent.Agent, groups []string) error {
+    var err error
+    if len(groups) == 0 {
+        if groups, err = a.GetGroups(context.Background()); err != nil {
+            return err
+        }
+    }
+
+    output := make([]groupOutput, 0, len(groups))
+    for _, group := range groups {
+        g := groupOutput{Group: group, Candidates: make([]candidateOutput, 0)}
+
+        master, err := a.GetMaster(context.Background(), group)
+        if err != nil {
+            return err
+        }
+        if master != ms.INVALID_NODE {
+            g.Master = &master
+        }
+
+        candidates, err := a.GetCandidates(context.Background(), group)
+        if err != nil {
+            return err
+        }
+        for _, c := range candidates {
+            g.Candidates = append(g.Candidates, candidateOutput{NodeId: c.NodeId, Priority: c.Priority, Revision: c.Revision})
+        }
+        output = append(output, g)
+    }
+
+    if *jsonOutput {
+        return printJSON(output)
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "GROUP\tMASTER\tCANDIDATES (NODE:PRIORITY)")
+    for _, g := range output {
+        master := "none"
+        if g.Master != nil {
+            master = strconv.FormatUint(uint64(*g.Master), 10)
+        }
+
+        candidates := make([]string, 0, len(g.Candidates))
+        for _, c := range g.Candidates {
+            candidates = append(candidates, fmt.Sprintf("%v:%v", c.NodeId, c.Priority))
+        }
+        fmt.Fprintf(w, "%v\t%v\t%v\n", g.Group, master, strings.Join(candidates, " "))
+    }
+    return w.Flush()
+}
+
+//解析key的类别、group和nodeId，非node/ms相关的key返回false
+func decodeEvent(ev *clientv3.Event) (eventOutput, bool) {
+    out := eventOutput{
+        Time:     time.Now().Format(time.RFC3339Nano),
+        Type:     ev.Type.String(),
+        Value:    string(ev.Kv.Value),
+        Revision: ev.Kv.ModRevision,
+    }
+
+    //删除事件使用删除前的value
+    if ev.Type == mvccpb.DELETE && ev.PrevKv != nil {
+        out.Value = string(ev.PrevKv.Value)
+    }
+
+    key := string(ev.Kv.Key)
+    setNodeId := func(s string) {
+        if inodeId, err := strconv.ParseUint(s, 10, 32); err == nil {
+            nodeId := uint32(inodeId)
+            out.NodeId = &nodeId
+        }
+    }
+
+    switch {
+    case strings.HasPrefix(key, node.NODE_PREFIX):
+        out.Category = "node"
+        setNodeId(strings.TrimPrefix(key, node.NODE_PREFIX))
+    case strings.HasPrefix(key, ms.MS_PREFIX):
+        group, nodeId, ok := ms.ParseKey(key)
+        if !ok {
+            return out, false
+        }
+        out.Category = "candidate"
+        out.Group = group
+        out.NodeId = &nodeId
+    case strings.HasPrefix(key, ms.MS_MASTER_PREFIX):
+        group, ok := ms.ParseMasterKey(key)
+        if !ok {
+            return out, false
+        }
+        out.Category = "master"
+        out.Group = group
+        setNodeId(out.Value)
+    case strings.HasPrefix(key, ms.MS_TRANSFER_PREFIX):
+        out.Category = "transfer"
+        out.Group = strings.TrimPrefix(key, ms.MS_TRANSFER_PREFIX)
+    default:
+        return out, false
+    }
+    return out, true
+}
+
+func tailEvents(a *agent.Agent, args []string) error {
+    var filter event.Filter
+    if len(args) > 0 {
+        var err error
+        if filter, err = event.ParseFilter(args[0]); err != nil {
+            return err
+        }
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    exit := make(chan os.Signal, 1)
+    signal.Notify(exit, syscall.SIGINT, syscall.SIGTERM)
+    go func() {
+        <-exit
+        cancel()
+    }()
+
+    //持续输出时无法预先计算列宽，使用固定宽度
+    format := "%-35v %-6v %-9v %-12v %-10v %-8v %v\n"
+    encoder := json.NewEncoder(os.Stdout)
+    if !*jsonOutput {
+        fmt.Printf(format, "TIME", "TYPE", "CATEGORY", "GROUP", "NODE", "CAUSE", "VALUE")
+    }
+
+    wChan := a.Client().Watch(ctx, event.EVENT_ROOT_PREFIX, clientv3.WithPrefix(), clientv3.WithPrevKV())
+    for wResp := range wChan {
+        if err := wResp.Err(); err != nil {
+            return err
+        }
+
+        for _, ev := range wResp.Events {
+            //删除事件使用删除前的value匹配nodeId
+            value := ev.Kv.Value
+            if ev.Type == mvccpb.DELETE && ev.PrevKv != nil {
+                value = ev.PrevKv.Value
+            }
+            if !filter.Match(event.MakeMessage(string(ev.Kv.Key), string(value), event.EVENT_TYPE_PUT)) {
+                continue
+            }
+
+            out, ok := decodeEvent(ev)
+            if !ok {
+                continue
+            }
+
+            if ev.Type == mvccpb.DELETE {
+                out.Cause = event.CauseName(event.DeleteCause(ctx, a.Client(), ev, wResp.Events))
+            }
+
+            if *jsonOutput {
+                encoder.Encode(out)
+                continue
+            }
+
+            nodeId := "-"
+            if out.NodeId != nil {
+                nodeId = strconv.FormatUint(uint64(*out.NodeId), 10)
+            }
+            group := out.Group
+            if group == "" {
+                group = "-"
+            }
+            cause := out.Cause
+            if cause == "" {
+                cause = "-"
+            }
+            fmt.Printf(format, out.Time, out.Type, out.Category, group, nodeId, cause, out.Value)
+        }
+    }
+    return nil
+}
+
+func forceOffline(a *agent.Agent, args []string) error {
+    if len(args) != 1 {
+        return fmt.Errorf("usage: etcdagentctl offline <nodeId>")
+    }
+
+    inodeId, err := strconv.ParseUint(args[0], 10, 32)
+    if err != nil {
+        return fmt.Errorf("invalid nodeId %q", args[0])
+    }
+
+    nodeId := uint32(inodeId)
+    if err = a.NodeForceOffline(context.Background(), nodeId); err != nil {
+        return err
+    }
+
+    if *jsonOutput {
+        return printJSON(map[string]interface{}{"nodeId": nodeId, "offline": true})
+    }
+    fmt.Printf("node %v is forced offline\n", nodeId)
+    return nil
+}
+
+func forceStepDown(a *agent.Agent, args []string) error {
+    if len(args) != 1 {
+        return fmt.Errorf("usage: etcdagentctl stepdown <group>")
+    }
+
+    group := args[0]
+    master, err := a.MSForceStepDown(context.Background(), group)
+    if err != nil {
+        return err
+    }
+
+    if *jsonOutput {
+        return printJSON(map[string]interface{}{"group": group, "master": master, "stepDown": true})
+    }
+    fmt.Printf("master %v of group %v is forced to step down\n", master, group)
+    return nil
+}
+
+//etcdagentctl不注册node，只能发现与本地状态无关的差异，本地node的检查由注册node的agent完成
+func auditNodes(a *agent.Agent, args []string) error {
+    repair := false
+    for _, arg := range args {
+        if arg != "--repair" && arg != "-repair" {
+            return fmt.Errorf("usage: etcdagentctl audit [--repair]")
+        }
+        repair = true
+    }
+
+    report, err := a.NodeAudit(context.Background(), repair)
+    if err != nil {
+        return err
+    }
+
+    output := auditOutput{
+        Revision:  report.Revision,
+        Malformed: append(make([]string, 0), report.Malformed...),
+        Orphaned:  append(make([]uint32, 0), report.Orphaned...),
+        Repaired:  report.Repaired,
+    }
+    if *jsonOutput {
+        return printJSON(output)
+    }
+
+    if len(output.Malformed) == 0 && len(output.Orphaned) == 0 {
+        fmt.Printf("node registry is consistent at revision %v\n", output.Revision)
+        return nil
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "PROBLEM\tKEY")
+    for _, key := range output.Malformed {
+        fmt.Fprintf(w, "malformed\t%v\n", key)
+    }
+    for _, nodeId := range output.Orphaned {
+        fmt.Fprintf(w, "orphaned\t%v%v\n", node.NODE_PREFIX, nodeId)
+    }
+    if err = w.Flush(); err != nil {
+        return err
+    }
+
+    if repair {
+        fmt.Printf("%v keys repaired\n", output.Repaired)
+    }
+    return nil
+}
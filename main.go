@@ -2,11 +2,19 @@ package main
 
 import "C"
 import (
+    "context"
     "etcdagent/agent"
+    "etcdagent/agent/config"
+    "etcdagent/agent/event"
+    "etcdagent/agent/ipc"
+    "etcdagent/agent/lock"
     "etcdagent/agent/log"
     "etcdagent/agent/ms"
+    "etcdagent/agent/node"
+    "etcdagent/agent/rpc"
+    "flag"
+    "fmt"
 	"os"
-	"os/exec"
     "os/signal"
     "strings"
     "sync"
@@ -19,125 +27,659 @@ var once sync.Once
 var etcd *agent.Agent
 
 const (
-    ETCD_SUCCESS = 0
-    ETCD_ERROR   = 1
+    ETCD_SUCCESS   = 0
+    ETCD_ERROR     = 1
+    ETCD_CONFLICT  = 2
+    ETCD_BUSY      = 3
+    ETCD_TIMEOUT   = 4
+    ETCD_COMPACTED = 5
 )
 
+//守护进程模式：一个agent服务本机所有进程，通过Unix socket提供node、ms和事件接口
 func main() {
     exit := make(chan os.Signal, 10)
     signal.Notify(exit, syscall.SIGINT, syscall.SIGTERM)
 
-    var a *agent.Agent
+    path := flag.String("config", os.Getenv(agent.ETCD_CONFIG_ENV), "agent config file (YAML or JSON)")
+    socket := flag.String("socket", "", "unix socket to serve local processes, overrides ipc.socket in config")
+    rpcAddr := flag.String("rpc", "", "grpc address, unix:<path> or <loopback host>:<port>, overrides rpc.address in config")
+    flag.Parse()
+
+    var conf *agent.AgentConfig
     var err error
 
-    if a, err = agent.NewAgent([]string{"172.100.1.226:2379"}, 5*time.Second); err != nil {
+    if *path != "" {
+        conf, err = agent.LoadConfig(*path)
+    } else {
+        conf = agent.DefaultConfig()
+        err = conf.ApplyEnv()
+    }
+
+    if err != nil {
+        log.Warn("Load agent config error, reason: %v", err.Error())
+        fmt.Fprintln(os.Stderr, err.Error())
+        os.Exit(1)
+    }
+
+    if *socket != "" {
+        conf.Ipc.Socket = *socket
+    }
+
+    if *rpcAddr != "" {
+        conf.Rpc.Address = *rpcAddr
+    }
+
+    var a *agent.Agent
+    if a, err = agent.NewAgentFromConfig(conf); err != nil {
         log.Warn("New agent error, reason: %v", err.Error())
+        fmt.Fprintln(os.Stderr, err.Error())
         os.Exit(1)
     }
 
     go a.Run()
 
+    server := ipc.NewServer(a, conf.Ipc.Socket)
+    go func() {
+        if err := server.Serve(); err != nil {
+            fmt.Fprintln(os.Stderr, err.Error())
+            exit <- syscall.SIGTERM
+        }
+    }()
+
+    //gRPC服务供Go、Python等非C进程使用
+    var rpcServer rpc.Server
+    if conf.Rpc.Address != "" {
+        rpcServer = rpc.NewServer(a, conf.Rpc.Address)
+        go func() {
+            if err := rpcServer.Serve(); err != nil {
+                fmt.Fprintln(os.Stderr, err.Error())
+                exit <- syscall.SIGTERM
+            }
+        }()
+    }
+
     sig := <-exit
     log.Warn("Receive signal = %v, etcdagent will stop", sig)
+    if rpcServer != nil {
+        rpcServer.Close()
+    }
+    server.Close()
 }
 
 //export EtcdAgentInit
-func EtcdAgentInit(etdcdservers string) {
+func EtcdAgentInit(etdcdservers string) int {
+    ret := ETCD_SUCCESS
     once.Do(func() {
-        err := exec.Command("bash", "-c", "echo 1024 > /proc/sys/fs/mqueue/msg_max").Run()
-        if err != nil {
-            log.Warn("Set mqueue msg_max error: %v", err.Error())
-            os.Exit(1)
-        }
-        
         addrs := strings.Split(etdcdservers, ";")
         log.Warn("ETCD_ADDRS:%v\n", addrs)
         if a, err := agent.NewAgent(addrs, 5*time.Second); err != nil {
             log.Warn("New etcd agent error, addrs = %v, reason: %v.\n ", addrs, err.Error())
-            os.Exit(1)
+            ret = ETCD_ERROR
+            return
         } else {
             etcd = a
         }
 
         go etcd.Run()
     })
+    return ret
+}
+
+//使用配置文件初始化，配置文件中的环境变量覆盖规则与独立运行时一致
+//export EtcdAgentInitFromFile
+func EtcdAgentInitFromFile(path string) int {
+    ret := ETCD_SUCCESS
+    once.Do(func() {
+        conf, err := agent.LoadConfig(path)
+        if err != nil {
+            log.Warn("Load agent config error, reason: %v", err.Error())
+            ret = ETCD_ERROR
+            return
+        }
+
+        if etcd, err = agent.NewAgentFromConfig(conf); err != nil {
+            log.Warn("New etcd agent error, config = %v, reason: %v", path, err.Error())
+            ret = ETCD_ERROR
+            return
+        }
+
+        go etcd.Run()
+    })
+    return ret
 }
 
 //export EtcdNodeOnline
 func EtcdNodeOnline(nodeId uint32, serviceAddr string) int {
-    if err := etcd.NodeOnline(nodeId, serviceAddr); err != nil {
+    if err := etcd.NodeOnline(context.Background(), nodeId, serviceAddr); err != nil {
+        if _, ok := err.(*node.ConflictError); ok {
+            return ETCD_CONFLICT
+        }
         return ETCD_ERROR
     }
     return ETCD_SUCCESS
 }
 
+//以下*Timeout接口最多阻塞timeoutMs，超时返回ETCD_TIMEOUT，timeoutMs为0时使用agent配置的默认超时
+//export EtcdNodeOnlineTimeout
+func EtcdNodeOnlineTimeout(nodeId uint32, serviceAddr string, timeoutMs uint32) int {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+
+    err := etcd.NodeOnline(ctx, nodeId, serviceAddr)
+    if _, ok := err.(*node.ConflictError); ok {
+        return ETCD_CONFLICT
+    }
+    return waitResult(err)
+}
+
 //export EtcdNodeKeepalive
 func EtcdNodeKeepalive(nodeId uint32) int {
-    if err := etcd.NodeKeepalive(nodeId); err != nil {
+    if err := etcd.NodeKeepalive(context.Background(), nodeId); err != nil {
         return ETCD_ERROR
     }
     return ETCD_SUCCESS
 }
 
+//export EtcdNodeKeepaliveTimeout
+func EtcdNodeKeepaliveTimeout(nodeId uint32, timeoutMs uint32) int {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+    return waitResult(etcd.NodeKeepalive(ctx, nodeId))
+}
+
 //export EtcdNodeOffline
 func EtcdNodeOffline(nodeId uint32) int {
-    if err := etcd.NodeOffline(nodeId); err != nil {
+    if err := etcd.NodeOffline(context.Background(), nodeId); err != nil {
         return ETCD_ERROR
     }
     return ETCD_SUCCESS
 }
 
+//export EtcdNodeOfflineTimeout
+func EtcdNodeOfflineTimeout(nodeId uint32, timeoutMs uint32) int {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+    return waitResult(etcd.NodeOffline(ctx, nodeId))
+}
+
 //export EtcdGetAllNodes
 func EtcdGetAllNodes() *C.struct_Nodes {
-    p, _ := etcd.CGetAllNodes()
+    p, _ := etcd.CGetAllNodes(context.Background())
+    return (*C.struct_Nodes)(unsafe.Pointer(p))
+}
+
+//出错或者超时返回NULL
+//export EtcdGetAllNodesTimeout
+func EtcdGetAllNodesTimeout(timeoutMs uint32) *C.struct_Nodes {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+
+    p, _ := etcd.CGetAllNodes(ctx)
     return (*C.struct_Nodes)(unsafe.Pointer(p))
 }
 
 //export EtcdGetNodeServiceAddr
 func EtcdGetNodeServiceAddr(nodeId uint32) *C.struct_ServiceAddr {
-    p, _ := etcd.CGetNodeServiceAddr(nodeId)
+    p, _ := etcd.CGetNodeServiceAddr(context.Background(), nodeId)
     return (*C.struct_ServiceAddr)(unsafe.Pointer(p))
 }
 
+//出错或者超时返回NULL
+//export EtcdGetNodeServiceAddrTimeout
+func EtcdGetNodeServiceAddrTimeout(nodeId uint32, timeoutMs uint32) *C.struct_ServiceAddr {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+
+    p, _ := etcd.CGetNodeServiceAddr(ctx, nodeId)
+    return (*C.struct_ServiceAddr)(unsafe.Pointer(p))
+}
+
+//检查本地注册的node与etcd是否一致，结果通过audit返回，类型为struct NodeAudit *，使用完后需要free
+//repair为true时修复差异，audit->repaired为修复的个数
+//export EtcdNodeAudit
+func EtcdNodeAudit(repair bool, audit *unsafe.Pointer) int {
+    //结果需要调用者释放，audit为空时不检查，避免内存泄漏
+    if audit == nil {
+        log.Warn("Node audit error, audit is NULL")
+        return ETCD_ERROR
+    }
+
+    p, err := etcd.CNodeAudit(context.Background(), repair)
+    if err != nil {
+        log.Warn("Node audit error, reason: %v", err.Error())
+        return ETCD_ERROR
+    }
+    *audit = unsafe.Pointer(p)
+    return ETCD_SUCCESS
+}
+
+//identity为空时不绑定主机，每次分配新的nodeId
+//export EtcdNodeAllocateId
+func EtcdNodeAllocateId(identity string, nodeId *uint32) int {
+    id, err := etcd.NodeAllocateId(context.Background(), identity)
+    if err != nil {
+        return ETCD_ERROR
+    }
+
+    if nodeId != nil {
+        *nodeId = id
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdNodeReleaseId
+func EtcdNodeReleaseId(nodeId uint32) int {
+    if err := etcd.NodeReleaseId(nodeId); err != nil {
+        return ETCD_ERROR
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdNodeSetIdGrace
+func EtcdNodeSetIdGrace(grace uint32) {
+    etcd.NodeSetIdGrace(int64(grace))
+}
+
 func EtcdNodeSetTTL(ttl uint32) {
     etcd.NodeSetTTL(int64(ttl))
 }
 
 //export EtcdMSCompete
-func EtcdMSCompete(nodeId uint32) int {
-    if err := etcd.MSCompete(nodeId); err != nil {
+func EtcdMSCompete(group string, nodeId uint32, priority uint32) int {
+    if err := etcd.MSCompete(context.Background(), group, nodeId, priority); err != nil {
+        if _, ok := err.(*ms.ConflictError); ok {
+            return ETCD_CONFLICT
+        }
         return ETCD_ERROR
     }
     return ETCD_SUCCESS
 }
 
+//export EtcdMSCompeteTimeout
+func EtcdMSCompeteTimeout(group string, nodeId uint32, priority uint32, timeoutMs uint32) int {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+    err := etcd.MSCompete(ctx, group, nodeId, priority)
+    if _, ok := err.(*ms.ConflictError); ok {
+        return ETCD_CONFLICT
+    }
+    return waitResult(err)
+}
+
+//export EtcdMSSetPreempt
+func EtcdMSSetPreempt(preempt bool, delayMs uint32) {
+    etcd.MSSetPreempt(preempt, time.Duration(delayMs)*time.Millisecond)
+}
+
 //export EtcdMSGiveUp
-func EtcdMSGiveUp(nodeId uint32) int {
-    if err := etcd.MSGiveUp(nodeId); err != nil {
+func EtcdMSGiveUp(group string, nodeId uint32) int {
+    if err := etcd.MSGiveUp(context.Background(), group, nodeId); err != nil {
+        return ETCD_ERROR
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdMSGiveUpTimeout
+func EtcdMSGiveUpTimeout(group string, nodeId uint32, timeoutMs uint32) int {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+    return waitResult(etcd.MSGiveUp(ctx, group, nodeId))
+}
+
+//export EtcdMSTransfer
+func EtcdMSTransfer(group string, from uint32, to uint32, timeoutMs uint32) int {
+    return waitResult(etcd.MSTransfer(context.Background(), group, from, to, time.Duration(timeoutMs)*time.Millisecond))
+}
+
+//export EtcdMSTransferAck
+func EtcdMSTransferAck(group string, nodeId uint32) int {
+    if err := etcd.MSTransferAck(context.Background(), group, nodeId); err != nil {
         return ETCD_ERROR
     }
     return ETCD_SUCCESS
 }
 
 //export EtcdMSKeepalive
-func EtcdMSKeepalive(nodeId uint32) int {
-    if err := etcd.MSKeepalive(nodeId); err != nil {
+func EtcdMSKeepalive(group string, nodeId uint32) int {
+    if err := etcd.MSKeepalive(context.Background(), group, nodeId); err != nil {
         return ETCD_ERROR
     }
     return ETCD_SUCCESS
 }
 
+//export EtcdMSKeepaliveTimeout
+func EtcdMSKeepaliveTimeout(group string, nodeId uint32, timeoutMs uint32) int {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+    return waitResult(etcd.MSKeepalive(ctx, group, nodeId))
+}
+
 //export EtcdIsMaster
-func EtcdIsMaster(nodeId uint32) bool {
-    return etcd.IsMaster(nodeId)
+func EtcdIsMaster(group string, nodeId uint32) bool {
+    return etcd.IsMaster(context.Background(), group, nodeId)
+}
+
+//超时时与etcd不可达相同：本地候选者在lease到期前沿用最近一次确认的状态，否则返回false
+//export EtcdIsMasterTimeout
+func EtcdIsMasterTimeout(group string, nodeId uint32, timeoutMs uint32) bool {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+    return etcd.IsMaster(ctx, group, nodeId)
+}
+
+//返回master身份剩余的有效毫秒数，不是master时返回0，非本地候选者无法估算时返回-1
+//export EtcdIsMasterUntil
+func EtcdIsMasterUntil(group string, nodeId uint32) int64 {
+    return isMasterUntil(context.Background(), group, nodeId)
+}
+
+//export EtcdIsMasterUntilTimeout
+func EtcdIsMasterUntilTimeout(group string, nodeId uint32, timeoutMs uint32) int64 {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+    return isMasterUntil(ctx, group, nodeId)
+}
+
+func isMasterUntil(ctx context.Context, group string, nodeId uint32) int64 {
+    master, deadline := etcd.IsMasterUntil(ctx, group, nodeId)
+    if !master {
+        return 0
+    }
+
+    if deadline.IsZero() {
+        return -1
+    }
+    return int64(time.Until(deadline) / time.Millisecond)
 }
 
 //export EtcdGetMaster
-func EtcdGetMaster() uint32 {
+func EtcdGetMaster(group string) uint32 {
     var err error
     var master uint32
-    if master, err = etcd.GetMaster(); err != nil {
+    if master, err = etcd.GetMaster(context.Background(), group); err != nil {
         return ms.INVALID_NODE
     }
     return master
 }
+
+//没有master时master为INVALID_NODE
+//export EtcdGetMasterTimeout
+func EtcdGetMasterTimeout(group string, timeoutMs uint32, master *uint32) int {
+    ctx, cancel := timeoutContext(timeoutMs)
+    defer cancel()
+
+    nodeId, err := etcd.GetMaster(ctx, group)
+    if err == nil && master != nil {
+        *master = nodeId
+    }
+    return waitResult(err)
+}
+
+//export EtcdLock
+func EtcdLock(name string, nodeId uint32, timeoutMs uint32, fence *int64) int {
+    rev, err := etcd.Lock.Lock(name, nodeId, time.Duration(timeoutMs)*time.Millisecond)
+    if err != nil {
+        if err == lock.ErrLockTimeout {
+            return ETCD_TIMEOUT
+        }
+        return ETCD_ERROR
+    }
+
+    if fence != nil {
+        *fence = rev
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdTryLock
+func EtcdTryLock(name string, nodeId uint32, fence *int64) int {
+    rev, err := etcd.TryLock(name, nodeId)
+    if err != nil {
+        if err == lock.ErrLocked {
+            return ETCD_BUSY
+        }
+        return ETCD_ERROR
+    }
+
+    if fence != nil {
+        *fence = rev
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdUnlock
+func EtcdUnlock(name string, nodeId uint32) int {
+    if err := etcd.Lock.Unlock(name, nodeId); err != nil {
+        return ETCD_ERROR
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdLockKeepalive
+func EtcdLockKeepalive(name string, nodeId uint32) int {
+    if err := etcd.LockKeepalive(name, nodeId); err != nil {
+        return ETCD_ERROR
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdLockSetTTL
+func EtcdLockSetTTL(ttl uint32) int {
+    if err := etcd.LockSetTTL(int64(ttl)); err != nil {
+        log.Warn("Set lock ttl error, reason: %v", err.Error())
+        return ETCD_ERROR
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdConfigGet
+func EtcdConfigGet(key string) *C.struct_ConfigValue {
+    p, _ := etcd.CConfigGet(key)
+    return (*C.struct_ConfigValue)(unsafe.Pointer(p))
+}
+
+//export EtcdConfigPut
+func EtcdConfigPut(key string, value string) int {
+    if _, err := etcd.ConfigPut(key, value); err != nil {
+        return ETCD_ERROR
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdConfigDelete
+func EtcdConfigDelete(key string) int {
+    if err := etcd.ConfigDelete(key); err != nil {
+        return ETCD_ERROR
+    }
+    return ETCD_SUCCESS
+}
+
+//export EtcdConfigList
+func EtcdConfigList(prefix string) *C.struct_ConfigList {
+    p, _ := etcd.CConfigList(prefix)
+    return (*C.struct_ConfigList)(unsafe.Pointer(p))
+}
+
+//revision为0表示key必须不存在，成功时通过newRevision返回新的revision
+//export EtcdConfigCompareAndSwap
+func EtcdConfigCompareAndSwap(key string, value string, revision int64, newRevision *int64) int {
+    rev, err := etcd.ConfigCompareAndSwap(key, value, revision)
+    if err != nil {
+        if err == config.ErrConfigConflict {
+            return ETCD_CONFLICT
+        }
+        return ETCD_ERROR
+    }
+
+    if newRevision != nil {
+        *newRevision = rev
+    }
+    return ETCD_SUCCESS
+}
+
+//ids为nodeId数组，count为数组长度
+//export EtcdWaitForNodes
+func EtcdWaitForNodes(ids *uint32, count uint32, timeoutMs uint32) int {
+    nodes := make([]uint32, count)
+    if count != 0 {
+        copy(nodes, (*[1 << 20]uint32)(unsafe.Pointer(ids))[:count:count])
+    }
+    return waitResult(etcd.WaitForNodes(context.Background(), nodes, time.Duration(timeoutMs)*time.Millisecond))
+}
+
+//export EtcdWaitForNodeCount
+func EtcdWaitForNodeCount(count uint32, timeoutMs uint32) int {
+    return waitResult(etcd.WaitForNodeCount(context.Background(), int(count), time.Duration(timeoutMs)*time.Millisecond))
+}
+
+//export EtcdWaitForMaster
+func EtcdWaitForMaster(group string, timeoutMs uint32, master *uint32) int {
+    nodeId, err := etcd.WaitForMaster(context.Background(), group, time.Duration(timeoutMs)*time.Millisecond)
+    if err == nil && master != nil {
+        *master = nodeId
+    }
+    return waitResult(err)
+}
+
+//export EtcdBarrierEnter
+func EtcdBarrierEnter(name string, nodeId uint32, count uint32, timeoutMs uint32) int {
+    return waitResult(etcd.BarrierEnter(name, nodeId, int(count), time.Duration(timeoutMs)*time.Millisecond))
+}
+
+//export EtcdBarrierLeave
+func EtcdBarrierLeave(name string, nodeId uint32, timeoutMs uint32) int {
+    return waitResult(etcd.BarrierLeave(name, nodeId, time.Duration(timeoutMs)*time.Millisecond))
+}
+
+//订阅满足filter的事件并投递到指定的MQ，filter格式见event.ParseFilter，为空时接收所有事件
+//MQ已存在时沿用原有属性，队列满时丢弃事件
+//export EtcdSubscribeMq
+func EtcdSubscribeMq(filter string, name string, maxMsg int64, msgSize int64, id *uint32) int {
+    f, err := event.ParseFilter(filter)
+    if err != nil {
+        log.Warn("Subscribe error, reason: %v", err.Error())
+        return ETCD_ERROR
+    }
+
+    target, err := event.NewMqTarget(name, maxMsg, msgSize)
+    if err != nil {
+        log.Warn("Subscribe error, reason: %v", err.Error())
+        return ETCD_ERROR
+    }
+
+    subId := etcd.SubscribeTarget(f, target)
+    if id != nil {
+        *id = subId
+    }
+    return ETCD_SUCCESS
+}
+
+//callback类型为EventCallback，在agent的事件线程中执行，不能阻塞，也不能调用EtcdSubscribe*、EtcdUnsubscribe
+//export EtcdSubscribeCallback
+func EtcdSubscribeCallback(filter string, callback unsafe.Pointer, arg unsafe.Pointer, id *uint32) int {
+    f, err := event.ParseFilter(filter)
+    if err != nil {
+        log.Warn("Subscribe error, reason: %v", err.Error())
+        return ETCD_ERROR
+    }
+
+    target, err := event.NewCallbackTarget(callback, arg)
+    if err != nil {
+        log.Warn("Subscribe error, reason: %v", err.Error())
+        return ETCD_ERROR
+    }
+
+    subId := etcd.SubscribeTarget(f, target)
+    if id != nil {
+        *id = subId
+    }
+    return ETCD_SUCCESS
+}
+
+//policy为drop-newest、drop-oldest或者resync，timeoutMs为MQ满时的最长等待时间
+//export EtcdEventSetOverflow
+func EtcdEventSetOverflow(policy string, timeoutMs uint32) int {
+    if err := etcd.EventSetOverflow(policy, time.Duration(timeoutMs)*time.Millisecond); err != nil {
+        log.Warn("Set overflow policy error, reason: %v", err.Error())
+        return ETCD_ERROR
+    }
+    return ETCD_SUCCESS
+}
+
+//MQ发送统计，单位为事件个数
+//export EtcdEventStats
+func EtcdEventStats(sent *uint64, dropped *uint64, resyncs *uint64) {
+    stats := etcd.EventStats()
+    if sent != nil {
+        *sent = stats.Sent
+    }
+    if dropped != nil {
+        *dropped = stats.Dropped
+    }
+    if resyncs != nil {
+        *resyncs = stats.Resyncs
+    }
+}
+
+//etcd暂时不可用时的重试统计：重试次数、重试后成功和最终失败的请求数
+//export EtcdRetryStats
+func EtcdRetryStats(retries *uint64, recovered *uint64, exhausted *uint64) {
+    stats := etcd.RetryStats()
+    if retries != nil {
+        *retries = stats.Retries
+    }
+    if recovered != nil {
+        *recovered = stats.Recovered
+    }
+    if exhausted != nil {
+        *exhausted = stats.Exhausted
+    }
+}
+
+//通过message返回revision之后的事件，类型为Message *，格式与MQ中的消息一致，使用完后需要free
+//maxEvents为0时不限制个数，revision之后的事件已不在journal中时返回ETCD_COMPACTED，需要重新读取全量状态
+//export EtcdEventsSince
+func EtcdEventsSince(revision int64, maxEvents uint32, message *unsafe.Pointer) int {
+    if message == nil {
+        log.Warn("Get events since revision %v error, message is NULL", revision)
+        return ETCD_ERROR
+    }
+
+    p, err := etcd.CEventsSince(context.Background(), revision, int(maxEvents))
+    if err != nil {
+        log.Warn("Get events since revision %v error, reason: %v", revision, err.Error())
+        if err == event.ErrJournalCompacted {
+            return ETCD_COMPACTED
+        }
+        return ETCD_ERROR
+    }
+    *message = unsafe.Pointer(p)
+    return ETCD_SUCCESS
+}
+
+//export EtcdUnsubscribe
+func EtcdUnsubscribe(id uint32) int {
+    if err := etcd.Unsubscribe(id); err != nil {
+        return ETCD_ERROR
+    }
+    return ETCD_SUCCESS
+}
+
+//timeoutMs为0时只使用agent配置的默认超时
+func timeoutContext(timeoutMs uint32) (context.Context, context.CancelFunc) {
+    if timeoutMs == 0 {
+        return context.WithCancel(context.Background())
+    }
+    return context.WithTimeout(context.Background(), time.Duration(timeoutMs)*time.Millisecond)
+}
+
+func waitResult(err error) int {
+    switch err {
+    case nil:
+        return ETCD_SUCCESS
+    case context.DeadlineExceeded:
+        return ETCD_TIMEOUT
+    default:
+        return ETCD_ERROR
+    }
+}